@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net/url"
+	"strconv"
 
 	"github.com/spf13/cobra"
 )
@@ -88,6 +90,63 @@ var delete = &cobra.Command{
 	},
 }
 
+var deleteBucket = &cobra.Command{
+	Use:   "rmbucket -p path [-r] [--dry-run]",
+	Short: "Удаляет бакет, с -r вместе со всеми секретами и вложенными бакетами",
+	Run: func(cmd *cobra.Command, args []string) {
+		recursive, _ := cmd.Flags().GetBool("recursive")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		if storagePath == "" {
+			fmt.Println("Необходимо указать путь до бакета")
+			return
+		}
+
+		query := url.Values{}
+		query.Set("path", storagePath)
+		query.Set("recursive", strconv.FormatBool(recursive))
+		query.Set("dry_run", strconv.FormatBool(dryRun))
+
+		response, err := prepareQueryRequest("DELETE", "buckets", query)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer response.Body.Close()
+
+		buf, _ := io.ReadAll(response.Body)
+		if response.StatusCode != 200 {
+			fmt.Printf("Status: %v\n", response.StatusCode)
+			if len(buf) != 0 {
+				fmt.Printf("%s\n", buf)
+			}
+			return
+		}
+
+		var report struct {
+			DeletedSecrets int      `json:"deleted_secrets"`
+			DeletedBuckets int      `json:"deleted_buckets"`
+			Secrets        []string `json:"secrets"`
+			Buckets        []string `json:"buckets"`
+		}
+		if err := json.Unmarshal(buf, &report); err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		for _, bucket := range report.Buckets {
+			fmt.Printf("BUCKET:  %s\n", bucket)
+		}
+		for _, secret := range report.Secrets {
+			fmt.Printf("SECRET:  %s\n", secret)
+		}
+		if dryRun {
+			fmt.Printf("Будет удалено секретов: %d, бакетов: %d\n", report.DeletedSecrets, report.DeletedBuckets)
+			return
+		}
+		fmt.Printf("Удалено секретов: %d, бакетов: %d\n", report.DeletedSecrets, report.DeletedBuckets)
+	},
+}
+
 var (
 	key         string
 	value       string
@@ -105,7 +164,12 @@ func init() {
 	set.Flags().StringVarP(&value, "value", "v", "", "Значение, которое надо установить по ключу")
 	set.Flags().StringVarP(&storagePath, "path", "p", "", "Путь до значения в хранилище")
 
+	deleteBucket.Flags().StringVarP(&storagePath, "path", "p", "", "Путь до бакета в хранилище")
+	deleteBucket.Flags().BoolP("recursive", "r", false, "Удалить бакет вместе со всем содержимым")
+	deleteBucket.Flags().Bool("dry-run", false, "Показать, что будет удалено, ничего не удаляя")
+
 	rootCmd.AddCommand(get)
 	rootCmd.AddCommand(set)
 	rootCmd.AddCommand(delete)
+	rootCmd.AddCommand(deleteBucket)
 }
@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/liriquew/secret_storage/cli/config"
+	"github.com/spf13/cobra"
+)
+
+var uploadCmd = &cobra.Command{
+	Use:   "upload -k key -i file [-p path]",
+	Short: "Загружает файл в хранилище как секрет",
+	Run: func(cmd *cobra.Command, args []string) {
+		input, _ := cmd.Flags().GetString("input")
+		if input == "" || key == "" {
+			fmt.Println("Необходимо указать ключ и файл")
+			return
+		}
+
+		file, err := os.Open(input)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer file.Close()
+
+		query := url.Values{}
+		query.Set("path", storagePath)
+		query.Set("name", filepath.Base(input))
+
+		req, err := http.NewRequest("PUT", baseURL+"files/"+url.PathEscape(key)+"?"+query.Encode(), file)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		req.Header.Add("Authorization", "Bearer "+config.GetToken())
+		req.Header.Add("Content-Type", "application/octet-stream")
+		if contentType := mime.TypeByExtension(filepath.Ext(input)); contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+
+		response, err := http.DefaultClient.Do(req)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer response.Body.Close()
+
+		buf, _ := io.ReadAll(response.Body)
+		if response.StatusCode != 200 {
+			fmt.Printf("Status: %v\n", response.StatusCode)
+		}
+		if len(buf) != 0 {
+			fmt.Printf("%s\n", buf)
+		}
+	},
+}
+
+var downloadCmd = &cobra.Command{
+	Use:   "download -k key -o file [-p path] [--resume]",
+	Short: "Скачивает файл из хранилища, с --resume докачивает уже начатый файл",
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		resume, _ := cmd.Flags().GetBool("resume")
+		if output == "" || key == "" {
+			fmt.Println("Необходимо указать ключ и файл")
+			return
+		}
+
+		query := url.Values{}
+		query.Set("path", storagePath)
+
+		req, err := http.NewRequest("GET", baseURL+"files/"+url.PathEscape(key)+"?"+query.Encode(), nil)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		req.Header.Add("Authorization", "Bearer "+config.GetToken())
+
+		flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+		if info, err := os.Stat(output); resume && err == nil && info.Size() > 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", info.Size()))
+			flags = os.O_WRONLY | os.O_APPEND
+		}
+
+		response, err := http.DefaultClient.Do(req)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer response.Body.Close()
+
+		switch response.StatusCode {
+		case 200:
+			// the whole file is sent if the range can't be served
+			flags = os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+		case 206:
+		case 416:
+			fmt.Println("Файл уже скачан")
+			return
+		default:
+			fmt.Printf("Status: %v\n", response.StatusCode)
+			buf, _ := io.ReadAll(response.Body)
+			if len(buf) != 0 {
+				fmt.Printf("%s\n", buf)
+			}
+			return
+		}
+
+		file, err := os.OpenFile(output, flags, 0o600)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer file.Close()
+
+		if _, err := io.Copy(file, response.Body); err != nil {
+			fmt.Println("Загрузка прервана, повторите с --resume:", err)
+		}
+	},
+}
+
+func init() {
+	uploadCmd.Flags().StringVarP(&key, "key", "k", "", "Ключ, по которому будет сохранен файл")
+	uploadCmd.Flags().StringVarP(&storagePath, "path", "p", "", "Путь до значения в хранилище")
+	uploadCmd.Flags().StringP("input", "i", "", "Загружаемый файл")
+
+	downloadCmd.Flags().StringVarP(&key, "key", "k", "", "Ключ файла")
+	downloadCmd.Flags().StringVarP(&storagePath, "path", "p", "", "Путь до значения в хранилище")
+	downloadCmd.Flags().StringP("output", "o", "", "Файл для сохранения")
+	downloadCmd.Flags().Bool("resume", false, "Докачать файл, если он уже частично скачан")
+
+	rootCmd.AddCommand(uploadCmd)
+	rootCmd.AddCommand(downloadCmd)
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 
 	"github.com/liriquew/secret_storage/cli/config"
 )
@@ -56,3 +57,17 @@ func prepareRequest(method, path string, data *KV, completePath bool) (*http.Res
 	}
 	return response, nil
 }
+
+// prepareQueryRequest sends a request without body to the API path with query parameters.
+func prepareQueryRequest(method, path string, query url.Values) (*http.Response, error) {
+	token := config.GetToken()
+
+	req, err := http.NewRequest(method, baseURL+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("Authorization", "Bearer "+token)
+
+	return http.DefaultClient.Do(req)
+}
@@ -0,0 +1,246 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/liriquew/secret_storage/cli/config"
+	"github.com/spf13/cobra"
+)
+
+const passphraseHeader = "X-Backup-Passphrase"
+
+var operator = &cobra.Command{
+	Use:   "operator",
+	Short: "Команды администратора хранилища",
+}
+
+var backup = &cobra.Command{
+	Use:   "backup -o file -k passphrase",
+	Short: "Сохраняет зашифрованную резервную копию хранилища в файл",
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		passphrase, _ := cmd.Flags().GetString("passphrase")
+		if output == "" || passphrase == "" {
+			fmt.Println("Необходимо указать файл и пароль резервной копии")
+			return
+		}
+
+		req, err := http.NewRequest("GET", baseURL+"sys/backup", nil)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		req.Header.Add("Authorization", "Bearer "+config.GetToken())
+		req.Header.Add(passphraseHeader, passphrase)
+
+		response, err := http.DefaultClient.Do(req)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer response.Body.Close()
+
+		if response.StatusCode != 200 {
+			fmt.Printf("Status: %v\n", response.StatusCode)
+			buf, _ := io.ReadAll(response.Body)
+			if len(buf) != 0 {
+				fmt.Printf("%s\n", buf)
+			}
+			return
+		}
+
+		file, err := os.Create(output)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer file.Close()
+
+		// a stream cut by the server is detected on restore
+		written, err := io.Copy(file, response.Body)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		fmt.Printf("Резервная копия сохранена в %s (%d байт)\n", output, written)
+	},
+}
+
+var restore = &cobra.Command{
+	Use:   "restore -i file -k passphrase",
+	Short: "Восстанавливает запечатанное хранилище из резервной копии",
+	Run: func(cmd *cobra.Command, args []string) {
+		input, _ := cmd.Flags().GetString("input")
+		passphrase, _ := cmd.Flags().GetString("passphrase")
+		if input == "" || passphrase == "" {
+			fmt.Println("Необходимо указать файл и пароль резервной копии")
+			return
+		}
+
+		file, err := os.Open(input)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer file.Close()
+
+		req, err := http.NewRequest("POST", baseURL+"sys/restore", file)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		req.Header.Add("Authorization", "Bearer "+config.GetToken())
+		req.Header.Add(passphraseHeader, passphrase)
+
+		response, err := http.DefaultClient.Do(req)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer response.Body.Close()
+
+		if response.StatusCode != 200 {
+			fmt.Printf("Status: %v\n", response.StatusCode)
+			buf, _ := io.ReadAll(response.Body)
+			if len(buf) != 0 {
+				fmt.Printf("%s\n", buf)
+			}
+			return
+		}
+
+		fmt.Println("ОК, распечатайте хранилище частями исходного мастер ключа")
+	},
+}
+
+var verify = &cobra.Command{
+	Use:   "verify",
+	Short: "Проверяет, что все значения хранилища расшифровываются, и выводит отчет в JSON",
+	Run: func(cmd *cobra.Command, args []string) {
+		req, err := http.NewRequest("GET", baseURL+"sys/verify", nil)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		req.Header.Add("Authorization", "Bearer "+config.GetToken())
+
+		response, err := http.DefaultClient.Do(req)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer response.Body.Close()
+
+		buf, _ := io.ReadAll(response.Body)
+		if response.StatusCode != 200 {
+			fmt.Printf("Status: %v\n", response.StatusCode)
+		}
+		if len(buf) != 0 {
+			fmt.Printf("%s\n", buf)
+		}
+	},
+}
+
+var rotate = &cobra.Command{
+	Use:   "rotate",
+	Short: "Добавляет новый ключ шифрования данных и делает его активным",
+	Run: func(cmd *cobra.Command, args []string) {
+		req, err := http.NewRequest("POST", baseURL+"sys/rotate", nil)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		req.Header.Add("Authorization", "Bearer "+config.GetToken())
+
+		response, err := http.DefaultClient.Do(req)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer response.Body.Close()
+
+		buf, _ := io.ReadAll(response.Body)
+		if response.StatusCode != 200 {
+			fmt.Printf("Status: %v\n", response.StatusCode)
+		}
+		if len(buf) != 0 {
+			fmt.Printf("%s\n", buf)
+		}
+	},
+}
+
+var rewrapStatus = &cobra.Command{
+	Use:   "rewrap",
+	Short: "Выводит прогресс перешифрования данных активным ключом",
+	Run: func(cmd *cobra.Command, args []string) {
+		req, err := http.NewRequest("GET", baseURL+"sys/rewrap", nil)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		req.Header.Add("Authorization", "Bearer "+config.GetToken())
+
+		response, err := http.DefaultClient.Do(req)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer response.Body.Close()
+
+		buf, _ := io.ReadAll(response.Body)
+		if response.StatusCode != 200 {
+			fmt.Printf("Status: %v\n", response.StatusCode)
+		}
+		if len(buf) != 0 {
+			fmt.Printf("%s\n", buf)
+		}
+	},
+}
+
+var purgeKeys = &cobra.Command{
+	Use:   "purge-keys",
+	Short: "Удаляет старые ключи шифрования, которыми больше ничего не зашифровано",
+	Run: func(cmd *cobra.Command, args []string) {
+		req, err := http.NewRequest("POST", baseURL+"sys/rewrap/purge", nil)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		req.Header.Add("Authorization", "Bearer "+config.GetToken())
+
+		response, err := http.DefaultClient.Do(req)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer response.Body.Close()
+
+		buf, _ := io.ReadAll(response.Body)
+		if response.StatusCode != 200 {
+			fmt.Printf("Status: %v\n", response.StatusCode)
+		}
+		if len(buf) != 0 {
+			fmt.Printf("%s\n", buf)
+		}
+	},
+}
+
+func init() {
+	backup.Flags().StringP("output", "o", "", "Файл резервной копии")
+	backup.Flags().StringP("passphrase", "k", "", "Пароль резервной копии")
+
+	restore.Flags().StringP("input", "i", "", "Файл резервной копии")
+	restore.Flags().StringP("passphrase", "k", "", "Пароль резервной копии")
+
+	operator.AddCommand(backup)
+	operator.AddCommand(restore)
+	operator.AddCommand(verify)
+	operator.AddCommand(rotate)
+	operator.AddCommand(rewrapStatus)
+	operator.AddCommand(purgeKeys)
+
+	rootCmd.AddCommand(operator)
+}
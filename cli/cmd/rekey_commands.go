@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/websocket"
+	"github.com/liriquew/secret_storage/cli/config"
+	"github.com/spf13/cobra"
+)
+
+var rekey = &cobra.Command{
+	Use:   "rekey",
+	Short: "Заменяет части мастер ключа без перешифрования данных",
+}
+
+// rekeyRequest sends a request of the ceremony and prints the response.
+func rekeyRequest(method, path string) int {
+	req, err := http.NewRequest(method, baseURL+"rekey"+path, nil)
+	if err != nil {
+		fmt.Println(err)
+		return 0
+	}
+	req.Header.Add("Authorization", "Bearer "+config.GetToken())
+
+	response, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fmt.Println(err)
+		return 0
+	}
+	defer response.Body.Close()
+
+	buf, _ := io.ReadAll(response.Body)
+	if response.StatusCode != 200 {
+		fmt.Printf("Status: %v\n", response.StatusCode)
+	}
+	if len(buf) != 0 {
+		fmt.Printf("%s\n", buf)
+	}
+	return response.StatusCode
+}
+
+var rekeyInit = &cobra.Command{
+	Use:   "init -p partsNum -t threshold -c currentThreshold",
+	Short: "Начинает замену частей мастер ключа",
+	Run: func(cmd *cobra.Command, args []string) {
+		parts, _ := cmd.Flags().GetInt("parts")
+		threshold, _ := cmd.Flags().GetInt("threshold")
+		currentThreshold, _ := cmd.Flags().GetInt("current-threshold")
+		if threshold < 2 || parts < threshold || currentThreshold < 2 || currentThreshold > 255 {
+			fmt.Println("Неверно указаны аргументы: 2 <= threshold <= parts <= 255, 2 <= current-threshold <= 255")
+			return
+		}
+
+		rekeyRequest("POST", fmt.Sprintf("/init?parts=%d&threshold=%d&current_threshold=%d", parts, threshold, currentThreshold))
+	},
+}
+
+var rekeyUpdate = &cobra.Command{
+	Use:   "update -n nonce",
+	Short: "Передает текущие части мастер ключа, по одной в строке",
+	Run: func(cmd *cobra.Command, args []string) {
+		nonce, _ := cmd.Flags().GetString("nonce")
+		if nonce == "" {
+			fmt.Println("Необходимо указать nonce, полученный при запуске замены")
+			return
+		}
+
+		for {
+			part := ""
+			fmt.Scanln(&part)
+			if part == "" {
+				break
+			}
+
+			status := rekeyRequest("POST", fmt.Sprintf("/update?nonce=%s&part=%s", url.QueryEscape(nonce), url.QueryEscape(part)))
+			if status != http.StatusAccepted {
+				return
+			}
+		}
+	},
+}
+
+var rekeyShares = &cobra.Command{
+	Use:   "shares -n nonce",
+	Short: "Ожидает завершения замены и выводит одну новую часть мастер ключа",
+	Run: func(cmd *cobra.Command, args []string) {
+		nonce, _ := cmd.Flags().GetString("nonce")
+		if nonce == "" {
+			fmt.Println("Необходимо указать nonce, полученный при запуске замены")
+			return
+		}
+
+		wsURL := strings.Replace(baseURL, "http", "ws", 1) + "rekey/shares?nonce=" + url.QueryEscape(nonce)
+		headers := http.Header{}
+		headers.Add("Authorization", "Bearer "+config.GetToken())
+
+		conn, response, err := websocket.DefaultDialer.Dial(wsURL, headers)
+		if err != nil {
+			if response != nil {
+				buf, _ := io.ReadAll(response.Body)
+				fmt.Printf("Status: %v %s\n", response.StatusCode, buf)
+				return
+			}
+			fmt.Println(err)
+			return
+		}
+		defer conn.Close()
+
+		// the share comes first, it is valid only once the rekey is complete
+		var share, outcome struct {
+			Part     string `json:"part"`
+			Complete bool   `json:"complete"`
+			Error    string `json:"error"`
+		}
+		if err := conn.ReadJSON(&share); err != nil || share.Part == "" {
+			fmt.Println("Замена отменена или не удалась, текущие части остаются действительными")
+			return
+		}
+		if err := conn.ReadJSON(&outcome); err != nil || !outcome.Complete {
+			fmt.Println("Замена не удалась, часть недействительна:", outcome.Error)
+			return
+		}
+		if outcome.Error != "" {
+			fmt.Println("Внимание:", outcome.Error)
+		}
+
+		fmt.Println("Новая часть мастер ключа")
+		fmt.Println(share.Part)
+	},
+}
+
+var rekeyStatus = &cobra.Command{
+	Use:   "status",
+	Short: "Показывает прогресс замены частей мастер ключа",
+	Run: func(cmd *cobra.Command, args []string) {
+		rekeyRequest("GET", "")
+	},
+}
+
+var rekeyCancel = &cobra.Command{
+	Use:   "cancel",
+	Short: "Отменяет замену, текущие части остаются действительными",
+	Run: func(cmd *cobra.Command, args []string) {
+		rekeyRequest("DELETE", "")
+	},
+}
+
+func init() {
+	rekeyInit.Flags().IntP("parts", "p", -1, "Общее число новых частей")
+	rekeyInit.Flags().IntP("threshold", "t", -1, "Число новых частей, необходимое для разблокировки хранилища")
+	rekeyInit.Flags().IntP("current-threshold", "c", -1, "Число текущих частей, необходимое для разблокировки хранилища")
+
+	rekeyUpdate.Flags().StringP("nonce", "n", "", "Nonce замены")
+	rekeyShares.Flags().StringP("nonce", "n", "", "Nonce замены")
+
+	rekey.AddCommand(rekeyInit)
+	rekey.AddCommand(rekeyUpdate)
+	rekey.AddCommand(rekeyShares)
+	rekey.AddCommand(rekeyStatus)
+	rekey.AddCommand(rekeyCancel)
+
+	rootCmd.AddCommand(rekey)
+}
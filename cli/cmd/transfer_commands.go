@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/liriquew/secret_storage/cli/config"
+	"github.com/spf13/cobra"
+)
+
+type importReport struct {
+	Created   []string `json:"created"`
+	Updated   []string `json:"updated"`
+	Unchanged []string `json:"unchanged"`
+	Conflicts []string `json:"conflicts"`
+	DryRun    bool     `json:"dry_run"`
+}
+
+var exportCmd = &cobra.Command{
+	Use:   "export [-p path] [-f json|yaml|env] [-o file] [--skip-files]",
+	Short: "Выгружает все секреты бакета в файл или stdout",
+	Run: func(cmd *cobra.Command, args []string) {
+		format, _ := cmd.Flags().GetString("format")
+		output, _ := cmd.Flags().GetString("output")
+
+		query := url.Values{}
+		query.Set("path", storagePath)
+		query.Set("format", format)
+		if skipFiles, _ := cmd.Flags().GetBool("skip-files"); skipFiles {
+			query.Set("skip_files", "true")
+		}
+
+		response, err := prepareQueryRequest("GET", "export", query)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer response.Body.Close()
+
+		if response.StatusCode != 200 {
+			fmt.Printf("Status: %v\n", response.StatusCode)
+			buf, _ := io.ReadAll(response.Body)
+			if len(buf) != 0 {
+				fmt.Printf("%s\n", buf)
+			}
+			return
+		}
+		for _, file := range response.Header.Values("X-Skipped-File") {
+			if name, err := url.PathUnescape(file); err == nil {
+				file = name
+			}
+			fmt.Fprintf(os.Stderr, "Файл пропущен: %s\n", file)
+		}
+
+		if output == "" {
+			io.Copy(os.Stdout, response.Body)
+			return
+		}
+
+		file, err := os.Create(output)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer file.Close()
+
+		if _, err := io.Copy(file, response.Body); err != nil {
+			fmt.Println(err)
+		}
+	},
+}
+
+var importCmd = &cobra.Command{
+	Use:   "import -i file [-p path] [-f json|yaml|env] [--dry-run] [--overwrite]",
+	Short: "Загружает секреты из файла в бакет одной транзакцией",
+	Run: func(cmd *cobra.Command, args []string) {
+		input, _ := cmd.Flags().GetString("input")
+		format, _ := cmd.Flags().GetString("format")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		overwrite, _ := cmd.Flags().GetBool("overwrite")
+		if input == "" {
+			fmt.Println("Необходимо указать файл")
+			return
+		}
+
+		file, err := os.Open(input)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer file.Close()
+
+		query := url.Values{}
+		query.Set("path", storagePath)
+		query.Set("format", format)
+		query.Set("dry_run", fmt.Sprint(dryRun))
+		query.Set("overwrite", fmt.Sprint(overwrite))
+
+		req, err := http.NewRequest("POST", baseURL+"import?"+query.Encode(), file)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		req.Header.Add("Authorization", "Bearer "+config.GetToken())
+
+		response, err := http.DefaultClient.Do(req)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer response.Body.Close()
+
+		if response.StatusCode != 200 && response.StatusCode != 409 {
+			fmt.Printf("Status: %v\n", response.StatusCode)
+			buf, _ := io.ReadAll(response.Body)
+			if len(buf) != 0 {
+				fmt.Printf("%s\n", buf)
+			}
+			return
+		}
+
+		var report importReport
+		json.NewDecoder(response.Body).Decode(&report)
+
+		fmt.Printf("Создано:\t%s\n", strings.Join(report.Created, ", "))
+		fmt.Printf("Обновлено:\t%s\n", strings.Join(report.Updated, ", "))
+		fmt.Printf("Без изменений:\t%s\n", strings.Join(report.Unchanged, ", "))
+		fmt.Printf("Конфликты:\t%s\n", strings.Join(report.Conflicts, ", "))
+
+		switch {
+		case report.DryRun:
+			fmt.Println("Пробный запуск, изменения не записаны")
+		case response.StatusCode == 409:
+			fmt.Println("Импорт отменен из-за конфликтов, используйте --overwrite для замены существующих значений")
+		}
+	},
+}
+
+func init() {
+	exportCmd.Flags().StringVarP(&storagePath, "path", "p", "", "Путь до бакета в хранилище")
+	exportCmd.Flags().StringP("format", "f", "json", "Формат: json, yaml или env")
+	exportCmd.Flags().StringP("output", "o", "", "Файл для выгрузки, по умолчанию stdout")
+	exportCmd.Flags().Bool("skip-files", false, "Выгрузить секреты без файлов, которые нельзя записать в формат")
+
+	importCmd.Flags().StringVarP(&storagePath, "path", "p", "", "Путь до бакета в хранилище")
+	importCmd.Flags().StringP("format", "f", "json", "Формат: json, yaml или env")
+	importCmd.Flags().StringP("input", "i", "", "Файл с секретами")
+	importCmd.Flags().Bool("dry-run", false, "Только показать изменения")
+	importCmd.Flags().Bool("overwrite", false, "Заменять существующие значения")
+
+	rootCmd.AddCommand(exportCmd)
+	rootCmd.AddCommand(importCmd)
+}
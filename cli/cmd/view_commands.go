@@ -4,13 +4,53 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net/url"
+	"strconv"
 
 	"github.com/spf13/cobra"
 )
 
 type BucketInfo struct {
-	Buckets []string `json:"buckets"`
-	KVs     []KV     `json:"kvs"`
+	Buckets    []string `json:"buckets"`
+	KVs        []KV     `json:"records"`
+	NextCursor string   `json:"next_cursor"`
+}
+
+// listAll requests the bucket page by page following next_cursor
+// and returns all its entries at once.
+func listAll(path string, pageSize int) (*BucketInfo, error) {
+	all := &BucketInfo{}
+
+	query := url.Values{}
+	query.Set("path", path)
+	query.Set("limit", strconv.Itoa(pageSize))
+	for {
+		response, err := prepareQueryRequest("GET", "list", query)
+		if err != nil {
+			return nil, err
+		}
+
+		if response.StatusCode != 200 {
+			buf, _ := io.ReadAll(response.Body)
+			response.Body.Close()
+			return nil, fmt.Errorf("status: %v %s", response.StatusCode, buf)
+		}
+
+		var page BucketInfo
+		err = json.NewDecoder(response.Body).Decode(&page)
+		response.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		all.Buckets = append(all.Buckets, page.Buckets...)
+		all.KVs = append(all.KVs, page.KVs...)
+
+		if page.NextCursor == "" {
+			return all, nil
+		}
+		query.Set("after", page.NextCursor)
+	}
 }
 
 type BucketFullInfo struct {
@@ -30,41 +70,38 @@ func showBuckets(bucket *BucketFullInfo, indent string) {
 }
 
 var listBucket = &cobra.Command{
-	Use:   "list [-p path] [-r]",
+	Use:   "list [-p path] [-r] [-l limit]",
 	Short: "Возвращает элементы в бакете",
 	Run: func(cmd *cobra.Command, args []string) {
 		isRecursion, _ := cmd.Flags().GetBool("recursion")
-		var url string
 		if isRecursion {
-			url = "reclist/" + storagePath
-		} else {
-			url = "list/" + storagePath
-		}
-
-		response, err := prepareRequest("GET", url, nil, true)
-		if err != nil {
-			fmt.Println("Ошибка при выполнении запроса:", err)
-			return
-		}
-		defer response.Body.Close()
+			response, err := prepareRequest("GET", "reclist/"+storagePath, nil, true)
+			if err != nil {
+				fmt.Println("Ошибка при выполнении запроса:", err)
+				return
+			}
+			defer response.Body.Close()
 
-		if response.StatusCode != 200 {
-			fmt.Printf("Status: %v\n", response.StatusCode)
-			buf, _ := io.ReadAll(response.Body)
-			if len(buf) != 0 {
-				fmt.Printf("%s\n", buf)
+			if response.StatusCode != 200 {
+				fmt.Printf("Status: %v\n", response.StatusCode)
+				buf, _ := io.ReadAll(response.Body)
+				if len(buf) != 0 {
+					fmt.Printf("%s\n", buf)
+				}
+				return
 			}
-			return
-		}
 
-		if isRecursion {
 			var data BucketFullInfo
 			json.NewDecoder(response.Body).Decode(&data)
 
 			showBuckets(&data, "")
 		} else {
-			var data BucketInfo
-			json.NewDecoder(response.Body).Decode(&data)
+			pageSize, _ := cmd.Flags().GetInt("limit")
+			data, err := listAll(storagePath, pageSize)
+			if err != nil {
+				fmt.Println("Ошибка при выполнении запроса:", err)
+				return
+			}
 
 			fmt.Println("Buckets:")
 			for _, bucket := range data.Buckets {
@@ -82,6 +119,7 @@ var listBucket = &cobra.Command{
 func init() {
 	listBucket.Flags().StringVarP(&storagePath, "path", "p", "", "Путь до значения в хранилище")
 	listBucket.Flags().BoolP("recursion", "r", false, "Рекурсивное отображение")
+	listBucket.Flags().IntP("limit", "l", 100, "Количество элементов, запрашиваемых за один запрос")
 
 	rootCmd.AddCommand(listBucket)
 }
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+type ChangeEvent struct {
+	Op      string    `json:"op"`
+	Path    string    `json:"path"`
+	Key     string    `json:"key"`
+	Version int       `json:"version"`
+	Time    time.Time `json:"time"`
+}
+
+var watchCmd = &cobra.Command{
+	Use:   "watch [-p path]",
+	Short: "Выводит изменения секретов в бакете и вложенных бакетах по мере их появления",
+	Run: func(cmd *cobra.Command, args []string) {
+		query := url.Values{}
+		query.Set("path", storagePath)
+
+		response, err := prepareQueryRequest("GET", "watch", query)
+		if err != nil {
+			fmt.Println("Ошибка при выполнении запроса:", err)
+			return
+		}
+		defer response.Body.Close()
+
+		if response.StatusCode != 200 {
+			buf, _ := io.ReadAll(response.Body)
+			fmt.Printf("Status: %v %s\n", response.StatusCode, buf)
+			return
+		}
+
+		// the server sends server-sent events, every change is one data line
+		scanner := bufio.NewScanner(response.Body)
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data:")
+			if !ok {
+				continue
+			}
+
+			var event ChangeEvent
+			if err := json.Unmarshal([]byte(data), &event); err != nil {
+				// the server explains why it ends the stream
+				fmt.Println(data)
+				continue
+			}
+
+			location := event.Key
+			if event.Path != "" {
+				location = event.Path + "/" + event.Key
+			}
+			fmt.Printf("%s  %-6s  %s  v%d\n", event.Time.Local().Format(time.DateTime), event.Op, location, event.Version)
+		}
+
+		fmt.Println("Поток изменений прерван, повторите команду")
+	},
+}
+
+func init() {
+	watchCmd.Flags().StringVarP(&storagePath, "path", "p", "", "Путь до отслеживаемого бакета")
+
+	rootCmd.AddCommand(watchCmd)
+}
@@ -3,6 +3,7 @@ module github.com/liriquew/secret_storage/cli
 go 1.24.3
 
 require (
+	github.com/gorilla/websocket v1.5.3
 	github.com/spf13/cobra v1.9.1
 	github.com/spf13/viper v1.20.1
 )
@@ -11,11 +11,21 @@ import (
 	"github.com/liriquew/secret_storage/server/internal/app"
 	"github.com/liriquew/secret_storage/server/internal/lib/config"
 	"github.com/liriquew/secret_storage/server/pkg/logger"
+	"github.com/liriquew/secret_storage/server/pkg/logger/sl"
 )
 
 func main() {
 	cfg := config.MustLoad()
 
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "verify":
+			os.Exit(verify(cfg, os.Args[2:]))
+		case "migrate":
+			os.Exit(migrate(cfg, os.Args[2:]))
+		}
+	}
+
 	log := logger.SetupPrettySlog("SECRET_STORAGE")
 	log.Info("Config is loaded: ", slog.Any("Config", cfg))
 
@@ -33,7 +43,7 @@ func main() {
 	defer cancel()
 
 	if err := application.Stop(ctx); err != nil {
-		log.Error("Error while server shutdown: %w", err)
+		log.Error("Error while server shutdown", sl.Err(err))
 		return
 	}
 
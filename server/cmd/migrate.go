@@ -0,0 +1,33 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/liriquew/secret_storage/server/internal/lib/config"
+	"github.com/liriquew/secret_storage/server/internal/storage"
+)
+
+// migrate upgrades the layout of the database of a stopped server and prints
+// the report as json. With -dry-run the migrations are checked without changes.
+func migrate(cfg config.AppConfig, args []string) int {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "apply migrations and roll them back")
+	flags.Parse(args)
+
+	report, err := storage.Migrate(cfg.Storage, *dryRun)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error while migrating storage:", err)
+		return 1
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
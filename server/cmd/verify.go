@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/liriquew/secret_storage/server/internal/app"
+	"github.com/liriquew/secret_storage/server/internal/lib/config"
+)
+
+// verify runs the offline verification of the storage and prints the report as json.
+// Shares are passed with -share or read from stdin one per line.
+// Exits with 1 if problems are found and with 2 if the storage can't be verified.
+func verify(cfg config.AppConfig, args []string) int {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+
+	var shares []string
+	flags.Func("share", "unseal share, can be repeated", func(share string) error {
+		shares = append(shares, share)
+		return nil
+	})
+	flags.Parse(args)
+
+	if len(shares) == 0 {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			if share := strings.TrimSpace(scanner.Text()); share != "" {
+				shares = append(shares, share)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			fmt.Fprintln(os.Stderr, "error while reading shares:", err)
+			return 2
+		}
+	}
+	if len(shares) == 0 {
+		fmt.Fprintln(os.Stderr, "unseal shares required")
+		return 2
+	}
+
+	report, err := app.Verify(cfg.Storage, shares)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error while verifying storage:", err)
+		return 2
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	if !report.OK {
+		return 1
+	}
+	return 0
+}
@@ -7,6 +7,8 @@ import (
 type Service interface {
 	AuthRequired(*gin.Context)
 	ShamirRequired(*gin.Context)
+	LeaderRequired(*gin.Context)
+	AdminRequired(*gin.Context)
 
 	SignUp(*gin.Context)
 	SignIn(*gin.Context)
@@ -15,9 +17,27 @@ type Service interface {
 	Get(*gin.Context)
 	Delete(*gin.Context)
 	Update(*gin.Context)
+	ListVersions(*gin.Context)
+	Rollback(*gin.Context)
+	Quota(*gin.Context)
+	Undelete(*gin.Context)
+	Destroy(*gin.Context)
+	DeleteBucket(*gin.Context)
+	GetMetadata(*gin.Context)
+	UpdateMetadata(*gin.Context)
+	UploadFile(*gin.Context)
+	DownloadFile(*gin.Context)
+
+	Batch(*gin.Context)
+	Move(*gin.Context)
+	Copy(*gin.Context)
 
 	ListSecrets(*gin.Context)
 	ListSecretsRecursively(*gin.Context)
+	Search(*gin.Context)
+	Export(*gin.Context)
+	Import(*gin.Context)
+	Watch(*gin.Context)
 
 	Unseal(*gin.Context)
 	UnsealComplete(*gin.Context)
@@ -25,6 +45,19 @@ type Service interface {
 	MasterComplete(*gin.Context)
 
 	IsReady(*gin.Context)
+	HAStatus(*gin.Context)
+	Backup(*gin.Context)
+	Restore(*gin.Context)
+	Verify(*gin.Context)
+	Rotate(*gin.Context)
+	RewrapStatus(*gin.Context)
+	PurgeKeys(*gin.Context)
+
+	RekeyStatus(*gin.Context)
+	RekeyInit(*gin.Context)
+	RekeyUpdate(*gin.Context)
+	RekeyShares(*gin.Context)
+	RekeyCancel(*gin.Context)
 }
 
 func CORSMiddleware() gin.HandlerFunc {
@@ -62,7 +95,22 @@ func New(service Service) *gin.Engine {
 		apiGroup.GET("/master", service.Master)
 		apiGroup.GET("/master/complete", service.MasterComplete)
 
+		apiGroup.GET("/sys/ha-status", service.HAStatus)
+		// backups are restored into a sealed storage
+		apiGroup.POST("/sys/restore", service.AuthRequired, service.AdminRequired, service.Restore)
+
 		apiGroup.Use(service.ShamirRequired)
+		apiGroup.Use(service.LeaderRequired)
+
+		// the ceremony is run by an operator, every holder of a new share connects to /rekey/shares
+		rekey := apiGroup.Group("/rekey", service.AuthRequired, service.AdminRequired)
+		{
+			rekey.GET("", service.RekeyStatus)
+			rekey.POST("/init", service.RekeyInit)
+			rekey.POST("/update", service.RekeyUpdate)
+			rekey.GET("/shares", service.RekeyShares)
+			rekey.DELETE("", service.RekeyCancel)
+		}
 
 		apiGroup.POST("/signup", service.SignUp)
 		apiGroup.POST("/signin", service.SignIn)
@@ -75,10 +123,41 @@ func New(service Service) *gin.Engine {
 				sercretManage.GET("/:key", service.Get)
 				sercretManage.DELETE("/:key", service.Delete)
 				sercretManage.PATCH("/:key", service.Update)
+				sercretManage.GET("/:key/versions", service.ListVersions)
+				sercretManage.POST("/:key/rollback", service.Rollback)
+				sercretManage.POST("/:key/undelete", service.Undelete)
+				sercretManage.DELETE("/:key/destroy", service.Destroy)
+				sercretManage.GET("/:key/metadata", service.GetMetadata)
+				sercretManage.PUT("/:key/metadata", service.UpdateMetadata)
+			}
+
+			files := authorized.Group("/files") // query param /api/files/key?path=lvl1/lvl2
+			{
+				files.PUT("/:key", service.UploadFile)
+				files.GET("/:key", service.DownloadFile)
+				files.HEAD("/:key", service.DownloadFile)
 			}
 
+			authorized.DELETE("/buckets", service.DeleteBucket) // query param /api/buckets?path=lvl1/lvl2&recursive=true
+
+			authorized.GET("/quota", service.Quota)
+
+			authorized.POST("/batch", service.Batch)
+			authorized.POST("/move", service.Move)
+			authorized.POST("/copy", service.Copy)
+
 			authorized.GET("/list", service.ListSecrets)
 			authorized.GET("/reclist", service.ListSecretsRecursively)
+			authorized.GET("/search", service.Search)
+			authorized.GET("/export", service.Export)
+			authorized.POST("/import", service.Import)
+			authorized.GET("/watch", service.Watch)
+
+			authorized.GET("/sys/backup", service.AdminRequired, service.Backup)
+			authorized.GET("/sys/verify", service.AdminRequired, service.Verify)
+			authorized.POST("/sys/rotate", service.AdminRequired, service.Rotate)
+			authorized.GET("/sys/rewrap", service.AdminRequired, service.RewrapStatus)
+			authorized.POST("/sys/rewrap/purge", service.AdminRequired, service.PurgeKeys)
 		}
 	}
 
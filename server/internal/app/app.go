@@ -6,6 +6,7 @@ import (
 	"log"
 	"log/slog"
 	"net/http"
+	"sync"
 
 	"github.com/gin-gonic/gin"
 	"github.com/liriquew/secret_storage/server/internal/app/api"
@@ -14,12 +15,16 @@ import (
 )
 
 type App struct {
-	router *gin.Engine
-	srv    *http.Server
+	router  *gin.Engine
+	srv     *http.Server
+	service *service.Service
+
+	stopJobs context.CancelFunc
+	jobs     sync.WaitGroup
 }
 
 func New(log *slog.Logger, cfg config.AppConfig) *App {
-	service := service.New(log, cfg.Storage)
+	service := service.New(log, cfg)
 
 	r := api.New(service)
 
@@ -27,14 +32,38 @@ func New(log *slog.Logger, cfg config.AppConfig) *App {
 		Addr:    fmt.Sprintf(":%d", cfg.Service.Port),
 		Handler: r.Handler(),
 	}
+	// change streams never end by themselves
+	srv.RegisterOnShutdown(service.CloseWatchers)
 
 	return &App{
-		router: r,
-		srv:    srv,
+		router:  r,
+		srv:     srv,
+		service: service,
 	}
 }
 
 func (a *App) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	a.stopJobs = cancel
+
+	a.jobs.Add(4)
+	go func() {
+		defer a.jobs.Done()
+		a.service.RunPurger(ctx)
+	}()
+	go func() {
+		defer a.jobs.Done()
+		a.service.RunReaper(ctx)
+	}()
+	go func() {
+		defer a.jobs.Done()
+		a.service.RunResealer(ctx)
+	}()
+	go func() {
+		defer a.jobs.Done()
+		a.service.RunRewrapper(ctx)
+	}()
+
 	go func() {
 		if err := a.srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("listen: %s\n", err)
@@ -43,5 +72,10 @@ func (a *App) Start() {
 }
 
 func (a *App) Stop(ctx context.Context) error {
+	if a.stopJobs != nil {
+		a.stopJobs()
+	}
+	a.jobs.Wait()
+
 	return a.srv.Shutdown(ctx)
 }
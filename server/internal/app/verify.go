@@ -0,0 +1,39 @@
+package app
+
+import (
+	encryptedstorage "github.com/liriquew/secret_storage/server/internal/encrypted_storage"
+	"github.com/liriquew/secret_storage/server/internal/lib/config"
+	"github.com/liriquew/secret_storage/server/internal/lib/shamir"
+	"github.com/liriquew/secret_storage/server/internal/models"
+)
+
+// Verify checks the storage of a stopped server offline, the master key
+// is combined from unseal shares. The local copy of the database is read
+// even if the node is a part of an HA cluster.
+func Verify(cfg config.StorageConfig, shares []string) (*models.VerifyReport, error) {
+	keyInfo := shamir.NewShamirInfo()
+	for _, share := range shares {
+		if err := keyInfo.AddPart(share); err != nil {
+			return nil, err
+		}
+	}
+
+	parts, err := keyInfo.Parts()
+	if err != nil {
+		return nil, err
+	}
+	masterKey, err := shamir.Combine(parts)
+	if err != nil {
+		return nil, err
+	}
+
+	// verification must not change the database, it's opened read-only
+	// and a database which needs migration isn't verified
+	repository, err := encryptedstorage.OpenReadOnly(cfg, masterKey)
+	if err != nil {
+		return nil, err
+	}
+	defer repository.Close()
+
+	return repository.Verify()
+}
@@ -0,0 +1,56 @@
+package encryptedstorage
+
+import (
+	"crypto/sha256"
+	"io"
+
+	"github.com/liriquew/secret_storage/server/internal/lib/backup"
+	"github.com/liriquew/secret_storage/server/internal/lib/config"
+	"github.com/liriquew/secret_storage/server/internal/storage"
+)
+
+// Backup streams a consistent snapshot of the storage into w wrapped in
+// an envelope encrypted with passphrase. Nothing is written to w if the
+// snapshot can't be taken.
+func (es *EncryptedStorage) Backup(w io.Writer, passphrase []byte) error {
+	err := es.db.Snapshot(func(snapshot io.WriterTo) error {
+		// the first pass computes the checksum for the header,
+		// so the snapshot is never buffered
+		hash := sha256.New()
+		size, err := snapshot.WriteTo(hash)
+		if err != nil {
+			return err
+		}
+
+		envelope, err := backup.NewWriter(w, passphrase, size, hash.Sum(nil))
+		if err != nil {
+			return err
+		}
+
+		if _, err := snapshot.WriteTo(envelope); err != nil {
+			return err
+		}
+
+		return envelope.Close()
+	})
+	if err != nil {
+		return mapStorageErr(err)
+	}
+
+	return nil
+}
+
+// Restore decrypts the backup read from r and swaps it in place of the
+// storage described by cfg, the storage must be sealed.
+func Restore(cfg config.StorageConfig, r io.Reader, passphrase []byte) error {
+	snapshot, err := backup.NewReader(r, passphrase)
+	if err != nil {
+		return err
+	}
+
+	if err := storage.Restore(cfg, snapshot); err != nil {
+		return mapStorageErr(err)
+	}
+
+	return nil
+}
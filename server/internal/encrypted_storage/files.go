@@ -0,0 +1,298 @@
+package encryptedstorage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+
+	"github.com/liriquew/secret_storage/server/internal/models"
+)
+
+// FileChunkSize is the size of chunks files are split into before encryption.
+const FileChunkSize = 256 * 1024
+
+const fileIDSize = 16
+
+var (
+	ErrNotFile       = errors.New("secret is not a file")
+	ErrFileCorrupted = errors.New("file is corrupted")
+)
+
+// chunkAD binds a chunk to its file and its place in the file,
+// so chunks can't be swapped, reordered or dropped unnoticed.
+func chunkAD(id []byte, index int) []byte {
+	return binary.BigEndian.AppendUint32(append([]byte(nil), id...), uint32(index))
+}
+
+// uploadBatchChunks is the number of chunks an upload writes in one transaction.
+const uploadBatchChunks = 16
+
+// Upload writes a file secret a batch of chunks at a time, so it holds
+// in memory only the batch in progress. Chunks are staged until Commit
+// writes the version of the record, an upload which isn't committed
+// must be aborted.
+type Upload struct {
+	es     *EncryptedStorage
+	file   *models.FileManifest
+	digest hash.Hash
+	buf    []byte
+
+	batch     [][]byte
+	staged    int
+	committed bool
+}
+
+func (es *EncryptedStorage) NewUpload(info *models.FileInfo) (*Upload, error) {
+	file := &models.FileManifest{
+		ID:          make([]byte, fileIDSize),
+		Name:        info.Name,
+		ContentType: info.ContentType,
+		ChunkSize:   FileChunkSize,
+	}
+	if _, err := rand.Read(file.ID); err != nil {
+		return nil, err
+	}
+
+	return &Upload{
+		es:     es,
+		file:   file,
+		digest: sha256.New(),
+		buf:    make([]byte, FileChunkSize),
+	}, nil
+}
+
+// ReadBatch reads the next batch of chunks from r and encrypts them,
+// it reports whether r is exhausted.
+func (u *Upload) ReadBatch(r io.Reader) (bool, error) {
+	u.batch = u.batch[:0]
+	for len(u.batch) < uploadBatchChunks {
+		n, err := io.ReadFull(r, u.buf)
+		if n > 0 {
+			u.digest.Write(u.buf[:n])
+			u.file.Size += int64(n)
+
+			chunk, err := u.es.crypter.Seal(u.buf[:n], chunkAD(u.file.ID, u.file.Chunks))
+			if err != nil {
+				return false, err
+			}
+			u.batch = append(u.batch, chunk)
+			u.file.Chunks++
+		}
+
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// WriteBatch stages the batch read last.
+func (u *Upload) WriteBatch() error {
+	// an empty file is staged too, the commit finds it by its id
+	if len(u.batch) == 0 && u.staged > 0 {
+		return nil
+	}
+
+	if err := u.es.db.StageChunks(u.file.ID, u.staged, u.batch); err != nil {
+		return mapStorageErr(err)
+	}
+	u.staged += len(u.batch)
+	u.batch = u.batch[:0]
+	return nil
+}
+
+// Commit writes the staged content as a new version of the record.
+func (u *Upload) Commit(path []string, key string, opts models.WriteOptions) (int, error) {
+	var err error
+	u.file.Digest, err = u.es.seal(u.digest.Sum(nil), fileAD(path, key, u.file.ID))
+	if err != nil {
+		return 0, err
+	}
+
+	version, err := u.es.db.SetFile(path, key, u.file, opts)
+	if err != nil {
+		return 0, mapStorageErr(err)
+	}
+
+	u.committed = true
+	return version, nil
+}
+
+// Abort drops the staged chunks of the upload, it does nothing once the upload is committed.
+func (u *Upload) Abort() error {
+	if u.committed || u.staged == 0 {
+		return nil
+	}
+	return u.es.db.DropUpload(u.file.ID)
+}
+
+// SetFile reads the content from r chunk by chunk, encrypts every chunk
+// separately and writes them as a new version of the record.
+func (es *EncryptedStorage) SetFile(path []string, key string, r io.Reader, info *models.FileInfo, opts models.WriteOptions) (int, error) {
+	upload, err := es.NewUpload(info)
+	if err != nil {
+		return 0, err
+	}
+	defer upload.Abort()
+
+	for done := false; !done; {
+		if done, err = upload.ReadBatch(r); err != nil {
+			return 0, err
+		}
+		if err := upload.WriteBatch(); err != nil {
+			return 0, err
+		}
+	}
+
+	return upload.Commit(path, key, opts)
+}
+
+// DropStaleUploads removes uploads started before the moment before and never committed.
+func (es *EncryptedStorage) DropStaleUploads(before time.Time) (int, error) {
+	return es.db.DropStaleUploads(before)
+}
+
+// File reads the content of a version of a file secret, chunks are read
+// and decrypted on demand. Reading the whole content from the start
+// also checks it against the digest taken on upload.
+type File struct {
+	Record *models.Record
+	// Digest is the sha256 of the content
+	Digest []byte
+
+	es   *EncryptedStorage
+	path []string
+	key  string
+
+	offset     int64
+	chunk      []byte
+	chunkIndex int
+
+	hash   hash.Hash
+	hashed int64
+	err    error
+}
+
+func (es *EncryptedStorage) OpenFile(path []string, key string, version int) (*File, error) {
+	record, err := es.db.GetRecord(path, key, version)
+	if err != nil {
+		return nil, mapStorageErr(err)
+	}
+	if record.File == nil {
+		return nil, ErrNotFile
+	}
+
+	digest, err := es.open(record.File.Digest, fileAD(path, key, record.File.ID), record.File.ID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad digest", ErrFileCorrupted)
+	}
+
+	return &File{
+		Record:     record,
+		Digest:     digest,
+		es:         es,
+		path:       path,
+		key:        key,
+		chunkIndex: -1,
+		hash:       sha256.New(),
+	}, nil
+}
+
+func (f *File) Info() *models.FileInfo {
+	return f.Record.File.Info()
+}
+
+// Err returns the error which interrupted reading, if any.
+func (f *File) Err() error {
+	return f.err
+}
+
+func (f *File) Read(p []byte) (int, error) {
+	if f.err != nil {
+		return 0, f.err
+	}
+
+	manifest := f.Record.File
+	if f.offset >= manifest.Size {
+		return 0, io.EOF
+	}
+
+	index := int(f.offset / int64(manifest.ChunkSize))
+	if index != f.chunkIndex {
+		if err := f.loadChunk(index); err != nil {
+			f.err = err
+			return 0, err
+		}
+	}
+
+	n := copy(p, f.chunk[f.offset-int64(index)*int64(manifest.ChunkSize):])
+	if f.hashed == f.offset {
+		f.hash.Write(p[:n])
+		f.hashed += int64(n)
+	}
+	f.offset += int64(n)
+
+	if f.hashed == manifest.Size && !bytes.Equal(f.hash.Sum(nil), f.Digest) {
+		f.err = fmt.Errorf("%w: digest mismatch", ErrFileCorrupted)
+		return n, f.err
+	}
+	return n, nil
+}
+
+func (f *File) loadChunk(index int) error {
+	manifest := f.Record.File
+
+	ciphertext, err := f.es.db.GetFileChunk(f.path, f.key, f.Record.Version, index)
+	if err != nil {
+		return mapStorageErr(err)
+	}
+
+	chunk, err := f.es.crypter.Open(ciphertext, chunkAD(manifest.ID, index))
+	if err != nil {
+		return fmt.Errorf("%w: chunk %d", ErrFileCorrupted, index)
+	}
+
+	expected := manifest.ChunkSize
+	if index == manifest.Chunks-1 {
+		expected = int(manifest.Size - int64(index)*int64(manifest.ChunkSize))
+	}
+	if len(chunk) != expected {
+		return fmt.Errorf("%w: chunk %d has size %d", ErrFileCorrupted, index, len(chunk))
+	}
+
+	f.chunk = chunk
+	f.chunkIndex = index
+	return nil
+}
+
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.Record.File.Size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	if offset == 0 {
+		// reading from the start checks the digest again
+		f.hash.Reset()
+		f.hashed = 0
+	}
+	f.offset = offset
+	return offset, nil
+}
@@ -0,0 +1,117 @@
+package encryptedstorage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/liriquew/secret_storage/server/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func uploadTestFile(t *testing.T, es *EncryptedStorage, path []string, key string, content []byte) {
+	_, err := es.SetFile(path, key, bytes.NewReader(content), &models.FileInfo{Name: key}, models.WriteOptions{})
+	require.NoError(t, err)
+}
+
+func readTestFile(t *testing.T, es *EncryptedStorage, path []string, key string) ([]byte, error) {
+	f, err := es.OpenFile(path, key, 0)
+	require.NoError(t, err)
+	return io.ReadAll(f)
+}
+
+// fileChunks returns the manifest and the stored chunks of the current version of a file.
+func fileChunks(t *testing.T, es *EncryptedStorage, path []string, key string) (*models.FileManifest, [][]byte) {
+	record, err := es.db.GetRecord(path, key, 0)
+	require.NoError(t, err)
+	require.NotNil(t, record.File)
+
+	var chunks [][]byte
+	for index := range record.File.Chunks {
+		chunk, err := es.db.GetFileChunk(path, key, record.Version, index)
+		require.NoError(t, err)
+		chunks = append(chunks, chunk)
+	}
+	return record.File, chunks
+}
+
+// replaceFile writes the file anew with the given chunks under the same manifest.
+func replaceFile(t *testing.T, es *EncryptedStorage, path []string, key string, file *models.FileManifest, chunks [][]byte) {
+	_, err := es.Destroy(path, key)
+	require.NoError(t, err)
+
+	require.NoError(t, es.db.StageChunks(file.ID, 0, chunks))
+	_, err = es.db.SetFile(path, key, file, models.WriteOptions{})
+	require.NoError(t, err)
+}
+
+func TestFileUpload(t *testing.T) {
+	es := newTestStorage(t, testConfig(t), testMasterKey)
+	path := []string{"alice", "files"}
+
+	// more chunks than a single batch holds, the last one is short
+	content := make([]byte, (uploadBatchChunks+1)*FileChunkSize+7)
+	rand.Read(content)
+	uploadTestFile(t, es, path, "archive", content)
+
+	read, err := readTestFile(t, es, path, "archive")
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(content, read))
+
+	uploadTestFile(t, es, path, "empty", nil)
+	read, err = readTestFile(t, es, path, "empty")
+	require.NoError(t, err)
+	assert.Empty(t, read)
+
+	t.Run("Aborted", func(t *testing.T) {
+		upload, err := es.NewUpload(&models.FileInfo{Name: "aborted"})
+		require.NoError(t, err)
+		_, err = upload.ReadBatch(bytes.NewReader(content[:FileChunkSize]))
+		require.NoError(t, err)
+		require.NoError(t, upload.WriteBatch())
+		require.NoError(t, upload.Abort())
+
+		dropped, err := es.DropStaleUploads(time.Now())
+		require.NoError(t, err)
+		assert.Zero(t, dropped)
+	})
+}
+
+func TestFileChunkBinding(t *testing.T) {
+	es := newTestStorage(t, testConfig(t), testMasterKey)
+	path := []string{"alice", "files"}
+
+	content := make([]byte, 3*FileChunkSize)
+	rand.Read(content)
+	uploadTestFile(t, es, path, "a", content)
+	uploadTestFile(t, es, path, "b", content)
+
+	file, chunks := fileChunks(t, es, path, "a")
+	_, other := fileChunks(t, es, path, "b")
+
+	t.Run("Swapped Indexes", func(t *testing.T) {
+		replaceFile(t, es, path, "a", file, [][]byte{chunks[1], chunks[0], chunks[2]})
+
+		_, err := readTestFile(t, es, path, "a")
+		assert.ErrorIs(t, err, ErrFileCorrupted)
+	})
+
+	t.Run("Chunk Of Another File", func(t *testing.T) {
+		// the same content at the same index, but sealed for another file
+		replaceFile(t, es, path, "a", file, [][]byte{chunks[0], other[1], chunks[2]})
+
+		_, err := readTestFile(t, es, path, "a")
+		assert.ErrorIs(t, err, ErrFileCorrupted)
+	})
+
+	t.Run("Intact", func(t *testing.T) {
+		replaceFile(t, es, path, "a", file, chunks)
+
+		read, err := readTestFile(t, es, path, "a")
+		require.NoError(t, err)
+		assert.True(t, bytes.Equal(content, read))
+	})
+}
@@ -0,0 +1,526 @@
+package encryptedstorage
+
+import (
+	"bytes"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/liriquew/secret_storage/server/internal/lib/encrypt"
+	"github.com/liriquew/secret_storage/server/internal/models"
+	"github.com/liriquew/secret_storage/server/internal/storage"
+)
+
+// Values, names and file chunks are sealed with data keys of the keyring, the keyring
+// itself is sealed with the master key and stored in the meta bucket. Every ciphertext
+// starts with the term of its data key:
+//
+//	"ssk" <uint32 term> <nonce> <ciphertext>
+//
+// and the header is a part of the associated data. Ciphertexts without the header were
+// sealed with the master key before the keyring existed, in such databases the master
+// key becomes the data key of term 1.
+var keyringHeader = []byte("ssk")
+
+const (
+	keyringAD      = "secret storage keyring"
+	dataKeySize    = 32
+	termHeaderSize = 3 + 4
+)
+
+var (
+	ErrUnknownKeyTerm   = errors.New("unknown data key term")
+	ErrKeyringCorrupted = errors.New("keyring can't be unwrapped")
+	// ErrMasterKeyMismatch is returned when a key other than the master key
+	// is used to unseal a database without the keyring or to rekey
+	ErrMasterKeyMismatch = errors.New("master key doesn't match the stored data")
+)
+
+type dataKey struct {
+	Term      uint32    `json:"term"`
+	Key       []byte    `json:"key"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// keyringState is the wrapped content of the keyring.
+type keyringState struct {
+	Active uint32 `json:"active"`
+	// BlindKey keys blind indexes of names, it never rotates
+	// since indexes are looked up by recomputing them
+	BlindKey []byte     `json:"blind_key"`
+	Keys     []*dataKey `json:"keys"`
+}
+
+// keyring seals with the active data key and opens with the key named
+// by the ciphertext. It implements Erypter, so callers don't see terms.
+type keyring struct {
+	db Storage
+
+	m sync.RWMutex
+	// master is replaced by a rekey
+	master *encrypt.EncryptWrapper
+	state  *keyringState
+	// wrapped is the stored keyring the state is unwrapped from
+	wrapped  []byte
+	crypters map[uint32]*encrypt.EncryptWrapper
+}
+
+// openKeyring unwraps the keyring of the database, a database without one gets
+// its keyring created. Sealed data of an older database stays readable because
+// the master key becomes its first data key.
+func openKeyring(db Storage, masterKey []byte) (*keyring, error) {
+	master, err := encrypt.NewEncrypter(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	k := &keyring{db: db, master: master}
+
+	if db.Keyring() == nil {
+		err = db.UpdateKeyring(func(wrapped []byte, sample *storage.SealedSample) ([]byte, error) {
+			if wrapped != nil {
+				return nil, nil
+			}
+
+			// the key becomes the first data key, a wrong one would lock the database for good
+			if err := checkSample(master, sample); err != nil {
+				return nil, err
+			}
+
+			state, err := newKeyringState(masterKey, sample != nil)
+			if err != nil {
+				return nil, err
+			}
+			return k.wrap(state)
+		})
+		if err != nil {
+			return nil, mapStorageErr(err)
+		}
+	}
+
+	if err := k.refresh(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// viewKeyring is openKeyring for a database opened read-only. The keyring of a database
+// without one is made in memory and never stored, it only opens data sealed so far.
+func viewKeyring(db Storage, masterKey []byte) (*keyring, error) {
+	master, err := encrypt.NewEncrypter(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	k := &keyring{db: db, master: master}
+
+	if db.Keyring() != nil {
+		if err := k.refresh(); err != nil {
+			return nil, err
+		}
+		return k, nil
+	}
+
+	sample, err := db.SealedSample()
+	if err != nil {
+		return nil, mapStorageErr(err)
+	}
+	if err := checkSample(master, sample); err != nil {
+		return nil, err
+	}
+
+	state, err := newKeyringState(masterKey, sample != nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := k.use(state, nil); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// checkSample opens data sealed before the keyring with the master key.
+func checkSample(master *encrypt.EncryptWrapper, sample *storage.SealedSample) error {
+	var err error
+	switch {
+	case sample == nil:
+		return nil
+	case sample.Value == nil:
+		_, err = master.Open(sample.Name, []byte(sample.Blind))
+	case sample.Value.File != nil:
+		file := sample.Value.File
+		path, key := splitLocation(sample.Value.Location)
+		_, err = openValue(master, file.Digest, fileAD(path, key, file.ID), file.ID, false)
+	default:
+		path, key := splitLocation(sample.Value.Location)
+		_, err = openValue(master, sample.Value.Value, locationAD(path, key), nil, false)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrMasterKeyMismatch, err)
+	}
+	return nil
+}
+
+func newKeyringState(masterKey []byte, sealed bool) (*keyringState, error) {
+	first := &dataKey{Term: 1, CreatedAt: time.Now().UTC()}
+	var blindKey []byte
+	if sealed {
+		// keep the keys everything was sealed and blinded with
+		first.Key = slices.Clone(masterKey)
+		var err error
+		blindKey, err = hkdf.Key(sha256.New, masterKey, nil, blindKeyInfo, sha256.Size)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		first.Key = make([]byte, dataKeySize)
+		blindKey = make([]byte, sha256.Size)
+		if _, err := rand.Read(first.Key); err != nil {
+			return nil, err
+		}
+		if _, err := rand.Read(blindKey); err != nil {
+			return nil, err
+		}
+	}
+
+	return &keyringState{Active: 1, BlindKey: blindKey, Keys: []*dataKey{first}}, nil
+}
+
+func (k *keyring) masterCrypter() *encrypt.EncryptWrapper {
+	k.m.RLock()
+	defer k.m.RUnlock()
+	return k.master
+}
+
+func (k *keyring) wrap(state *keyringState) ([]byte, error) {
+	return wrapKeyring(k.masterCrypter(), state)
+}
+
+func (k *keyring) unwrap(wrapped []byte) (*keyringState, error) {
+	return unwrapKeyring(k.masterCrypter(), wrapped)
+}
+
+func wrapKeyring(master *encrypt.EncryptWrapper, state *keyringState) ([]byte, error) {
+	raw, err := json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+	return master.Seal(raw, []byte(keyringAD))
+}
+
+func unwrapKeyring(master *encrypt.EncryptWrapper, wrapped []byte) (*keyringState, error) {
+	raw, err := master.Open(wrapped, []byte(keyringAD))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrKeyringCorrupted, err)
+	}
+
+	state := &keyringState{}
+	if err := json.Unmarshal(raw, state); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrKeyringCorrupted, err)
+	}
+	return state, nil
+}
+
+// refresh unwraps the stored keyring once it changes, so rotations replicated
+// from the leader of the HA cluster are picked up like the local ones. A keyring
+// the master key of this node can't unwrap was rekeyed on another node, the data
+// keys known so far stay in use until the node is unsealed with the new shares.
+func (k *keyring) refresh() error {
+	wrapped := k.db.Keyring()
+	if wrapped == nil {
+		return fmt.Errorf("%w: keyring is missing", ErrKeyringCorrupted)
+	}
+
+	k.m.RLock()
+	same := bytes.Equal(wrapped, k.wrapped)
+	k.m.RUnlock()
+	if same {
+		return nil
+	}
+
+	state, err := k.unwrap(wrapped)
+	if err != nil {
+		k.m.Lock()
+		defer k.m.Unlock()
+		if k.state == nil {
+			return err
+		}
+		k.wrapped = wrapped
+		return nil
+	}
+
+	return k.use(state, wrapped)
+}
+
+// use makes state the current keyring, wrapped is the stored keyring it is unwrapped from.
+func (k *keyring) use(state *keyringState, wrapped []byte) error {
+	crypters := make(map[uint32]*encrypt.EncryptWrapper, len(state.Keys))
+	for _, key := range state.Keys {
+		crypter, err := encrypt.NewEncrypter(key.Key)
+		if err != nil {
+			return fmt.Errorf("%w: term %d: %w", ErrKeyringCorrupted, key.Term, err)
+		}
+		crypters[key.Term] = crypter
+	}
+	if crypters[state.Active] == nil {
+		return fmt.Errorf("%w: active term %d", ErrKeyringCorrupted, state.Active)
+	}
+
+	k.m.Lock()
+	defer k.m.Unlock()
+	k.state, k.wrapped, k.crypters = state, wrapped, crypters
+	return nil
+}
+
+func (k *keyring) blindKey() []byte {
+	k.m.RLock()
+	defer k.m.RUnlock()
+	return k.state.BlindKey
+}
+
+// rotate adds a data key and makes it active, older keys stay for opening.
+func (k *keyring) rotate() (*models.KeyringStatus, error) {
+	err := k.db.UpdateKeyring(func(wrapped []byte, _ *storage.SealedSample) ([]byte, error) {
+		if wrapped == nil {
+			return nil, ErrKeyringCorrupted
+		}
+
+		// the stored keyring is the latest one even if this node missed a rotation
+		state, err := k.unwrap(wrapped)
+		if err != nil {
+			return nil, err
+		}
+
+		key := &dataKey{Key: make([]byte, dataKeySize), CreatedAt: time.Now().UTC()}
+		if _, err := rand.Read(key.Key); err != nil {
+			return nil, err
+		}
+		for _, existing := range state.Keys {
+			key.Term = max(key.Term, existing.Term)
+		}
+		key.Term++
+
+		state.Keys = append(state.Keys, key)
+		state.Active = key.Term
+		return k.wrap(state)
+	})
+	if err != nil {
+		return nil, mapStorageErr(err)
+	}
+
+	if err := k.refresh(); err != nil {
+		return nil, err
+	}
+	return k.status(), nil
+}
+
+// purge removes the keys of terms below term except the active one,
+// nothing may be sealed with them any more.
+func (k *keyring) purge(term uint32) (*models.KeyringStatus, error) {
+	err := k.db.UpdateKeyring(func(wrapped []byte, _ *storage.SealedSample) ([]byte, error) {
+		if wrapped == nil {
+			return nil, ErrKeyringCorrupted
+		}
+
+		state, err := k.unwrap(wrapped)
+		if err != nil {
+			return nil, err
+		}
+
+		kept := slices.DeleteFunc(slices.Clone(state.Keys), func(key *dataKey) bool {
+			return key.Term < term && key.Term != state.Active
+		})
+		if len(kept) == len(state.Keys) {
+			return nil, nil
+		}
+		state.Keys = kept
+		return k.wrap(state)
+	})
+	if err != nil {
+		return nil, mapStorageErr(err)
+	}
+
+	if err := k.refresh(); err != nil {
+		return nil, err
+	}
+	return k.status(), nil
+}
+
+// rekey wraps the keyring with newKey, oldKey must unwrap the stored one. Data keys
+// don't change, so nothing sealed with them is touched. The returned term is the one
+// whose data key is oldKey, it is kept by databases created before the keyring.
+func (k *keyring) rekey(oldKey, newKey []byte) (uint32, error) {
+	old, err := encrypt.NewEncrypter(oldKey)
+	if err != nil {
+		return 0, err
+	}
+	master, err := encrypt.NewEncrypter(newKey)
+	if err != nil {
+		return 0, err
+	}
+
+	var legacyTerm uint32
+	err = k.db.UpdateKeyring(func(wrapped []byte, _ *storage.SealedSample) ([]byte, error) {
+		if wrapped == nil {
+			return nil, ErrKeyringCorrupted
+		}
+
+		state, err := unwrapKeyring(old, wrapped)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrMasterKeyMismatch, err)
+		}
+
+		legacyTerm = 0
+		for _, key := range state.Keys {
+			if bytes.Equal(key.Key, oldKey) {
+				legacyTerm = key.Term
+			}
+		}
+		return wrapKeyring(master, state)
+	})
+	if err != nil {
+		return 0, mapStorageErr(err)
+	}
+
+	k.m.Lock()
+	k.master = master
+	k.m.Unlock()
+	return legacyTerm, k.refresh()
+}
+
+// check returns ErrMasterKeyMismatch if key doesn't unwrap the stored keyring.
+func (k *keyring) check(key []byte) error {
+	master, err := encrypt.NewEncrypter(key)
+	if err != nil {
+		return err
+	}
+
+	wrapped := k.db.Keyring()
+	if wrapped == nil {
+		return fmt.Errorf("%w: keyring is missing", ErrKeyringCorrupted)
+	}
+	if _, err := unwrapKeyring(master, wrapped); err != nil {
+		return fmt.Errorf("%w: %w", ErrMasterKeyMismatch, err)
+	}
+	return nil
+}
+
+func (k *keyring) activeTerm() uint32 {
+	k.m.RLock()
+	defer k.m.RUnlock()
+	return k.state.Active
+}
+
+// sealedTerm returns the term of the key ciphertext is sealed with,
+// false for ones sealed before the keyring.
+func sealedTerm(ciphertext []byte) (uint32, bool) {
+	if len(ciphertext) < termHeaderSize || !bytes.HasPrefix(ciphertext, keyringHeader) {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(ciphertext[len(keyringHeader):termHeaderSize]), true
+}
+
+func (k *keyring) status() *models.KeyringStatus {
+	k.m.RLock()
+	defer k.m.RUnlock()
+
+	status := &models.KeyringStatus{ActiveTerm: int(k.state.Active)}
+	for _, key := range k.state.Keys {
+		status.Keys = append(status.Keys, &models.DataKeyInfo{
+			Term:      int(key.Term),
+			CreatedAt: key.CreatedAt,
+			Active:    key.Term == k.state.Active,
+		})
+	}
+	return status
+}
+
+func termHeader(term uint32) []byte {
+	return binary.BigEndian.AppendUint32(slices.Clone(keyringHeader), term)
+}
+
+// crypter returns the key of term, an unknown term refreshes the keyring once.
+func (k *keyring) crypter(term uint32) (*encrypt.EncryptWrapper, error) {
+	k.m.RLock()
+	crypter := k.crypters[term]
+	k.m.RUnlock()
+	if crypter != nil {
+		return crypter, nil
+	}
+
+	if err := k.refresh(); err != nil {
+		return nil, err
+	}
+
+	k.m.RLock()
+	defer k.m.RUnlock()
+	if crypter = k.crypters[term]; crypter == nil {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownKeyTerm, term)
+	}
+	return crypter, nil
+}
+
+func (k *keyring) Seal(plaintext, additionalData []byte) ([]byte, error) {
+	if err := k.refresh(); err != nil {
+		return nil, err
+	}
+
+	k.m.RLock()
+	term := k.state.Active
+	crypter := k.crypters[term]
+	k.m.RUnlock()
+
+	header := termHeader(term)
+	ciphertext, err := crypter.Seal(plaintext, append(slices.Clone(header), additionalData...))
+	if err != nil {
+		return nil, err
+	}
+	return append(header, ciphertext...), nil
+}
+
+func (k *keyring) Open(ciphertext, additionalData []byte) ([]byte, error) {
+	term, ok := sealedTerm(ciphertext)
+	if !ok {
+		return k.openLegacy(ciphertext, additionalData)
+	}
+
+	header := ciphertext[:termHeaderSize]
+	crypter, err := k.crypter(term)
+	if err == nil {
+		var plaintext []byte
+		plaintext, err = crypter.Open(ciphertext[termHeaderSize:], append(slices.Clone(header), additionalData...))
+		if err == nil {
+			return plaintext, nil
+		}
+	}
+
+	// a ciphertext sealed before the keyring may start with the header by chance
+	if plaintext, legacyErr := k.openLegacy(ciphertext, additionalData); legacyErr == nil {
+		return plaintext, nil
+	}
+	return nil, err
+}
+
+// openLegacy opens ciphertexts sealed before the keyring with the key of term 1.
+func (k *keyring) openLegacy(ciphertext, additionalData []byte) ([]byte, error) {
+	k.m.RLock()
+	crypter := k.crypters[1]
+	k.m.RUnlock()
+	if crypter == nil {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownKeyTerm, 1)
+	}
+	return crypter.Open(ciphertext, additionalData)
+}
+
+func (k *keyring) Encrypt(plaintext []byte) ([]byte, error) {
+	return k.Seal(plaintext, nil)
+}
+
+func (k *keyring) Decrypt(ciphertext []byte) ([]byte, error) {
+	return k.Open(ciphertext, nil)
+}
@@ -0,0 +1,120 @@
+package encryptedstorage
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/liriquew/secret_storage/server/internal/lib/config"
+	"github.com/liriquew/secret_storage/server/internal/lib/encrypt"
+	"github.com/liriquew/secret_storage/server/internal/models"
+	"github.com/liriquew/secret_storage/server/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// storedTerm returns the term of the key the current version of a value is sealed with.
+func storedTerm(t *testing.T, es *EncryptedStorage, path []string, key string) uint32 {
+	record, err := es.db.GetRecord(path, key, 0)
+	require.NoError(t, err)
+	require.False(t, isLegacyValue(record.Value))
+
+	term, ok := sealedTerm(record.Value[len(boundHeader):])
+	require.True(t, ok)
+	return term
+}
+
+func TestRotate(t *testing.T) {
+	cfg := testConfig(t)
+	es := newTestStorage(t, cfg, testMasterKey)
+
+	path := []string{"alice", "app"}
+	_, err := es.Set(path, "old", []byte("sealed before"), models.WriteOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, uint32(1), storedTerm(t, es, path, "old"))
+
+	status, err := es.Rotate()
+	require.NoError(t, err)
+	assert.Equal(t, 2, status.ActiveTerm)
+	assert.Len(t, status.Keys, 2)
+
+	record, err := es.Get(path, "old", 0)
+	require.NoError(t, err)
+	assert.Equal(t, "sealed before", string(record.Value))
+	assert.Equal(t, uint32(1), storedTerm(t, es, path, "old"))
+
+	_, err = es.Set(path, "new", []byte("sealed after"), models.WriteOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, uint32(2), storedTerm(t, es, path, "new"))
+
+	// a new version of an old secret is sealed with the new key too
+	_, err = es.Set(path, "old", []byte("updated"), models.WriteOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, uint32(2), storedTerm(t, es, path, "old"))
+
+	record, err = es.Get(path, "old", 1)
+	require.NoError(t, err)
+	assert.Equal(t, "sealed before", string(record.Value))
+
+	// the rotated keyring is stored, the reopened storage seals with the new key
+	require.NoError(t, es.Close())
+	es = newTestStorage(t, cfg, testMasterKey)
+	assert.Equal(t, uint32(2), es.keyring.activeTerm())
+	record, err = es.Get(path, "old", 1)
+	require.NoError(t, err)
+	assert.Equal(t, "sealed before", string(record.Value))
+}
+
+// newLegacyDatabase writes a value sealed with masterKey the way it was done
+// before the keyring, the database has no keyring yet.
+func newLegacyDatabase(t *testing.T, masterKey []byte) (cfg config.StorageConfig, path []string, key string) {
+	cfg = testConfig(t)
+	cfg.BlindNames = false
+
+	db, err := storage.New(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+
+	master, err := encrypt.NewEncrypter(masterKey)
+	require.NoError(t, err)
+	sealed, err := master.Seal([]byte("legacy"), nil)
+	require.NoError(t, err)
+
+	path, key = []string{"alice", "app"}, "token"
+	_, err = db.SetRecord(path, key, sealed, models.WriteOptions{})
+	require.NoError(t, err)
+	require.Nil(t, db.Keyring())
+	return cfg, path, key
+}
+
+func TestKeyringOfLegacyDatabase(t *testing.T) {
+	t.Run("Wrong Master Key", func(t *testing.T) {
+		cfg, _, _ := newLegacyDatabase(t, testMasterKey)
+
+		_, err := New(cfg, bytes.Repeat([]byte{0x11}, 32))
+		require.ErrorIs(t, err, ErrMasterKeyMismatch)
+
+		// the wrong key doesn't become the first data key
+		db, err := storage.New(cfg)
+		require.NoError(t, err)
+		assert.Nil(t, db.Keyring())
+		require.NoError(t, db.Close())
+	})
+
+	t.Run("Master Key", func(t *testing.T) {
+		cfg, path, key := newLegacyDatabase(t, testMasterKey)
+
+		es := newTestStorage(t, cfg, testMasterKey)
+		assert.NotNil(t, es.db.Keyring())
+		assert.ErrorIs(t, es.CheckMasterKey(bytes.Repeat([]byte{0x11}, 32)), ErrMasterKeyMismatch)
+		require.NoError(t, es.CheckMasterKey(testMasterKey))
+
+		record, err := es.Get(path, key, 0)
+		require.NoError(t, err)
+		assert.Equal(t, "legacy", string(record.Value))
+
+		// the master key is the first data key, new values get a term header
+		_, err = es.Set(path, "new", []byte("value"), models.WriteOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, uint32(1), storedTerm(t, es, path, "new"))
+	})
+}
@@ -0,0 +1,53 @@
+package encryptedstorage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	// blindKeyInfo derives the blind key of databases created before the keyring
+	blindKeyInfo = "secret storage blind names"
+	// blind indexes are truncated, 128 bits are enough to avoid collisions
+	blindSize = 16
+)
+
+// nameCodec makes blind indexes of names with HMAC-SHA256 under the blind key
+// of the keyring, real names are sealed bound to their indexes. The parent location
+// is mixed into the index, so the same name gets different indexes in different
+// places and for different users.
+type nameCodec struct {
+	key     []byte
+	crypter Erypter
+}
+
+func newNameCodec(key []byte, crypter Erypter) *nameCodec {
+	return &nameCodec{key: key, crypter: crypter}
+}
+
+func (c *nameCodec) Blind(scope []string, name string) string {
+	mac := hmac.New(sha256.New, c.key)
+	// parts are prefixed with their lengths to keep locations apart
+	var buf []byte
+	for _, part := range append(scope[:len(scope):len(scope)], name) {
+		buf = binary.AppendUvarint(buf, uint64(len(part)))
+		buf = append(buf, part...)
+	}
+	mac.Write(buf)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:blindSize])
+}
+
+func (c *nameCodec) Seal(blind string, name string) ([]byte, error) {
+	return c.crypter.Seal([]byte(name), []byte(blind))
+}
+
+func (c *nameCodec) Open(blind string, sealed []byte) (string, error) {
+	name, err := c.crypter.Open(sealed, []byte(blind))
+	if err != nil {
+		return "", fmt.Errorf("error while opening name %s: %w", blind, err)
+	}
+	return string(name), nil
+}
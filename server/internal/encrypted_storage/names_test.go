@@ -0,0 +1,29 @@
+package encryptedstorage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlindNameScope(t *testing.T) {
+	es := newTestStorage(t, testConfig(t), testMasterKey)
+	codec := newNameCodec(es.keyring.blindKey(), es.keyring)
+
+	blind := codec.Blind([]string{"alice", "app"}, "token")
+	assert.Equal(t, blind, codec.Blind([]string{"alice", "app"}, "token"))
+
+	for _, other := range []struct {
+		scope []string
+		name  string
+	}{
+		{[]string{"bob", "app"}, "token"},
+		{[]string{"alice"}, "token"},
+		{nil, "token"},
+		// parts are kept apart whatever they are made of
+		{[]string{"alice", "ap"}, "ptoken"},
+		{[]string{"alice", "app", "token"}, ""},
+	} {
+		assert.NotEqual(t, blind, codec.Blind(other.scope, other.name), "%v/%s", other.scope, other.name)
+	}
+}
@@ -2,6 +2,9 @@ package encryptedstorage
 
 import (
 	"errors"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/liriquew/secret_storage/server/internal/models"
 	"github.com/liriquew/secret_storage/server/internal/storage"
@@ -12,7 +15,6 @@ var (
 	recordsBucketName = []byte("kv")
 	userBucketName    = []byte("user")
 	metaBucketName    = []byte("meta")
-	metaTokenName     = []byte("token")
 )
 
 var (
@@ -21,67 +23,235 @@ var (
 	ErrIncorrectPath   = errors.New("incorrect path")
 	ErrIteratingBucket = errors.New("error while iterating bucket")
 
-	ErrRecordNotFound = errors.New("record not found")
+	ErrBucketNotEmpty  = errors.New("bucket is not empty")
+	ErrRecordNotFound  = errors.New("record not found")
+	ErrVersionNotFound = errors.New("version not found")
+	ErrCASMismatch     = errors.New("check-and-set version mismatch")
+
+	ErrUnknownBatchOperation = errors.New("unknown batch operation")
+
+	ErrNotLeader        = errors.New("node is not the raft leader")
+	ErrBadSearchPattern = errors.New("bad search pattern")
+
+	// storage error is kept as is, its message lists the conflicting paths
+	ErrRelocationConflict = storage.ErrRelocationConflict
+	ErrRelocationOverlap  = errors.New("source and destination overlap")
+
+	ErrSnapshotUnsupported = errors.New("storage backend doesn't support snapshots")
+	ErrInvalidSnapshot     = errors.New("invalid snapshot")
+
+	ErrRewrapIncomplete = errors.New("rewrap of stored data isn't finished")
+	// ErrKeysInUse is returned by PurgeKeys while stored ciphertexts are sealed with the keys to purge
+	ErrKeysInUse = errors.New("data keys are still used by stored data")
+
+	ErrQuotaExceeded = errors.New("quota exceeded")
+	ErrValueTooLarge = errors.New("value is too large")
 )
 
-func (es *EncryptedStorage) Set(path []string, key string, value []byte) error {
-	value, err := es.crypter.Encrypt(value)
+// mapStorageErr converts errors of the underlying storage into errors of this package.
+func mapStorageErr(err error) error {
+	switch {
+	case errors.Is(err, storage.ErrEmptyPathPart):
+		return ErrEmptyPathPart
+	case errors.Is(err, storage.ErrBucketNotFound):
+		return ErrBucketNotFound
+	case errors.Is(err, storage.ErrIncorrectPath):
+		return ErrIncorrectPath
+	case errors.Is(err, storage.ErrBucketNotEmpty):
+		return ErrBucketNotEmpty
+	case errors.Is(err, storage.ErrRecordNotFound):
+		return ErrRecordNotFound
+	case errors.Is(err, storage.ErrVersionNotFound):
+		return ErrVersionNotFound
+	case errors.Is(err, storage.ErrCASMismatch):
+		return ErrCASMismatch
+	case errors.Is(err, storage.ErrUnknownBatchOperation):
+		return ErrUnknownBatchOperation
+	case errors.Is(err, storage.ErrNotLeader):
+		return ErrNotLeader
+	case errors.Is(err, storage.ErrBadSearchPattern):
+		return ErrBadSearchPattern
+	case errors.Is(err, storage.ErrRelocationOverlap):
+		return ErrRelocationOverlap
+	case errors.Is(err, storage.ErrSnapshotUnsupported):
+		return ErrSnapshotUnsupported
+	case errors.Is(err, storage.ErrInvalidSnapshot):
+		return ErrInvalidSnapshot
+	case errors.Is(err, storage.ErrQuotaExceeded):
+		return ErrQuotaExceeded
+	case errors.Is(err, storage.ErrValueTooLarge):
+		return ErrValueTooLarge
+	case errors.Is(err, storage.ErrNotFile):
+		return ErrNotFile
+	case errors.Is(err, storage.ErrChunkNotFound):
+		return ErrFileCorrupted
+	}
+	return err
+}
+
+func (es *EncryptedStorage) Set(path []string, key string, value []byte, opts models.WriteOptions) (int, error) {
+	value, err := es.sealValue(path, key, value)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	if err := es.db.Set(path, key, value, recordsBucketName); err != nil {
-		if errors.Is(err, storage.ErrIncorrectPath) {
-			return ErrIncorrectPath
-		}
-		return err
+	version, err := es.db.SetRecord(path, key, value, opts)
+	if err != nil {
+		return 0, mapStorageErr(err)
 	}
 
-	return nil
+	return version, nil
 }
 
-func (es *EncryptedStorage) Get(path []string, key string) ([]byte, error) {
-	value, err := es.db.Get(path, key, recordsBucketName)
+func (es *EncryptedStorage) Get(path []string, key string, version int) (*models.Record, error) {
+	record, err := es.db.GetRecord(path, key, version)
 	if err != nil {
-		if errors.Is(err, storage.ErrBucketNotFound) {
-			return nil, ErrBucketNotFound
-		}
+		return nil, mapStorageErr(err)
+	}
 
-		return nil, err
+	if record.File != nil {
+		// content of files is read with OpenFile
+		return record, nil
+	}
+	if record.Value == nil {
+		return nil, ErrRecordNotFound
 	}
 
-	decryptedValue, err := es.crypter.Decrypt(value)
+	record.Value, err = es.openValue(path, key, record.Value)
 	if err != nil {
 		return nil, err
 	}
 
-	if decryptedValue == nil {
-		return nil, ErrRecordNotFound
+	return record, nil
+}
+
+func (es *EncryptedStorage) ListVersions(path []string, key string) ([]*models.SecretVersion, error) {
+	versions, err := es.db.ListVersions(path, key)
+	if err != nil {
+		return nil, mapStorageErr(err)
 	}
 
-	return decryptedValue, err
+	return versions, nil
 }
 
-func (es *EncryptedStorage) Delete(path []string, key string) (int, error) {
+func (es *EncryptedStorage) Rollback(path []string, key string, version int, opts models.WriteOptions) (int, error) {
+	newVersion, err := es.db.Rollback(path, key, version, opts)
+	if err != nil {
+		return 0, mapStorageErr(err)
+	}
+
+	return newVersion, nil
+}
+
+func (es *EncryptedStorage) GetMetadata(path []string, key string) (*models.SecretMetadata, error) {
+	metadata, err := es.db.GetMetadata(path, key)
+	if err != nil {
+		return nil, mapStorageErr(err)
+	}
+
+	return metadata, nil
+}
+
+func (es *EncryptedStorage) SetLabels(path []string, key string, labels map[string]string) (*models.SecretMetadata, error) {
+	metadata, err := es.db.SetLabels(path, key, labels)
+	if err != nil {
+		return nil, mapStorageErr(err)
+	}
+
+	return metadata, nil
+}
+
+// Delete marks the record as deleted, it stays restorable with Undelete
+// until it is destroyed or purged.
+func (es *EncryptedStorage) Delete(path []string, key string) (time.Time, error) {
+	deletedAt, err := es.db.SoftDelete(path, key)
+	if err != nil {
+		return time.Time{}, mapStorageErr(err)
+	}
+
+	return deletedAt, nil
+}
+
+func (es *EncryptedStorage) Undelete(path []string, key string) error {
+	if err := es.db.Undelete(path, key); err != nil {
+		return mapStorageErr(err)
+	}
+
+	return nil
+}
+
+// Destroy removes the record with all of its versions permanently.
+func (es *EncryptedStorage) Destroy(path []string, key string) (int, error) {
 	deletedBuckets, err := es.db.Delete(path, key, recordsBucketName)
 	if err != nil {
-		if errors.Is(err, storage.ErrBucketNotFound) {
-			return 0, ErrBucketNotFound
-		}
-		return 0, err
+		return 0, mapStorageErr(err)
 	}
 
 	return deletedBuckets, nil
 }
 
-func (es *EncryptedStorage) ListRecords(path []string) (*models.BucketInfo, error) {
-	bucketInfo, err := es.db.ListRecords(path)
+// DeleteBucket removes the bucket, with recursive together with all secrets
+// and buckets inside. Nothing is removed on a dry run.
+func (es *EncryptedStorage) DeleteBucket(path []string, recursive bool, dryRun bool) (*models.BucketDeletion, error) {
+	report, err := es.db.DeleteBucket(path, recursive, dryRun)
 	if err != nil {
-		return nil, err
+		return nil, mapStorageErr(err)
+	}
+
+	return report, nil
+}
+
+func (es *EncryptedStorage) PurgeDeleted(before time.Time) (int, error) {
+	return es.db.PurgeDeleted(before)
+}
+
+func (es *EncryptedStorage) DeleteExpired(now time.Time) (int, error) {
+	return es.db.DeleteExpired(now)
+}
+
+// Batch encrypts values of set operations and applies all operations atomically.
+func (es *EncryptedStorage) Batch(ops []*models.BatchOperation) ([]*models.BatchResult, error) {
+	for _, op := range ops {
+		if op.Op != models.BatchOpSet {
+			continue
+		}
+
+		var err error
+		op.Value, err = es.sealValue(op.Path, op.Key, op.Value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	results, err := es.db.Batch(ops)
+	if err != nil {
+		return results, mapStorageErr(err)
+	}
+
+	return results, nil
+}
+
+// Relocate reseals values for their new location, values are bound to it.
+func (es *EncryptedStorage) Relocate(r *models.Relocation) (int, error) {
+	relocated, err := es.db.Relocate(r, &resealer{es: es})
+	if err != nil {
+		return 0, mapStorageErr(err)
+	}
+
+	return relocated, nil
+}
+
+func (es *EncryptedStorage) ListRecords(path []string, page models.PageOptions) (*models.BucketInfo, error) {
+	bucketInfo, err := es.db.ListRecords(path, page)
+	if err != nil {
+		return nil, mapStorageErr(err)
 	}
 
 	for i, record := range bucketInfo.Records {
-		bucketInfo.Records[i].Value, err = es.crypter.Decrypt(record.Value)
+		if record.File != nil {
+			continue
+		}
+		bucketInfo.Records[i].Value, err = es.openValue(path, string(record.Key), record.Value)
 		if err != nil {
 			return nil, err
 		}
@@ -93,10 +263,10 @@ func (es *EncryptedStorage) ListRecords(path []string) (*models.BucketInfo, erro
 func (es *EncryptedStorage) ListRecordsRecursively(path []string) (*models.BucketFullInfo, error) {
 	bucketFullInfo, err := es.db.ListRecordsRecursively(path)
 	if err != nil {
-		return nil, err
+		return nil, mapStorageErr(err)
 	}
 
-	err = es.decryptBucketFullInfo(bucketFullInfo)
+	err = es.decryptBucketFullInfo(path, bucketFullInfo)
 	if err != nil {
 		return nil, err
 	}
@@ -104,17 +274,49 @@ func (es *EncryptedStorage) ListRecordsRecursively(path []string) (*models.Bucke
 	return bucketFullInfo, err
 }
 
-func (es *EncryptedStorage) decryptBucketFullInfo(bucketInfo *models.BucketFullInfo) error {
+// Search decrypts values of matched secrets only if the query asks for them.
+func (es *EncryptedStorage) Search(path []string, query *models.SearchQuery) ([]*models.SearchMatch, error) {
+	matches, err := es.db.Search(path, query)
+	if err != nil {
+		return nil, mapStorageErr(err)
+	}
+
+	for _, match := range matches {
+		if match.Record == nil || match.Record.File != nil {
+			continue
+		}
+		// the path of a match is relative to the searched bucket and ends with the key
+		key := string(match.Record.Key)
+		relative := strings.TrimSuffix(strings.TrimSuffix(match.Path, key), "/")
+		matchPath := slices.Clip(path)
+		if relative != "" {
+			matchPath = append(matchPath, strings.Split(relative, "/")...)
+		}
+
+		match.Record.Value, err = es.openValue(matchPath, key, match.Record.Value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return matches, nil
+}
+
+// decryptBucketFullInfo decrypts values of the bucket at path with all nested buckets.
+func (es *EncryptedStorage) decryptBucketFullInfo(path []string, bucketInfo *models.BucketFullInfo) error {
 	var err error
 	for i, record := range bucketInfo.Records {
-		bucketInfo.Records[i].Value, err = es.crypter.Decrypt(record.Value)
+		if record.File != nil {
+			continue
+		}
+		bucketInfo.Records[i].Value, err = es.openValue(path, string(record.Key), record.Value)
 		if err != nil {
 			return err
 		}
 	}
 
 	for _, bucket := range bucketInfo.Buckets {
-		if err := es.decryptBucketFullInfo(bucket); err != nil {
+		if err := es.decryptBucketFullInfo(append(slices.Clip(path), bucket.Name), bucket); err != nil {
 			return err
 		}
 	}
@@ -147,3 +349,17 @@ func (es *EncryptedStorage) CheckUserCredentials(user *models.User) error {
 
 	return nil
 }
+
+// Usage reports sizes of encrypted values as they are stored.
+func (es *EncryptedStorage) Usage(username string) (*models.QuotaStatus, error) {
+	status, err := es.db.Usage(username)
+	if err != nil {
+		return nil, mapStorageErr(err)
+	}
+
+	return status, nil
+}
+
+func (es *EncryptedStorage) HAStatus() *models.HAStatus {
+	return es.db.HAStatus()
+}
@@ -0,0 +1,199 @@
+package encryptedstorage
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/liriquew/secret_storage/server/internal/models"
+	"github.com/liriquew/secret_storage/server/internal/storage"
+)
+
+// rewrapBatchSize is the number of secrets or names rewrapped in one transaction.
+const rewrapBatchSize = 100
+
+// rewrapper seals ciphertexts sealed with keys of older terms again with the active key,
+// legacy values are upgraded on the way.
+type rewrapper struct {
+	resealer
+	term uint32
+}
+
+func (r *rewrapper) stale(ciphertext []byte) bool {
+	term, ok := sealedTerm(ciphertext)
+	return !ok || term < r.term
+}
+
+func (r *rewrapper) ResealValue(from, to []string, value []byte) ([]byte, error) {
+	if !isLegacyValue(value) && !r.stale(value[len(boundHeader):]) {
+		return nil, nil
+	}
+	return r.resealer.ResealValue(from, to, value)
+}
+
+func (r *rewrapper) ResealFile(from, to []string, file *models.FileManifest) (*models.FileManifest, error) {
+	if !isLegacyValue(file.Digest) && !r.stale(file.Digest[len(boundHeader):]) {
+		return nil, nil
+	}
+	return r.resealer.ResealFile(from, to, file)
+}
+
+func (r *rewrapper) RewrapChunk(file *models.FileManifest, index int, chunk []byte) ([]byte, error) {
+	if !r.stale(chunk) {
+		return nil, nil
+	}
+
+	plaintext, err := r.es.crypter.Open(chunk, chunkAD(file.ID, index))
+	if err != nil {
+		return nil, err
+	}
+	return r.es.crypter.Seal(plaintext, chunkAD(file.ID, index))
+}
+
+func (r *rewrapper) RewrapName(blind string, sealed []byte) ([]byte, error) {
+	if !r.stale(sealed) {
+		return nil, nil
+	}
+
+	// names are bound to their blind indexes like nameCodec does
+	name, err := r.es.crypter.Open(sealed, []byte(blind))
+	if err != nil {
+		return nil, err
+	}
+	return r.es.crypter.Seal(name, []byte(blind))
+}
+
+// Rewrap seals the next batch of ciphertexts sealed with older data keys again with
+// the active one, returns the number of rewrapped ciphertexts. Every rotation starts
+// a new pass over the storage, a finished pass isn't walked again.
+func (es *EncryptedStorage) Rewrap() (int, error) {
+	if err := es.keyring.refresh(); err != nil {
+		return 0, err
+	}
+	term := es.keyring.activeTerm()
+
+	status, err := es.db.RewrapStatus()
+	if err != nil {
+		return 0, mapStorageErr(err)
+	}
+	if status != nil && status.Term == int(term) && status.Phase == models.RewrapPhaseDone {
+		return 0, nil
+	}
+
+	rewrapped, err := es.db.Rewrap(int(term), rewrapBatchSize, &rewrapper{resealer: resealer{es: es}, term: term})
+	if err != nil {
+		return rewrapped, mapStorageErr(err)
+	}
+	return rewrapped, nil
+}
+
+// RewrapStatus reports the progress of the last rewrap pass
+// and the retired keys it allows to purge.
+func (es *EncryptedStorage) RewrapStatus() (*models.RewrapStatus, error) {
+	if err := es.keyring.refresh(); err != nil {
+		return nil, err
+	}
+
+	status, err := es.db.RewrapStatus()
+	if err != nil {
+		return nil, mapStorageErr(err)
+	}
+	if status == nil {
+		status = &models.RewrapStatus{}
+	}
+
+	keyring := es.keyring.status()
+	status.ActiveTerm = keyring.ActiveTerm
+	if status.Phase == models.RewrapPhaseDone {
+		for _, key := range keyring.Keys {
+			if key.Term < status.Term && !key.Active {
+				status.PurgeableTerms = append(status.PurgeableTerms, key.Term)
+			}
+		}
+	}
+	return status, nil
+}
+
+// PurgeKeys removes the data keys retired before the last finished rewrap pass. Every
+// stored ciphertext is checked first, the keys are kept while any is sealed with them.
+func (es *EncryptedStorage) PurgeKeys() (*models.KeyringStatus, error) {
+	status, err := es.db.RewrapStatus()
+	if err != nil {
+		return nil, mapStorageErr(err)
+	}
+	if status == nil || status.Phase != models.RewrapPhaseDone {
+		return nil, ErrRewrapIncomplete
+	}
+
+	inUse, err := es.termsInUse(uint32(status.Term))
+	if err != nil {
+		return nil, err
+	}
+	if len(inUse) > 0 {
+		var terms []string
+		for _, term := range slices.Sorted(maps.Keys(inUse)) {
+			terms = append(terms, fmt.Sprintf("term %d - %d ciphertexts", term, inUse[term]))
+		}
+		return nil, fmt.Errorf("%w: %s", ErrKeysInUse, strings.Join(terms, ", "))
+	}
+
+	return es.keyring.purge(uint32(status.Term))
+}
+
+// termsInUse counts stored values, file digests, chunks and sealed names by the terms
+// below term they are sealed with. Ciphertexts sealed before the keyring count for term 1.
+func (es *EncryptedStorage) termsInUse(term uint32) (map[uint32]int, error) {
+	inUse := map[uint32]int{}
+	count := func(ciphertext []byte) {
+		sealed, ok := sealedTerm(ciphertext)
+		if !ok {
+			sealed = 1
+		}
+		if sealed < term {
+			inUse[sealed]++
+		}
+	}
+	countValue := func(value []byte) {
+		if !isLegacyValue(value) {
+			value = value[len(boundHeader):]
+		}
+		count(value)
+	}
+
+	// a value that can't be walked may be sealed with any key
+	var unchecked *models.VerifyIssue
+	err := es.db.WalkValues(func(v *storage.StoredValue) error {
+		if v.File == nil {
+			countValue(v.Value)
+			return nil
+		}
+
+		countValue(v.File.Digest)
+		for index := range v.File.Chunks {
+			if chunk := v.Chunk(index); chunk != nil {
+				count(chunk)
+			}
+		}
+		return nil
+	}, func(issue *models.VerifyIssue) {
+		if unchecked == nil {
+			unchecked = issue
+		}
+	})
+	if err != nil {
+		return nil, mapStorageErr(err)
+	}
+	if unchecked != nil {
+		return nil, fmt.Errorf("stored data can't be checked, %s: %s", unchecked.Path, unchecked.Problem)
+	}
+
+	err = es.db.WalkSealedNames(func(_ string, sealed []byte) error {
+		count(sealed)
+		return nil
+	})
+	if err != nil {
+		return nil, mapStorageErr(err)
+	}
+	return inUse, nil
+}
@@ -0,0 +1,51 @@
+package encryptedstorage
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/liriquew/secret_storage/server/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPurgeKeys(t *testing.T) {
+	es := newTestStorage(t, testConfig(t), testMasterKey)
+
+	path := []string{"alice", "app"}
+	_, err := es.Set(path, "token", []byte("value\x00"), models.WriteOptions{})
+	require.NoError(t, err)
+
+	_, err = es.PurgeKeys()
+	require.ErrorIs(t, err, ErrRewrapIncomplete)
+
+	_, err = es.Rotate()
+	require.NoError(t, err)
+	rewrapAll(t, es)
+
+	// a value written with the retired key after the pass passed it by
+	missed := sealWithTerm(t, es, 1, []byte("missed\x00"), locationAD(path, "missed"))
+	_, err = es.db.SetRecord(path, "missed", append(bytes.Clone(boundHeader), missed...), models.WriteOptions{})
+	require.NoError(t, err)
+
+	_, err = es.PurgeKeys()
+	require.ErrorIs(t, err, ErrKeysInUse)
+	assert.Contains(t, err.Error(), "term 1 - 1 ciphertexts")
+	assert.Len(t, es.keyring.status().Keys, 2)
+
+	// the next pass rewraps it
+	_, err = es.Rotate()
+	require.NoError(t, err)
+	rewrapAll(t, es)
+
+	status, err := es.PurgeKeys()
+	require.NoError(t, err)
+	require.Len(t, status.Keys, 1)
+	assert.Equal(t, 3, status.ActiveTerm)
+
+	for key, value := range map[string]string{"token": "value\x00", "missed": "missed\x00"} {
+		record, err := es.Get(path, key, 0)
+		require.NoError(t, err)
+		assert.Equal(t, value, string(record.Value))
+	}
+}
@@ -1,8 +1,11 @@
 package encryptedstorage
 
 import (
+	"fmt"
+	"io"
+	"time"
+
 	"github.com/liriquew/secret_storage/server/internal/lib/config"
-	"github.com/liriquew/secret_storage/server/internal/lib/encrypt"
 	"github.com/liriquew/secret_storage/server/internal/models"
 	"github.com/liriquew/secret_storage/server/internal/storage"
 )
@@ -11,18 +14,56 @@ type Storage interface {
 	Get(path []string, key string, bucketName []byte) ([]byte, error)
 	Set(path []string, key string, value []byte, bucketName []byte) error
 	Delete(path []string, key string, bucketName []byte) (int, error)
-	ListRecords(path []string) (*models.BucketInfo, error)
+	DeleteBucket(path []string, recursive bool, dryRun bool) (*models.BucketDeletion, error)
+	SetRecord(path []string, key string, value []byte, opts models.WriteOptions) (int, error)
+	GetRecord(path []string, key string, version int) (*models.Record, error)
+	ListVersions(path []string, key string) ([]*models.SecretVersion, error)
+	Rollback(path []string, key string, version int, opts models.WriteOptions) (int, error)
+	StageChunks(id []byte, first int, chunks [][]byte) error
+	DropUpload(id []byte) error
+	DropStaleUploads(before time.Time) (int, error)
+	SetFile(path []string, key string, file *models.FileManifest, opts models.WriteOptions) (int, error)
+	GetFileChunk(path []string, key string, version int, index int) ([]byte, error)
+	GetMetadata(path []string, key string) (*models.SecretMetadata, error)
+	SetLabels(path []string, key string, labels map[string]string) (*models.SecretMetadata, error)
+	SoftDelete(path []string, key string) (time.Time, error)
+	Undelete(path []string, key string) error
+	PurgeDeleted(before time.Time) (int, error)
+	DeleteExpired(now time.Time) (int, error)
+	Batch(ops []*models.BatchOperation) ([]*models.BatchResult, error)
+	ListRecords(path []string, page models.PageOptions) (*models.BucketInfo, error)
 	ListRecordsRecursively(path []string) (*models.BucketFullInfo, error)
+	Search(path []string, query *models.SearchQuery) ([]*models.SearchMatch, error)
+	Relocate(r *models.Relocation, resealer storage.Resealer) (int, error)
+	ResealRecords(resealer storage.Resealer) (int, error)
+	LegacyResealed() bool
+	MarkLegacyResealed() error
+	Rewrap(term int, limit int, rewrapper storage.Rewrapper) (int, error)
+	RewrapStatus() (*models.RewrapStatus, error)
+	WalkValues(visit func(*storage.StoredValue) error, report func(*models.VerifyIssue)) error
+	WalkSealedNames(visit func(blind string, sealed []byte) error) error
+	Snapshot(fn func(io.WriterTo) error) error
+	Usage(username string) (*models.QuotaStatus, error)
+	HAStatus() *models.HAStatus
+	Migration() *models.MigrationReport
+	OnChange(hook func([]*models.ChangeEvent))
+	Keyring() []byte
+	UpdateKeyring(update func(wrapped []byte, sample *storage.SealedSample) ([]byte, error)) error
+	SealedSample() (*storage.SealedSample, error)
+	Close() error
 }
 
 type Erypter interface {
 	Encrypt([]byte) ([]byte, error)
 	Decrypt([]byte) ([]byte, error)
+	Seal(plaintext, additionalData []byte) ([]byte, error)
+	Open(ciphertext, additionalData []byte) ([]byte, error)
 }
 
 type EncryptedStorage struct {
 	db      Storage
 	crypter Erypter
+	keyring *keyring
 }
 
 func New(cfg config.StorageConfig, key []byte) (*EncryptedStorage, error) {
@@ -31,13 +72,95 @@ func New(cfg config.StorageConfig, key []byte) (*EncryptedStorage, error) {
 		return nil, err
 	}
 
-	crypter, err := encrypt.NewEncrypter(key)
+	ring, err := openKeyring(db, key)
 	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return newEncryptedStorage(db, ring, cfg.BlindNames)
+}
+
+// OpenReadOnly opens the storage without changing the database, for offline checks.
+// The database isn't migrated to the current schema or to blind names, and
+// the keyring of a database created before it isn't stored.
+func OpenReadOnly(cfg config.StorageConfig, key []byte) (*EncryptedStorage, error) {
+	db, err := storage.OpenReadOnly(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	ring, err := viewKeyring(db, key)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return newEncryptedStorage(db, ring, false)
+}
+
+// newEncryptedStorage opens blind names of db if the database has them or blindNames is set,
+// db is closed on failure.
+func newEncryptedStorage(db *storage.Storage, ring *keyring, blindNames bool) (*EncryptedStorage, error) {
+	// once migrated the database keeps blind names whatever the config says
+	blinded, err := db.NamesBlinded()
+	if err == nil && (blinded || blindNames) {
+		err = db.BlindNames(newNameCodec(ring.blindKey(), ring))
+	}
+	if err != nil {
+		db.Close()
 		return nil, err
 	}
 
 	return &EncryptedStorage{
 		db:      db,
-		crypter: crypter,
+		crypter: ring,
+		keyring: ring,
 	}, nil
 }
+
+// Rotate adds a data key to the keyring and seals new values with it,
+// values sealed with older keys are opened as before.
+func (es *EncryptedStorage) Rotate() (*models.KeyringStatus, error) {
+	return es.keyring.rotate()
+}
+
+// Rekey wraps the keyring with a new master key, oldKey must be the current one.
+// Stored data isn't sealed again since the data keys stay the same.
+//
+// A database created before the keyring keeps oldKey as a data key, its term is
+// returned. If that key is still active a new one is rotated in, so the rewrap moves
+// the data off it and the term can be purged. Until then oldKey opens that data.
+func (es *EncryptedStorage) Rekey(oldKey, newKey []byte) (int, error) {
+	legacyTerm, err := es.keyring.rekey(oldKey, newKey)
+	if err != nil || legacyTerm == 0 {
+		return 0, err
+	}
+
+	if legacyTerm == es.keyring.activeTerm() {
+		if _, err := es.keyring.rotate(); err != nil {
+			return int(legacyTerm), fmt.Errorf("error while rotating out the old master key: %w", err)
+		}
+	}
+	return int(legacyTerm), nil
+}
+
+// CheckMasterKey returns ErrMasterKeyMismatch if key isn't the current master key.
+func (es *EncryptedStorage) CheckMasterKey(key []byte) error {
+	return es.keyring.check(key)
+}
+
+// Migration reports the migrations of the storage layout applied on unseal.
+func (es *EncryptedStorage) Migration() *models.MigrationReport {
+	return es.db.Migration()
+}
+
+// OnChange sets the hook called with changes of secrets committed by writes,
+// events carry real names and never carry values.
+func (es *EncryptedStorage) OnChange(hook func([]*models.ChangeEvent)) {
+	es.db.OnChange(hook)
+}
+
+func (es *EncryptedStorage) Close() error {
+	return es.db.Close()
+}
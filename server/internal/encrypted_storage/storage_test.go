@@ -0,0 +1,54 @@
+package encryptedstorage
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/liriquew/secret_storage/server/internal/lib/config"
+	"github.com/liriquew/secret_storage/server/internal/models"
+	"github.com/stretchr/testify/require"
+)
+
+var testMasterKey = bytes.Repeat([]byte{0x5a}, 32)
+
+func testConfig(t *testing.T) config.StorageConfig {
+	return config.StorageConfig{
+		Type:        "bbolt",
+		Path:        filepath.Join(t.TempDir(), "data.db"),
+		MaxVersions: 3,
+		BlindNames:  true,
+	}
+}
+
+func newTestStorage(t *testing.T, cfg config.StorageConfig, key []byte) *EncryptedStorage {
+	es, err := New(cfg, key)
+	require.NoError(t, err)
+	t.Cleanup(func() { es.Close() })
+	return es
+}
+
+// sealWithTerm seals plaintext with the data key of term whatever the active one is.
+func sealWithTerm(t *testing.T, es *EncryptedStorage, term uint32, plaintext, ad []byte) []byte {
+	crypter, err := es.keyring.crypter(term)
+	require.NoError(t, err)
+
+	header := termHeader(term)
+	ciphertext, err := crypter.Seal(plaintext, append(bytes.Clone(header), ad...))
+	require.NoError(t, err)
+	return append(header, ciphertext...)
+}
+
+// rewrapAll runs the rewrap pass to the end.
+func rewrapAll(t *testing.T, es *EncryptedStorage) {
+	for {
+		_, err := es.Rewrap()
+		require.NoError(t, err)
+
+		status, err := es.RewrapStatus()
+		require.NoError(t, err)
+		if status.Phase == models.RewrapPhaseDone {
+			return
+		}
+	}
+}
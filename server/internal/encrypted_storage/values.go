@@ -0,0 +1,157 @@
+package encryptedstorage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+
+	"github.com/liriquew/secret_storage/server/internal/models"
+)
+
+// Values are sealed with a header naming the format of the ciphertext:
+//
+//	"ssv" <format> <nonce> <ciphertext>
+//
+// Format 1 binds the value to its location, the header and the location are
+// the associated data of AES-GCM. Values without the header are legacy ones
+// sealed without associated data, they are read as they are until resealed.
+// Once no legacy values are left they are rejected, since a legacy value
+// isn't bound to its location and could be swapped in from another one.
+var valueMagic = []byte("ssv")
+
+var ErrLegacyValue = errors.New("value in the legacy format is rejected after reseal")
+
+const valueFormatBound byte = 1
+
+var boundHeader = append(bytes.Clone(valueMagic), valueFormatBound)
+
+// locationAD encodes the namespace, the path and the key of a value,
+// every part is prefixed with its length to keep the encoding unambiguous.
+func locationAD(path []string, key string) []byte {
+	ad := bytes.Clone(boundHeader)
+	for _, part := range append(path[:len(path):len(path)], key) {
+		ad = binary.AppendUvarint(ad, uint64(len(part)))
+		ad = append(ad, part...)
+	}
+	return ad
+}
+
+// fileAD binds the manifest of a file to its location and to its chunks.
+func fileAD(path []string, key string, id []byte) []byte {
+	return append(locationAD(path, key), id...)
+}
+
+func isLegacyValue(value []byte) bool {
+	return !bytes.HasPrefix(value, boundHeader)
+}
+
+// seal encrypts plaintext in the current format, ad is made by locationAD or fileAD.
+func (es *EncryptedStorage) seal(plaintext, ad []byte) ([]byte, error) {
+	ciphertext, err := es.crypter.Seal(plaintext, ad)
+	if err != nil {
+		return nil, err
+	}
+	return append(bytes.Clone(boundHeader), ciphertext...), nil
+}
+
+// open decrypts a value sealed by seal with the same ad or a legacy value
+// sealed with legacyAD. A legacy value may start with the header by chance,
+// so a value failing to open in the current format is tried as a legacy one.
+func (es *EncryptedStorage) open(value, ad, legacyAD []byte) ([]byte, error) {
+	return openValue(es.crypter, value, ad, legacyAD, es.db.LegacyResealed())
+}
+
+// openValue is open with crypter, legacy values are rejected if legacyRejected is set.
+func openValue(crypter Erypter, value, ad, legacyAD []byte, legacyRejected bool) ([]byte, error) {
+	if isLegacyValue(value) {
+		if legacyRejected {
+			return nil, ErrLegacyValue
+		}
+		return crypter.Open(value, legacyAD)
+	}
+
+	plaintext, err := crypter.Open(value[len(boundHeader):], ad)
+	if err == nil || legacyRejected {
+		return plaintext, err
+	}
+	if plaintext, legacyErr := crypter.Open(value, legacyAD); legacyErr == nil {
+		return plaintext, nil
+	}
+	return nil, err
+}
+
+func (es *EncryptedStorage) sealValue(path []string, key string, value []byte) ([]byte, error) {
+	return es.seal(value, locationAD(path, key))
+}
+
+func (es *EncryptedStorage) openValue(path []string, key string, value []byte) ([]byte, error) {
+	return es.open(value, locationAD(path, key), nil)
+}
+
+// resealer seals values for their new location when secrets are relocated,
+// legacy values are upgraded on the way.
+type resealer struct {
+	es *EncryptedStorage
+	// upgradeOnly leaves values in the current format as they are
+	upgradeOnly bool
+}
+
+func splitLocation(location []string) ([]string, string) {
+	return location[:len(location)-1], location[len(location)-1]
+}
+
+func (r *resealer) ResealValue(from, to []string, value []byte) ([]byte, error) {
+	if r.upgradeOnly && !isLegacyValue(value) {
+		return nil, nil
+	}
+
+	fromPath, fromKey := splitLocation(from)
+	plaintext, err := r.es.openValue(fromPath, fromKey, value)
+	if err != nil {
+		return nil, err
+	}
+	toPath, toKey := splitLocation(to)
+	return r.es.sealValue(toPath, toKey, plaintext)
+}
+
+func (r *resealer) ResealFile(from, to []string, file *models.FileManifest) (*models.FileManifest, error) {
+	if r.upgradeOnly && !isLegacyValue(file.Digest) {
+		return nil, nil
+	}
+
+	fromPath, fromKey := splitLocation(from)
+	digest, err := r.es.open(file.Digest, fileAD(fromPath, fromKey, file.ID), file.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	toPath, toKey := splitLocation(to)
+	resealed := *file
+	resealed.Digest, err = r.es.seal(digest, fileAD(toPath, toKey, file.ID))
+	if err != nil {
+		return nil, err
+	}
+	return &resealed, nil
+}
+
+// ResealLegacy reseals values stored in the legacy format with their locations,
+// returns the number of resealed values. Once nothing is left to reseal the
+// database is marked so and isn't walked again, new values are never sealed
+// as legacy ones.
+func (es *EncryptedStorage) ResealLegacy() (int, error) {
+	if es.db.LegacyResealed() {
+		return 0, nil
+	}
+
+	resealed, err := es.db.ResealRecords(&resealer{es: es, upgradeOnly: true})
+	if err != nil {
+		return resealed, mapStorageErr(err)
+	}
+
+	if resealed == 0 {
+		if err := es.db.MarkLegacyResealed(); err != nil {
+			return 0, mapStorageErr(err)
+		}
+	}
+	return resealed, nil
+}
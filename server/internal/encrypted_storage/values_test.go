@@ -0,0 +1,83 @@
+package encryptedstorage
+
+import (
+	"testing"
+
+	"github.com/liriquew/secret_storage/server/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValueLocationBinding(t *testing.T) {
+	es := newTestStorage(t, testConfig(t), testMasterKey)
+
+	path := []string{"alice", "app"}
+	_, err := es.Set(path, "token", []byte("value"), models.WriteOptions{})
+	require.NoError(t, err)
+
+	record, err := es.db.GetRecord(path, "token", 0)
+	require.NoError(t, err)
+	sealed := record.Value
+
+	for _, location := range []struct {
+		path []string
+		key  string
+	}{
+		{[]string{"alice", "app"}, "other"},
+		{[]string{"alice", "other"}, "token"},
+		{[]string{"bob", "app"}, "token"},
+	} {
+		// the ciphertext copied as it is to another location
+		_, err := es.db.SetRecord(location.path, location.key, sealed, models.WriteOptions{})
+		require.NoError(t, err)
+
+		_, err = es.Get(location.path, location.key, 0)
+		assert.Error(t, err, "%v/%s", location.path, location.key)
+	}
+
+	// the ciphertext moved back to its own location opens again
+	_, err = es.db.SetRecord(path, "token", sealed, models.WriteOptions{})
+	require.NoError(t, err)
+	record, err = es.Get(path, "token", 0)
+	require.NoError(t, err)
+	assert.Equal(t, "value", string(record.Value))
+}
+
+func TestLegacyValues(t *testing.T) {
+	es := newTestStorage(t, testConfig(t), testMasterKey)
+
+	path := []string{"alice", "app"}
+	setLegacy := func(key, value string) {
+		sealed, err := es.crypter.Seal([]byte(value), nil)
+		require.NoError(t, err)
+		require.True(t, isLegacyValue(sealed))
+
+		_, err = es.db.SetRecord(path, key, sealed, models.WriteOptions{})
+		require.NoError(t, err)
+	}
+
+	setLegacy("old", "legacy")
+	record, err := es.Get(path, "old", 0)
+	require.NoError(t, err)
+	assert.Equal(t, "legacy", string(record.Value))
+
+	resealed, err := es.ResealLegacy()
+	require.NoError(t, err)
+	assert.Equal(t, 1, resealed)
+	assert.False(t, es.db.LegacyResealed())
+
+	// nothing is left to reseal, the database is marked
+	resealed, err = es.ResealLegacy()
+	require.NoError(t, err)
+	assert.Equal(t, 0, resealed)
+	require.True(t, es.db.LegacyResealed())
+
+	record, err = es.Get(path, "old", 0)
+	require.NoError(t, err)
+	assert.Equal(t, "legacy", string(record.Value))
+
+	// a headerless value swapped in afterwards isn't trusted
+	setLegacy("swapped", "injected")
+	_, err = es.Get(path, "swapped", 0)
+	assert.ErrorIs(t, err, ErrLegacyValue)
+}
@@ -0,0 +1,140 @@
+package encryptedstorage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/liriquew/secret_storage/server/internal/models"
+	"github.com/liriquew/secret_storage/server/internal/storage"
+)
+
+// Verify walks the whole storage and decrypts every value, file digest and chunk
+// with the current crypter. Problems are collected into the report and never stop the walk.
+func (es *EncryptedStorage) Verify() (*models.VerifyReport, error) {
+	report := &models.VerifyReport{
+		StartedAt: time.Now().UTC(),
+		Issues:    []*models.VerifyIssue{},
+	}
+	addIssue := func(issue *models.VerifyIssue) {
+		report.Issues = append(report.Issues, issue)
+	}
+
+	err := es.db.WalkValues(func(v *storage.StoredValue) error {
+		report.Values++
+		if v.Current {
+			report.Secrets++
+		}
+
+		if v.File != nil {
+			es.verifyFile(report, v)
+			return nil
+		}
+
+		if isLegacyValue(v.Value) {
+			report.Legacy++
+		}
+
+		path, key := splitLocation(v.Location)
+		plaintext, err := es.openValue(path, key, v.Value)
+		if err != nil {
+			addIssue(valueIssue(v, models.ProblemUndecryptable, err))
+			return nil
+		}
+
+		if err := checkEncoding(plaintext); err != nil {
+			addIssue(valueIssue(v, models.ProblemBadEncoding, err))
+		}
+		return nil
+	}, addIssue)
+	if err != nil {
+		return nil, mapStorageErr(err)
+	}
+
+	report.FinishedAt = time.Now().UTC()
+	report.OK = len(report.Issues) == 0
+	return report, nil
+}
+
+// checkEncoding checks the encoding byte closing every value, see models.RecordDTO.ToInternalRecord.
+func checkEncoding(plaintext []byte) error {
+	if len(plaintext) == 0 {
+		return fmt.Errorf("value has no encoding byte")
+	}
+
+	switch encoding := plaintext[len(plaintext)-1]; encoding {
+	case models.UTF8Encoding, models.Base64Encoding:
+		return nil
+	default:
+		return fmt.Errorf("unknown encoding byte %d", encoding)
+	}
+}
+
+func valueIssue(v *storage.StoredValue, problem string, err error) *models.VerifyIssue {
+	return &models.VerifyIssue{
+		Path:    strings.Join(v.Location, "/"),
+		Version: v.Version,
+		Problem: problem,
+		Error:   err.Error(),
+	}
+}
+
+func chunkIssue(v *storage.StoredValue, index int, problem string, err error) *models.VerifyIssue {
+	issue := valueIssue(v, problem, err)
+	issue.Chunk = &index
+	return issue
+}
+
+// verifyFile decrypts the digest and every chunk of the file and checks the content against the digest.
+func (es *EncryptedStorage) verifyFile(report *models.VerifyReport, v *storage.StoredValue) {
+	manifest := v.File
+	if isLegacyValue(manifest.Digest) {
+		report.Legacy++
+	}
+
+	path, key := splitLocation(v.Location)
+	digest, err := es.open(manifest.Digest, fileAD(path, key, manifest.ID), manifest.ID)
+	if err != nil {
+		report.Issues = append(report.Issues, valueIssue(v, models.ProblemUndecryptable, fmt.Errorf("bad digest: %w", err)))
+	}
+
+	content := sha256.New()
+	complete := true
+	for index := range manifest.Chunks {
+		report.Chunks++
+
+		ciphertext := v.Chunk(index)
+		if ciphertext == nil {
+			report.Issues = append(report.Issues, chunkIssue(v, index, models.ProblemMissingChunk, storage.ErrChunkNotFound))
+			complete = false
+			continue
+		}
+
+		chunk, err := es.crypter.Open(ciphertext, chunkAD(manifest.ID, index))
+		if err != nil {
+			report.Issues = append(report.Issues, chunkIssue(v, index, models.ProblemUndecryptable, err))
+			complete = false
+			continue
+		}
+
+		expected := manifest.ChunkSize
+		if index == manifest.Chunks-1 {
+			expected = int(manifest.Size - int64(index)*int64(manifest.ChunkSize))
+		}
+		if len(chunk) != expected {
+			err := fmt.Errorf("chunk has size %d, expected %d", len(chunk), expected)
+			report.Issues = append(report.Issues, chunkIssue(v, index, models.ProblemBadChunk, err))
+			complete = false
+			continue
+		}
+		content.Write(chunk)
+	}
+
+	// the digest is compared only if the whole content is read
+	if complete && digest != nil && !bytes.Equal(content.Sum(nil), digest) {
+		err := fmt.Errorf("content doesn't match the digest")
+		report.Issues = append(report.Issues, valueIssue(v, models.ProblemDigestMismatch, err))
+	}
+}
@@ -0,0 +1,275 @@
+// Package backup implements the encrypted envelope of storage backups.
+//
+// An envelope is a fixed header followed by the snapshot split into chunks
+// sealed with AES-GCM under a key derived from a passphrase. The header is
+// authenticated as additional data of every chunk, the last chunk is marked
+// in its nonce, so a tampered, reordered or truncated stream is detected.
+package backup
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"io"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	Version = 1
+
+	chunkSize  = 64 * 1024
+	saltSize   = 16
+	prefixSize = 7
+	// magic, version, size, checksum, salt, nonce prefix
+	headerSize = 8 + 2 + 8 + sha256.Size + saltSize + prefixSize
+)
+
+var magic = []byte("SSBACKUP")
+
+var (
+	ErrBadFormat          = errors.New("not a storage backup")
+	ErrUnsupportedVersion = errors.New("unsupported backup version")
+	ErrCorrupted          = errors.New("backup is corrupted or passphrase is wrong")
+	ErrChecksumMismatch   = errors.New("backup checksum mismatch")
+)
+
+type Header struct {
+	Version  uint16
+	Size     uint64
+	Checksum [sha256.Size]byte
+
+	salt   [saltSize]byte
+	prefix [prefixSize]byte
+}
+
+func (h *Header) marshal() []byte {
+	buf := make([]byte, 0, headerSize)
+	buf = append(buf, magic...)
+	buf = binary.BigEndian.AppendUint16(buf, h.Version)
+	buf = binary.BigEndian.AppendUint64(buf, h.Size)
+	buf = append(buf, h.Checksum[:]...)
+	buf = append(buf, h.salt[:]...)
+	return append(buf, h.prefix[:]...)
+}
+
+func unmarshalHeader(buf []byte) (*Header, error) {
+	if !bytes.Equal(buf[:len(magic)], magic) {
+		return nil, ErrBadFormat
+	}
+	buf = buf[len(magic):]
+
+	h := &Header{}
+	h.Version = binary.BigEndian.Uint16(buf)
+	if h.Version != Version {
+		return nil, ErrUnsupportedVersion
+	}
+	h.Size = binary.BigEndian.Uint64(buf[2:])
+	buf = buf[10:]
+
+	buf = buf[copy(h.Checksum[:], buf):]
+	buf = buf[copy(h.salt[:], buf):]
+	copy(h.prefix[:], buf)
+
+	return h, nil
+}
+
+func newAEAD(passphrase []byte, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// stream keeps the state shared by the writer and the reader.
+type stream struct {
+	aead    cipher.AEAD
+	header  []byte
+	prefix  [prefixSize]byte
+	counter uint32
+}
+
+func (s *stream) nonce(last bool) []byte {
+	nonce := make([]byte, 0, s.aead.NonceSize())
+	nonce = append(nonce, s.prefix[:]...)
+	nonce = binary.BigEndian.AppendUint32(nonce, s.counter)
+	if last {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+type writer struct {
+	stream
+	w   io.Writer
+	buf []byte
+}
+
+// NewWriter writes the header of an envelope for a snapshot of size bytes
+// with sha256 checksum and returns a writer encrypting the snapshot.
+// Close must be called to write the last chunk.
+func NewWriter(w io.Writer, passphrase []byte, size int64, checksum []byte) (io.WriteCloser, error) {
+	h := &Header{Version: Version, Size: uint64(size)}
+	copy(h.Checksum[:], checksum)
+	if _, err := rand.Read(h.salt[:]); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(h.prefix[:]); err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(passphrase, h.salt[:])
+	if err != nil {
+		return nil, err
+	}
+
+	header := h.marshal()
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &writer{
+		stream: stream{aead: aead, header: header, prefix: h.prefix},
+		w:      w,
+		buf:    make([]byte, 0, chunkSize),
+	}, nil
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		// a full chunk is flushed only when more data follows,
+		// so the last chunk is always shorter than chunkSize
+		if len(w.buf) == chunkSize {
+			if err := w.flush(false); err != nil {
+				return written, err
+			}
+		}
+
+		n := copy(w.buf[len(w.buf):chunkSize], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (w *writer) flush(last bool) error {
+	chunk := w.aead.Seal(nil, w.nonce(last), w.buf, w.header)
+	w.counter++
+	w.buf = w.buf[:0]
+
+	_, err := w.w.Write(chunk)
+	return err
+}
+
+func (w *writer) Close() error {
+	if len(w.buf) == chunkSize {
+		if err := w.flush(false); err != nil {
+			return err
+		}
+	}
+	return w.flush(true)
+}
+
+type reader struct {
+	stream
+	r      io.Reader
+	h      *Header
+	hash   hash.Hash
+	size   uint64
+	chunk  []byte
+	buf    []byte
+	last   bool
+	broken error
+}
+
+// NewReader reads the header of an envelope and returns a reader of the decrypted snapshot.
+// Reading fails if the stream was tampered with, truncated or doesn't match the checksum.
+func NewReader(r io.Reader, passphrase []byte) (io.Reader, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, ErrBadFormat
+		}
+		return nil, err
+	}
+
+	h, err := unmarshalHeader(header)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(passphrase, h.salt[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return &reader{
+		stream: stream{aead: aead, header: header, prefix: h.prefix},
+		r:      r,
+		h:      h,
+		hash:   sha256.New(),
+		chunk:  make([]byte, chunkSize+aead.Overhead()),
+	}, nil
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.broken != nil {
+			return 0, r.broken
+		}
+		if r.last {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			r.broken = err
+		}
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// next decrypts the next chunk, the last one is checked against the header.
+func (r *reader) next() error {
+	n, err := io.ReadFull(r.r, r.chunk)
+	switch {
+	case errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF):
+		r.last = true
+	case err != nil:
+		return err
+	}
+	if n < r.aead.Overhead() {
+		return ErrCorrupted
+	}
+
+	plaintext, err := r.aead.Open(r.chunk[:0], r.nonce(r.last), r.chunk[:n], r.header)
+	if err != nil {
+		return ErrCorrupted
+	}
+	r.counter++
+
+	r.hash.Write(plaintext)
+	r.size += uint64(len(plaintext))
+	r.buf = plaintext
+
+	if r.last && (r.size != r.h.Size || !bytes.Equal(r.hash.Sum(nil), r.h.Checksum[:])) {
+		r.buf = nil
+		return ErrChecksumMismatch
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func seal(t *testing.T, snapshot []byte, passphrase string) []byte {
+	checksum := sha256.Sum256(snapshot)
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, []byte(passphrase), int64(len(snapshot)), checksum[:])
+	require.NoError(t, err)
+
+	// odd writes must not change chunking
+	for len(snapshot) > 0 {
+		n := min(len(snapshot), 1000)
+		_, err := w.Write(snapshot[:n])
+		require.NoError(t, err)
+		snapshot = snapshot[n:]
+	}
+	require.NoError(t, w.Close())
+
+	return buf.Bytes()
+}
+
+func open(envelope []byte, passphrase string) ([]byte, error) {
+	r, err := NewReader(bytes.NewReader(envelope), []byte(passphrase))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestEnvelope(t *testing.T) {
+	for _, size := range []int{0, 10, chunkSize, 3*chunkSize + 7} {
+		snapshot := make([]byte, size)
+		rand.Read(snapshot)
+
+		envelope := seal(t, snapshot, "passphrase")
+
+		opened, err := open(envelope, "passphrase")
+		require.NoError(t, err)
+		assert.Equal(t, snapshot, opened)
+	}
+
+	snapshot := make([]byte, 2*chunkSize+100)
+	rand.Read(snapshot)
+	envelope := seal(t, snapshot, "passphrase")
+
+	t.Run("Wrong Passphrase", func(t *testing.T) {
+		_, err := open(envelope, "wrong")
+		assert.ErrorIs(t, err, ErrCorrupted)
+	})
+
+	t.Run("Truncated", func(t *testing.T) {
+		_, err := open(envelope[:headerSize+chunkSize+16], "passphrase")
+		assert.ErrorIs(t, err, ErrCorrupted)
+	})
+
+	t.Run("Tampered Header", func(t *testing.T) {
+		tampered := bytes.Clone(envelope)
+		tampered[12]++
+
+		_, err := open(tampered, "passphrase")
+		assert.ErrorIs(t, err, ErrCorrupted)
+	})
+
+	t.Run("Checksum Mismatch", func(t *testing.T) {
+		checksum := sha256.Sum256([]byte("other"))
+
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, []byte("passphrase"), 10, checksum[:])
+		require.NoError(t, err)
+		w.Write(make([]byte, 10))
+		require.NoError(t, w.Close())
+
+		_, err = open(buf.Bytes(), "passphrase")
+		assert.ErrorIs(t, err, ErrChecksumMismatch)
+	})
+
+	t.Run("Not A Backup", func(t *testing.T) {
+		_, err := open([]byte("data"), "passphrase")
+		assert.ErrorIs(t, err, ErrBadFormat)
+	})
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"os"
+	"time"
 
 	"github.com/ilyakaznacheev/cleanenv"
 )
@@ -13,10 +14,72 @@ type AppConfig struct {
 
 type ServiceConfig struct {
 	Port int `yaml:"port" env-required:"true"`
+	// Admins are usernames allowed to use the operator endpoints, e.g. backup and restore
+	Admins []string `yaml:"admins"`
+	// MaxFileSize limits the size of a file secret upload in bytes
+	MaxFileSize int64 `yaml:"max_file_size" env-default:"67108864"`
+	// MaxConcurrentUploads limits file uploads writing to the storage at once,
+	// an upload holds a slot only while it writes a batch of chunks
+	MaxConcurrentUploads int `yaml:"max_concurrent_uploads" env-default:"4"`
 }
 
 type StorageConfig struct {
-	Path string `yaml:"path" env-required:"true"`
+	// Type is the name of the storage backend: bbolt, sqlite or memory
+	Type        string `yaml:"type" env-default:"bbolt"`
+	Path        string `yaml:"path"`
+	MaxVersions int    `yaml:"max_versions" env-default:"10"`
+
+	// deleted records are purged after DeleteRetention, checked every PurgeInterval
+	DeleteRetention time.Duration `yaml:"delete_retention" env-default:"720h"`
+	PurgeInterval   time.Duration `yaml:"purge_interval" env-default:"1h"`
+	// expired records are deleted every ReapInterval
+	ReapInterval time.Duration `yaml:"reap_interval" env-default:"1m"`
+	// values sealed in the legacy format are resealed every ResealInterval
+	ResealInterval time.Duration `yaml:"reseal_interval" env-default:"1m"`
+	// after a rotation of the data key stored data is sealed with the new key
+	// in batches of 100 secrets, one batch every RewrapInterval
+	RewrapInterval time.Duration `yaml:"rewrap_interval" env-default:"1s"`
+
+	HA HAConfig `yaml:"ha"`
+
+	// Quota is applied to every user, UserQuotas override its fields for some of them
+	Quota      QuotaConfig            `yaml:"quota"`
+	UserQuotas map[string]QuotaConfig `yaml:"user_quotas"`
+
+	// BlindNames stores names of users, buckets and secrets as blind indexes,
+	// an existing database is migrated once it is unsealed
+	BlindNames bool `yaml:"blind_names"`
+
+	// ReadOnly opens the database without changing it, it's set by offline checks
+	ReadOnly bool `yaml:"-"`
+}
+
+// QuotaConfig limits the storage taken by a user, sizes are sizes of encrypted values.
+// Zero fields mean no limit, in UserQuotas they fall back to the default quota
+// and negative ones mean no limit.
+type QuotaConfig struct {
+	MaxSecrets   int64 `yaml:"max_secrets"`
+	MaxBytes     int64 `yaml:"max_bytes"`
+	MaxValueSize int64 `yaml:"max_value_size"`
+}
+
+// HAConfig enables replication of the storage backend through raft.
+// Every node lists the whole cluster in Peers, itself included.
+type HAConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	NodeID  string `yaml:"node_id"`
+	// DataDir keeps the raft log and snapshots
+	DataDir string `yaml:"data_dir"`
+	// Bootstrap forms the cluster from Peers on the first start
+	Bootstrap bool     `yaml:"bootstrap"`
+	Peers     []HAPeer `yaml:"peers"`
+}
+
+type HAPeer struct {
+	ID       string `yaml:"id"`
+	RaftAddr string `yaml:"raft_addr"`
+	// APIAddr is the base URL followers redirect writes to, e.g. http://10.0.0.1:8080
+	APIAddr string `yaml:"api_addr"`
 }
 
 type AppTestConfig struct {
@@ -26,6 +89,10 @@ type AppTestConfig struct {
 type ServiceTestConfig struct {
 	Host string `yaml:"host" env-required:"true"`
 	Port string `yaml:"port" env-required:"true"`
+	// AdminUsername is a user listed in admins of the tested server,
+	// tests of operator endpoints are skipped without it
+	AdminUsername string `yaml:"admin_username" env:"TEST_ADMIN_USERNAME"`
+	AdminPassword string `yaml:"admin_password" env:"TEST_ADMIN_PASSWORD"`
 }
 
 func MustLoad() AppConfig {
@@ -8,59 +8,11 @@ import (
 	"io"
 )
 
-type TokenManager interface {
-	GetToken() ([]byte, error)
-	SetToken([]byte) error
-}
-
 type EncryptWrapper struct {
 	aead     cipher.AEAD
 	keyBytes []byte
 }
 
-func NewFromManager(tokenManager TokenManager, key []byte) (*EncryptWrapper, error) {
-	encrypter, err := NewEncrypter(key)
-	if err != nil {
-		return nil, err
-	}
-
-	rootKey, err := tokenManager.GetToken()
-	if err != nil {
-		return nil, err
-	}
-
-	var rootKeyDecrypted []byte
-	switch len(rootKey) {
-	case 0:
-		rootKeyDecrypted = make([]byte, 32)
-		_, err := rand.Read(rootKeyDecrypted)
-		if err != nil {
-			return nil, err
-		}
-
-		rootKeyEnc, err := encrypter.Encrypt(rootKeyDecrypted)
-		if err != nil {
-			return nil, err
-		}
-
-		tokenManager.SetToken(rootKeyEnc)
-	case 32 + aes.BlockSize + encrypter.aead.NonceSize():
-		rootKeyDecrypted, err = encrypter.Decrypt(rootKey)
-		if err != nil {
-			return nil, err
-		}
-	default:
-		return nil, errors.New("invalid key size")
-	}
-
-	encrypter, err = NewEncrypter(rootKeyDecrypted)
-	if err != nil {
-		return nil, err
-	}
-
-	return encrypter, nil
-}
-
 func NewEncrypter(key []byte) (*EncryptWrapper, error) {
 	w := &EncryptWrapper{}
 	aesCipher, err := aes.NewCipher(key)
@@ -78,20 +30,34 @@ func NewEncrypter(key []byte) (*EncryptWrapper, error) {
 	return w, nil
 }
 
+var ErrShortCiphertext = errors.New("ciphertext is too short")
+
 func (w *EncryptWrapper) Encrypt(plaintext []byte) ([]byte, error) {
+	return w.Seal(plaintext, nil)
+}
+
+func (w *EncryptWrapper) Decrypt(cipherText []byte) ([]byte, error) {
+	return w.Open(cipherText, nil)
+}
+
+// Seal encrypts plaintext binding it to additionalData, the same data must be passed to Open.
+func (w *EncryptWrapper) Seal(plaintext, additionalData []byte) ([]byte, error) {
 	nonce := make([]byte, 12)
 	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
 		return nil, err
 	}
-	ciphertext := w.aead.Seal(nil, nonce, plaintext, nil)
+	ciphertext := w.aead.Seal(nil, nonce, plaintext, additionalData)
 
 	return append(nonce, ciphertext...), nil
 }
 
-func (w *EncryptWrapper) Decrypt(cipherText []byte) ([]byte, error) {
+func (w *EncryptWrapper) Open(cipherText, additionalData []byte) ([]byte, error) {
+	if len(cipherText) < 12 {
+		return nil, ErrShortCiphertext
+	}
 	nonce, ciphertext := cipherText[:12], cipherText[12:]
 
-	plaintext, err := w.aead.Open(nil, nonce, ciphertext, nil)
+	plaintext, err := w.aead.Open(nil, nonce, ciphertext, additionalData)
 	if err != nil {
 		return nil, err
 	}
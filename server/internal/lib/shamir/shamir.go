@@ -56,6 +56,12 @@ func (s *ShamirInfo) Parts() ([][]byte, error) {
 	return parts, nil
 }
 
+func (s *ShamirInfo) Count() int {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return len(s.parts)
+}
+
 func (s *ShamirInfo) Reset() {
 	s.parts = make(map[string]struct{})
 	s.threshold = 0
@@ -0,0 +1,320 @@
+// Package transfer converts trees of secrets from and to documents
+// in JSON, YAML and dotenv formats.
+//
+// In JSON and YAML buckets are nested objects and secrets are string values.
+// In dotenv every secret is a line NAME=value where NAME is the path of
+// the secret joined with "__". Binary values are written as "base64:" followed
+// by the standard base64 encoding of the value in every format.
+package transfer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+	FormatEnv  = "env"
+
+	base64Prefix = "base64:"
+	envSeparator = "__"
+)
+
+var (
+	ErrUnknownFormat    = errors.New("unknown format")
+	ErrBadDocument      = errors.New("bad document")
+	ErrNotRepresentable = errors.New("secret name can't be represented in the format")
+)
+
+// Entry is a secret of the tree, Path is relative to the root of the tree.
+type Entry struct {
+	Path   []string
+	Key    string
+	Value  []byte
+	Binary bool
+}
+
+// Name returns the path of the entry joined with sep.
+func (e *Entry) Name(sep string) string {
+	return strings.Join(append(e.Path[:len(e.Path):len(e.Path)], e.Key), sep)
+}
+
+func (e *Entry) text() string {
+	if e.Binary || strings.HasPrefix(string(e.Value), base64Prefix) {
+		// text values looking like binary ones are encoded too to be imported back as is
+		return base64Prefix + base64.StdEncoding.EncodeToString(e.Value)
+	}
+	return string(e.Value)
+}
+
+func newEntry(path []string, text string) (*Entry, error) {
+	if len(path) == 0 || slices.Contains(path, "") {
+		return nil, fmt.Errorf("%w: empty name in %q", ErrBadDocument, strings.Join(path, "/"))
+	}
+
+	entry := &Entry{
+		Path: path[: len(path)-1 : len(path)-1],
+		Key:  path[len(path)-1],
+	}
+
+	encoded, ok := strings.CutPrefix(text, base64Prefix)
+	if !ok {
+		entry.Value = []byte(text)
+		return entry, nil
+	}
+
+	value, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: bad base64 value", ErrBadDocument, entry.Name("/"))
+	}
+	entry.Value = value
+	entry.Binary = true
+
+	return entry, nil
+}
+
+func Formats() []string {
+	return []string{FormatJSON, FormatYAML, FormatEnv}
+}
+
+// Encode writes entries in the format, entries must not contain a secret and a bucket with the same path.
+func Encode(w io.Writer, format string, entries []*Entry) error {
+	switch format {
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(tree(entries))
+	case FormatYAML:
+		encoder := yaml.NewEncoder(w)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(tree(entries)); err != nil {
+			return err
+		}
+		return encoder.Close()
+	case FormatEnv:
+		return encodeEnv(w, entries)
+	}
+	return fmt.Errorf("%w: %s", ErrUnknownFormat, format)
+}
+
+// tree nests entries into maps, both encoders sort keys of maps.
+func tree(entries []*Entry) map[string]any {
+	root := map[string]any{}
+	for _, entry := range entries {
+		node := root
+		for _, pathPart := range entry.Path {
+			nested, ok := node[pathPart].(map[string]any)
+			if !ok {
+				nested = map[string]any{}
+				node[pathPart] = nested
+			}
+			node = nested
+		}
+		node[entry.Key] = entry.text()
+	}
+	return root
+}
+
+func encodeEnv(w io.Writer, entries []*Entry) error {
+	lines := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		for _, part := range append(entry.Path[:len(entry.Path):len(entry.Path)], entry.Key) {
+			if strings.Contains(part, envSeparator) || strings.ContainsAny(part, "= \t\r\n#\"'") {
+				return fmt.Errorf("%w: %s", ErrNotRepresentable, entry.Name("/"))
+			}
+		}
+		lines = append(lines, entry.Name(envSeparator)+"="+quoteEnv(entry.text()))
+	}
+	slices.Sort(lines)
+
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var envEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "$", `\$`)
+
+func quoteEnv(value string) string {
+	return `"` + envEscaper.Replace(value) + `"`
+}
+
+// Decode reads entries of the document in the format, for dotenv the last
+// of duplicated names wins.
+func Decode(r io.Reader, format string) ([]*Entry, error) {
+	switch format {
+	case FormatJSON:
+		decoder := json.NewDecoder(r)
+		decoder.UseNumber()
+
+		var document map[string]any
+		if err := decoder.Decode(&document); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrBadDocument, err)
+		}
+		return flattenJSON(nil, document, nil)
+	case FormatYAML:
+		var document yaml.Node
+		err := yaml.NewDecoder(r).Decode(&document)
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrBadDocument, err)
+		}
+		return flattenYAML(nil, document.Content[0], nil)
+	case FormatEnv:
+		return decodeEnv(r)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownFormat, format)
+}
+
+func flattenJSON(path []string, node map[string]any, entries []*Entry) ([]*Entry, error) {
+	for name, value := range node {
+		childPath := append(path[:len(path):len(path)], name)
+
+		var text string
+		switch value := value.(type) {
+		case map[string]any:
+			var err error
+			entries, err = flattenJSON(childPath, value, entries)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		case string:
+			text = value
+		case json.Number, bool:
+			text = fmt.Sprint(value)
+		default:
+			return nil, fmt.Errorf("%w: %s: value must be a string or an object", ErrBadDocument, strings.Join(childPath, "/"))
+		}
+
+		entry, err := newEntry(childPath, text)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func flattenYAML(path []string, node *yaml.Node, entries []*Entry) ([]*Entry, error) {
+	if node.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%w: %s: mapping expected", ErrBadDocument, strings.Join(path, "/"))
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		name, value := node.Content[i].Value, node.Content[i+1]
+		childPath := append(path[:len(path):len(path)], name)
+
+		switch {
+		case value.Kind == yaml.MappingNode:
+			var err error
+			entries, err = flattenYAML(childPath, value, entries)
+			if err != nil {
+				return nil, err
+			}
+		case value.Kind == yaml.ScalarNode && value.Tag != "!!null":
+			// scalars are taken as written, so 0123 stays 0123
+			entry, err := newEntry(childPath, value.Value)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+		default:
+			return nil, fmt.Errorf("%w: %s: value must be a string or a mapping", ErrBadDocument, strings.Join(childPath, "/"))
+		}
+	}
+	return entries, nil
+}
+
+func decodeEnv(r io.Reader) ([]*Entry, error) {
+	byName := map[string]*Entry{}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		name, value, found := strings.Cut(line, "=")
+		if !found {
+			return nil, fmt.Errorf("%w: line %d: = expected", ErrBadDocument, lineNum)
+		}
+		name = strings.TrimSpace(name)
+
+		value, err := unquoteEnv(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %w", ErrBadDocument, lineNum, err)
+		}
+
+		entry, err := newEntry(strings.Split(name, envSeparator), value)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		byName[name] = entry
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadDocument, err)
+	}
+
+	entries := make([]*Entry, 0, len(byName))
+	for _, entry := range byName {
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func unquoteEnv(value string) (string, error) {
+	switch {
+	case len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'':
+		return value[1 : len(value)-1], nil
+	case len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"':
+	default:
+		if strings.ContainsAny(value, `"'`) {
+			return "", errors.New("unbalanced quotes")
+		}
+		// unquoted values end at a comment
+		value, _, _ = strings.Cut(value, " #")
+		return strings.TrimSpace(value), nil
+	}
+
+	var unquoted bytes.Buffer
+	escaped := false
+	for _, r := range value[1 : len(value)-1] {
+		if !escaped && r == '\\' {
+			escaped = true
+			continue
+		}
+		if escaped {
+			switch r {
+			case 'n':
+				r = '\n'
+			case 'r':
+				r = '\r'
+			}
+			escaped = false
+		}
+		unquoted.WriteRune(r)
+	}
+	if escaped {
+		return "", errors.New("unfinished escape sequence")
+	}
+
+	return unquoted.String(), nil
+}
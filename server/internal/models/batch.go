@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+const (
+	BatchOpSet    = "set"
+	BatchOpDelete = "delete"
+)
+
+type BatchOperationDTO struct {
+	Op   string `json:"op"`
+	Path string `json:"path"`
+	RecordDTO
+	CAS *int `json:"cas,omitempty"`
+}
+
+type BatchOperation struct {
+	Op    string
+	Path  []string
+	Key   string
+	Value []byte
+	Opts  WriteOptions
+}
+
+type BatchResult struct {
+	Op        string     `json:"op"`
+	Path      string     `json:"path"`
+	Key       string     `json:"key"`
+	Version   int        `json:"version,omitempty"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
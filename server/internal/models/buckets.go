@@ -0,0 +1,11 @@
+package models
+
+// BucketDeletion reports a removed bucket subtree. Secrets and Buckets
+// list the removed entries relative to the namespace on dry runs only.
+type BucketDeletion struct {
+	DeletedSecrets int      `json:"deleted_secrets"`
+	DeletedBuckets int      `json:"deleted_buckets"`
+	DryRun         bool     `json:"dry_run"`
+	Secrets        []string `json:"secrets,omitempty"`
+	Buckets        []string `json:"buckets,omitempty"`
+}
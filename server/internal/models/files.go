@@ -0,0 +1,31 @@
+package models
+
+// FileManifest describes a version of a secret stored as a file. The content
+// is split into chunks of ChunkSize bytes encrypted separately, the manifest
+// itself is stored unencrypted next to them.
+type FileManifest struct {
+	// ID is random for every upload, chunks are bound to it and to their indexes
+	ID          []byte `json:"id"`
+	Name        string `json:"name,omitempty"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	ChunkSize   int    `json:"chunk_size"`
+	Chunks      int    `json:"chunks"`
+	// Digest is the encrypted sha256 of the content
+	Digest []byte `json:"digest"`
+}
+
+// FileInfo is the public part of FileManifest.
+type FileInfo struct {
+	Name        string `json:"name,omitempty"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+}
+
+func (m *FileManifest) Info() *FileInfo {
+	return &FileInfo{
+		Name:        m.Name,
+		ContentType: m.ContentType,
+		Size:        m.Size,
+	}
+}
@@ -0,0 +1,27 @@
+package models
+
+type HAStatus struct {
+	Enabled bool   `json:"enabled"`
+	NodeID  string `json:"node_id,omitempty"`
+	// State is one of Leader, Follower, Candidate or Shutdown
+	State         string `json:"state,omitempty"`
+	LeaderID      string `json:"leader_id,omitempty"`
+	LeaderAPIAddr string `json:"leader_api_addr,omitempty"`
+	Term          uint64 `json:"term,omitempty"`
+	CommitIndex   uint64 `json:"commit_index,omitempty"`
+	AppliedIndex  uint64 `json:"applied_index,omitempty"`
+
+	Peers []*HAPeerStatus `json:"peers,omitempty"`
+}
+
+type HAPeerStatus struct {
+	ID       string `json:"id"`
+	RaftAddr string `json:"raft_addr"`
+	APIAddr  string `json:"api_addr,omitempty"`
+	Voter    bool   `json:"voter"`
+	Leader   bool   `json:"leader"`
+}
+
+func (s *HAStatus) IsLeader() bool {
+	return !s.Enabled || s.State == "Leader"
+}
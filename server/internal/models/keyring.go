@@ -0,0 +1,73 @@
+package models
+
+import "time"
+
+// KeyringStatus lists the data keys of the keyring, key material is never reported.
+type KeyringStatus struct {
+	// ActiveTerm is the term of the key new values are sealed with
+	ActiveTerm int            `json:"active_term"`
+	Keys       []*DataKeyInfo `json:"keys"`
+}
+
+type DataKeyInfo struct {
+	Term      int       `json:"term"`
+	CreatedAt time.Time `json:"created_at"`
+	Active    bool      `json:"active"`
+}
+
+// Phases of the rewrap pass.
+const (
+	RewrapPhaseSecrets = "secrets"
+	RewrapPhaseNames   = "names"
+	RewrapPhaseDone    = "done"
+)
+
+// RewrapStatus is the progress of the pass sealing stored ciphertexts again with the active
+// data key. A rotation starts a new pass, ActiveTerm differs from Term until it does.
+type RewrapStatus struct {
+	// Term is the key term the pass rewraps to
+	Term       int    `json:"term"`
+	ActiveTerm int    `json:"active_term"`
+	Phase      string `json:"phase"`
+	// Secrets and Names are the numbers of secrets and sealed names walked so far
+	Secrets int `json:"secrets"`
+	Names   int `json:"names"`
+	// Rewrapped is the number of ciphertexts sealed again
+	Rewrapped  int        `json:"rewrapped"`
+	StartedAt  time.Time  `json:"started_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	// PurgeableTerms are retired keys no ciphertext is sealed with any more
+	PurgeableTerms []int `json:"purgeable_terms,omitempty"`
+}
+
+// RekeyShareMessage is sent to a receiver of a new share. The share comes first, then
+// the outcome: the share is valid only once Complete is received. Error without Complete
+// voids it, with Complete it tells that not every receiver got their share.
+type RekeyShareMessage struct {
+	Part     string `json:"part,omitempty"`
+	Complete bool   `json:"complete,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// RekeyStatus is the progress of replacing the shares of the master key. The new shares
+// are never returned, each of them is sent to its own receiver.
+type RekeyStatus struct {
+	Started bool   `json:"started"`
+	Nonce   string `json:"nonce,omitempty"`
+	// Parts and Threshold describe the new shares
+	Parts     int `json:"parts,omitempty"`
+	Threshold int `json:"threshold,omitempty"`
+	// CurrentThreshold is the number of current shares to submit
+	CurrentThreshold int `json:"current_threshold,omitempty"`
+	// Progress is the number of current shares submitted so far
+	Progress int `json:"progress"`
+	// Receivers is the number of connected receivers of the new shares
+	Receivers int  `json:"receivers"`
+	Complete  bool `json:"complete,omitempty"`
+	// LegacyTerm is the data key term holding the replaced master key, databases
+	// created before the keyring have one. The old shares open the data sealed with
+	// it until the rewrap is done and the term is purged, Warning says so.
+	LegacyTerm int    `json:"legacy_term,omitempty"`
+	Warning    string `json:"warning,omitempty"`
+}
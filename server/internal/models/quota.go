@@ -0,0 +1,29 @@
+package models
+
+// Usage is the storage taken by a user: the number of secrets, deleted ones
+// included until they are purged, and the total size of stored ciphertexts
+// of all their versions.
+type Usage struct {
+	Secrets int64 `json:"secrets"`
+	Bytes   int64 `json:"bytes"`
+}
+
+func (u Usage) Add(other Usage) Usage {
+	return Usage{Secrets: u.Secrets + other.Secrets, Bytes: u.Bytes + other.Bytes}
+}
+
+func (u Usage) Sub(other Usage) Usage {
+	return Usage{Secrets: u.Secrets - other.Secrets, Bytes: u.Bytes - other.Bytes}
+}
+
+// Quota limits the usage of a user, zero fields mean no limit.
+type Quota struct {
+	MaxSecrets   int64 `json:"max_secrets,omitempty"`
+	MaxBytes     int64 `json:"max_bytes,omitempty"`
+	MaxValueSize int64 `json:"max_value_size,omitempty"`
+}
+
+type QuotaStatus struct {
+	Usage Usage `json:"usage"`
+	Quota Quota `json:"quota"`
+}
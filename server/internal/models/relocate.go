@@ -0,0 +1,23 @@
+package models
+
+// RelocateDTO describes a move or a copy. Without Key the whole bucket From is
+// relocated to To, otherwise the secret Key is relocated into the bucket To
+// under NewKey (Key if empty).
+type RelocateDTO struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Key       string `json:"key,omitempty"`
+	NewKey    string `json:"new_key,omitempty"`
+	Overwrite bool   `json:"overwrite"`
+}
+
+type Relocation struct {
+	From   []string
+	To     []string
+	Key    string
+	NewKey string
+	// Overwrite replaces conflicting destination entries instead of aborting
+	Overwrite bool
+	// Move removes the source once it is copied
+	Move bool
+}
@@ -0,0 +1,17 @@
+package models
+
+// Migration is a step of the storage layout upgrade.
+type Migration struct {
+	// Version is the schema version the migration brings the database to
+	Version     int    `json:"version"`
+	Description string `json:"description"`
+}
+
+type MigrationReport struct {
+	From       int          `json:"from"`
+	To         int          `json:"to"`
+	Migrations []*Migration `json:"migrations"`
+	// Backup is the copy of the database taken before the migrations
+	Backup string `json:"backup,omitempty"`
+	DryRun bool   `json:"dry_run"`
+}
@@ -0,0 +1,17 @@
+package models
+
+type SearchQuery struct {
+	// Pattern is a glob over slash separated path parts, ** matches any number of parts
+	Pattern string
+	// Prefix is a prefix of the matched path
+	Prefix string
+	// WithValues makes matched secrets returned with their current values
+	WithValues bool
+}
+
+type SearchMatch struct {
+	// Path is relative to the searched bucket, for secrets it ends with the key
+	Path   string  `json:"path"`
+	Bucket bool    `json:"bucket,omitempty"`
+	Record *Record `json:"record,omitempty"`
+}
@@ -3,12 +3,23 @@ package models
 import (
 	"encoding/base64"
 	"encoding/json"
+	"time"
 )
 
 type RecordDTO struct {
-	Key    string `json:"key"`
-	Value  string `json:"value"`
-	Base64 bool   `json:"is_base64"`
+	Key      string          `json:"key"`
+	Value    string          `json:"value"`
+	Base64   bool            `json:"is_base64"`
+	Version  int             `json:"version,omitempty"`
+	Metadata *SecretMetadata `json:"metadata,omitempty"`
+	// File is set for secrets stored as files, their content is downloaded separately
+	File *FileInfo `json:"file,omitempty"`
+
+	// TTL (e.g. "1h30m") or ExpiresAt makes the record expire, only one of them can be set.
+	// Without them the record keeps its expiration unless ClearExpiration is set
+	TTL             string     `json:"ttl,omitempty"`
+	ExpiresAt       *time.Time `json:"expires_at,omitempty"`
+	ClearExpiration bool       `json:"clear_expiration,omitempty"`
 }
 
 const (
@@ -36,11 +47,24 @@ func (r *RecordDTO) ToInternalRecord() *Record {
 }
 
 type Record struct {
-	Key   []byte
-	Value []byte
+	Key      []byte
+	Value    []byte
+	Version  int
+	Metadata *SecretMetadata
+	// File is set instead of Value if the version is stored as a file
+	File *FileManifest
 }
 
 func (r *Record) MarshalJSON() ([]byte, error) {
+	if r.File != nil {
+		return json.Marshal(RecordDTO{
+			Key:      string(r.Key),
+			Version:  r.Version,
+			Metadata: r.Metadata,
+			File:     r.File.Info(),
+		})
+	}
+
 	var value string
 
 	if n := len(r.Value); n > 0 && r.Value[n-1] == UTF8Encoding {
@@ -50,8 +74,10 @@ func (r *Record) MarshalJSON() ([]byte, error) {
 	}
 
 	return json.Marshal(RecordDTO{
-		Key:   string(r.Key),
-		Value: value,
+		Key:      string(r.Key),
+		Value:    value,
+		Version:  r.Version,
+		Metadata: r.Metadata,
 	})
 }
 
@@ -63,6 +89,8 @@ func (r *Record) UnmarshalJSON(data []byte) error {
 	}
 
 	r.Key = []byte(dto.Key)
+	r.Version = dto.Version
+	r.Metadata = dto.Metadata
 
 	if dto.Base64 {
 		decoded, err := base64.StdEncoding.DecodeString(dto.Value)
@@ -80,6 +108,24 @@ func (r *Record) UnmarshalJSON(data []byte) error {
 type BucketInfo struct {
 	Buckets []string  `json:"buckets"`
 	Records []*Record `json:"records"`
+	// NextCursor is set if the listing is cut by the page limit,
+	// it is passed as after to get the next page
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// PageOptions limits a listing to Limit entries following the After key,
+// zero Limit means no limit.
+type PageOptions struct {
+	After []byte
+	Limit int
+}
+
+func EncodeCursor(key []byte) string {
+	return base64.RawURLEncoding.EncodeToString(key)
+}
+
+func DecodeCursor(cursor string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(cursor)
 }
 
 type BucketFullInfo struct {
@@ -87,3 +133,34 @@ type BucketFullInfo struct {
 	Buckets []*BucketFullInfo `json:"buckets"`
 	Records []*Record         `json:"records"`
 }
+
+type SecretVersion struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SecretMetadata is stored next to the secret unencrypted,
+// only Labels can be changed by the user.
+type SecretMetadata struct {
+	CreatedAt      time.Time         `json:"created_at"`
+	CreatedBy      string            `json:"created_by"`
+	UpdatedAt      time.Time         `json:"updated_at"`
+	UpdatedBy      string            `json:"updated_by"`
+	CurrentVersion int               `json:"current_version"`
+	Labels         map[string]string `json:"labels"`
+	ExpiresAt      *time.Time        `json:"expires_at,omitempty"`
+}
+
+// WriteOptions describes how a record should be written.
+type WriteOptions struct {
+	// Author is the name of the user who makes the change
+	Author string
+	// ExpiresAt is the moment after which the record is treated as deleted,
+	// nil keeps the current expiration of the record
+	ExpiresAt *time.Time
+	// ClearExpiration makes the record never expire, ExpiresAt must be nil
+	ClearExpiration bool
+	// CAS is the expected current version of the record, 0 means that the record
+	// must not exist, nil disables the check
+	CAS *int
+}
@@ -0,0 +1,13 @@
+package models
+
+// ImportReport lists paths of imported secrets by what happens to them,
+// paths are relative to the import root.
+type ImportReport struct {
+	Created   []string `json:"created"`
+	Updated   []string `json:"updated"`
+	Unchanged []string `json:"unchanged"`
+	// Conflicts abort the import, existing secrets are conflicts unless
+	// overwrite is requested, secrets colliding with buckets always are
+	Conflicts []string `json:"conflicts"`
+	DryRun    bool     `json:"dry_run"`
+}
@@ -0,0 +1,49 @@
+package models
+
+import "time"
+
+// Problems found by verification of the storage.
+const (
+	// ProblemUnknownName is a blind index missing from the name index, the entry is skipped
+	ProblemUnknownName = "unknown_name"
+	// ProblemBadHeader is a secret which header can't be decoded, its versions are skipped
+	ProblemBadHeader = "bad_header"
+	// ProblemBadVersion is a version entry which can't be decoded
+	ProblemBadVersion = "bad_version"
+	// ProblemMissingVersion is the current version of a secret missing from its versions
+	ProblemMissingVersion = "missing_version"
+	// ProblemUndecryptable is a value, a file digest or a chunk which fails to decrypt
+	ProblemUndecryptable = "undecryptable"
+	// ProblemBadEncoding is a decrypted value without a valid encoding byte
+	ProblemBadEncoding = "bad_encoding"
+	// ProblemMissingChunk is a chunk of a file missing from the storage
+	ProblemMissingChunk = "missing_chunk"
+	// ProblemBadChunk is a chunk of a wrong size
+	ProblemBadChunk = "bad_chunk"
+	// ProblemDigestMismatch is a file which content doesn't match its digest
+	ProblemDigestMismatch = "digest_mismatch"
+)
+
+// VerifyIssue is a corrupted entry found by verification.
+type VerifyIssue struct {
+	// Path is the location of the entry: namespace, path and key
+	Path    string `json:"path"`
+	Version int    `json:"version,omitempty"`
+	// Chunk is set for problems of a single chunk of a file
+	Chunk   *int   `json:"chunk,omitempty"`
+	Problem string `json:"problem"`
+	Error   string `json:"error,omitempty"`
+}
+
+type VerifyReport struct {
+	OK         bool      `json:"ok"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	// Secrets, Values and Chunks count checked entries, Values counts every version
+	Secrets int `json:"secrets"`
+	Values  int `json:"values"`
+	Chunks  int `json:"chunks"`
+	// Legacy counts values still sealed in the legacy format
+	Legacy int            `json:"legacy"`
+	Issues []*VerifyIssue `json:"issues"`
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// Operations of change events.
+const (
+	ChangeCreate = "create"
+	ChangeUpdate = "update"
+	ChangeDelete = "delete"
+)
+
+// ChangeEvent is a committed change of a secret, it never carries the value.
+type ChangeEvent struct {
+	Op string `json:"op"`
+	// Username is the namespace of the secret, Path is relative to it
+	Username string    `json:"-"`
+	Path     string    `json:"path"`
+	Key      string    `json:"key"`
+	Version  int       `json:"version"`
+	Time     time.Time `json:"time"`
+}
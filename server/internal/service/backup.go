@@ -0,0 +1,79 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	storage "github.com/liriquew/secret_storage/server/internal/encrypted_storage"
+	"github.com/liriquew/secret_storage/server/internal/lib/backup"
+	"github.com/liriquew/secret_storage/server/pkg/logger/sl"
+)
+
+func (s *Service) Backup(c *gin.Context) {
+	passphrase := c.GetHeader(passphraseHeader)
+	if passphrase == "" {
+		c.String(http.StatusBadRequest, "passphrase required")
+		return
+	}
+
+	c.Header("Content-Type", "application/octet-stream")
+	c.Header("Content-Disposition", `attachment; filename="backup.ssb"`)
+
+	err := s.repo().Backup(c.Writer, []byte(passphrase))
+	if err != nil {
+		s.log.Error("error while streaming backup", sl.Err(err))
+		if c.Writer.Written() {
+			// the envelope misses its last chunk, so the client
+			// can't mistake the truncated stream for a backup
+			c.Abort()
+			return
+		}
+
+		c.Writer.Header().Del("Content-Type")
+		c.Writer.Header().Del("Content-Disposition")
+		if errors.Is(err, storage.ErrSnapshotUnsupported) {
+			c.String(http.StatusNotImplemented, err.Error())
+			return
+		}
+		c.Status(http.StatusInternalServerError)
+	}
+}
+
+// Restore swaps the storage for a backup, the node must be sealed
+// and the backup is used once it is unsealed.
+func (s *Service) Restore(c *gin.Context) {
+	passphrase := c.GetHeader(passphraseHeader)
+	if passphrase == "" {
+		c.String(http.StatusBadRequest, "passphrase required")
+		return
+	}
+
+	s.sealMu.Lock()
+	defer s.sealMu.Unlock()
+
+	if s.repo() != nil {
+		c.String(http.StatusConflict, "storage must be sealed")
+		return
+	}
+
+	err := storage.Restore(s.storageCfg, c.Request.Body, []byte(passphrase))
+	if err != nil {
+		s.log.Error("error while restoring backup", sl.Err(err))
+		switch {
+		case errors.Is(err, backup.ErrBadFormat) ||
+			errors.Is(err, backup.ErrUnsupportedVersion) ||
+			errors.Is(err, backup.ErrCorrupted) ||
+			errors.Is(err, backup.ErrChecksumMismatch) ||
+			errors.Is(err, storage.ErrInvalidSnapshot):
+			c.String(http.StatusBadRequest, err.Error())
+		case errors.Is(err, storage.ErrSnapshotUnsupported):
+			c.String(http.StatusNotImplemented, err.Error())
+		default:
+			c.Status(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
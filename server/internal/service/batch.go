@@ -0,0 +1,102 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	storage "github.com/liriquew/secret_storage/server/internal/encrypted_storage"
+	"github.com/liriquew/secret_storage/server/internal/models"
+	"github.com/liriquew/secret_storage/server/pkg/logger/sl"
+)
+
+const maxBatchSize = 1000
+
+func (s *Service) Batch(c *gin.Context) {
+	var opsDTO []*models.BatchOperationDTO
+	if err := c.ShouldBindJSON(&opsDTO); err != nil {
+		c.String(http.StatusBadRequest, "bad json")
+		return
+	}
+
+	if len(opsDTO) == 0 || len(opsDTO) > maxBatchSize {
+		c.String(http.StatusBadRequest, "batch must contain from 1 to %d operations", maxBatchSize)
+		return
+	}
+
+	username := extractUsername(c)
+
+	ops := make([]*models.BatchOperation, 0, len(opsDTO))
+	for i, opDTO := range opsDTO {
+		op := &models.BatchOperation{
+			Op:   opDTO.Op,
+			Path: userPath(username, opDTO.Path),
+			Key:  opDTO.Key,
+		}
+
+		switch opDTO.Op {
+		case models.BatchOpSet:
+			expiresAt, err := extractExpiration(&opDTO.RecordDTO)
+			if err != nil {
+				c.String(http.StatusBadRequest, "operation %d: bad expiration: %s", i, err)
+				return
+			}
+
+			op.Value = opDTO.ToInternalRecord().Value
+			op.Opts = models.WriteOptions{
+				Author:          username,
+				ExpiresAt:       expiresAt,
+				ClearExpiration: opDTO.ClearExpiration,
+				CAS:             opDTO.CAS,
+			}
+		case models.BatchOpDelete:
+		default:
+			c.String(http.StatusBadRequest, "operation %d: unknown operation %q", i, opDTO.Op)
+			return
+		}
+
+		ops = append(ops, op)
+	}
+
+	results, err := s.repo().Batch(ops)
+	if err != nil {
+		s.log.Error("error while applying batch", sl.Err(err))
+
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, storage.ErrIncorrectPath) || errors.Is(err, storage.ErrEmptyPathPart):
+			status = http.StatusBadRequest
+		case errors.Is(err, storage.ErrBucketNotFound) || errors.Is(err, storage.ErrRecordNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, storage.ErrCASMismatch):
+			status = http.StatusConflict
+		}
+		quotaStatus, overQuota := quotaErrStatus(err)
+		if overQuota {
+			status = quotaStatus
+		}
+
+		if len(results) == 0 {
+			if overQuota {
+				// the batch as a whole is over quota
+				c.String(status, err.Error())
+				return
+			}
+			c.Status(status)
+			return
+		}
+
+		failed := results[len(results)-1]
+		c.JSON(status, gin.H{
+			"error":     failed.Error,
+			"operation": len(results) - 1,
+		})
+		return
+	}
+
+	for i, result := range results {
+		result.Path = opsDTO[i].Path
+	}
+
+	c.JSON(http.StatusOK, results)
+}
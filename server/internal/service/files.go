@@ -0,0 +1,214 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	storage "github.com/liriquew/secret_storage/server/internal/encrypted_storage"
+	"github.com/liriquew/secret_storage/server/internal/models"
+	"github.com/liriquew/secret_storage/server/pkg/logger/sl"
+)
+
+const (
+	fileFormField      = "file"
+	defaultContentType = "application/octet-stream"
+)
+
+// extractUpload returns the content of an upload, either the raw body or
+// the "file" part of a multipart form.
+func extractUpload(c *gin.Context) (io.Reader, *models.FileInfo, error) {
+	if c.ContentType() != "multipart/form-data" {
+		info := &models.FileInfo{
+			Name:        c.Query(nameParam),
+			ContentType: c.ContentType(),
+		}
+		if info.ContentType == "" {
+			info.ContentType = defaultContentType
+		}
+		return c.Request.Body, info, nil
+	}
+
+	form, err := c.Request.MultipartReader()
+	if err != nil {
+		return nil, nil, err
+	}
+	for {
+		part, err := form.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil, nil, errors.New("file part not found")
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if part.FormName() != fileFormField {
+			continue
+		}
+
+		info := &models.FileInfo{
+			Name:        part.FileName(),
+			ContentType: part.Header.Get("Content-Type"),
+		}
+		if info.ContentType == "" {
+			info.ContentType = defaultContentType
+		}
+		return part, info, nil
+	}
+}
+
+// UploadFile stores the request body as a new version of a file secret.
+func (s *Service) UploadFile(c *gin.Context) {
+	key := c.Param(keyParam)
+	path := extractPath(c)
+
+	cas, err := extractCAS(c)
+	if err != nil {
+		c.String(http.StatusBadRequest, "bad cas")
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, s.maxFileSize)
+	content, info, err := extractUpload(c)
+	if err != nil {
+		c.String(http.StatusBadRequest, "bad upload: %s", err)
+		return
+	}
+
+	opts := models.WriteOptions{
+		Author: extractUsername(c),
+		CAS:    cas,
+	}
+
+	upload, err := s.repo().NewUpload(info)
+	if err != nil {
+		s.log.Error("error while starting upload", sl.Err(err))
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		if err := upload.Abort(); err != nil {
+			s.log.Error("error while dropping upload", sl.Err(err))
+		}
+	}()
+
+	version, err := s.writeUpload(c.Request.Context(), upload, content, path, key, opts)
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		c.Status(http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		s.log.Error("error while uploading file", sl.Err(err))
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			c.String(http.StatusRequestEntityTooLarge, "file is larger than %d bytes", maxBytesErr.Limit)
+			return
+		}
+		if status, ok := quotaErrStatus(err); ok {
+			c.String(status, err.Error())
+			return
+		}
+		if errors.Is(err, storage.ErrIncorrectPath) || errors.Is(err, storage.ErrEmptyPathPart) {
+			c.Status(http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, storage.ErrCASMismatch) {
+			c.String(http.StatusConflict, err.Error())
+			return
+		}
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"version": version,
+	})
+}
+
+// writeUpload reads the content batch by batch and writes every batch holding
+// an upload slot, a client sending its file slowly doesn't hold one.
+func (s *Service) writeUpload(ctx context.Context, upload *storage.Upload, content io.Reader, path []string, key string, opts models.WriteOptions) (int, error) {
+	for done := false; !done; {
+		var err error
+		if done, err = upload.ReadBatch(content); err != nil {
+			return 0, err
+		}
+		if err := s.withUploadSlot(ctx, upload.WriteBatch); err != nil {
+			return 0, err
+		}
+	}
+
+	var version int
+	err := s.withUploadSlot(ctx, func() (err error) {
+		version, err = upload.Commit(path, key, opts)
+		return err
+	})
+	return version, err
+}
+
+// withUploadSlot runs write holding an upload slot, limiting uploads
+// writing at once bounds the load they put on the storage.
+func (s *Service) withUploadSlot(ctx context.Context, write func() error) error {
+	select {
+	case s.uploads <- struct{}{}:
+		defer func() { <-s.uploads }()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return write()
+}
+
+// DownloadFile streams the content of a file secret, ranges are supported.
+func (s *Service) DownloadFile(c *gin.Context) {
+	key := c.Param(keyParam)
+	path := extractPath(c)
+
+	version, err := extractVersion(c)
+	if err != nil {
+		c.String(http.StatusBadRequest, "bad version")
+		return
+	}
+
+	file, err := s.repo().OpenFile(path, key, version)
+	if err != nil {
+		s.log.Error("error while opening file", sl.Err(err))
+		switch {
+		case errors.Is(err, storage.ErrEmptyPathPart):
+			c.Status(http.StatusBadRequest)
+		case errors.Is(err, storage.ErrBucketNotFound) ||
+			errors.Is(err, storage.ErrRecordNotFound) ||
+			errors.Is(err, storage.ErrVersionNotFound):
+			c.Status(http.StatusNotFound)
+		case errors.Is(err, storage.ErrNotFile):
+			c.String(http.StatusConflict, err.Error())
+		default:
+			c.Status(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	info := file.Info()
+	name := info.Name
+	if name == "" {
+		name = key
+	}
+
+	// every upload has its own id, so it identifies the content
+	c.Header("ETag", `"`+hex.EncodeToString(file.Record.File.ID)+`"`)
+	c.Header("Repr-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(file.Digest)+":")
+	c.Header("Content-Type", info.ContentType)
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	c.Header("X-Secret-Version", strconv.Itoa(file.Record.Version))
+
+	http.ServeContent(c.Writer, c.Request, "", time.Time{}, file)
+	if err := file.Err(); err != nil {
+		// the response is cut short of its Content-Length
+		s.log.Error("error while streaming file", sl.Err(err))
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"errors"
-	"log/slog"
 	"net/http"
 	"strconv"
 
@@ -28,7 +27,7 @@ func (s *Service) SignUp(c *gin.Context) {
 		return
 	}
 
-	if err := s.repository.CreateUser(user); err != nil {
+	if err := s.repo().CreateUser(user); err != nil {
 		c.Status(http.StatusTeapot)
 		return
 	}
@@ -58,7 +57,7 @@ func (s *Service) SignIn(c *gin.Context) {
 		return
 	}
 
-	if err := s.repository.CheckUserCredentials(user); err != nil {
+	if err := s.repo().CheckUserCredentials(user); err != nil {
 		s.log.Error("error while singUp", sl.Err(err))
 		c.Status(http.StatusUnauthorized)
 		return
@@ -85,24 +84,64 @@ func (s *Service) Create(c *gin.Context) {
 
 	path := extractPath(c)
 
-	if err := s.repository.Set(path, record.Key, record.ToInternalRecord().Value); err != nil {
+	expiresAt, err := extractExpiration(record)
+	if err != nil {
+		c.String(http.StatusBadRequest, "bad expiration: %s", err)
+		return
+	}
+
+	cas, err := extractCAS(c)
+	if err != nil {
+		c.String(http.StatusBadRequest, "bad cas")
+		return
+	}
+	if cas == nil && c.Query(overwriteParam) != "true" {
+		// record must not exist unless overwrite is requested
+		cas = new(int)
+	}
+
+	opts := models.WriteOptions{
+		Author:          extractUsername(c),
+		ExpiresAt:       expiresAt,
+		ClearExpiration: record.ClearExpiration,
+		CAS:             cas,
+	}
+
+	version, err := s.repo().Set(path, record.Key, record.ToInternalRecord().Value, opts)
+	if err != nil {
 		s.log.Error("error while creating record", sl.Err(err))
-		if errors.Is(err, storage.ErrIncorrectPath) {
+		if status, ok := quotaErrStatus(err); ok {
+			c.String(status, err.Error())
+			return
+		}
+		if errors.Is(err, storage.ErrIncorrectPath) || errors.Is(err, storage.ErrEmptyPathPart) {
 			c.Status(http.StatusBadRequest)
 			return
 		}
+		if errors.Is(err, storage.ErrCASMismatch) {
+			c.String(http.StatusConflict, err.Error())
+			return
+		}
 		c.Status(http.StatusInternalServerError)
 		return
 	}
 
-	c.Status(http.StatusOK)
+	c.JSON(http.StatusOK, gin.H{
+		"version": version,
+	})
 }
 
 func (s *Service) Get(c *gin.Context) {
 	key := c.Param(keyParam)
 	path := extractPath(c)
 
-	value, err := s.repository.Get(path, key)
+	version, err := extractVersion(c)
+	if err != nil {
+		c.String(http.StatusBadRequest, "bad version")
+		return
+	}
+
+	record, err := s.repo().Get(path, key, version)
 	if err != nil {
 		s.log.Error("error while getting record", sl.Err(err))
 		if errors.Is(err, storage.ErrEmptyPathPart) {
@@ -113,7 +152,7 @@ func (s *Service) Get(c *gin.Context) {
 			c.Status(http.StatusNotFound)
 			return
 		}
-		if errors.Is(err, storage.ErrRecordNotFound) {
+		if errors.Is(err, storage.ErrRecordNotFound) || errors.Is(err, storage.ErrVersionNotFound) {
 			c.Status(http.StatusNotFound)
 			return
 		}
@@ -122,7 +161,7 @@ func (s *Service) Get(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, &models.Record{Key: []byte(key), Value: value})
+	c.JSON(http.StatusOK, record)
 }
 
 func (s *Service) Update(c *gin.Context) {
@@ -137,44 +176,277 @@ func (s *Service) Update(c *gin.Context) {
 
 	path := extractPath(c)
 
-	if err := s.repository.Set(path, record.Key, record.ToInternalRecord().Value); err != nil {
+	expiresAt, err := extractExpiration(record)
+	if err != nil {
+		c.String(http.StatusBadRequest, "bad expiration: %s", err)
+		return
+	}
+
+	cas, err := extractCAS(c)
+	if err != nil {
+		c.String(http.StatusBadRequest, "bad cas")
+		return
+	}
+
+	opts := models.WriteOptions{
+		Author:          extractUsername(c),
+		ExpiresAt:       expiresAt,
+		ClearExpiration: record.ClearExpiration,
+		CAS:             cas,
+	}
+
+	version, err := s.repo().Set(path, record.Key, record.ToInternalRecord().Value, opts)
+	if err != nil {
 		s.log.Error("error while updating record", sl.Err(err))
-		if errors.Is(err, storage.ErrIncorrectPath) {
+		if status, ok := quotaErrStatus(err); ok {
+			c.String(status, err.Error())
+			return
+		}
+		if errors.Is(err, storage.ErrIncorrectPath) || errors.Is(err, storage.ErrEmptyPathPart) {
 			c.Status(http.StatusBadRequest)
 			return
 		}
+		if errors.Is(err, storage.ErrCASMismatch) {
+			c.String(http.StatusConflict, err.Error())
+			return
+		}
 
 		c.Status(http.StatusInternalServerError)
+		return
 	}
 
-	c.Status(http.StatusOK)
+	c.JSON(http.StatusOK, gin.H{
+		"version": version,
+	})
 }
 
 func (s *Service) Delete(c *gin.Context) {
 	key := c.Param(keyParam)
 	path := extractPath(c)
 
-	deleted, err := s.repository.Delete(path, key)
+	deletedAt, err := s.repo().Delete(path, key)
 	if err != nil {
-		s.log.Error("error while getting record", sl.Err(err))
-		if errors.Is(err, storage.ErrBucketNotFound) {
+		s.log.Error("error while deleting record", sl.Err(err))
+		if errors.Is(err, storage.ErrBucketNotFound) || errors.Is(err, storage.ErrRecordNotFound) {
+			c.Status(http.StatusNotFound)
+			return
+		}
+
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"deleted_at": deletedAt,
+	})
+}
+
+func (s *Service) Undelete(c *gin.Context) {
+	key := c.Param(keyParam)
+	path := extractPath(c)
+
+	if err := s.repo().Undelete(path, key); err != nil {
+		s.log.Error("error while undeleting record", sl.Err(err))
+		if status, ok := quotaErrStatus(err); ok {
+			c.String(status, err.Error())
+			return
+		}
+		if errors.Is(err, storage.ErrBucketNotFound) || errors.Is(err, storage.ErrRecordNotFound) {
 			c.Status(http.StatusNotFound)
 			return
 		}
 
 		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+func (s *Service) Destroy(c *gin.Context) {
+	key := c.Param(keyParam)
+	path := extractPath(c)
+
+	deleted, err := s.repo().Destroy(path, key)
+	if err != nil {
+		s.log.Error("error while destroying record", sl.Err(err))
+		if errors.Is(err, storage.ErrBucketNotFound) || errors.Is(err, storage.ErrRecordNotFound) {
+			c.Status(http.StatusNotFound)
+			return
+		}
+
+		c.Status(http.StatusInternalServerError)
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"deleted_buckets": deleted,
 	})
 }
+
+// DeleteBucket removes the bucket at path, with recursive=true together with
+// everything inside. With dry_run=true the response lists what would be removed.
+func (s *Service) DeleteBucket(c *gin.Context) {
+	path := extractPath(c)
+	if len(path) == 1 {
+		// the whole namespace can't be deleted
+		c.String(http.StatusBadRequest, "path is required")
+		return
+	}
+
+	report, err := s.repo().DeleteBucket(path, c.Query(recursiveParam) == "true", c.Query(dryRunParam) == "true")
+	if err != nil {
+		s.log.Error("error while deleting bucket", sl.Err(err))
+		switch {
+		case errors.Is(err, storage.ErrBucketNotFound):
+			c.Status(http.StatusNotFound)
+		case errors.Is(err, storage.ErrBucketNotEmpty):
+			c.String(http.StatusConflict, "bucket is not empty, recursive=true is required")
+		default:
+			c.Status(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+func (s *Service) ListVersions(c *gin.Context) {
+	key := c.Param(keyParam)
+	path := extractPath(c)
+
+	versions, err := s.repo().ListVersions(path, key)
+	if err != nil {
+		s.log.Error("error while listing versions", sl.Err(err))
+		if errors.Is(err, storage.ErrBucketNotFound) || errors.Is(err, storage.ErrRecordNotFound) {
+			c.Status(http.StatusNotFound)
+			return
+		}
+
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, versions)
+}
+
+func (s *Service) Rollback(c *gin.Context) {
+	key := c.Param(keyParam)
+	path := extractPath(c)
+
+	version, err := extractVersion(c)
+	if err != nil || version == 0 {
+		c.String(http.StatusBadRequest, "bad version")
+		return
+	}
+
+	newVersion, err := s.repo().Rollback(path, key, version, models.WriteOptions{Author: extractUsername(c)})
+	if err != nil {
+		s.log.Error("error while rolling back record", sl.Err(err))
+		if status, ok := quotaErrStatus(err); ok {
+			c.String(status, err.Error())
+			return
+		}
+		if errors.Is(err, storage.ErrBucketNotFound) ||
+			errors.Is(err, storage.ErrRecordNotFound) ||
+			errors.Is(err, storage.ErrVersionNotFound) {
+			c.Status(http.StatusNotFound)
+			return
+		}
+
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"version": newVersion,
+	})
+}
+
+func (s *Service) Quota(c *gin.Context) {
+	status, err := s.repo().Usage(extractUsername(c))
+	if err != nil {
+		s.log.Error("error while getting usage", sl.Err(err))
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+func (s *Service) GetMetadata(c *gin.Context) {
+	key := c.Param(keyParam)
+	path := extractPath(c)
+
+	metadata, err := s.repo().GetMetadata(path, key)
+	if err != nil {
+		s.log.Error("error while getting metadata", sl.Err(err))
+		if errors.Is(err, storage.ErrBucketNotFound) || errors.Is(err, storage.ErrRecordNotFound) {
+			c.Status(http.StatusNotFound)
+			return
+		}
+
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, metadata)
+}
+
+func (s *Service) UpdateMetadata(c *gin.Context) {
+	metadataUpdate := &struct {
+		Labels map[string]string `json:"labels"`
+	}{}
+	if err := c.ShouldBindJSON(metadataUpdate); err != nil {
+		c.String(http.StatusBadRequest, "bad json")
+		return
+	}
+
+	for label := range metadataUpdate.Labels {
+		if label == "" {
+			c.String(http.StatusBadRequest, "empty label name")
+			return
+		}
+	}
+
+	key := c.Param(keyParam)
+	path := extractPath(c)
+
+	metadata, err := s.repo().SetLabels(path, key, metadataUpdate.Labels)
+	if err != nil {
+		s.log.Error("error while updating metadata", sl.Err(err))
+		if errors.Is(err, storage.ErrBucketNotFound) || errors.Is(err, storage.ErrRecordNotFound) {
+			c.Status(http.StatusNotFound)
+			return
+		}
+
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, metadata)
+}
+
 func (s *Service) ListSecrets(c *gin.Context) {
 	path := extractPath(c)
 
-	records, err := s.repository.ListRecords(path)
+	page, err := extractPage(c)
+	if err != nil {
+		c.String(http.StatusBadRequest, "bad page: %s", err)
+		return
+	}
+
+	records, err := s.repo().ListRecords(path, page)
 	if err != nil {
 		s.log.Error("error while listing records", sl.Err(err))
+		if errors.Is(err, storage.ErrBucketNotFound) {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, storage.ErrEmptyPathPart) {
+			c.String(http.StatusBadRequest, "bad path")
+			return
+		}
 		c.Status(http.StatusInternalServerError)
 		return
 	}
@@ -185,9 +457,17 @@ func (s *Service) ListSecrets(c *gin.Context) {
 func (s *Service) ListSecretsRecursively(c *gin.Context) {
 	path := extractPath(c)
 
-	records, err := s.repository.ListRecordsRecursively(path)
+	records, err := s.repo().ListRecordsRecursively(path)
 	if err != nil {
 		s.log.Error("error while listing recursively records", sl.Err(err))
+		if errors.Is(err, storage.ErrBucketNotFound) {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, storage.ErrEmptyPathPart) {
+			c.String(http.StatusBadRequest, "bad path")
+			return
+		}
 		c.Status(http.StatusInternalServerError)
 		return
 	}
@@ -195,6 +475,38 @@ func (s *Service) ListSecretsRecursively(c *gin.Context) {
 	c.JSON(http.StatusOK, records)
 }
 
+func (s *Service) Search(c *gin.Context) {
+	query := &models.SearchQuery{
+		Pattern:    c.Query(queryParam),
+		Prefix:     c.Query(prefixParam),
+		WithValues: c.Query(valuesParam) == "true",
+	}
+	if query.Pattern == "" && query.Prefix == "" {
+		c.String(http.StatusBadRequest, "q or prefix required")
+		return
+	}
+
+	matches, err := s.repo().Search(extractPath(c), query)
+	if err != nil && !errors.Is(err, storage.ErrBucketNotFound) {
+		s.log.Error("error while searching records", sl.Err(err))
+		if errors.Is(err, storage.ErrBadSearchPattern) || errors.Is(err, storage.ErrEmptyPathPart) {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	if matches == nil {
+		matches = []*models.SearchMatch{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"matches": matches,
+	})
+}
+
 func (s *Service) Unseal(c *gin.Context) {
 	part := c.Query(partParam)
 
@@ -219,6 +531,11 @@ func (s *Service) UnsealComplete(c *gin.Context) {
 	err := s.Setup()
 	if err != nil {
 		s.log.Error("error while comleting unseal", sl.Err(err))
+		if errors.Is(err, storage.ErrMasterKeyMismatch) {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
 		c.Status(http.StatusInternalServerError)
 		return
 	}
@@ -249,7 +566,7 @@ func (s *Service) Master(c *gin.Context) {
 
 func (s *Service) MasterComplete(c *gin.Context) {
 	err := s.Notify(func(n int) ([][]byte, error) {
-		masterKey, err := GeneratePassword(32)
+		masterKey, err := GeneratePassword(masterKeySize)
 		if err != nil {
 			return nil, err
 		}
@@ -263,7 +580,6 @@ func (s *Service) MasterComplete(c *gin.Context) {
 			}{
 				Part: base64.RawStdEncoding.EncodeToString(part),
 			})
-			s.log.Info("part:", slog.String("part", string(parts[i])))
 		}
 		return parts, nil
 	})
@@ -274,3 +590,18 @@ func (s *Service) MasterComplete(c *gin.Context) {
 
 	c.Status(http.StatusOK)
 }
+
+func (s *Service) HAStatus(c *gin.Context) {
+	repository := s.repo()
+	if repository == nil {
+		// sealed node doesn't take part in the cluster
+		c.JSON(http.StatusOK, &models.HAStatus{
+			Enabled: s.storageCfg.HA.Enabled,
+			NodeID:  s.storageCfg.HA.NodeID,
+			State:   "Sealed",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, repository.HAStatus())
+}
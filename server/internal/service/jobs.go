@@ -0,0 +1,123 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/liriquew/secret_storage/server/pkg/logger/sl"
+)
+
+// RunPurger removes records deleted more than DeleteRetention ago
+// every PurgeInterval until ctx is done.
+func (s *Service) RunPurger(ctx context.Context) {
+	runPeriodically(ctx, s.storageCfg.PurgeInterval, func() {
+		repository := s.repo()
+		if repository == nil || !repository.HAStatus().IsLeader() {
+			// storage is sealed or the job belongs to the leader of the cluster
+			return
+		}
+
+		purged, err := repository.PurgeDeleted(time.Now().Add(-s.storageCfg.DeleteRetention))
+		if err != nil {
+			s.log.Error("error while purging deleted records", sl.Err(err))
+			return
+		}
+
+		if purged > 0 {
+			s.log.Info("deleted records purged", slog.Int("count", purged))
+		}
+	})
+}
+
+// staleUploadAge is the age of an upload which isn't committed yet after which
+// it is dropped as abandoned, the node writing it was restarted.
+const staleUploadAge = 24 * time.Hour
+
+// RunReaper removes expired records and abandoned uploads every ReapInterval until ctx is done.
+func (s *Service) RunReaper(ctx context.Context) {
+	runPeriodically(ctx, s.storageCfg.ReapInterval, func() {
+		repository := s.repo()
+		if repository == nil || !repository.HAStatus().IsLeader() {
+			return
+		}
+
+		reaped, err := repository.DeleteExpired(time.Now())
+		if err != nil {
+			s.log.Error("error while deleting expired records", sl.Err(err))
+			return
+		}
+
+		if reaped > 0 {
+			s.log.Info("expired records deleted", slog.Int("count", reaped))
+		}
+
+		dropped, err := repository.DropStaleUploads(time.Now().Add(-staleUploadAge))
+		if err != nil {
+			s.log.Error("error while dropping stale uploads", sl.Err(err))
+			return
+		}
+
+		if dropped > 0 {
+			s.log.Info("stale uploads dropped", slog.Int("count", dropped))
+		}
+	})
+}
+
+// RunResealer reseals values stored in the legacy format, unbound to their
+// location, every ResealInterval until ctx is done.
+func (s *Service) RunResealer(ctx context.Context) {
+	runPeriodically(ctx, s.storageCfg.ResealInterval, func() {
+		repository := s.repo()
+		if repository == nil || !repository.HAStatus().IsLeader() {
+			return
+		}
+
+		resealed, err := repository.ResealLegacy()
+		if err != nil {
+			s.log.Error("error while resealing legacy records", sl.Err(err))
+		}
+
+		if resealed > 0 {
+			s.log.Info("legacy records resealed", slog.Int("count", resealed))
+		}
+	})
+}
+
+// RunRewrapper seals a batch of values sealed with older data keys again
+// with the active key every RewrapInterval until ctx is done.
+func (s *Service) RunRewrapper(ctx context.Context) {
+	runPeriodically(ctx, s.storageCfg.RewrapInterval, func() {
+		repository := s.repo()
+		if repository == nil || !repository.HAStatus().IsLeader() {
+			return
+		}
+
+		rewrapped, err := repository.Rewrap()
+		if err != nil {
+			s.log.Error("error while rewrapping records", sl.Err(err))
+		}
+
+		if rewrapped > 0 {
+			s.log.Info("records rewrapped with the active key", slog.Int("count", rewrapped))
+		}
+	})
+}
+
+func runPeriodically(ctx context.Context, interval time.Duration, job func()) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			job()
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	storage "github.com/liriquew/secret_storage/server/internal/encrypted_storage"
+	"github.com/liriquew/secret_storage/server/pkg/logger/sl"
+)
+
+// Rotate adds a data key to the keyring, new values are sealed with it
+// while values sealed with older keys stay readable.
+func (s *Service) Rotate(c *gin.Context) {
+	status, err := s.repo().Rotate()
+	if err != nil {
+		s.log.Error("error while rotating data key", sl.Err(err))
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+// RewrapStatus reports the progress of rewrapping stored data with the active key.
+func (s *Service) RewrapStatus(c *gin.Context) {
+	status, err := s.repo().RewrapStatus()
+	if err != nil {
+		s.log.Error("error while reading rewrap status", sl.Err(err))
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+// PurgeKeys removes the data keys no stored ciphertext is sealed with.
+func (s *Service) PurgeKeys(c *gin.Context) {
+	status, err := s.repo().PurgeKeys()
+	if err != nil {
+		if errors.Is(err, storage.ErrRewrapIncomplete) || errors.Is(err, storage.ErrKeysInUse) {
+			c.String(http.StatusConflict, err.Error())
+			return
+		}
+
+		s.log.Error("error while purging data keys", sl.Err(err))
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
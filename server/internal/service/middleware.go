@@ -3,14 +3,16 @@ package service
 import (
 	"log/slog"
 	"net/http"
+	"slices"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/liriquew/secret_storage/server/internal/lib/jwt"
+	"github.com/liriquew/secret_storage/server/internal/models"
 )
 
 func (s *Service) ShamirRequired(c *gin.Context) {
-	if s.repository == nil {
+	if s.repo() == nil {
 		c.AbortWithStatusJSON(http.StatusTeapot, gin.H{
 			"type": "storage is encrypted now",
 		})
@@ -41,3 +43,42 @@ func (s *Service) AuthRequired(c *gin.Context) {
 
 	c.Next()
 }
+
+// AdminRequired lets through only users listed as admins in the service config,
+// it must follow AuthRequired.
+func (s *Service) AdminRequired(c *gin.Context) {
+	if !slices.Contains(s.admins, extractUsername(c)) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"type": "admin rights required"})
+		return
+	}
+
+	c.Next()
+}
+
+// LeaderRequired redirects writes sent to a follower of the HA cluster to the leader.
+func (s *Service) LeaderRequired(c *gin.Context) {
+	if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+		c.Next()
+		return
+	}
+
+	status := s.repo().HAStatus()
+	if status.IsLeader() {
+		c.Next()
+		return
+	}
+
+	redirectToLeader(c, status)
+}
+
+func redirectToLeader(c *gin.Context, status *models.HAStatus) {
+	if status.LeaderAPIAddr == "" {
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+			"type": "cluster has no leader",
+		})
+		return
+	}
+
+	c.Redirect(http.StatusTemporaryRedirect, strings.TrimSuffix(status.LeaderAPIAddr, "/")+c.Request.URL.RequestURI())
+	c.Abort()
+}
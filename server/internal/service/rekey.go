@@ -0,0 +1,320 @@
+package service
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	storage "github.com/liriquew/secret_storage/server/internal/encrypted_storage"
+	"github.com/liriquew/secret_storage/server/internal/lib/shamir"
+	"github.com/liriquew/secret_storage/server/internal/models"
+	socketnotifier "github.com/liriquew/secret_storage/server/internal/socket_notifier"
+	"github.com/liriquew/secret_storage/server/pkg/logger/sl"
+)
+
+// masterKeySize is the size of master keys made by /master and by rekey,
+// a share is one byte longer for its x-coordinate.
+const masterKeySize = 32
+
+// rekeyCeremony collects shares of the current master key, once they reconstruct it
+// the keyring is wrapped with a new master key split into parts with threshold.
+// Every new share is sent to its own receiver, like the shares made by /master.
+type rekeyCeremony struct {
+	nonce     string
+	parts     int
+	threshold int
+	// currentThreshold is the number of current shares reconstructing the master key
+	currentThreshold int
+	shares           shamir.ShamirInfo
+	receivers        *socketnotifier.Notifier
+}
+
+func (s *Service) rekeyStatus() *models.RekeyStatus {
+	if s.rekey == nil {
+		return &models.RekeyStatus{}
+	}
+
+	return &models.RekeyStatus{
+		Started:          true,
+		Nonce:            s.rekey.nonce,
+		Parts:            s.rekey.parts,
+		Threshold:        s.rekey.threshold,
+		CurrentThreshold: s.rekey.currentThreshold,
+		Progress:         s.rekey.shares.Count(),
+		Receivers:        s.rekey.receivers.Count(),
+	}
+}
+
+func (s *Service) RekeyStatus(c *gin.Context) {
+	s.rekeyMu.Lock()
+	defer s.rekeyMu.Unlock()
+
+	c.JSON(http.StatusOK, s.rekeyStatus())
+}
+
+// RekeyInit starts the ceremony, the returned nonce must be sent with every share.
+func (s *Service) RekeyInit(c *gin.Context) {
+	parts, err := strconv.Atoi(c.Query(partsParam))
+	if err != nil {
+		c.String(http.StatusBadRequest, "bad parts")
+		return
+	}
+	threshold, err := strconv.Atoi(c.Query(thresholdParam))
+	if err != nil {
+		c.String(http.StatusBadRequest, "bad threshold")
+		return
+	}
+	if threshold < 2 || parts < threshold || parts > 255 {
+		c.String(http.StatusBadRequest, "must be 2 <= threshold <= parts <= 255")
+		return
+	}
+	currentThreshold, err := strconv.Atoi(c.Query(currentThresholdParam))
+	if err != nil || currentThreshold < 2 || currentThreshold > 255 {
+		c.String(http.StatusBadRequest, "must be 2 <= current_threshold <= 255")
+		return
+	}
+
+	s.rekeyMu.Lock()
+	defer s.rekeyMu.Unlock()
+
+	if s.rekey != nil {
+		c.String(http.StatusConflict, "rekey already started")
+		return
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		s.log.Error("error while generating rekey nonce", sl.Err(err))
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	s.rekey = &rekeyCeremony{
+		nonce:            hex.EncodeToString(nonce),
+		parts:            parts,
+		threshold:        threshold,
+		currentThreshold: currentThreshold,
+		shares:           shamir.NewShamirInfo(),
+		receivers:        socketnotifier.New(s.log),
+	}
+
+	c.JSON(http.StatusOK, s.rekeyStatus())
+}
+
+// RekeyShares connects a receiver of a new share by websocket, the share is sent once
+// the ceremony completes. Shares are accepted only when every new share has its receiver.
+func (s *Service) RekeyShares(c *gin.Context) {
+	s.rekeyMu.Lock()
+	defer s.rekeyMu.Unlock()
+
+	ceremony := s.rekey
+	if ceremony == nil {
+		c.String(http.StatusBadRequest, "rekey isn't started")
+		return
+	}
+	if c.Query(nonceParam) != ceremony.nonce {
+		c.String(http.StatusConflict, "nonce mismatch")
+		return
+	}
+	if ceremony.receivers.Count() == ceremony.parts {
+		c.String(http.StatusConflict, "every share has its receiver")
+		return
+	}
+
+	if err := ceremony.receivers.AddConn(c); err != nil {
+		s.log.Error("error while adding websocket connection", sl.Err(err))
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+// RekeyUpdate adds a share of the current master key. Every share is checked as it comes,
+// until currentThreshold of them are submitted the progress is returned with 202. Shares
+// which don't reconstruct the master key are dropped with 400. Otherwise the keyring is
+// wrapped with a new master key and its shares are sent to their receivers, followed by
+// the outcome. If a share can't be delivered the old master key is restored.
+func (s *Service) RekeyUpdate(c *gin.Context) {
+	part := c.Query(partParam)
+	raw, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(part, " ", "+"))
+	if part == "" || err != nil {
+		c.String(http.StatusBadRequest, "bad part")
+		return
+	}
+	if len(raw) != masterKeySize+1 {
+		c.String(http.StatusBadRequest, "part must be %d bytes, got %d", masterKeySize+1, len(raw))
+		return
+	}
+	if raw[masterKeySize] == 0 {
+		c.String(http.StatusBadRequest, "part has zero x-coordinate")
+		return
+	}
+
+	s.rekeyMu.Lock()
+	defer s.rekeyMu.Unlock()
+
+	ceremony := s.rekey
+	if ceremony == nil {
+		c.String(http.StatusBadRequest, "rekey isn't started")
+		return
+	}
+	if c.Query(nonceParam) != ceremony.nonce {
+		c.String(http.StatusConflict, "nonce mismatch")
+		return
+	}
+	if receivers := ceremony.receivers.Count(); receivers != ceremony.parts {
+		c.String(http.StatusConflict, "%d of %d share receivers connected", receivers, ceremony.parts)
+		return
+	}
+
+	oldParts, err := ceremony.shares.Parts()
+	if err != nil {
+		s.log.Error("error while decoding rekey parts", sl.Err(err))
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	for _, added := range oldParts {
+		if bytes.Equal(added, raw) {
+			c.String(http.StatusConflict, "part already added")
+			return
+		}
+		// shares of one key never share the x-coordinate
+		if added[masterKeySize] == raw[masterKeySize] {
+			c.String(http.StatusBadRequest, "part with the same x-coordinate is already added, one of them is foreign")
+			return
+		}
+	}
+
+	if err := ceremony.shares.AddPart(part); err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	oldParts = append(oldParts, raw)
+	if len(oldParts) < ceremony.currentThreshold {
+		c.JSON(http.StatusAccepted, s.rekeyStatus())
+		return
+	}
+
+	oldKey, err := shamir.Combine(oldParts)
+	if err == nil {
+		err = s.repo().CheckMasterKey(oldKey)
+		if err != nil && !errors.Is(err, storage.ErrMasterKeyMismatch) {
+			s.log.Error("error while checking master key", sl.Err(err))
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+	}
+	if err != nil {
+		s.log.Error("rekey parts don't reconstruct the master key", sl.Err(err))
+		// one foreign share spoils any set, so the shares are submitted again
+		ceremony.shares.Reset()
+		c.String(http.StatusBadRequest, "submitted parts don't reconstruct the master key, they are dropped: %s", err)
+		return
+	}
+
+	newKey, err := GeneratePassword(masterKeySize)
+	if err != nil {
+		s.log.Error("error while generating master key", sl.Err(err))
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	newParts, err := shamir.Split(newKey, ceremony.parts, ceremony.threshold)
+	if err != nil {
+		s.log.Error("error while splitting master key", sl.Err(err))
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	// the keyring is wrapped first, so the shares sent are never void without
+	// their receivers being told; the ceremony ends whatever the outcome
+	status := s.rekeyStatus()
+	s.rekey = nil
+	defer ceremony.receivers.Close()
+
+	legacyTerm, err := s.repo().Rekey(oldKey, newKey)
+	if err != nil && legacyTerm == 0 {
+		s.log.Error("error while rekeying master key", sl.Err(err))
+		ceremony.receivers.Broadcast(rekeyShareMessage(models.RekeyShareMessage{Error: "the master key isn't changed"}))
+		c.String(http.StatusInternalServerError, "the master key isn't changed, the current shares stay valid")
+		return
+	}
+	if err != nil {
+		// the keyring is already wrapped with the new key, only the rotation failed
+		s.log.Error("error while rotating out the old master key", sl.Err(err))
+	}
+	rotateErr := err
+
+	err = ceremony.receivers.Send(func(int) ([][]byte, error) {
+		messages := make([][]byte, len(newParts))
+		for i, part := range newParts {
+			messages[i] = rekeyShareMessage(models.RekeyShareMessage{Part: base64.RawStdEncoding.EncodeToString(part)})
+		}
+		return messages, nil
+	})
+	if err != nil {
+		s.log.Error("error while sending new shares", sl.Err(err))
+
+		if _, rollbackErr := s.repo().Rekey(newKey, oldKey); rollbackErr != nil {
+			// the delivered shares are the only way to unseal the storage now
+			s.log.Error("error while restoring the old master key", sl.Err(rollbackErr))
+			ceremony.receivers.Broadcast(rekeyShareMessage(models.RekeyShareMessage{
+				Complete: true,
+				Error:    "not every share is delivered and the old master key can't be restored",
+			}))
+			c.String(http.StatusInternalServerError, "new shares aren't delivered to every receiver and the old master key "+
+				"can't be restored, the delivered shares are the current ones")
+			return
+		}
+
+		ceremony.receivers.Broadcast(rekeyShareMessage(models.RekeyShareMessage{
+			Error: "not every share is delivered, the share is void and the master key isn't changed",
+		}))
+		c.String(http.StatusInternalServerError, "new shares aren't delivered, the master key isn't changed")
+		return
+	}
+	ceremony.receivers.Broadcast(rekeyShareMessage(models.RekeyShareMessage{Complete: true}))
+
+	status.Complete = true
+	if legacyTerm != 0 {
+		status.LegacyTerm = legacyTerm
+		status.Warning = fmt.Sprintf("the old master key is the data key of term %d, the old shares open the data sealed with it "+
+			"until the rewrap is done and the term is purged with POST /api/sys/rewrap/purge", legacyTerm)
+		if rotateErr != nil {
+			status.Warning += ", rotate the data key with POST /api/sys/rotate first"
+		}
+	}
+
+	s.log.Info("master key rekeyed",
+		slog.Int("parts", ceremony.parts),
+		slog.Int("threshold", ceremony.threshold),
+		slog.Int("legacy_term", legacyTerm),
+	)
+	c.JSON(http.StatusOK, status)
+}
+
+func rekeyShareMessage(message models.RekeyShareMessage) []byte {
+	buf, _ := json.Marshal(message)
+	return buf
+}
+
+// RekeyCancel drops the submitted shares, the current shares stay valid.
+func (s *Service) RekeyCancel(c *gin.Context) {
+	s.rekeyMu.Lock()
+	defer s.rekeyMu.Unlock()
+
+	if s.rekey != nil {
+		s.rekey.receivers.Close()
+	}
+	s.rekey = nil
+	c.JSON(http.StatusOK, s.rekeyStatus())
+}
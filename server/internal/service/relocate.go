@@ -0,0 +1,72 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	storage "github.com/liriquew/secret_storage/server/internal/encrypted_storage"
+	"github.com/liriquew/secret_storage/server/internal/models"
+	"github.com/liriquew/secret_storage/server/pkg/logger/sl"
+)
+
+func (s *Service) Move(c *gin.Context) {
+	s.relocate(c, true)
+}
+
+func (s *Service) Copy(c *gin.Context) {
+	s.relocate(c, false)
+}
+
+func (s *Service) relocate(c *gin.Context, move bool) {
+	dto := &models.RelocateDTO{}
+	if err := c.ShouldBindJSON(dto); err != nil {
+		c.String(http.StatusBadRequest, "bad json")
+		return
+	}
+
+	username := extractUsername(c)
+
+	relocation := &models.Relocation{
+		From:      userPath(username, dto.From),
+		To:        userPath(username, dto.To),
+		Key:       dto.Key,
+		NewKey:    dto.NewKey,
+		Overwrite: dto.Overwrite,
+		Move:      move,
+	}
+	if relocation.NewKey == "" {
+		relocation.NewKey = relocation.Key
+	}
+	if relocation.Key == "" && (len(relocation.From) == 1 || len(relocation.To) == 1) {
+		// the whole namespace can't be relocated
+		c.String(http.StatusBadRequest, "from and to are required")
+		return
+	}
+
+	relocated, err := s.repo().Relocate(relocation)
+	if err != nil {
+		s.log.Error("error while relocating records", sl.Err(err))
+		if status, ok := quotaErrStatus(err); ok {
+			c.String(status, err.Error())
+			return
+		}
+		switch {
+		case errors.Is(err, storage.ErrRelocationConflict):
+			c.String(http.StatusConflict, err.Error())
+		case errors.Is(err, storage.ErrBucketNotFound) || errors.Is(err, storage.ErrRecordNotFound):
+			c.Status(http.StatusNotFound)
+		case errors.Is(err, storage.ErrRelocationOverlap) ||
+			errors.Is(err, storage.ErrIncorrectPath) ||
+			errors.Is(err, storage.ErrEmptyPathPart):
+			c.String(http.StatusBadRequest, err.Error())
+		default:
+			c.Status(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"relocated": relocated,
+	})
+}
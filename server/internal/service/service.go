@@ -1,8 +1,12 @@
 package service
 
 import (
+	"io"
 	"log/slog"
 	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	encryptedstorage "github.com/liriquew/secret_storage/server/internal/encrypted_storage"
@@ -13,11 +17,37 @@ import (
 )
 
 type Storage interface {
-	Set(path []string, key string, value []byte) error
-	Get(path []string, key string) ([]byte, error)
-	Delete(path []string, key string) (int, error)
-	ListRecords(path []string) (*models.BucketInfo, error)
+	Set(path []string, key string, value []byte, opts models.WriteOptions) (int, error)
+	Get(path []string, key string, version int) (*models.Record, error)
+	Delete(path []string, key string) (time.Time, error)
+	Undelete(path []string, key string) error
+	Destroy(path []string, key string) (int, error)
+	DeleteBucket(path []string, recursive bool, dryRun bool) (*models.BucketDeletion, error)
+	PurgeDeleted(before time.Time) (int, error)
+	DeleteExpired(now time.Time) (int, error)
+	ResealLegacy() (int, error)
+	Batch(ops []*models.BatchOperation) ([]*models.BatchResult, error)
+	ListVersions(path []string, key string) ([]*models.SecretVersion, error)
+	Rollback(path []string, key string, version int, opts models.WriteOptions) (int, error)
+	NewUpload(info *models.FileInfo) (*encryptedstorage.Upload, error)
+	DropStaleUploads(before time.Time) (int, error)
+	OpenFile(path []string, key string, version int) (*encryptedstorage.File, error)
+	GetMetadata(path []string, key string) (*models.SecretMetadata, error)
+	SetLabels(path []string, key string, labels map[string]string) (*models.SecretMetadata, error)
+	ListRecords(path []string, page models.PageOptions) (*models.BucketInfo, error)
 	ListRecordsRecursively(path []string) (*models.BucketFullInfo, error)
+	Search(path []string, query *models.SearchQuery) ([]*models.SearchMatch, error)
+	Relocate(r *models.Relocation) (int, error)
+	Backup(w io.Writer, passphrase []byte) error
+	Verify() (*models.VerifyReport, error)
+	Rotate() (*models.KeyringStatus, error)
+	Rewrap() (int, error)
+	RewrapStatus() (*models.RewrapStatus, error)
+	PurgeKeys() (*models.KeyringStatus, error)
+	CheckMasterKey(key []byte) error
+	Rekey(oldKey, newKey []byte) (int, error)
+	Usage(username string) (*models.QuotaStatus, error)
+	HAStatus() *models.HAStatus
 
 	CreateUser(user *models.User) error
 	CheckUserCredentials(user *models.User) error
@@ -27,32 +57,74 @@ var (
 	pathParam      = "path"
 	partParam      = "part"
 	thresholdParam = "threshold"
+	versionParam   = "version"
+	casParam       = "cas"
+	overwriteParam = "overwrite"
+	queryParam     = "q"
+	prefixParam    = "prefix"
+	valuesParam    = "values"
+	limitParam     = "limit"
+	afterParam     = "after"
+	formatParam    = "format"
+	dryRunParam    = "dry_run"
+	recursiveParam = "recursive"
+	nameParam      = "name"
+	partsParam     = "parts"
+	nonceParam     = "nonce"
+	// currentThresholdParam is the threshold of the shares replaced by rekey
+	currentThresholdParam = "current_threshold"
+	skipFilesParam        = "skip_files"
 
 	usernameKey = "username"
 
+	passphraseHeader   = "X-Backup-Passphrase"
+	skippedFilesHeader = "X-Skipped-File"
+
 	keyParam = "key"
 )
 
 type Service struct {
-	repository Storage
+	// repository holds the Storage, it is set once the storage is unsealed
+	repository atomic.Value
 	log        *slog.Logger
 
 	*socketnotifier.Notifier
+	feed          *socketnotifier.Feed
 	masterKeyInfo shamir.ShamirInfo
 	storageCfg    config.StorageConfig
+	admins        []string
+	maxFileSize   int64
+	// uploads holds a slot for every file upload in progress
+	uploads chan struct{}
+
+	// sealMu keeps restore of a backup from racing with unseal
+	sealMu sync.Mutex
+
+	rekeyMu sync.Mutex
+	rekey   *rekeyCeremony
 }
 
-func New(log *slog.Logger, storageConfig config.StorageConfig) *Service {
+func New(log *slog.Logger, cfg config.AppConfig) *Service {
 	return &Service{
 		log:           log,
 		masterKeyInfo: shamir.NewShamirInfo(),
-		storageCfg:    storageConfig,
+		storageCfg:    cfg.Storage,
+		admins:        cfg.Service.Admins,
+		maxFileSize:   cfg.Service.MaxFileSize,
+		uploads:       make(chan struct{}, max(cfg.Service.MaxConcurrentUploads, 1)),
 		Notifier:      socketnotifier.New(log),
+		feed:          socketnotifier.NewFeed(log),
 	}
 }
 
+// repo returns the unsealed storage, nil while it is sealed.
+func (s *Service) repo() Storage {
+	repository, _ := s.repository.Load().(Storage)
+	return repository
+}
+
 func (s *Service) IsReady(c *gin.Context) {
-	if s.repository == nil {
+	if s.repo() == nil {
 		c.Status(http.StatusExpectationFailed)
 	} else {
 		c.Status(http.StatusOK)
@@ -60,6 +132,9 @@ func (s *Service) IsReady(c *gin.Context) {
 }
 
 func (s *Service) Setup() error {
+	s.sealMu.Lock()
+	defer s.sealMu.Unlock()
+
 	defer s.masterKeyInfo.Reset()
 	parts, err := s.masterKeyInfo.Parts()
 	if err != nil {
@@ -76,6 +151,16 @@ func (s *Service) Setup() error {
 		return err
 	}
 
-	s.repository = storage
+	if migration := storage.Migration(); len(migration.Migrations) > 0 {
+		s.log.Info("storage schema migrated",
+			slog.Int("from", migration.From),
+			slog.Int("to", migration.To),
+			slog.String("backup", migration.Backup),
+		)
+	}
+
+	storage.OnChange(s.feed.Publish)
+
+	s.repository.Store(Storage(storage))
 	return nil
 }
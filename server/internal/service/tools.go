@@ -2,15 +2,41 @@ package service
 
 import (
 	"crypto/rand"
+	"errors"
+	"fmt"
 	"math/big"
+	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	storage "github.com/liriquew/secret_storage/server/internal/encrypted_storage"
+	"github.com/liriquew/secret_storage/server/internal/models"
 )
 
+// quotaErrStatus returns the status of writes rejected by the quota of the user.
+func quotaErrStatus(err error) (int, bool) {
+	switch {
+	case errors.Is(err, storage.ErrValueTooLarge):
+		return http.StatusRequestEntityTooLarge, true
+	case errors.Is(err, storage.ErrQuotaExceeded):
+		return http.StatusTooManyRequests, true
+	}
+	return 0, false
+}
+
+func extractUsername(c *gin.Context) string {
+	return c.Value(usernameKey).(string)
+}
+
 func extractPath(c *gin.Context) []string {
-	username := c.Value(usernameKey).(string)
-	queryPath := strings.Split(c.Query(pathParam), "/")
+	return userPath(extractUsername(c), c.Query(pathParam))
+}
+
+// userPath splits rawPath and places it into the namespace of username.
+func userPath(username string, rawPath string) []string {
+	queryPath := strings.Split(rawPath, "/")
 
 	path := make([]string, 0, len(queryPath)+1)
 	path = append(path, username)
@@ -24,6 +50,99 @@ func extractPath(c *gin.Context) []string {
 	return path
 }
 
+// extractVersion returns the version query param, 0 stands for the current version.
+func extractVersion(c *gin.Context) (int, error) {
+	rawVersion := c.Query(versionParam)
+	if rawVersion == "" {
+		return 0, nil
+	}
+
+	version, err := strconv.Atoi(rawVersion)
+	if err != nil {
+		return 0, err
+	}
+	if version < 0 {
+		return 0, errors.New("negative version")
+	}
+
+	return version, nil
+}
+
+// extractCAS returns the cas query param, nil means that it isn't set.
+func extractCAS(c *gin.Context) (*int, error) {
+	rawCAS, ok := c.GetQuery(casParam)
+	if !ok {
+		return nil, nil
+	}
+
+	cas, err := strconv.Atoi(rawCAS)
+	if err != nil {
+		return nil, err
+	}
+	if cas < 0 {
+		return nil, errors.New("negative cas")
+	}
+
+	return &cas, nil
+}
+
+const maxPageLimit = 1000
+
+// extractPage returns the limit and after query params, a missing limit means no limit.
+func extractPage(c *gin.Context) (models.PageOptions, error) {
+	var page models.PageOptions
+
+	if rawLimit := c.Query(limitParam); rawLimit != "" {
+		limit, err := strconv.Atoi(rawLimit)
+		if err != nil {
+			return page, err
+		}
+		if limit < 1 || limit > maxPageLimit {
+			return page, fmt.Errorf("limit must be from 1 to %d", maxPageLimit)
+		}
+		page.Limit = limit
+	}
+
+	if rawAfter := c.Query(afterParam); rawAfter != "" {
+		after, err := models.DecodeCursor(rawAfter)
+		if err != nil {
+			return page, errors.New("bad cursor")
+		}
+		page.After = after
+	}
+
+	return page, nil
+}
+
+// extractExpiration returns the moment the record expires at, nil means never.
+func extractExpiration(record *models.RecordDTO) (*time.Time, error) {
+	if record.TTL != "" && record.ExpiresAt != nil {
+		return nil, errors.New("both ttl and expires_at are set")
+	}
+	if record.ClearExpiration && (record.TTL != "" || record.ExpiresAt != nil) {
+		return nil, errors.New("clear_expiration is set with ttl or expires_at")
+	}
+
+	if record.TTL != "" {
+		ttl, err := time.ParseDuration(record.TTL)
+		if err != nil {
+			return nil, err
+		}
+		if ttl <= 0 {
+			return nil, errors.New("non-positive ttl")
+		}
+
+		expiresAt := time.Now().Add(ttl).UTC()
+		return &expiresAt, nil
+	}
+
+	if record.ExpiresAt != nil && !record.ExpiresAt.After(time.Now()) {
+		return nil, errors.New("expires_at is in the past")
+	}
+
+	return record.ExpiresAt, nil
+}
+
 func GeneratePassword(length int) ([]byte, error) {
 	const (
 		lowerBytes = "abcdefghijklmnopqrstuvwxyz"
@@ -0,0 +1,267 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	storage "github.com/liriquew/secret_storage/server/internal/encrypted_storage"
+	"github.com/liriquew/secret_storage/server/internal/lib/transfer"
+	"github.com/liriquew/secret_storage/server/internal/models"
+	"github.com/liriquew/secret_storage/server/pkg/logger/sl"
+)
+
+const (
+	maxImportSize    = 10 << 20
+	maxImportEntries = 10000
+)
+
+var contentTypes = map[string]string{
+	transfer.FormatJSON: "application/json; charset=utf-8",
+	transfer.FormatYAML: "application/yaml; charset=utf-8",
+	transfer.FormatEnv:  "text/plain; charset=utf-8",
+}
+
+func extractFormat(c *gin.Context) (string, bool) {
+	format := c.DefaultQuery(formatParam, transfer.FormatJSON)
+	if !slices.Contains(transfer.Formats(), format) {
+		c.String(http.StatusBadRequest, "format must be one of %s", strings.Join(transfer.Formats(), ", "))
+		return "", false
+	}
+	return format, true
+}
+
+// flattenBucket collects secrets of the tree with paths relative to its root, files
+// can't be represented in the formats, their locations are collected separately.
+func flattenBucket(bucket *models.BucketFullInfo, path []string, entries []*transfer.Entry, files []string) ([]*transfer.Entry, []string) {
+	for _, record := range bucket.Records {
+		n := len(record.Value)
+		if record.File != nil || n == 0 {
+			files = append(files, strings.Join(append(path[:len(path):len(path)], string(record.Key)), "/"))
+			continue
+		}
+
+		entries = append(entries, &transfer.Entry{
+			Path:   path,
+			Key:    string(record.Key),
+			Value:  record.Value[:n-1],
+			Binary: record.Value[n-1] == models.Base64Encoding,
+		})
+	}
+
+	for _, nested := range bucket.Buckets {
+		entries, files = flattenBucket(nested, append(path[:len(path):len(path)], nested.Name), entries, files)
+	}
+
+	return entries, files
+}
+
+func (s *Service) Export(c *gin.Context) {
+	format, ok := extractFormat(c)
+	if !ok {
+		return
+	}
+
+	tree, err := s.repo().ListRecordsRecursively(extractPath(c))
+	if err != nil {
+		s.log.Error("error while exporting records", sl.Err(err))
+		switch {
+		case errors.Is(err, storage.ErrBucketNotFound):
+			c.Status(http.StatusNotFound)
+		case errors.Is(err, storage.ErrEmptyPathPart):
+			c.String(http.StatusBadRequest, err.Error())
+		default:
+			c.Status(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	entries, files := flattenBucket(tree, nil, nil, nil)
+	if len(files) > 0 {
+		// an export must not lose secrets unnoticed
+		if c.Query(skipFilesParam) != "true" {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"type":  "file secrets can't be exported",
+				"files": files,
+			})
+			return
+		}
+		for _, file := range files {
+			c.Writer.Header().Add(skippedFilesHeader, url.PathEscape(file))
+		}
+	}
+
+	var document bytes.Buffer
+	if err := transfer.Encode(&document, format, entries); err != nil {
+		s.log.Error("error while encoding export", sl.Err(err))
+		if errors.Is(err, transfer.ErrNotRepresentable) {
+			c.String(http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.Data(http.StatusOK, contentTypes[format], document.Bytes())
+}
+
+// Import writes all secrets of the document in one batch. Every write checks
+// the version the plan was made for, so a secret changed in between fails
+// the whole import.
+func (s *Service) Import(c *gin.Context) {
+	format, ok := extractFormat(c)
+	if !ok {
+		return
+	}
+
+	entries, err := transfer.Decode(http.MaxBytesReader(c.Writer, c.Request.Body, maxImportSize), format)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(entries) == 0 || len(entries) > maxImportEntries {
+		c.String(http.StatusBadRequest, "document must contain from 1 to %d secrets", maxImportEntries)
+		return
+	}
+
+	root := extractPath(c)
+	report, ops, err := s.planImport(root, entries, c.Query(overwriteParam) == "true")
+	if err != nil {
+		s.log.Error("error while planning import", sl.Err(err))
+		if errors.Is(err, storage.ErrEmptyPathPart) || errors.Is(err, storage.ErrIncorrectPath) {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	report.DryRun = c.Query(dryRunParam) == "true"
+	if report.DryRun {
+		c.JSON(http.StatusOK, report)
+		return
+	}
+	if len(report.Conflicts) > 0 {
+		c.JSON(http.StatusConflict, report)
+		return
+	}
+
+	if len(ops) > 0 {
+		username := extractUsername(c)
+		for _, op := range ops {
+			op.Opts.Author = username
+		}
+
+		if _, err := s.repo().Batch(ops); err != nil {
+			s.log.Error("error while importing records", sl.Err(err))
+			if status, ok := quotaErrStatus(err); ok {
+				c.String(status, err.Error())
+				return
+			}
+			if errors.Is(err, storage.ErrCASMismatch) || errors.Is(err, storage.ErrIncorrectPath) {
+				c.String(http.StatusConflict, "secrets were changed during import, retry it")
+				return
+			}
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// planImport compares entries with the secrets stored under root and
+// returns the report with set operations for created and updated secrets.
+func (s *Service) planImport(root []string, entries []*transfer.Entry, overwrite bool) (*models.ImportReport, []*models.BatchOperation, error) {
+	existing := map[string]*models.Record{}
+	buckets := map[string]bool{}
+
+	tree, err := s.repo().ListRecordsRecursively(root)
+	switch {
+	case err == nil:
+		collectTree(tree, "", existing, buckets)
+	case !errors.Is(err, storage.ErrBucketNotFound):
+		return nil, nil, err
+	}
+
+	// buckets of the document itself, a secret can't share a path with them
+	for _, entry := range entries {
+		for i := range entry.Path {
+			buckets[strings.Join(entry.Path[:i+1], "/")] = true
+		}
+	}
+
+	slices.SortFunc(entries, func(a, b *transfer.Entry) int {
+		return strings.Compare(a.Name("/"), b.Name("/"))
+	})
+
+	report := &models.ImportReport{
+		Created:   []string{},
+		Updated:   []string{},
+		Unchanged: []string{},
+		Conflicts: []string{},
+	}
+	var ops []*models.BatchOperation
+	for _, entry := range entries {
+		name := entry.Name("/")
+
+		value := append(slices.Clip(entry.Value), models.UTF8Encoding)
+		if entry.Binary {
+			value[len(value)-1] = models.Base64Encoding
+		}
+
+		if buckets[name] || hasSecretPrefix(entry.Path, existing) {
+			report.Conflicts = append(report.Conflicts, name)
+			continue
+		}
+
+		record, exists := existing[name]
+		switch {
+		case !exists:
+			report.Created = append(report.Created, name)
+			record = &models.Record{Version: 0}
+		case bytes.Equal(record.Value, value):
+			report.Unchanged = append(report.Unchanged, name)
+			continue
+		case !overwrite:
+			report.Conflicts = append(report.Conflicts, name)
+			continue
+		default:
+			report.Updated = append(report.Updated, name)
+		}
+
+		ops = append(ops, &models.BatchOperation{
+			Op:    models.BatchOpSet,
+			Path:  append(slices.Clip(root), entry.Path...),
+			Key:   entry.Key,
+			Value: value,
+			Opts:  models.WriteOptions{CAS: &record.Version},
+		})
+	}
+
+	return report, ops, nil
+}
+
+func collectTree(bucket *models.BucketFullInfo, path string, records map[string]*models.Record, buckets map[string]bool) {
+	for _, record := range bucket.Records {
+		records[path+string(record.Key)] = record
+	}
+
+	for _, nested := range bucket.Buckets {
+		buckets[path+nested.Name] = true
+		collectTree(nested, path+nested.Name+"/", records, buckets)
+	}
+}
+
+func hasSecretPrefix(path []string, records map[string]*models.Record) bool {
+	for i := range path {
+		if _, ok := records[strings.Join(path[:i+1], "/")]; ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,21 @@
+package service
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/liriquew/secret_storage/server/pkg/logger/sl"
+)
+
+// Verify decrypts every stored value, the report lists corrupted
+// and undecryptable entries. The node must be unsealed.
+func (s *Service) Verify(c *gin.Context) {
+	report, err := s.repo().Verify()
+	if err != nil {
+		s.log.Error("error while verifying storage", sl.Err(err))
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,29 @@
+package service
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/liriquew/secret_storage/server/pkg/logger/sl"
+)
+
+// Watch streams changes of secrets under the path of the caller's namespace
+// over a WebSocket or as server-sent events. Values are never sent.
+func (s *Service) Watch(c *gin.Context) {
+	// writes are committed on the leader and only replicated to followers,
+	// so followers see no changes
+	if status := s.repo().HAStatus(); !status.IsLeader() {
+		redirectToLeader(c, status)
+		return
+	}
+
+	path := extractPath(c)
+	if err := s.feed.Watch(c, path[0], strings.Join(path[1:], "/")); err != nil {
+		s.log.Error("error while watching changes", sl.Err(err))
+	}
+}
+
+// CloseWatchers ends all change streams, it's called on shutdown.
+func (s *Service) CloseWatchers() {
+	s.feed.Close()
+}
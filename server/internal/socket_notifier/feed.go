@@ -0,0 +1,218 @@
+package socketnotifier
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/liriquew/secret_storage/server/internal/models"
+	"github.com/liriquew/secret_storage/server/pkg/logger/sl"
+)
+
+const (
+	// watcherBuffer is the number of events a watcher may fall behind
+	// before it is disconnected
+	watcherBuffer = 256
+
+	writeTimeout = 10 * time.Second
+	pingInterval = 30 * time.Second
+)
+
+// Feed fans changes of secrets out to watchers. Publishing never blocks:
+// a watcher which doesn't keep up is disconnected and has to reconnect.
+type Feed struct {
+	watchers map[*watcher]struct{}
+	m        sync.Mutex
+	log      *slog.Logger
+	// closed is closed on shutdown to end all streams
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+type watcher struct {
+	username string
+	prefix   string
+	events   chan *models.ChangeEvent
+	// dropped is closed when the watcher falls behind
+	dropped chan struct{}
+}
+
+func NewFeed(log *slog.Logger) *Feed {
+	return &Feed{
+		watchers: map[*watcher]struct{}{},
+		m:        sync.Mutex{},
+		log:      log,
+		closed:   make(chan struct{}),
+	}
+}
+
+// Close ends all streams, the server doesn't wait for hijacked
+// WebSocket connections on shutdown.
+func (f *Feed) Close() {
+	f.closeOnce.Do(func() { close(f.closed) })
+}
+
+// matches reports whether the event is in the watched subtree.
+func (w *watcher) matches(event *models.ChangeEvent) bool {
+	if event.Username != w.username {
+		return false
+	}
+	return w.prefix == "" || event.Path == w.prefix || strings.HasPrefix(event.Path, w.prefix+"/")
+}
+
+// Publish hands events to the watchers of their subtrees.
+func (f *Feed) Publish(events []*models.ChangeEvent) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	for w := range f.watchers {
+		if !w.send(events) {
+			f.log.Warn("watcher is too slow, dropping it", slog.String("username", w.username))
+			close(w.dropped)
+			delete(f.watchers, w)
+		}
+	}
+}
+
+// send queues matching events without waiting, false means that the watcher fell behind.
+func (w *watcher) send(events []*models.ChangeEvent) bool {
+	for _, event := range events {
+		if !w.matches(event) {
+			continue
+		}
+
+		select {
+		case w.events <- event:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (f *Feed) subscribe(username, prefix string) *watcher {
+	w := &watcher{
+		username: username,
+		prefix:   prefix,
+		events:   make(chan *models.ChangeEvent, watcherBuffer),
+		dropped:  make(chan struct{}),
+	}
+
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.watchers[w] = struct{}{}
+	return w
+}
+
+func (f *Feed) unsubscribe(w *watcher) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	delete(f.watchers, w)
+}
+
+// Watch streams changes of the subtree prefix of the namespace username until
+// the client goes away. WebSocket clients get an event per text message,
+// other clients get server-sent events.
+func (f *Feed) Watch(c *gin.Context, username, prefix string) error {
+	if websocket.IsWebSocketUpgrade(c.Request) {
+		return f.watchSocket(c, username, prefix)
+	}
+	f.watchStream(c, username, prefix)
+	return nil
+}
+
+func (f *Feed) watchSocket(c *gin.Context, username, prefix string) error {
+	// the watcher is subscribed before the handshake, so the client gets
+	// every change committed after it's connected
+	w := f.subscribe(username, prefix)
+	defer f.unsubscribe(w)
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// the client sends nothing, reading only handles control messages
+	// and notices when the connection is closed
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(pingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case event := <-w.events:
+			msg, err := json.Marshal(event)
+			if err != nil {
+				return err
+			}
+			conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				f.log.Error("error while sending change", sl.Err(err))
+				return nil
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout)); err != nil {
+				return nil
+			}
+		case <-w.dropped:
+			msg := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "watcher is too slow")
+			conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(writeTimeout))
+			return nil
+		case <-closed:
+			return nil
+		case <-f.closed:
+			msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server is shutting down")
+			conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(writeTimeout))
+			return nil
+		}
+	}
+}
+
+func (f *Feed) watchStream(c *gin.Context, username, prefix string) {
+	w := f.subscribe(username, prefix)
+	defer f.unsubscribe(w)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	ping := time.NewTicker(pingInterval)
+	defer ping.Stop()
+
+	c.Stream(func(_ io.Writer) bool {
+		select {
+		case event := <-w.events:
+			c.SSEvent("change", event)
+			return true
+		case <-ping.C:
+			// a comment keeps proxies from closing an idle stream
+			c.Writer.WriteString(": ping\n\n")
+			return true
+		case <-w.dropped:
+			c.SSEvent("dropped", "watcher is too slow")
+			return false
+		case <-c.Request.Context().Done():
+			return false
+		case <-f.closed:
+			return false
+		}
+	})
+}
@@ -1,6 +1,7 @@
 package socketnotifier
 
 import (
+	"errors"
 	"log/slog"
 	"net/http"
 	"sync"
@@ -44,9 +45,38 @@ func (n *Notifier) AddConn(c *gin.Context) error {
 	return nil
 }
 
+// Count returns the number of connections waiting for a message.
+func (n *Notifier) Count() int {
+	n.m.Lock()
+	defer n.m.Unlock()
+	return len(n.conns)
+}
+
+// Close drops the waiting connections without a message.
+func (n *Notifier) Close() {
+	n.m.Lock()
+	defer n.m.Unlock()
+
+	for _, conn := range n.conns {
+		conn.Close()
+	}
+	n.conns = nil
+}
+
 type messagesMaker func(int) ([][]byte, error)
 
+// Notify sends every connection its own message made by msgsMaker and closes it,
+// messages aren't logged since they carry parts of keys. Errors of connections
+// which didn't get their messages are joined.
 func (n *Notifier) Notify(msgsMaker messagesMaker) error {
+	err := n.Send(msgsMaker)
+	n.Close()
+	return err
+}
+
+// Send is Notify which keeps the connections open, so they can be told
+// the outcome with Broadcast. They are closed by Close.
+func (n *Notifier) Send(msgsMaker messagesMaker) error {
 	n.m.Lock()
 	defer n.m.Unlock()
 	messages, err := msgsMaker(len(n.conns))
@@ -54,17 +84,27 @@ func (n *Notifier) Notify(msgsMaker messagesMaker) error {
 		return err
 	}
 
+	var errs []error
 	for i, msg := range messages {
-		err := n.conns[i].WriteMessage(1, msg)
-		if err != nil {
+		if err := n.conns[i].WriteMessage(websocket.TextMessage, msg); err != nil {
 			n.log.Error("error while notify conn", sl.Err(err))
+			errs = append(errs, err)
 			continue
 		}
-		n.log.Info("notify message", slog.String("message", string(msg)))
-		n.conns[i].Close()
+		n.log.Info("notify message", slog.Int("conn", i))
 	}
 
-	n.conns = nil
+	return errors.Join(errs...)
+}
 
-	return nil
+// Broadcast sends msg to every connection, connections which fail are only logged.
+func (n *Notifier) Broadcast(msg []byte) {
+	n.m.Lock()
+	defer n.m.Unlock()
+
+	for i, conn := range n.conns {
+		if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+			n.log.Error("error while broadcast to conn", slog.Int("conn", i), sl.Err(err))
+		}
+	}
 }
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/liriquew/secret_storage/server/internal/lib/config"
+)
+
+// Backend is a transactional store of nested buckets modeled after bbolt.
+// Storage keeps its whole layout on top of it, so every backend gets
+// versions, tombstones and the rest of the features for free.
+//
+// Values returned by Get and cursors are valid only inside the transaction
+// and must not be modified.
+type Backend interface {
+	View(fn func(Tx) error) error
+	// Update runs fn in a read-write transaction, the transaction is
+	// rolled back if fn returns an error.
+	Update(fn func(Tx) error) error
+	Close() error
+}
+
+type Tx interface {
+	// Bucket returns nil if the top-level bucket doesn't exist.
+	Bucket(name []byte) Bucket
+	CreateBucketIfNotExists(name []byte) (Bucket, error)
+}
+
+type Bucket interface {
+	// Get returns nil for missing keys and nested buckets.
+	Get(key []byte) []byte
+	Put(key []byte, value []byte) error
+	Delete(key []byte) error
+
+	// Bucket returns nil if the nested bucket doesn't exist.
+	Bucket(name []byte) Bucket
+	CreateBucket(name []byte) (Bucket, error)
+	CreateBucketIfNotExists(name []byte) (Bucket, error)
+	DeleteBucket(name []byte) error
+	// MoveBucket moves the nested bucket with everything inside to dst
+	// keeping its name, dst must belong to the same transaction. The moved
+	// bucket must not be changed by the transaction before the move:
+	// bbolt loses such changes.
+	MoveBucket(name []byte, dst Bucket) error
+
+	// Cursor iterates keys in byte order, nested buckets have nil values.
+	Cursor() Cursor
+	ForEach(fn func(k, v []byte) error) error
+}
+
+type Cursor interface {
+	First() (key []byte, value []byte)
+	Next() (key []byte, value []byte)
+	Seek(seek []byte) (key []byte, value []byte)
+	// Delete removes the current key, it can't be used for nested buckets.
+	Delete() error
+}
+
+var (
+	ErrBucketExists        = errors.New("bucket already exists")
+	ErrIncompatibleValue   = errors.New("incompatible value")
+	ErrKeyRequired         = errors.New("key required")
+	ErrUnknownBackend      = errors.New("unknown storage backend")
+	ErrTxNotWritable       = errors.New("tx not writable")
+	ErrDifferentTx         = errors.New("buckets belong to different transactions")
+	ErrBackendAlreadyKnown = errors.New("storage backend already registered")
+)
+
+const DefaultBackend = "bbolt"
+
+type BackendFactory func(cfg config.StorageConfig) (Backend, error)
+
+var (
+	backends   = map[string]BackendFactory{}
+	backendsMu sync.RWMutex
+)
+
+// RegisterBackend makes a backend available by name in the type field of StorageConfig.
+func RegisterBackend(name string, factory BackendFactory) error {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+
+	if _, ok := backends[name]; ok {
+		return fmt.Errorf("%w: %s", ErrBackendAlreadyKnown, name)
+	}
+
+	backends[name] = factory
+	return nil
+}
+
+// Backends returns names of registered backends.
+func Backends() []string {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func openBackend(cfg config.StorageConfig) (Backend, error) {
+	backendType := cfg.Type
+	if backendType == "" {
+		backendType = DefaultBackend
+	}
+
+	backendsMu.RLock()
+	factory, ok := backends[backendType]
+	backendsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownBackend, backendType)
+	}
+
+	return factory(cfg)
+}
@@ -0,0 +1,243 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/liriquew/secret_storage/server/internal/lib/config"
+	bolt "go.etcd.io/bbolt"
+	bolterrors "go.etcd.io/bbolt/errors"
+)
+
+func init() {
+	if err := RegisterBackend("bbolt", newBoltBackend); err != nil {
+		panic(err)
+	}
+}
+
+type boltBackend struct {
+	db *bolt.DB
+}
+
+func newBoltBackend(cfg config.StorageConfig) (Backend, error) {
+	if cfg.Path == "" {
+		return nil, errors.New("bbolt backend requires path")
+	}
+
+	db, err := bolt.Open(cfg.Path, 0600, &bolt.Options{Timeout: 1 * time.Second, ReadOnly: cfg.ReadOnly})
+	if err != nil {
+		return nil, err
+	}
+
+	return &boltBackend{db}, nil
+}
+
+func (b *boltBackend) View(fn func(Tx) error) error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx})
+	})
+}
+
+func (b *boltBackend) Update(fn func(Tx) error) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx})
+	})
+}
+
+func (b *boltBackend) Close() error {
+	return b.db.Close()
+}
+
+func (b *boltBackend) CopyFile(path string) error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(path, 0600)
+	})
+}
+
+func (b *boltBackend) Snapshot(fn func(io.WriterTo) error) error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		return fn(tx)
+	})
+}
+
+// restoreBoltSnapshot writes the snapshot next to the database, checks it
+// and renames it over the database, the old file is left intact on failure.
+func restoreBoltSnapshot(cfg config.StorageConfig, r io.Reader) error {
+	if cfg.Path == "" {
+		return errors.New("bbolt backend requires path")
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(cfg.Path), filepath.Base(cfg.Path)+".restore-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, r)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("error while restoring snapshot: %w", err)
+	}
+
+	if err := checkBoltSnapshot(tmp.Name()); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), cfg.Path)
+}
+
+func checkBoltSnapshot(path string) error {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second, ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidSnapshot, err)
+	}
+	defer db.Close()
+
+	return db.View(func(tx *bolt.Tx) error {
+		// the channel is drained to let the check finish
+		var checkErr error
+		for err := range tx.Check() {
+			if checkErr == nil {
+				checkErr = fmt.Errorf("%w: %w", ErrInvalidSnapshot, err)
+			}
+		}
+		if checkErr != nil {
+			return checkErr
+		}
+
+		for _, name := range [][]byte{recordsBucketName, userBucketName, metaBucketName} {
+			if tx.Bucket(name) == nil {
+				return fmt.Errorf("%w: no %s bucket", ErrInvalidSnapshot, name)
+			}
+		}
+		return nil
+	})
+}
+
+// boltErr converts bbolt errors into errors of the Backend contract.
+func boltErr(err error) error {
+	switch {
+	case errors.Is(err, bolterrors.ErrBucketExists):
+		return ErrBucketExists
+	case errors.Is(err, bolterrors.ErrIncompatibleValue):
+		return ErrIncompatibleValue
+	case errors.Is(err, bolterrors.ErrTxNotWritable):
+		return ErrTxNotWritable
+	case errors.Is(err, bolterrors.ErrBucketNotFound):
+		return ErrBucketNotFound
+	case errors.Is(err, bolterrors.ErrDifferentDB):
+		return ErrDifferentTx
+	case errors.Is(err, bolterrors.ErrKeyRequired) || errors.Is(err, bolterrors.ErrBucketNameRequired):
+		return ErrKeyRequired
+	}
+	return err
+}
+
+type boltTx struct {
+	tx *bolt.Tx
+}
+
+func (t boltTx) Bucket(name []byte) Bucket {
+	return wrapBoltBucket(t.tx.Bucket(name))
+}
+
+func (t boltTx) CreateBucketIfNotExists(name []byte) (Bucket, error) {
+	b, err := t.tx.CreateBucketIfNotExists(name)
+	if err != nil {
+		return nil, boltErr(err)
+	}
+	return wrapBoltBucket(b), nil
+}
+
+type boltBucket struct {
+	b *bolt.Bucket
+}
+
+// wrapBoltBucket keeps nil buckets nil after conversion to the interface.
+func wrapBoltBucket(b *bolt.Bucket) Bucket {
+	if b == nil {
+		return nil
+	}
+	return boltBucket{b}
+}
+
+func (b boltBucket) Get(key []byte) []byte {
+	return b.b.Get(key)
+}
+
+func (b boltBucket) Put(key []byte, value []byte) error {
+	return boltErr(b.b.Put(key, value))
+}
+
+func (b boltBucket) Delete(key []byte) error {
+	return boltErr(b.b.Delete(key))
+}
+
+func (b boltBucket) Bucket(name []byte) Bucket {
+	return wrapBoltBucket(b.b.Bucket(name))
+}
+
+func (b boltBucket) CreateBucket(name []byte) (Bucket, error) {
+	nested, err := b.b.CreateBucket(name)
+	if err != nil {
+		return nil, boltErr(err)
+	}
+	return wrapBoltBucket(nested), nil
+}
+
+func (b boltBucket) CreateBucketIfNotExists(name []byte) (Bucket, error) {
+	nested, err := b.b.CreateBucketIfNotExists(name)
+	if err != nil {
+		return nil, boltErr(err)
+	}
+	return wrapBoltBucket(nested), nil
+}
+
+func (b boltBucket) DeleteBucket(name []byte) error {
+	return boltErr(b.b.DeleteBucket(name))
+}
+
+func (b boltBucket) MoveBucket(name []byte, dst Bucket) error {
+	to, ok := dst.(boltBucket)
+	if !ok {
+		return ErrDifferentTx
+	}
+	return boltErr(b.b.MoveBucket(name, to.b))
+}
+
+func (b boltBucket) Cursor() Cursor {
+	return boltCursor{b.b.Cursor()}
+}
+
+func (b boltBucket) ForEach(fn func(k, v []byte) error) error {
+	return b.b.ForEach(fn)
+}
+
+type boltCursor struct {
+	c *bolt.Cursor
+}
+
+func (c boltCursor) First() ([]byte, []byte) {
+	return c.c.First()
+}
+
+func (c boltCursor) Next() ([]byte, []byte) {
+	return c.c.Next()
+}
+
+func (c boltCursor) Seek(seek []byte) ([]byte, []byte) {
+	return c.c.Seek(seek)
+}
+
+func (c boltCursor) Delete() error {
+	return boltErr(c.c.Delete())
+}
@@ -0,0 +1,320 @@
+package storage
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/liriquew/secret_storage/server/internal/lib/config"
+)
+
+func init() {
+	if err := RegisterBackend("memory", newMemoryBackend); err != nil {
+		panic(err)
+	}
+}
+
+var ErrBackendClosed = errors.New("storage backend closed")
+
+// memoryBackend keeps everything in process memory, it is meant for
+// development and tests. Writers are serialized, a failed Update is
+// rolled back with the undo log collected during the transaction.
+type memoryBackend struct {
+	m    sync.RWMutex
+	root *memNode
+}
+
+type memNode struct {
+	values  map[string][]byte
+	buckets map[string]*memNode
+}
+
+func newMemNode() *memNode {
+	return &memNode{
+		values:  map[string][]byte{},
+		buckets: map[string]*memNode{},
+	}
+}
+
+func newMemoryBackend(config.StorageConfig) (Backend, error) {
+	return &memoryBackend{root: newMemNode()}, nil
+}
+
+func (mb *memoryBackend) View(fn func(Tx) error) error {
+	mb.m.RLock()
+	defer mb.m.RUnlock()
+
+	if mb.root == nil {
+		return ErrBackendClosed
+	}
+
+	return fn(&memTx{root: mb.root})
+}
+
+func (mb *memoryBackend) Update(fn func(Tx) error) error {
+	mb.m.Lock()
+	defer mb.m.Unlock()
+
+	if mb.root == nil {
+		return ErrBackendClosed
+	}
+
+	tx := &memTx{root: mb.root, writable: true}
+	if err := fn(tx); err != nil {
+		tx.rollback()
+		return err
+	}
+
+	return nil
+}
+
+func (mb *memoryBackend) Close() error {
+	mb.m.Lock()
+	defer mb.m.Unlock()
+
+	mb.root = nil
+	return nil
+}
+
+type memTx struct {
+	root     *memNode
+	writable bool
+	undo     []func()
+}
+
+func (t *memTx) rollback() {
+	for i := len(t.undo) - 1; i >= 0; i-- {
+		t.undo[i]()
+	}
+	t.undo = nil
+}
+
+func (t *memTx) Bucket(name []byte) Bucket {
+	return (&memBucket{tx: t, node: t.root}).Bucket(name)
+}
+
+func (t *memTx) CreateBucketIfNotExists(name []byte) (Bucket, error) {
+	return (&memBucket{tx: t, node: t.root}).CreateBucketIfNotExists(name)
+}
+
+type memBucket struct {
+	tx   *memTx
+	node *memNode
+}
+
+func (b *memBucket) Get(key []byte) []byte {
+	return b.node.values[string(key)]
+}
+
+func (b *memBucket) Put(key []byte, value []byte) error {
+	if !b.tx.writable {
+		return ErrTxNotWritable
+	}
+	if len(key) == 0 {
+		return ErrKeyRequired
+	}
+
+	k := string(key)
+	if _, ok := b.node.buckets[k]; ok {
+		return ErrIncompatibleValue
+	}
+
+	node := b.node
+	if prev, ok := node.values[k]; ok {
+		b.tx.undo = append(b.tx.undo, func() { node.values[k] = prev })
+	} else {
+		b.tx.undo = append(b.tx.undo, func() { delete(node.values, k) })
+	}
+
+	node.values[k] = append(make([]byte, 0, len(value)), value...)
+	return nil
+}
+
+func (b *memBucket) Delete(key []byte) error {
+	if !b.tx.writable {
+		return ErrTxNotWritable
+	}
+
+	k := string(key)
+	if _, ok := b.node.buckets[k]; ok {
+		return ErrIncompatibleValue
+	}
+
+	node := b.node
+	prev, ok := node.values[k]
+	if !ok {
+		return nil
+	}
+
+	b.tx.undo = append(b.tx.undo, func() { node.values[k] = prev })
+	delete(node.values, k)
+	return nil
+}
+
+func (b *memBucket) Bucket(name []byte) Bucket {
+	nested, ok := b.node.buckets[string(name)]
+	if !ok {
+		return nil
+	}
+	return &memBucket{tx: b.tx, node: nested}
+}
+
+func (b *memBucket) CreateBucket(name []byte) (Bucket, error) {
+	if !b.tx.writable {
+		return nil, ErrTxNotWritable
+	}
+	if len(name) == 0 {
+		return nil, ErrKeyRequired
+	}
+
+	k := string(name)
+	if _, ok := b.node.buckets[k]; ok {
+		return nil, ErrBucketExists
+	}
+	if _, ok := b.node.values[k]; ok {
+		return nil, ErrIncompatibleValue
+	}
+
+	node := b.node
+	nested := newMemNode()
+	node.buckets[k] = nested
+	b.tx.undo = append(b.tx.undo, func() { delete(node.buckets, k) })
+
+	return &memBucket{tx: b.tx, node: nested}, nil
+}
+
+func (b *memBucket) CreateBucketIfNotExists(name []byte) (Bucket, error) {
+	if nested := b.Bucket(name); nested != nil {
+		if !b.tx.writable {
+			return nil, ErrTxNotWritable
+		}
+		return nested, nil
+	}
+	return b.CreateBucket(name)
+}
+
+func (b *memBucket) DeleteBucket(name []byte) error {
+	if !b.tx.writable {
+		return ErrTxNotWritable
+	}
+
+	k := string(name)
+	if _, ok := b.node.values[k]; ok {
+		return ErrIncompatibleValue
+	}
+
+	node := b.node
+	nested, ok := node.buckets[k]
+	if !ok {
+		return ErrBucketNotFound
+	}
+
+	b.tx.undo = append(b.tx.undo, func() { node.buckets[k] = nested })
+	delete(node.buckets, k)
+	return nil
+}
+
+func (b *memBucket) MoveBucket(name []byte, dst Bucket) error {
+	if !b.tx.writable {
+		return ErrTxNotWritable
+	}
+	to, ok := dst.(*memBucket)
+	if !ok || to.tx != b.tx {
+		return ErrDifferentTx
+	}
+
+	k := string(name)
+	if _, ok := b.node.values[k]; ok {
+		return ErrIncompatibleValue
+	}
+	nested, ok := b.node.buckets[k]
+	if !ok {
+		return ErrBucketNotFound
+	}
+	if _, ok := to.node.buckets[k]; ok {
+		return ErrBucketExists
+	}
+	if _, ok := to.node.values[k]; ok {
+		return ErrIncompatibleValue
+	}
+
+	from, into := b.node, to.node
+	b.tx.undo = append(b.tx.undo, func() {
+		delete(into.buckets, k)
+		from.buckets[k] = nested
+	})
+	delete(from.buckets, k)
+	into.buckets[k] = nested
+	return nil
+}
+
+func (b *memBucket) sortedKeys() []string {
+	keys := make([]string, 0, len(b.node.values)+len(b.node.buckets))
+	for k := range b.node.values {
+		keys = append(keys, k)
+	}
+	for k := range b.node.buckets {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (b *memBucket) Cursor() Cursor {
+	return &memCursor{bucket: b}
+}
+
+func (b *memBucket) ForEach(fn func(k, v []byte) error) error {
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// memCursor iterates over the keys snapshot taken by First or Seek,
+// keys deleted after the snapshot are skipped.
+type memCursor struct {
+	bucket *memBucket
+	keys   []string
+	idx    int
+}
+
+func (c *memCursor) First() ([]byte, []byte) {
+	c.keys = c.bucket.sortedKeys()
+	c.idx = 0
+	return c.current()
+}
+
+func (c *memCursor) Next() ([]byte, []byte) {
+	c.idx++
+	return c.current()
+}
+
+func (c *memCursor) Seek(seek []byte) ([]byte, []byte) {
+	c.keys = c.bucket.sortedKeys()
+	c.idx = sort.SearchStrings(c.keys, string(seek))
+	return c.current()
+}
+
+func (c *memCursor) Delete() error {
+	if c.idx >= len(c.keys) {
+		return nil
+	}
+	return c.bucket.Delete([]byte(c.keys[c.idx]))
+}
+
+func (c *memCursor) current() ([]byte, []byte) {
+	for ; c.idx < len(c.keys); c.idx++ {
+		k := c.keys[c.idx]
+		if v, ok := c.bucket.node.values[k]; ok {
+			return []byte(k), v
+		}
+		if _, ok := c.bucket.node.buckets[k]; ok {
+			return []byte(k), nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,650 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+	"github.com/liriquew/secret_storage/server/internal/lib/config"
+	"github.com/liriquew/secret_storage/server/internal/models"
+)
+
+var (
+	ErrNotLeader       = errors.New("node is not the raft leader")
+	ErrInvalidHAConfig = errors.New("invalid ha config")
+
+	errDiscardTx = errors.New("transaction is applied through raft")
+)
+
+const (
+	raftApplyTimeout   = 10 * time.Second
+	raftMaxPool        = 3
+	raftTCPTimeout     = 10 * time.Second
+	raftSnapshotRetain = 2
+)
+
+// replicatedBuckets are the top-level buckets created by Storage,
+// raft snapshots contain only them.
+var replicatedBuckets = [][]byte{recordsBucketName, userBucketName, metaBucketName}
+
+// raftBackend replicates writes of the local backend through raft. Values
+// are encrypted above the Backend, so only ciphertext reaches the log.
+//
+// Update runs on the leader only: the transaction is executed against the
+// local backend to record its changes and rolled back, then the recorded
+// changes are committed to the log and applied by every node, the leader
+// included. Reads are served from the local backend of any node.
+type raftBackend struct {
+	local Backend
+	cfg   config.HAConfig
+
+	raft      *raft.Raft
+	transport *raft.NetworkTransport
+	logStore  *raftboltdb.BoltStore
+
+	// the next transaction is recorded only after the previous one is applied
+	writeMu sync.Mutex
+}
+
+// onMeta is called with every value the log or a snapshot puts into the meta bucket.
+func newRaftBackend(local Backend, cfg config.HAConfig, onMeta func(key, value []byte)) (*raftBackend, error) {
+	if cfg.NodeID == "" || cfg.DataDir == "" {
+		return nil, fmt.Errorf("%w: node_id and data_dir are required", ErrInvalidHAConfig)
+	}
+
+	self, ok := findPeer(cfg.Peers, cfg.NodeID)
+	if !ok {
+		return nil, fmt.Errorf("%w: node %s is not listed in peers", ErrInvalidHAConfig, cfg.NodeID)
+	}
+
+	if err := os.MkdirAll(cfg.DataDir, 0700); err != nil {
+		return nil, err
+	}
+
+	raftCfg := raft.DefaultConfig()
+	raftCfg.LocalID = raft.ServerID(cfg.NodeID)
+	raftCfg.LogLevel = "WARN"
+
+	logStore, err := raftboltdb.New(raftboltdb.Options{
+		Path: filepath.Join(cfg.DataDir, "raft.db"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error while opening raft log: %w", err)
+	}
+
+	snapshots, err := raft.NewFileSnapshotStore(cfg.DataDir, raftSnapshotRetain, os.Stderr)
+	if err != nil {
+		logStore.Close()
+		return nil, fmt.Errorf("error while opening raft snapshots: %w", err)
+	}
+
+	transport, err := raft.NewTCPTransport(self.RaftAddr, nil, raftMaxPool, raftTCPTimeout, os.Stderr)
+	if err != nil {
+		logStore.Close()
+		return nil, fmt.Errorf("error while starting raft transport: %w", err)
+	}
+
+	if cfg.Bootstrap {
+		hasState, err := raft.HasExistingState(logStore, logStore, snapshots)
+		if err == nil && !hasState {
+			err = raft.BootstrapCluster(raftCfg, logStore, logStore, snapshots, transport, clusterConfiguration(cfg.Peers))
+		}
+		if err != nil {
+			transport.Close()
+			logStore.Close()
+			return nil, fmt.Errorf("error while bootstrapping raft cluster: %w", err)
+		}
+	}
+
+	r, err := raft.NewRaft(raftCfg, &raftFSM{local: local, onMeta: onMeta}, logStore, logStore, snapshots, transport)
+	if err != nil {
+		transport.Close()
+		logStore.Close()
+		return nil, fmt.Errorf("error while starting raft: %w", err)
+	}
+
+	return &raftBackend{
+		local:     local,
+		cfg:       cfg,
+		raft:      r,
+		transport: transport,
+		logStore:  logStore,
+	}, nil
+}
+
+func findPeer(peers []config.HAPeer, id string) (config.HAPeer, bool) {
+	for _, peer := range peers {
+		if peer.ID == id {
+			return peer, true
+		}
+	}
+	return config.HAPeer{}, false
+}
+
+func clusterConfiguration(peers []config.HAPeer) raft.Configuration {
+	var configuration raft.Configuration
+	for _, peer := range peers {
+		configuration.Servers = append(configuration.Servers, raft.Server{
+			Suffrage: raft.Voter,
+			ID:       raft.ServerID(peer.ID),
+			Address:  raft.ServerAddress(peer.RaftAddr),
+		})
+	}
+	return configuration
+}
+
+func (b *raftBackend) View(fn func(Tx) error) error {
+	return b.local.View(fn)
+}
+
+func (b *raftBackend) Update(fn func(Tx) error) error {
+	b.writeMu.Lock()
+	defer b.writeMu.Unlock()
+
+	if b.raft.State() != raft.Leader {
+		return ErrNotLeader
+	}
+
+	rec := &raftRecorder{}
+	err := b.local.Update(func(tx Tx) error {
+		if err := fn(&recordingTx{tx: tx, rec: rec}); err != nil {
+			return err
+		}
+		return errDiscardTx
+	})
+	if !errors.Is(err, errDiscardTx) {
+		return err
+	}
+
+	if len(rec.ops) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(rec.ops)
+	if err != nil {
+		return err
+	}
+
+	future := b.raft.Apply(data, raftApplyTimeout)
+	if err := future.Error(); err != nil {
+		if errors.Is(err, raft.ErrNotLeader) || errors.Is(err, raft.ErrLeadershipLost) {
+			return ErrNotLeader
+		}
+		return err
+	}
+
+	if err, ok := future.Response().(error); ok {
+		return err
+	}
+	return nil
+}
+
+func (b *raftBackend) Close() error {
+	err := b.raft.Shutdown().Error()
+	if closeErr := b.transport.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := b.logStore.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := b.local.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (b *raftBackend) apiAddr(id raft.ServerID) string {
+	peer, _ := findPeer(b.cfg.Peers, string(id))
+	return peer.APIAddr
+}
+
+func (b *raftBackend) Status() *models.HAStatus {
+	_, leaderID := b.raft.LeaderWithID()
+
+	status := &models.HAStatus{
+		Enabled:       true,
+		NodeID:        b.cfg.NodeID,
+		State:         b.raft.State().String(),
+		LeaderID:      string(leaderID),
+		LeaderAPIAddr: b.apiAddr(leaderID),
+		Term:          b.raft.CurrentTerm(),
+		CommitIndex:   b.raft.CommitIndex(),
+		AppliedIndex:  b.raft.AppliedIndex(),
+	}
+
+	future := b.raft.GetConfiguration()
+	if future.Error() != nil {
+		return status
+	}
+
+	for _, server := range future.Configuration().Servers {
+		status.Peers = append(status.Peers, &models.HAPeerStatus{
+			ID:       string(server.ID),
+			RaftAddr: string(server.Address),
+			APIAddr:  b.apiAddr(server.ID),
+			Voter:    server.Suffrage == raft.Voter,
+			Leader:   server.ID == leaderID,
+		})
+	}
+
+	return status
+}
+
+type raftOpType uint8
+
+const (
+	raftOpPut raftOpType = iota + 1
+	raftOpDelete
+	raftOpCreateBucket
+	raftOpDeleteBucket
+	raftOpMoveBucket
+)
+
+// raftOp is a single change of a transaction. Path is the path of the bucket
+// holding Key starting from the top-level bucket, top-level buckets themselves
+// have empty Path. To is the path of the bucket a moved bucket is put into.
+type raftOp struct {
+	Type  raftOpType `json:"type"`
+	Path  [][]byte   `json:"path,omitempty"`
+	Key   []byte     `json:"key"`
+	Value []byte     `json:"value"`
+	To    [][]byte   `json:"to,omitempty"`
+}
+
+// applyRaftOp applies op idempotently, the log may be replayed over a state
+// that already contains its changes.
+func applyRaftOp(tx Tx, op raftOp) error {
+	if len(op.Path) == 0 {
+		if op.Type != raftOpCreateBucket {
+			return fmt.Errorf("unexpected operation %d on top-level bucket", op.Type)
+		}
+		_, err := tx.CreateBucketIfNotExists(op.Key)
+		return err
+	}
+
+	b, err := createRaftPath(tx, op.Path)
+	if err != nil {
+		return err
+	}
+
+	switch op.Type {
+	case raftOpPut:
+		return b.Put(op.Key, op.Value)
+	case raftOpDelete:
+		return b.Delete(op.Key)
+	case raftOpCreateBucket:
+		_, err := b.CreateBucketIfNotExists(op.Key)
+		return err
+	case raftOpDeleteBucket:
+		err := b.DeleteBucket(op.Key)
+		if errors.Is(err, ErrBucketNotFound) {
+			return nil
+		}
+		return err
+	case raftOpMoveBucket:
+		if len(op.To) == 0 {
+			return fmt.Errorf("unexpected move of bucket to the top level")
+		}
+		dst, err := createRaftPath(tx, op.To)
+		if err != nil {
+			return err
+		}
+		if b.Bucket(op.Key) == nil && dst.Bucket(op.Key) != nil {
+			return nil
+		}
+		return b.MoveBucket(op.Key, dst)
+	}
+
+	return fmt.Errorf("unknown operation %d", op.Type)
+}
+
+// createRaftPath opens the bucket at path creating missing ones.
+func createRaftPath(tx Tx, path [][]byte) (Bucket, error) {
+	b, err := tx.CreateBucketIfNotExists(path[0])
+	for _, name := range path[1:] {
+		if err != nil {
+			break
+		}
+		b, err = b.CreateBucketIfNotExists(name)
+	}
+	return b, err
+}
+
+type raftFSM struct {
+	local  Backend
+	onMeta func(key, value []byte)
+}
+
+// isMetaPut reports whether op puts a value into the meta bucket.
+func isMetaPut(op raftOp) bool {
+	return op.Type == raftOpPut && len(op.Path) == 1 && bytes.Equal(op.Path[0], metaBucketName)
+}
+
+// metaChanged passes the values put into the meta bucket to onMeta once they are committed.
+func (f *raftFSM) metaChanged(puts []raftOp) {
+	if f.onMeta == nil {
+		return
+	}
+	for _, op := range puts {
+		f.onMeta(op.Key, op.Value)
+	}
+}
+
+func (f *raftFSM) Apply(l *raft.Log) interface{} {
+	var ops []raftOp
+	if err := json.Unmarshal(l.Data, &ops); err != nil {
+		return err
+	}
+
+	var puts []raftOp
+	err := f.local.Update(func(tx Tx) error {
+		for _, op := range ops {
+			if err := applyRaftOp(tx, op); err != nil {
+				return err
+			}
+			if isMetaPut(op) {
+				puts = append(puts, op)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	f.metaChanged(puts)
+	return nil
+}
+
+// Snapshot encodes the replicated buckets as a stream of operations recreating them.
+func (f *raftFSM) Snapshot() (raft.FSMSnapshot, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+
+	err := f.local.View(func(tx Tx) error {
+		for _, name := range replicatedBuckets {
+			b := tx.Bucket(name)
+			if b == nil {
+				continue
+			}
+
+			if err := enc.Encode(raftOp{Type: raftOpCreateBucket, Key: name}); err != nil {
+				return err
+			}
+			if err := dumpBucket(enc, b, [][]byte{name}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &raftSnapshot{data: buf.Bytes()}, nil
+}
+
+func dumpBucket(enc *json.Encoder, b Bucket, path [][]byte) error {
+	return b.ForEach(func(k, v []byte) error {
+		if v == nil {
+			if nested := b.Bucket(k); nested != nil {
+				if err := enc.Encode(raftOp{Type: raftOpCreateBucket, Path: path, Key: k}); err != nil {
+					return err
+				}
+				return dumpBucket(enc, nested, append(path[:len(path):len(path)], k))
+			}
+		}
+
+		return enc.Encode(raftOp{Type: raftOpPut, Path: path, Key: k, Value: v})
+	})
+}
+
+func (f *raftFSM) Restore(rc io.ReadCloser) error {
+	defer rc.Close()
+
+	var puts []raftOp
+	err := f.local.Update(func(tx Tx) error {
+		for _, name := range replicatedBuckets {
+			b, err := tx.CreateBucketIfNotExists(name)
+			if err != nil {
+				return err
+			}
+			if err := clearBucket(b); err != nil {
+				return err
+			}
+		}
+
+		dec := json.NewDecoder(rc)
+		for {
+			var op raftOp
+			err := dec.Decode(&op)
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			if err := applyRaftOp(tx, op); err != nil {
+				return err
+			}
+			if isMetaPut(op) {
+				puts = append(puts, op)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	f.metaChanged(puts)
+	return nil
+}
+
+func clearBucket(b Bucket) error {
+	var keys, buckets [][]byte
+	err := b.ForEach(func(k, v []byte) error {
+		if v == nil && b.Bucket(k) != nil {
+			buckets = append(buckets, bytes.Clone(k))
+		} else {
+			keys = append(keys, bytes.Clone(k))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+	for _, k := range buckets {
+		if err := b.DeleteBucket(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type raftSnapshot struct {
+	data []byte
+}
+
+func (s *raftSnapshot) Persist(sink raft.SnapshotSink) error {
+	if _, err := sink.Write(s.data); err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (s *raftSnapshot) Release() {}
+
+type raftRecorder struct {
+	ops []raftOp
+}
+
+// add copies keys and values, they are valid only inside the transaction.
+func (r *raftRecorder) add(opType raftOpType, path [][]byte, key, value []byte) {
+	r.ops = append(r.ops, raftOp{
+		Type:  opType,
+		Path:  path,
+		Key:   bytes.Clone(key),
+		Value: bytes.Clone(value),
+	})
+}
+
+// recordingTx records changes made through it as raft operations.
+type recordingTx struct {
+	tx  Tx
+	rec *raftRecorder
+}
+
+func (t *recordingTx) Bucket(name []byte) Bucket {
+	return wrapRecordingBucket(t.tx.Bucket(name), t.rec, [][]byte{bytes.Clone(name)})
+}
+
+func (t *recordingTx) CreateBucketIfNotExists(name []byte) (Bucket, error) {
+	b, err := t.tx.CreateBucketIfNotExists(name)
+	if err != nil {
+		return nil, err
+	}
+
+	t.rec.add(raftOpCreateBucket, nil, name, nil)
+	return wrapRecordingBucket(b, t.rec, [][]byte{bytes.Clone(name)}), nil
+}
+
+type recordingBucket struct {
+	b    Bucket
+	rec  *raftRecorder
+	path [][]byte
+}
+
+func wrapRecordingBucket(b Bucket, rec *raftRecorder, path [][]byte) Bucket {
+	if b == nil {
+		return nil
+	}
+	return &recordingBucket{b: b, rec: rec, path: path}
+}
+
+func (b *recordingBucket) childPath(name []byte) [][]byte {
+	return append(b.path[:len(b.path):len(b.path)], bytes.Clone(name))
+}
+
+func (b *recordingBucket) Get(key []byte) []byte {
+	return b.b.Get(key)
+}
+
+func (b *recordingBucket) Put(key []byte, value []byte) error {
+	if err := b.b.Put(key, value); err != nil {
+		return err
+	}
+	b.rec.add(raftOpPut, b.path, key, value)
+	return nil
+}
+
+func (b *recordingBucket) Delete(key []byte) error {
+	if err := b.b.Delete(key); err != nil {
+		return err
+	}
+	b.rec.add(raftOpDelete, b.path, key, nil)
+	return nil
+}
+
+func (b *recordingBucket) Bucket(name []byte) Bucket {
+	return wrapRecordingBucket(b.b.Bucket(name), b.rec, b.childPath(name))
+}
+
+func (b *recordingBucket) CreateBucket(name []byte) (Bucket, error) {
+	nested, err := b.b.CreateBucket(name)
+	if err != nil {
+		return nil, err
+	}
+	b.rec.add(raftOpCreateBucket, b.path, name, nil)
+	return wrapRecordingBucket(nested, b.rec, b.childPath(name)), nil
+}
+
+func (b *recordingBucket) CreateBucketIfNotExists(name []byte) (Bucket, error) {
+	nested, err := b.b.CreateBucketIfNotExists(name)
+	if err != nil {
+		return nil, err
+	}
+	b.rec.add(raftOpCreateBucket, b.path, name, nil)
+	return wrapRecordingBucket(nested, b.rec, b.childPath(name)), nil
+}
+
+func (b *recordingBucket) DeleteBucket(name []byte) error {
+	if err := b.b.DeleteBucket(name); err != nil {
+		return err
+	}
+	b.rec.add(raftOpDeleteBucket, b.path, name, nil)
+	return nil
+}
+
+func (b *recordingBucket) MoveBucket(name []byte, dst Bucket) error {
+	to, ok := dst.(*recordingBucket)
+	if !ok || to.rec != b.rec {
+		return ErrDifferentTx
+	}
+	if err := b.b.MoveBucket(name, to.b); err != nil {
+		return err
+	}
+	b.rec.ops = append(b.rec.ops, raftOp{
+		Type: raftOpMoveBucket,
+		Path: b.path,
+		Key:  bytes.Clone(name),
+		To:   to.path,
+	})
+	return nil
+}
+
+func (b *recordingBucket) Cursor() Cursor {
+	return &recordingCursor{c: b.b.Cursor(), bucket: b}
+}
+
+func (b *recordingBucket) ForEach(fn func(k, v []byte) error) error {
+	return b.b.ForEach(fn)
+}
+
+type recordingCursor struct {
+	c      Cursor
+	bucket *recordingBucket
+	key    []byte
+}
+
+func (c *recordingCursor) First() ([]byte, []byte) {
+	return c.track(c.c.First())
+}
+
+func (c *recordingCursor) Next() ([]byte, []byte) {
+	return c.track(c.c.Next())
+}
+
+func (c *recordingCursor) Seek(seek []byte) ([]byte, []byte) {
+	return c.track(c.c.Seek(seek))
+}
+
+func (c *recordingCursor) Delete() error {
+	if err := c.c.Delete(); err != nil {
+		return err
+	}
+	if c.key != nil {
+		c.bucket.rec.add(raftOpDelete, c.bucket.path, c.key, nil)
+	}
+	return nil
+}
+
+func (c *recordingCursor) track(k, v []byte) ([]byte, []byte) {
+	c.key = k
+	return k, v
+}
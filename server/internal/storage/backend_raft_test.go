@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/liriquew/secret_storage/server/internal/lib/config"
+	"github.com/liriquew/secret_storage/server/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const electionTimeout = 15 * time.Second
+
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// newTestCluster starts size in-process nodes on localhost, the first one bootstraps the cluster.
+func newTestCluster(t *testing.T, backendType string, size int) []*Storage {
+	peers := make([]config.HAPeer, size)
+	for i := range peers {
+		peers[i] = config.HAPeer{
+			ID:       fmt.Sprintf("node%d", i),
+			RaftAddr: freeAddr(t),
+			APIAddr:  fmt.Sprintf("http://node%d", i),
+		}
+	}
+
+	nodes := make([]*Storage, size)
+	for i := range nodes {
+		dir := t.TempDir()
+		cfg := config.StorageConfig{
+			Type:        backendType,
+			Path:        filepath.Join(dir, "data.db"),
+			MaxVersions: 3,
+			HA: config.HAConfig{
+				Enabled:   true,
+				NodeID:    peers[i].ID,
+				DataDir:   filepath.Join(dir, "raft"),
+				Bootstrap: i == 0,
+				Peers:     peers,
+			},
+		}
+		nodes[i] = newTestStorage(t, cfg)
+	}
+
+	return nodes
+}
+
+func waitForLeader(t *testing.T, nodes []*Storage) (*Storage, *models.HAStatus) {
+	var leader *Storage
+	var status *models.HAStatus
+	require.Eventually(t, func() bool {
+		for _, node := range nodes {
+			if status = node.HAStatus(); status.State == "Leader" {
+				leader = node
+				return true
+			}
+		}
+		return false
+	}, electionTimeout, 50*time.Millisecond)
+
+	return leader, status
+}
+
+func TestReplicatedStorageConformance(t *testing.T) {
+	for _, backendType := range Backends() {
+		t.Run(backendType, func(t *testing.T) {
+			nodes := newTestCluster(t, backendType, 1)
+			waitForLeader(t, nodes)
+
+			storageConformance(t, nodes[0])
+		})
+	}
+}
+
+func TestReplication(t *testing.T) {
+	nodes := newTestCluster(t, "memory", 3)
+	leader, status := waitForLeader(t, nodes)
+
+	assert.True(t, status.Enabled)
+	assert.Len(t, status.Peers, 3)
+
+	path := []string{"alice", "ha"}
+	_, err := leader.SetRecord(path, "key", []byte("value"), models.WriteOptions{Author: "alice"})
+	require.NoError(t, err)
+
+	var followers []*Storage
+	for _, node := range nodes {
+		if node == leader {
+			continue
+		}
+		followers = append(followers, node)
+
+		assert.Eventually(t, func() bool {
+			record, err := node.GetRecord(path, "key", 0)
+			return err == nil && string(record.Value) == "value"
+		}, electionTimeout, 50*time.Millisecond)
+
+		followerStatus := node.HAStatus()
+		assert.Equal(t, "Follower", followerStatus.State)
+		assert.Equal(t, status.NodeID, followerStatus.LeaderID)
+		assert.Equal(t, "http://"+status.NodeID, followerStatus.LeaderAPIAddr)
+
+		_, err = node.SetRecord(path, "other", []byte("value"), models.WriteOptions{})
+		assert.ErrorIs(t, err, ErrNotLeader)
+	}
+
+	// the rest of the cluster elects a new leader and keeps the data
+	require.NoError(t, leader.Close())
+
+	leader, _ = waitForLeader(t, followers)
+	_, err = leader.SetRecord(path, "key", []byte("new value"), models.WriteOptions{})
+	require.NoError(t, err)
+
+	for _, node := range followers {
+		assert.Eventually(t, func() bool {
+			record, err := node.GetRecord(path, "key", 0)
+			return err == nil && record.Version == 2
+		}, electionTimeout, 50*time.Millisecond)
+	}
+}
+
+func TestRaftSnapshotRestore(t *testing.T) {
+	source := newTestStorage(t, conformanceConfig(t, "memory"))
+	_, err := source.SetRecord([]string{"alice", "a"}, "key", []byte("value"), models.WriteOptions{})
+	require.NoError(t, err)
+	require.NoError(t, source.Set(nil, "alice", []byte("hash"), userBucketName))
+
+	snapshot, err := (&raftFSM{local: source.db}).Snapshot()
+	require.NoError(t, err)
+
+	target := newTestStorage(t, conformanceConfig(t, "memory"))
+	_, err = target.SetRecord([]string{"bob"}, "stale", []byte("value"), models.WriteOptions{})
+	require.NoError(t, err)
+
+	fsm := &raftFSM{local: target.db}
+	require.NoError(t, fsm.Restore(io.NopCloser(bytes.NewReader(snapshot.(*raftSnapshot).data))))
+
+	record, err := target.GetRecord([]string{"alice", "a"}, "key", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("value"), record.Value)
+
+	hash, err := target.Get(nil, "alice", userBucketName)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("hash"), hash)
+
+	_, err = target.ListRecords([]string{"bob"}, models.PageOptions{})
+	assert.ErrorIs(t, err, ErrBucketNotFound)
+}
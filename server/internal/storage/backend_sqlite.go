@@ -0,0 +1,436 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/liriquew/secret_storage/server/internal/lib/config"
+	_ "modernc.org/sqlite"
+)
+
+func init() {
+	if err := RegisterBackend("sqlite", newSQLiteBackend); err != nil {
+		panic(err)
+	}
+}
+
+// Every bucket entry is a row keyed by (namespace, path, key). Namespace is
+// the name of the top-level bucket, path is the encoded path of the parent
+// bucket inside it. A top-level bucket itself is a row with empty path and key.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS entries (
+	namespace BLOB    NOT NULL,
+	path      BLOB    NOT NULL,
+	key       BLOB    NOT NULL,
+	value     BLOB,
+	is_bucket INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (namespace, path, key)
+) WITHOUT ROWID`
+
+type sqliteBackend struct {
+	db *sql.DB
+}
+
+func newSQLiteBackend(cfg config.StorageConfig) (Backend, error) {
+	if cfg.Path == "" {
+		return nil, errors.New("sqlite backend requires path")
+	}
+
+	dsn := fmt.Sprintf("file:%s?_txlock=immediate&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)",
+		url.PathEscape(cfg.Path))
+	if cfg.ReadOnly {
+		dsn = fmt.Sprintf("file:%s?mode=ro&_pragma=busy_timeout(5000)", url.PathEscape(cfg.Path))
+	}
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// sqlite allows only one writer, the pool is serialized
+	// instead of failing with SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+
+	if cfg.ReadOnly {
+		return &sqliteBackend{db}, nil
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error while creating schema: %w", err)
+	}
+
+	return &sqliteBackend{db}, nil
+}
+
+func (b *sqliteBackend) View(fn func(Tx) error) error {
+	return b.run(false, fn)
+}
+
+func (b *sqliteBackend) Update(fn func(Tx) error) error {
+	return b.run(true, fn)
+}
+
+func (b *sqliteBackend) run(writable bool, fn func(Tx) error) error {
+	sqlTx, err := b.db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: !writable})
+	if err != nil {
+		return err
+	}
+
+	// the connection must be released even if fn panics,
+	// rollback after commit is a no-op
+	defer sqlTx.Rollback()
+
+	tx := &sqliteTx{tx: sqlTx, writable: writable}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if tx.err != nil {
+		return tx.err
+	}
+
+	if !writable {
+		return nil
+	}
+	return sqlTx.Commit()
+}
+
+func (b *sqliteBackend) Close() error {
+	return b.db.Close()
+}
+
+// CopyFile writes a consistent copy of the database, the copy is compacted.
+func (b *sqliteBackend) CopyFile(path string) error {
+	_, err := b.db.Exec("VACUUM INTO ?", path)
+	return err
+}
+
+type sqliteTx struct {
+	tx       *sql.Tx
+	writable bool
+	// err keeps the first error of methods that can't return it,
+	// the transaction fails with it
+	err error
+}
+
+func (t *sqliteTx) fail(err error) {
+	if t.err == nil {
+		t.err = err
+	}
+}
+
+func (t *sqliteTx) Bucket(name []byte) Bucket {
+	if len(name) == 0 {
+		return nil
+	}
+
+	root := &sqliteBucket{tx: t}
+	if !root.exists(name) {
+		return nil
+	}
+	return &sqliteBucket{tx: t, namespace: name, path: []byte{}}
+}
+
+func (t *sqliteTx) CreateBucketIfNotExists(name []byte) (Bucket, error) {
+	if !t.writable {
+		return nil, ErrTxNotWritable
+	}
+	if len(name) == 0 {
+		return nil, ErrKeyRequired
+	}
+
+	if b := t.Bucket(name); b != nil {
+		return b, nil
+	}
+
+	_, err := t.tx.Exec(`INSERT INTO entries (namespace, path, key, is_bucket) VALUES (?, ?, ?, 1)`,
+		name, []byte{}, []byte{})
+	if err != nil {
+		return nil, err
+	}
+
+	return &sqliteBucket{tx: t, namespace: name, path: []byte{}}, nil
+}
+
+// encodePathPart escapes zero bytes of name and terminates it, so the encoded
+// path of a bucket is a byte prefix of the paths of all its descendants only.
+func encodePathPart(name []byte) []byte {
+	encoded := bytes.ReplaceAll(name, []byte{0x00}, []byte{0x00, 0xff})
+	return append(encoded, 0x00, 0x01)
+}
+
+// prefixEnd returns the smallest byte string greater than every string with the given prefix.
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+type sqliteBucket struct {
+	tx *sqliteTx
+	// namespace is nil only for the pseudo bucket holding top-level buckets
+	namespace []byte
+	path      []byte
+}
+
+func (b *sqliteBucket) childPath(name []byte) []byte {
+	return append(append([]byte{}, b.path...), encodePathPart(name)...)
+}
+
+// lookup returns the entry stored under key, found is false if there is none.
+func (b *sqliteBucket) lookup(key []byte) (value []byte, isBucket bool, found bool) {
+	namespace, path := b.namespace, b.path
+	if namespace == nil {
+		namespace, path, key = key, []byte{}, []byte{}
+	}
+
+	row := b.tx.tx.QueryRow(`SELECT value, is_bucket FROM entries WHERE namespace = ? AND path = ? AND key = ?`,
+		namespace, path, key)
+
+	err := row.Scan(&value, &isBucket)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, false
+	}
+	if err != nil {
+		b.tx.fail(err)
+		return nil, false, false
+	}
+
+	if !isBucket && value == nil {
+		value = []byte{}
+	}
+	return value, isBucket, true
+}
+
+func (b *sqliteBucket) exists(name []byte) bool {
+	_, isBucket, found := b.lookup(name)
+	return found && isBucket
+}
+
+func (b *sqliteBucket) Get(key []byte) []byte {
+	value, isBucket, found := b.lookup(key)
+	if !found || isBucket {
+		return nil
+	}
+	return value
+}
+
+func (b *sqliteBucket) Put(key []byte, value []byte) error {
+	if !b.tx.writable {
+		return ErrTxNotWritable
+	}
+	if len(key) == 0 {
+		return ErrKeyRequired
+	}
+
+	if _, isBucket, _ := b.lookup(key); isBucket {
+		return ErrIncompatibleValue
+	}
+
+	if value == nil {
+		value = []byte{}
+	}
+
+	_, err := b.tx.tx.Exec(`
+		INSERT INTO entries (namespace, path, key, value, is_bucket) VALUES (?, ?, ?, ?, 0)
+		ON CONFLICT (namespace, path, key) DO UPDATE SET value = excluded.value`,
+		b.namespace, b.path, key, value)
+	return err
+}
+
+func (b *sqliteBucket) Delete(key []byte) error {
+	if !b.tx.writable {
+		return ErrTxNotWritable
+	}
+
+	if _, isBucket, _ := b.lookup(key); isBucket {
+		return ErrIncompatibleValue
+	}
+
+	_, err := b.tx.tx.Exec(`DELETE FROM entries WHERE namespace = ? AND path = ? AND key = ?`,
+		b.namespace, b.path, key)
+	return err
+}
+
+func (b *sqliteBucket) Bucket(name []byte) Bucket {
+	if !b.exists(name) {
+		return nil
+	}
+	return &sqliteBucket{tx: b.tx, namespace: b.namespace, path: b.childPath(name)}
+}
+
+func (b *sqliteBucket) CreateBucket(name []byte) (Bucket, error) {
+	if !b.tx.writable {
+		return nil, ErrTxNotWritable
+	}
+	if len(name) == 0 {
+		return nil, ErrKeyRequired
+	}
+
+	_, isBucket, found := b.lookup(name)
+	if found && isBucket {
+		return nil, ErrBucketExists
+	}
+	if found {
+		return nil, ErrIncompatibleValue
+	}
+
+	_, err := b.tx.tx.Exec(`INSERT INTO entries (namespace, path, key, is_bucket) VALUES (?, ?, ?, 1)`,
+		b.namespace, b.path, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sqliteBucket{tx: b.tx, namespace: b.namespace, path: b.childPath(name)}, nil
+}
+
+func (b *sqliteBucket) CreateBucketIfNotExists(name []byte) (Bucket, error) {
+	if !b.tx.writable {
+		return nil, ErrTxNotWritable
+	}
+
+	if nested := b.Bucket(name); nested != nil {
+		return nested, nil
+	}
+	return b.CreateBucket(name)
+}
+
+func (b *sqliteBucket) DeleteBucket(name []byte) error {
+	if !b.tx.writable {
+		return ErrTxNotWritable
+	}
+
+	_, isBucket, found := b.lookup(name)
+	if !found {
+		return ErrBucketNotFound
+	}
+	if !isBucket {
+		return ErrIncompatibleValue
+	}
+
+	if _, err := b.tx.tx.Exec(`DELETE FROM entries WHERE namespace = ? AND path = ? AND key = ?`,
+		b.namespace, b.path, name); err != nil {
+		return err
+	}
+
+	subtree := b.childPath(name)
+	_, err := b.tx.tx.Exec(`DELETE FROM entries WHERE namespace = ? AND path >= ? AND path < ?`,
+		b.namespace, subtree, prefixEnd(subtree))
+	return err
+}
+
+func (b *sqliteBucket) MoveBucket(name []byte, dst Bucket) error {
+	if !b.tx.writable {
+		return ErrTxNotWritable
+	}
+	to, ok := dst.(*sqliteBucket)
+	if !ok || to.tx != b.tx {
+		return ErrDifferentTx
+	}
+
+	_, isBucket, found := b.lookup(name)
+	if !found {
+		return ErrBucketNotFound
+	}
+	if !isBucket {
+		return ErrIncompatibleValue
+	}
+	if _, isBucket, found := to.lookup(name); found && isBucket {
+		return ErrBucketExists
+	} else if found {
+		return ErrIncompatibleValue
+	}
+
+	if _, err := b.tx.tx.Exec(`UPDATE entries SET namespace = ?, path = ? WHERE namespace = ? AND path = ? AND key = ?`,
+		to.namespace, to.path, b.namespace, b.path, name); err != nil {
+		return err
+	}
+
+	// descendants keep their paths relative to the moved bucket
+	from, into := b.childPath(name), to.childPath(name)
+	_, err := b.tx.tx.Exec(`
+		UPDATE entries SET namespace = ?, path = CAST(? || substr(path, ?) AS BLOB)
+		WHERE namespace = ? AND path >= ? AND path < ?`,
+		to.namespace, into, len(from)+1, b.namespace, from, prefixEnd(from))
+	return err
+}
+
+func (b *sqliteBucket) Cursor() Cursor {
+	return &sqliteCursor{bucket: b}
+}
+
+func (b *sqliteBucket) ForEach(fn func(k, v []byte) error) error {
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sqliteCursor queries the next row on every move,
+// so it sees changes made by the same transaction.
+type sqliteCursor struct {
+	bucket *sqliteBucket
+	key    []byte
+}
+
+func (c *sqliteCursor) First() ([]byte, []byte) {
+	return c.move(`key >= ?`, []byte{})
+}
+
+func (c *sqliteCursor) Next() ([]byte, []byte) {
+	if c.key == nil {
+		return nil, nil
+	}
+	return c.move(`key > ?`, c.key)
+}
+
+func (c *sqliteCursor) Seek(seek []byte) ([]byte, []byte) {
+	return c.move(`key >= ?`, seek)
+}
+
+func (c *sqliteCursor) Delete() error {
+	if c.key == nil {
+		return nil
+	}
+	return c.bucket.Delete(c.key)
+}
+
+func (c *sqliteCursor) move(cond string, from []byte) ([]byte, []byte) {
+	b := c.bucket
+	// the empty key marks top-level buckets, it is never a real entry
+	row := b.tx.tx.QueryRow(`
+		SELECT key, value, is_bucket FROM entries
+		WHERE namespace = ? AND path = ? AND key != x'' AND `+cond+`
+		ORDER BY key LIMIT 1`,
+		b.namespace, b.path, from)
+
+	var key, value []byte
+	var isBucket bool
+	err := row.Scan(&key, &value, &isBucket)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			b.tx.fail(err)
+		}
+		c.key = nil
+		return nil, nil
+	}
+
+	c.key = key
+	if isBucket {
+		return key, nil
+	}
+	if value == nil {
+		value = []byte{}
+	}
+	return key, value
+}
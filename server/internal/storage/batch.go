@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/liriquew/secret_storage/server/internal/models"
+)
+
+var ErrUnknownBatchOperation = errors.New("unknown batch operation")
+
+// Batch applies all operations in one transaction. If any of them fails,
+// nothing is written and the returned results end with the failed operation.
+func (s *Storage) Batch(ops []*models.BatchOperation) ([]*models.BatchResult, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	var results []*models.BatchResult
+	var changes *changeLog
+	err := s.db.Update(func(tx Tx) error {
+		b := tx.Bucket(recordsBucketName)
+		if b == nil {
+			return ErrFailedToOpenTopBucket
+		}
+
+		usage := s.newUsageTracker()
+		changes = s.newChangeLog()
+		refs := s.newNameRefs()
+
+		now := time.Now().UTC()
+		for i, op := range ops {
+			result := &models.BatchResult{
+				Op:  op.Op,
+				Key: op.Key,
+			}
+			results = append(results, result)
+
+			path, key := s.names.blindLocation(op.Path, op.Key)
+
+			var err error
+			switch op.Op {
+			case models.BatchOpSet:
+				err = s.names.register(tx, append(slices.Clip(op.Path), op.Key)...)
+				if err == nil {
+					result.Version, err = setRecord(b, path, key, op.Value, op.Opts, s.maxVersions, usage, changes, refs)
+				}
+			case models.BatchOpDelete:
+				err = softDeleteRecord(b, path, key, now, usage, changes)
+				if err == nil {
+					result.DeletedAt = &now
+				}
+			default:
+				err = fmt.Errorf("%w: %s", ErrUnknownBatchOperation, op.Op)
+			}
+
+			if err != nil {
+				result.Error = err.Error()
+				return fmt.Errorf("operation %d: %w", i, err)
+			}
+		}
+
+		if err := usage.commit(tx); err != nil {
+			// the batch as a whole is over quota, not one of its operations
+			results = nil
+			return err
+		}
+		if err := changes.resolve(tx, s.names); err != nil {
+			return err
+		}
+		return refs.commit(tx)
+	})
+	if err == nil {
+		s.publish(changes)
+	}
+
+	return results, err
+}
@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/liriquew/secret_storage/server/internal/models"
+)
+
+var ErrBucketNotEmpty = errors.New("bucket is not empty")
+
+// DeleteBucket removes the path bucket in one transaction, with recursive
+// together with everything inside, otherwise only if it's empty. Parent buckets
+// left empty are pruned as by Delete and counted as deleted.
+// With dryRun nothing is removed and the report lists what would be.
+func (s *Storage) DeleteBucket(path []string, recursive bool, dryRun bool) (*models.BucketDeletion, error) {
+	if len(path) < 2 {
+		// the namespace itself isn't a bucket of the user
+		return nil, ErrBucketNotFound
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	stored := s.names.blindPath(path)
+
+	var report *models.BucketDeletion
+	var changes *changeLog
+	err := s.db.Update(func(tx Tx) error {
+		top := tx.Bucket(recordsBucketName)
+		if top == nil {
+			return ErrFailedToOpenTopBucket
+		}
+
+		b, err := openBucketByPath(stored, top)
+		if err != nil {
+			return err
+		}
+		if k, _ := b.Cursor().First(); k != nil && !recursive {
+			return ErrBucketNotEmpty
+		}
+
+		before, err := pathUsage(top, stored, "")
+		if err != nil {
+			return err
+		}
+
+		changes = s.newChangeLog()
+		d := &bucketDeleter{
+			BucketDeletion: &models.BucketDeletion{DryRun: dryRun},
+			open:           s.names.opener(tx),
+			changes:        changes,
+		}
+		if err := d.collect(b, stored, path[1:]); err != nil {
+			return err
+		}
+		report = d.BucketDeletion
+
+		refs := s.newNameRefs()
+		pruned, err := deleteBucketByPath(top, stored, refs)
+		if err != nil {
+			return err
+		}
+		report.DeletedBuckets += pruned
+
+		usage := s.newUsageTracker()
+		usage.track(stored, before, models.Usage{})
+		if err := usage.commit(tx); err != nil {
+			return err
+		}
+		if err := changes.resolve(tx, s.names); err != nil {
+			return err
+		}
+		if err := refs.commit(tx); err != nil {
+			return err
+		}
+
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if dryRun && errors.Is(err, errDryRun) {
+		return report, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.publish(changes)
+	return report, nil
+}
+
+// bucketDeleter counts entries of a removed subtree and reports removed secrets to watchers.
+type bucketDeleter struct {
+	*models.BucketDeletion
+	open    func([]byte) (string, error)
+	changes *changeLog
+}
+
+// collect counts the path bucket b with everything inside, stored is its path
+// with stored names and location is its real path relative to the namespace.
+func (d *bucketDeleter) collect(b Bucket, stored []string, location []string) error {
+	d.DeletedBuckets++
+	if d.DryRun {
+		d.Buckets = append(d.Buckets, strings.Join(location, "/"))
+	}
+
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		// real names are only needed to list entries
+		var entryLocation []string
+		if d.DryRun {
+			name, err := d.open(k)
+			if err != nil {
+				return err
+			}
+			entryLocation = append(location[:len(location):len(location)], name)
+		}
+
+		nested := b.Bucket(k)
+		if v == nil && !isSecretBucket(nested) {
+			entryStored := append(stored[:len(stored):len(stored)], string(k))
+			if err := d.collect(nested, entryStored, entryLocation); err != nil {
+				return err
+			}
+			continue
+		}
+
+		d.DeletedSecrets++
+		if d.DryRun {
+			d.Secrets = append(d.Secrets, strings.Join(entryLocation, "/"))
+		}
+
+		version, err := removedVersion(b, string(k))
+		if err != nil {
+			return err
+		}
+		if version > 0 {
+			d.changes.add(models.ChangeDelete, stored, string(k), version)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/liriquew/secret_storage/server/internal/lib/config"
+	"github.com/liriquew/secret_storage/server/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeleteBucket(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, cfg config.StorageConfig) {
+		s := newTestStorage(t, cfg)
+
+		set := func(path []string, key string) {
+			_, err := s.SetRecord(path, key, []byte("value"), models.WriteOptions{})
+			require.NoError(t, err)
+		}
+		set([]string{"alice", "svc", "app"}, "token")
+		set([]string{"alice", "svc", "app", "db"}, "password")
+		set([]string{"alice", "svc", "app", "db"}, "user")
+		set([]string{"alice", "svc", "app", "cache"}, "url")
+		set([]string{"alice", "other"}, "key")
+
+		_, err := s.SoftDelete([]string{"alice", "svc", "app", "db"}, "user")
+		require.NoError(t, err)
+
+		var events []*models.ChangeEvent
+		s.OnChange(func(committed []*models.ChangeEvent) { events = append(events, committed...) })
+
+		t.Run("Not Recursive", func(t *testing.T) {
+			_, err := s.DeleteBucket([]string{"alice", "svc", "app"}, false, false)
+			assert.ErrorIs(t, err, ErrBucketNotEmpty)
+
+			_, err = s.DeleteBucket([]string{"alice", "missing"}, true, false)
+			assert.ErrorIs(t, err, ErrBucketNotFound)
+		})
+
+		t.Run("Dry Run", func(t *testing.T) {
+			report, err := s.DeleteBucket([]string{"alice", "svc", "app"}, true, true)
+			require.NoError(t, err)
+
+			assert.True(t, report.DryRun)
+			assert.Equal(t, 4, report.DeletedSecrets)
+			// app, db and cache with the parent svc left empty
+			assert.Equal(t, 4, report.DeletedBuckets)
+			assert.ElementsMatch(t, []string{"svc/app/token", "svc/app/db/password", "svc/app/db/user", "svc/app/cache/url"}, report.Secrets)
+			assert.ElementsMatch(t, []string{"svc/app", "svc/app/db", "svc/app/cache"}, report.Buckets)
+
+			_, err = s.GetRecord([]string{"alice", "svc", "app", "db"}, "password", 0)
+			require.NoError(t, err)
+			assert.Empty(t, events)
+		})
+
+		t.Run("Delete", func(t *testing.T) {
+			report, err := s.DeleteBucket([]string{"alice", "svc", "app"}, true, false)
+			require.NoError(t, err)
+
+			assert.False(t, report.DryRun)
+			assert.Equal(t, 4, report.DeletedSecrets)
+			assert.Equal(t, 4, report.DeletedBuckets)
+			assert.Empty(t, report.Secrets)
+
+			_, err = s.ListRecords([]string{"alice", "svc"}, models.PageOptions{})
+			assert.ErrorIs(t, err, ErrBucketNotFound)
+			_, err = s.GetRecord([]string{"alice", "other"}, "key", 0)
+			require.NoError(t, err)
+
+			status, err := s.Usage("alice")
+			require.NoError(t, err)
+			assert.Equal(t, int64(1), status.Usage.Secrets)
+
+			// the softly deleted secret was already reported
+			assert.Len(t, events, 3)
+			for _, event := range events {
+				assert.Equal(t, models.ChangeDelete, event.Op)
+			}
+		})
+
+		t.Run("Pruned Buckets", func(t *testing.T) {
+			set([]string{"bob", "a", "b"}, "key")
+
+			deleted, err := s.Delete([]string{"bob", "a", "b"}, "key", recordsBucketName)
+			require.NoError(t, err)
+			// b, a and the namespace bucket
+			assert.Equal(t, 3, deleted)
+		})
+	})
+}
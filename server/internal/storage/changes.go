@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"strings"
+	"time"
+
+	"github.com/liriquew/secret_storage/server/internal/models"
+)
+
+// changeLog collects changes of secrets made by a transaction to publish them
+// once it is committed, a nil log collects nothing.
+type changeLog struct {
+	changes []*change
+	events  []*models.ChangeEvent
+}
+
+type change struct {
+	op string
+	// location is the path with the key, the first part is the namespace
+	location []string
+	version  int
+	// plain is set if the location is made of real names, not of stored ones
+	plain bool
+}
+
+func (s *Storage) newChangeLog() *changeLog {
+	if s.onChange == nil {
+		return nil
+	}
+	return &changeLog{}
+}
+
+// add records the change of the secret stored under key in the path bucket.
+func (l *changeLog) add(op string, path []string, key string, version int) {
+	if l == nil || len(path) == 0 {
+		return
+	}
+	location := append(append(make([]string, 0, len(path)+1), path...), key)
+	l.changes = append(l.changes, &change{op: op, location: location, version: version})
+}
+
+// addPlain is add for callers knowing the real location of the secret.
+func (l *changeLog) addPlain(op string, location []string, version int) {
+	if l == nil || len(location) < 2 {
+		return
+	}
+	location = append([]string(nil), location...)
+	l.changes = append(l.changes, &change{op: op, location: location, version: version, plain: true})
+}
+
+// resolve turns the collected changes into events with real names,
+// it must be called in the transaction which made the changes.
+func (l *changeLog) resolve(tx Tx, names *nameIndex) error {
+	if l == nil {
+		return nil
+	}
+
+	open := names.opener(tx)
+	now := time.Now().UTC()
+
+	l.events = l.events[:0]
+	for _, c := range l.changes {
+		location := c.location
+		if !c.plain {
+			location = make([]string, len(c.location))
+			for i, stored := range c.location {
+				name, err := open([]byte(stored))
+				if err != nil {
+					return err
+				}
+				location[i] = name
+			}
+		}
+
+		last := len(location) - 1
+		l.events = append(l.events, &models.ChangeEvent{
+			Op:       c.op,
+			Username: location[0],
+			Path:     strings.Join(location[1:last], "/"),
+			Key:      location[last],
+			Version:  c.version,
+			Time:     now,
+		})
+	}
+
+	return nil
+}
+
+// publish hands the events of a committed transaction to the hook,
+// it's called under the write lock to keep the order of commits.
+func (s *Storage) publish(l *changeLog) {
+	if l == nil || len(l.events) == 0 || s.onChange == nil {
+		return
+	}
+	s.onChange(l.events)
+}
+
+// OnChange sets the hook called with changes of secrets after every committed write.
+// The hook is called under the write lock in the order of commits, so it must not block.
+// Values are never passed to the hook.
+func (s *Storage) OnChange(hook func([]*models.ChangeEvent)) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.onChange = hook
+}
+
+// writeOp tells whether writing the version of the record stored under key in b
+// creates the secret or updates it, it must be called before the write.
+func writeOp(b Bucket, key string) (string, error) {
+	current, err := liveVersion(b, key)
+	if err != nil {
+		return "", err
+	}
+	if current == 0 {
+		return models.ChangeCreate, nil
+	}
+	return models.ChangeUpdate, nil
+}
+
+// removedVersion returns the version of the record stored under key in b
+// which removal is seen by watchers, 0 means that the record was already reported
+// as deleted when it was deleted softly.
+func removedVersion(b Bucket, key string) (int, error) {
+	secret, err := openSecret(b, key, false)
+	if err != nil {
+		return 0, err
+	}
+	if secret == nil {
+		return 1, nil
+	}
+	return secretRemovedVersion(secret)
+}
+
+func secretRemovedVersion(secret Bucket) (int, error) {
+	header, err := readHeader(secret)
+	if err != nil {
+		return 0, err
+	}
+	if header.DeletedAt != nil {
+		return 0, nil
+	}
+	return header.CurrentVersion, nil
+}
@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/liriquew/secret_storage/server/internal/lib/config"
+	"github.com/liriquew/secret_storage/server/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChangeEvents(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, cfg config.StorageConfig) {
+		s := newTestStorage(t, cfg)
+
+		var events []models.ChangeEvent
+		s.OnChange(func(committed []*models.ChangeEvent) {
+			for _, event := range committed {
+				assert.False(t, event.Time.IsZero())
+				event.Time = time.Time{}
+				events = append(events, *event)
+			}
+		})
+		collected := func() []models.ChangeEvent {
+			defer func() { events = nil }()
+			return events
+		}
+		event := func(op, path, key string, version int) models.ChangeEvent {
+			return models.ChangeEvent{Op: op, Username: "alice", Path: path, Key: key, Version: version}
+		}
+
+		path := []string{"alice", "app"}
+
+		t.Run("Writes", func(t *testing.T) {
+			_, err := s.SetRecord(path, "a", []byte("one"), models.WriteOptions{})
+			require.NoError(t, err)
+			_, err = s.SetRecord(path, "a", []byte("two"), models.WriteOptions{})
+			require.NoError(t, err)
+			_, err = s.Rollback(path, "a", 1, models.WriteOptions{})
+			require.NoError(t, err)
+
+			assert.Equal(t, []models.ChangeEvent{
+				event(models.ChangeCreate, "app", "a", 1),
+				event(models.ChangeUpdate, "app", "a", 2),
+				event(models.ChangeUpdate, "app", "a", 3),
+			}, collected())
+		})
+
+		t.Run("Deletes", func(t *testing.T) {
+			_, err := s.SoftDelete(path, "a")
+			require.NoError(t, err)
+			require.NoError(t, s.Undelete(path, "a"))
+			_, err = s.SoftDelete(path, "a")
+			require.NoError(t, err)
+			// destroying a deleted secret changes nothing for watchers
+			_, err = s.Delete(path, "a", recordsBucketName)
+			require.NoError(t, err)
+
+			assert.Equal(t, []models.ChangeEvent{
+				event(models.ChangeDelete, "app", "a", 3),
+				event(models.ChangeCreate, "app", "a", 3),
+				event(models.ChangeDelete, "app", "a", 3),
+			}, collected())
+		})
+
+		t.Run("Failed Writes", func(t *testing.T) {
+			cas := 5
+			_, err := s.SetRecord(path, "b", []byte("one"), models.WriteOptions{CAS: &cas})
+			require.Error(t, err)
+
+			_, err = s.Batch([]*models.BatchOperation{
+				{Op: models.BatchOpSet, Path: path, Key: "b", Value: []byte("one")},
+				{Op: models.BatchOpDelete, Path: path, Key: "missing"},
+			})
+			require.Error(t, err)
+
+			assert.Empty(t, collected())
+		})
+
+		t.Run("Relocate", func(t *testing.T) {
+			_, err := s.SetRecord(path, "b", []byte("one"), models.WriteOptions{})
+			require.NoError(t, err)
+			_, err = s.SetRecord([]string{"alice", "app", "nested"}, "c", []byte("one"), models.WriteOptions{})
+			require.NoError(t, err)
+			_, err = s.SetRecord([]string{"alice", "moved"}, "b", []byte("old"), models.WriteOptions{})
+			require.NoError(t, err)
+			collected()
+
+			_, err = s.Relocate(&models.Relocation{
+				From:      path,
+				To:        []string{"alice", "moved"},
+				Move:      true,
+				Overwrite: true,
+			}, nil)
+			require.NoError(t, err)
+
+			assert.ElementsMatch(t, []models.ChangeEvent{
+				event(models.ChangeUpdate, "moved", "b", 1),
+				event(models.ChangeCreate, "moved/nested", "c", 1),
+				event(models.ChangeDelete, "app", "b", 1),
+				event(models.ChangeDelete, "app/nested", "c", 1),
+			}, collected())
+		})
+
+		t.Run("Expired", func(t *testing.T) {
+			expiresAt := time.Now().Add(time.Hour)
+			_, err := s.SetRecord(path, "temp", []byte("one"), models.WriteOptions{ExpiresAt: &expiresAt})
+			require.NoError(t, err)
+			collected()
+
+			n, err := s.DeleteExpired(expiresAt.Add(time.Second))
+			require.NoError(t, err)
+			require.Equal(t, 1, n)
+
+			assert.Equal(t, []models.ChangeEvent{
+				event(models.ChangeDelete, "app", "temp", 1),
+			}, collected())
+		})
+	})
+}
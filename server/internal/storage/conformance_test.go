@@ -0,0 +1,639 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/liriquew/secret_storage/server/internal/lib/config"
+	"github.com/liriquew/secret_storage/server/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// conformanceConfig returns the config of a fresh instance of every registered backend,
+// each of them must pass the whole suite.
+func conformanceConfig(t *testing.T, backendType string) config.StorageConfig {
+	return config.StorageConfig{
+		Type:        backendType,
+		Path:        filepath.Join(t.TempDir(), "data.db"),
+		MaxVersions: 3,
+	}
+}
+
+func forEachBackend(t *testing.T, test func(t *testing.T, cfg config.StorageConfig)) {
+	for _, backendType := range Backends() {
+		t.Run(backendType, func(t *testing.T) {
+			test(t, conformanceConfig(t, backendType))
+		})
+	}
+}
+
+func newTestStorage(t *testing.T, cfg config.StorageConfig) *Storage {
+	s, err := New(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// setTestFile stages chunks of the file in one batch and commits it.
+func setTestFile(t *testing.T, s *Storage, path []string, key string, file *models.FileManifest, chunks [][]byte, opts models.WriteOptions) (int, error) {
+	require.NoError(t, s.StageChunks(file.ID, 0, chunks))
+	return s.SetFile(path, key, file, opts)
+}
+
+var errRollback = errors.New("rollback")
+
+func TestBackendConformance(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, cfg config.StorageConfig) {
+		db, err := openBackend(cfg)
+		require.NoError(t, err)
+		defer db.Close()
+
+		t.Run("Buckets And Values", func(t *testing.T) {
+			err := db.Update(func(tx Tx) error {
+				top, err := tx.CreateBucketIfNotExists([]byte("top"))
+				require.NoError(t, err)
+
+				nested, err := top.CreateBucket([]byte("nested"))
+				require.NoError(t, err)
+				require.NoError(t, nested.Put([]byte("k"), []byte("v")))
+
+				_, err = top.CreateBucket([]byte("nested"))
+				assert.ErrorIs(t, err, ErrBucketExists)
+
+				require.NoError(t, top.Put([]byte("b"), []byte("1")))
+				require.NoError(t, top.Put([]byte("a"), []byte("2")))
+
+				_, err = top.CreateBucket([]byte("a"))
+				assert.ErrorIs(t, err, ErrIncompatibleValue)
+				assert.ErrorIs(t, top.Put([]byte("nested"), []byte("x")), ErrIncompatibleValue)
+				assert.ErrorIs(t, top.Delete([]byte("nested")), ErrIncompatibleValue)
+				assert.ErrorIs(t, top.Put(nil, []byte("x")), ErrKeyRequired)
+				return nil
+			})
+			require.NoError(t, err)
+
+			err = db.View(func(tx Tx) error {
+				assert.Nil(t, tx.Bucket([]byte("missing")))
+
+				top := tx.Bucket([]byte("top"))
+				require.NotNil(t, top)
+				assert.Nil(t, top.Get([]byte("nested")))
+				assert.Nil(t, top.Bucket([]byte("a")))
+				assert.Equal(t, []byte("v"), top.Bucket([]byte("nested")).Get([]byte("k")))
+
+				var keys []string
+				var values [][]byte
+				c := top.Cursor()
+				for k, v := c.First(); k != nil; k, v = c.Next() {
+					keys = append(keys, string(k))
+					values = append(values, v)
+				}
+				assert.Equal(t, []string{"a", "b", "nested"}, keys)
+				assert.Nil(t, values[2])
+
+				k, _ := c.Seek([]byte("az"))
+				assert.Equal(t, []byte("b"), k)
+
+				assert.ErrorIs(t, top.Put([]byte("c"), []byte("3")), ErrTxNotWritable)
+				return nil
+			})
+			require.NoError(t, err)
+		})
+
+		t.Run("Cursor Delete", func(t *testing.T) {
+			err := db.Update(func(tx Tx) error {
+				b, err := tx.CreateBucketIfNotExists([]byte("cursor"))
+				require.NoError(t, err)
+				for _, k := range []string{"1", "2", "3"} {
+					require.NoError(t, b.Put([]byte(k), []byte(k)))
+				}
+
+				c := b.Cursor()
+				for k, _ := c.First(); k != nil && string(k) < "3"; k, _ = c.First() {
+					require.NoError(t, c.Delete())
+				}
+
+				k, _ := b.Cursor().First()
+				assert.Equal(t, []byte("3"), k)
+				return nil
+			})
+			require.NoError(t, err)
+		})
+
+		t.Run("Rollback", func(t *testing.T) {
+			err := db.Update(func(tx Tx) error {
+				b, err := tx.CreateBucketIfNotExists([]byte("rollback"))
+				require.NoError(t, err)
+				require.NoError(t, b.Put([]byte("kept"), []byte("old")))
+				_, err = b.CreateBucket([]byte("kept_bucket"))
+				return err
+			})
+			require.NoError(t, err)
+
+			err = db.Update(func(tx Tx) error {
+				b := tx.Bucket([]byte("rollback"))
+				require.NoError(t, b.Put([]byte("kept"), []byte("new")))
+				require.NoError(t, b.Put([]byte("added"), []byte("new")))
+				require.NoError(t, b.DeleteBucket([]byte("kept_bucket")))
+				_, err := b.CreateBucket([]byte("added_bucket"))
+				require.NoError(t, err)
+				_, err = tx.CreateBucketIfNotExists([]byte("added_top"))
+				require.NoError(t, err)
+				return errRollback
+			})
+			require.ErrorIs(t, err, errRollback)
+
+			err = db.View(func(tx Tx) error {
+				assert.Nil(t, tx.Bucket([]byte("added_top")))
+
+				b := tx.Bucket([]byte("rollback"))
+				assert.Equal(t, []byte("old"), b.Get([]byte("kept")))
+				assert.Nil(t, b.Get([]byte("added")))
+				assert.NotNil(t, b.Bucket([]byte("kept_bucket")))
+				assert.Nil(t, b.Bucket([]byte("added_bucket")))
+				return nil
+			})
+			require.NoError(t, err)
+		})
+
+		t.Run("Move Bucket", func(t *testing.T) {
+			err := db.Update(func(tx Tx) error {
+				src, err := tx.CreateBucketIfNotExists([]byte("move_src"))
+				require.NoError(t, err)
+				moved, err := src.CreateBucket([]byte("moved\x00"))
+				require.NoError(t, err)
+				require.NoError(t, moved.Put([]byte("k"), []byte("v")))
+				deep, err := moved.CreateBucket([]byte("deep"))
+				require.NoError(t, err)
+				require.NoError(t, deep.Put([]byte("k"), []byte("deep")))
+				require.NoError(t, src.Put([]byte("value"), []byte("v")))
+
+				dst, err := tx.CreateBucketIfNotExists([]byte("move_dst"))
+				require.NoError(t, err)
+				_, err = dst.CreateBucket([]byte("nested"))
+				return err
+			})
+			require.NoError(t, err)
+
+			err = db.Update(func(tx Tx) error {
+				src := tx.Bucket([]byte("move_src"))
+				dst := tx.Bucket([]byte("move_dst")).Bucket([]byte("nested"))
+
+				assert.ErrorIs(t, src.MoveBucket([]byte("missing"), dst), ErrBucketNotFound)
+				assert.ErrorIs(t, src.MoveBucket([]byte("value"), dst), ErrIncompatibleValue)
+				return src.MoveBucket([]byte("moved\x00"), dst)
+			})
+			require.NoError(t, err)
+
+			err = db.Update(func(tx Tx) error {
+				src := tx.Bucket([]byte("move_src"))
+				dst := tx.Bucket([]byte("move_dst")).Bucket([]byte("nested"))
+				_, err := src.CreateBucket([]byte("moved\x00"))
+				require.NoError(t, err)
+				assert.ErrorIs(t, src.MoveBucket([]byte("moved\x00"), dst), ErrBucketExists)
+
+				require.NoError(t, dst.MoveBucket([]byte("moved\x00"), tx.Bucket([]byte("move_dst"))))
+				return errRollback
+			})
+			require.ErrorIs(t, err, errRollback)
+
+			err = db.View(func(tx Tx) error {
+				assert.Nil(t, tx.Bucket([]byte("move_src")).Bucket([]byte("moved\x00")))
+				assert.Nil(t, tx.Bucket([]byte("move_dst")).Bucket([]byte("moved\x00")))
+
+				moved := tx.Bucket([]byte("move_dst")).Bucket([]byte("nested")).Bucket([]byte("moved\x00"))
+				require.NotNil(t, moved)
+				assert.Equal(t, []byte("v"), moved.Get([]byte("k")))
+				assert.Equal(t, []byte("deep"), moved.Bucket([]byte("deep")).Get([]byte("k")))
+				return nil
+			})
+			require.NoError(t, err)
+		})
+	})
+}
+
+func TestStorageConformance(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, cfg config.StorageConfig) {
+		storageConformance(t, newTestStorage(t, cfg))
+	})
+}
+
+func storageConformance(t *testing.T, s *Storage) {
+	author := models.WriteOptions{Author: "alice"}
+
+	t.Run("Versions", func(t *testing.T) {
+		path := []string{"alice", "versions"}
+		for i, value := range []string{"v1", "v2", "v3", "v4"} {
+			version, err := s.SetRecord(path, "key", []byte(value), author)
+			require.NoError(t, err)
+			assert.Equal(t, i+1, version)
+		}
+
+		record, err := s.GetRecord(path, "key", 0)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("v4"), record.Value)
+		assert.Equal(t, 4, record.Version)
+		assert.Equal(t, "alice", record.Metadata.CreatedBy)
+
+		// max versions is 3
+		_, err = s.GetRecord(path, "key", 1)
+		assert.ErrorIs(t, err, ErrVersionNotFound)
+
+		versions, err := s.ListVersions(path, "key")
+		require.NoError(t, err)
+		require.Len(t, versions, 3)
+		assert.Equal(t, 2, versions[0].Version)
+
+		version, err := s.Rollback(path, "key", 2, author)
+		require.NoError(t, err)
+		assert.Equal(t, 5, version)
+
+		record, err = s.GetRecord(path, "key", 0)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("v2"), record.Value)
+	})
+
+	t.Run("Check And Set", func(t *testing.T) {
+		path := []string{"alice", "cas"}
+		zero, one := 0, 1
+
+		_, err := s.SetRecord(path, "key", []byte("v1"), models.WriteOptions{CAS: &zero})
+		require.NoError(t, err)
+
+		_, err = s.SetRecord(path, "key", []byte("v2"), models.WriteOptions{CAS: &zero})
+		assert.ErrorIs(t, err, ErrCASMismatch)
+
+		_, err = s.SetRecord(path, "key", []byte("v2"), models.WriteOptions{CAS: &one})
+		assert.NoError(t, err)
+	})
+
+	t.Run("Soft Delete", func(t *testing.T) {
+		path := []string{"alice", "deleted"}
+		_, err := s.SetRecord(path, "key", []byte("value"), author)
+		require.NoError(t, err)
+		_, err = s.SetRecord(path, "other", []byte("value"), author)
+		require.NoError(t, err)
+
+		_, err = s.SoftDelete(path, "key")
+		require.NoError(t, err)
+
+		_, err = s.GetRecord(path, "key", 0)
+		assert.ErrorIs(t, err, ErrRecordNotFound)
+
+		info, err := s.ListRecords(path, models.PageOptions{})
+		require.NoError(t, err)
+		assert.Len(t, info.Records, 1)
+
+		require.NoError(t, s.Undelete(path, "key"))
+		_, err = s.GetRecord(path, "key", 0)
+		assert.NoError(t, err)
+
+		_, err = s.SoftDelete(path, "key")
+		require.NoError(t, err)
+
+		purged, err := s.PurgeDeleted(time.Now().Add(time.Second))
+		require.NoError(t, err)
+		assert.Equal(t, 1, purged)
+		assert.ErrorIs(t, s.Undelete(path, "key"), ErrRecordNotFound)
+	})
+
+	t.Run("Expiration", func(t *testing.T) {
+		path := []string{"alice", "expiring"}
+		expiresAt := time.Now().Add(time.Hour)
+		_, err := s.SetRecord(path, "key", []byte("value"), models.WriteOptions{ExpiresAt: &expiresAt})
+		require.NoError(t, err)
+
+		deleted, err := s.DeleteExpired(time.Now())
+		require.NoError(t, err)
+		assert.Equal(t, 0, deleted)
+
+		deleted, err = s.DeleteExpired(expiresAt)
+		require.NoError(t, err)
+		assert.Equal(t, 1, deleted)
+
+		// the only record is gone, so are empty path buckets
+		_, err = s.ListRecords(path, models.PageOptions{})
+		assert.ErrorIs(t, err, ErrBucketNotFound)
+	})
+
+	t.Run("Expiration Kept", func(t *testing.T) {
+		path := []string{"alice", "expiring"}
+		expiresAt := time.Now().Add(time.Hour).UTC()
+		_, err := s.SetRecord(path, "kept", []byte("value"), models.WriteOptions{ExpiresAt: &expiresAt})
+		require.NoError(t, err)
+
+		// a write without expiration keeps the one set earlier
+		_, err = s.SetRecord(path, "kept", []byte("updated"), models.WriteOptions{})
+		require.NoError(t, err)
+		record, err := s.GetRecord(path, "kept", 0)
+		require.NoError(t, err)
+		require.NotNil(t, record.Metadata.ExpiresAt)
+		assert.True(t, expiresAt.Equal(*record.Metadata.ExpiresAt))
+
+		_, err = s.SetRecord(path, "kept", []byte("persistent"), models.WriteOptions{ClearExpiration: true})
+		require.NoError(t, err)
+		record, err = s.GetRecord(path, "kept", 0)
+		require.NoError(t, err)
+		assert.Nil(t, record.Metadata.ExpiresAt)
+
+		// a deleted record is written anew
+		_, err = s.SetRecord(path, "deleted", []byte("value"), models.WriteOptions{ExpiresAt: &expiresAt})
+		require.NoError(t, err)
+		_, err = s.SoftDelete(path, "deleted")
+		require.NoError(t, err)
+		_, err = s.SetRecord(path, "deleted", []byte("again"), models.WriteOptions{})
+		require.NoError(t, err)
+		record, err = s.GetRecord(path, "deleted", 0)
+		require.NoError(t, err)
+		assert.Nil(t, record.Metadata.ExpiresAt)
+	})
+
+	t.Run("Labels", func(t *testing.T) {
+		path := []string{"alice", "labels"}
+		_, err := s.SetRecord(path, "key", []byte("value"), author)
+		require.NoError(t, err)
+
+		metadata, err := s.SetLabels(path, "key", map[string]string{"env": "prod"})
+		require.NoError(t, err)
+		assert.Equal(t, "prod", metadata.Labels["env"])
+
+		record, err := s.GetRecord(path, "key", 0)
+		require.NoError(t, err)
+		assert.Equal(t, 1, record.Version)
+		assert.Equal(t, "prod", record.Metadata.Labels["env"])
+	})
+
+	t.Run("Batch", func(t *testing.T) {
+		zero := 0
+		ops := []*models.BatchOperation{
+			{Op: models.BatchOpSet, Path: []string{"alice", "batch", "a"}, Key: "k1", Value: []byte("1")},
+			{Op: models.BatchOpSet, Path: []string{"alice", "batch", "b"}, Key: "k2", Value: []byte("2")},
+		}
+		results, err := s.Batch(ops)
+		require.NoError(t, err)
+		assert.Len(t, results, 2)
+
+		ops = []*models.BatchOperation{
+			{Op: models.BatchOpSet, Path: []string{"alice", "batch", "c"}, Key: "k3", Value: []byte("3")},
+			{Op: models.BatchOpSet, Path: []string{"alice", "batch", "a"}, Key: "k1", Value: []byte("1"), Opts: models.WriteOptions{CAS: &zero}},
+		}
+		_, err = s.Batch(ops)
+		assert.ErrorIs(t, err, ErrCASMismatch)
+
+		_, err = s.GetRecord([]string{"alice", "batch", "c"}, "k3", 0)
+		assert.Error(t, err)
+	})
+
+	t.Run("Listing And Delete", func(t *testing.T) {
+		for _, path := range [][]string{{"bob"}, {"bob", "a"}, {"bob", "a", "b"}} {
+			_, err := s.SetRecord(path, "key", []byte("value"), author)
+			require.NoError(t, err)
+		}
+
+		info, err := s.ListRecords([]string{"bob"}, models.PageOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a"}, info.Buckets)
+		require.Len(t, info.Records, 1)
+		assert.Equal(t, []byte("key"), info.Records[0].Key)
+
+		fullInfo, err := s.ListRecordsRecursively([]string{"bob"})
+		require.NoError(t, err)
+		require.Len(t, fullInfo.Buckets, 1)
+		require.Len(t, fullInfo.Buckets[0].Buckets, 1)
+		assert.Equal(t, "b", fullInfo.Buckets[0].Buckets[0].Name)
+
+		_, err = s.GetRecord([]string{"bob", "key"}, "meta", 0)
+		assert.ErrorIs(t, err, ErrBucketNotFound)
+
+		_, err = s.Delete([]string{"bob", "a", "b"}, "key", recordsBucketName)
+		require.NoError(t, err)
+		_, err = s.ListRecords([]string{"bob", "a", "b"}, models.PageOptions{})
+		assert.ErrorIs(t, err, ErrBucketNotFound)
+
+		_, err = s.Delete([]string{"bob", "a"}, "missing", recordsBucketName)
+		assert.ErrorIs(t, err, ErrRecordNotFound)
+	})
+
+	t.Run("Pagination", func(t *testing.T) {
+		path := []string{"erin"}
+		for _, key := range []string{"a", "b", "c", "d"} {
+			_, err := s.SetRecord(path, key, []byte("value"), author)
+			require.NoError(t, err)
+		}
+		_, err := s.SetRecord([]string{"erin", "bucket"}, "key", []byte("value"), author)
+		require.NoError(t, err)
+		_, err = s.SoftDelete(path, "c")
+		require.NoError(t, err)
+
+		var listed []string
+		page := models.PageOptions{Limit: 2}
+		for {
+			info, err := s.ListRecords(path, page)
+			require.NoError(t, err)
+
+			listed = append(listed, info.Buckets...)
+			for _, record := range info.Records {
+				listed = append(listed, string(record.Key))
+			}
+
+			if info.NextCursor == "" {
+				break
+			}
+			page.After, err = models.DecodeCursor(info.NextCursor)
+			require.NoError(t, err)
+		}
+
+		// deleted records don't take places in pages
+		assert.Equal(t, []string{"a", "b", "bucket", "d"}, listed)
+	})
+
+	t.Run("Search", func(t *testing.T) {
+		for _, path := range [][]string{{"dave", "db", "prod"}, {"dave", "db", "dev"}, {"dave", "web"}} {
+			_, err := s.SetRecord(path, "password", []byte("value"), author)
+			require.NoError(t, err)
+		}
+
+		search := func(query *models.SearchQuery) []string {
+			matches, err := s.Search([]string{"dave"}, query)
+			require.NoError(t, err)
+
+			var paths []string
+			for _, match := range matches {
+				paths = append(paths, match.Path)
+				assert.Equal(t, query.WithValues && !match.Bucket, match.Record != nil)
+			}
+			return paths
+		}
+
+		assert.Equal(t, []string{"db/dev/password", "db/prod/password"}, search(&models.SearchQuery{Pattern: "db/*/password"}))
+		assert.Equal(t, []string{"db/dev/password", "db/prod/password", "web/password"}, search(&models.SearchQuery{Pattern: "**/password"}))
+		assert.Equal(t, []string{"db/prod", "db/prod/password"}, search(&models.SearchQuery{Prefix: "db/pr", WithValues: true}))
+		assert.Equal(t, []string{"db/dev"}, search(&models.SearchQuery{Pattern: "*/d*"}))
+
+		_, err := s.Search([]string{"dave"}, &models.SearchQuery{Pattern: "db/[/password"})
+		assert.ErrorIs(t, err, ErrBadSearchPattern)
+	})
+
+	t.Run("Relocate", func(t *testing.T) {
+		_, err := s.SetRecord([]string{"frank", "a", "b"}, "key", []byte("value"), author)
+		require.NoError(t, err)
+		_, err = s.SetRecord([]string{"frank", "a", "b"}, "key", []byte("new value"), author)
+		require.NoError(t, err)
+		_, err = s.SetRecord([]string{"frank", "a", "c"}, "other", []byte("value"), author)
+		require.NoError(t, err)
+
+		n, err := s.Relocate(&models.Relocation{From: []string{"frank", "a", "b"}, To: []string{"frank", "x"}, Key: "key", NewKey: "renamed", Move: true}, nil)
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+
+		// versions are moved together with the secret
+		record, err := s.GetRecord([]string{"frank", "x"}, "renamed", 1)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("value"), record.Value)
+
+		_, err = s.ListRecords([]string{"frank", "a", "b"}, models.PageOptions{})
+		assert.ErrorIs(t, err, ErrBucketNotFound)
+
+		n, err = s.Relocate(&models.Relocation{From: []string{"frank", "a"}, To: []string{"frank", "copy"}}, nil)
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+
+		_, err = s.Relocate(&models.Relocation{From: []string{"frank", "a"}, To: []string{"frank", "copy"}}, nil)
+		assert.ErrorIs(t, err, ErrRelocationConflict)
+		assert.ErrorContains(t, err, "copy/c/other")
+
+		n, err = s.Relocate(&models.Relocation{From: []string{"frank", "a"}, To: []string{"frank", "copy"}, Overwrite: true, Move: true}, nil)
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+
+		_, err = s.ListRecords([]string{"frank", "a"}, models.PageOptions{})
+		assert.ErrorIs(t, err, ErrBucketNotFound)
+
+		record, err = s.GetRecord([]string{"frank", "copy", "c"}, "other", 0)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("value"), record.Value)
+
+		_, err = s.Relocate(&models.Relocation{From: []string{"frank", "copy"}, To: []string{"frank", "copy", "nested"}}, nil)
+		assert.ErrorIs(t, err, ErrRelocationOverlap)
+
+		_, err = s.Relocate(&models.Relocation{From: []string{"frank", "copy"}, To: []string{"frank", "x"}, Key: "missing", NewKey: "missing"}, nil)
+		assert.ErrorIs(t, err, ErrRecordNotFound)
+	})
+
+	t.Run("Files", func(t *testing.T) {
+		path := []string{"grace", "certs"}
+		file := &models.FileManifest{ID: []byte("id"), Size: 5, ChunkSize: 3, Chunks: 2}
+		_, err := s.SetFile(path, "bundle", file, author)
+		assert.ErrorIs(t, err, ErrUploadNotFound)
+		assert.ErrorIs(t, s.StageChunks(file.ID, 1, [][]byte{[]byte("de")}), ErrUploadNotFound)
+
+		// chunks are staged in batches and committed at once
+		require.NoError(t, s.StageChunks(file.ID, 0, [][]byte{[]byte("abc")}))
+		_, err = s.SetFile(path, "bundle", file, author)
+		assert.ErrorIs(t, err, ErrChunkNotFound)
+		require.NoError(t, s.StageChunks(file.ID, 1, [][]byte{[]byte("de")}))
+		version, err := s.SetFile(path, "bundle", file, author)
+		require.NoError(t, err)
+		assert.Equal(t, 1, version)
+
+		_, err = s.SetFile(path, "bundle", file, author)
+		assert.ErrorIs(t, err, ErrUploadNotFound)
+		err = s.db.View(func(tx Tx) error {
+			assert.Nil(t, uploadsBucket(tx).Bucket(file.ID))
+			b, err := openBucketByPath(path, tx.Bucket(recordsBucketName))
+			require.NoError(t, err)
+			assert.Nil(t, b.Bucket([]byte("bundle")).Bucket(secretChunksBucketName).Bucket(file.ID).Get(uploadStartedKey))
+			return nil
+		})
+		require.NoError(t, err)
+
+		record, err := s.GetRecord(path, "bundle", 0)
+		require.NoError(t, err)
+		assert.Nil(t, record.Value)
+		assert.Equal(t, file.ID, record.File.ID)
+
+		chunk, err := s.GetFileChunk(path, "bundle", 1, 1)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("de"), chunk)
+
+		_, err = s.GetFileChunk(path, "bundle", 1, 2)
+		assert.ErrorIs(t, err, ErrChunkNotFound)
+
+		_, err = s.SetRecord(path, "bundle", []byte("value"), author)
+		require.NoError(t, err)
+		_, err = s.GetFileChunk(path, "bundle", 2, 0)
+		assert.ErrorIs(t, err, ErrNotFile)
+
+		// rollback copies chunks of the file
+		version, err = s.Rollback(path, "bundle", 1, author)
+		require.NoError(t, err)
+		chunk, err = s.GetFileChunk(path, "bundle", version, 0)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("abc"), chunk)
+
+		// chunks of pruned versions are dropped with them
+		for range 3 {
+			_, err = s.SetRecord(path, "bundle", []byte("value"), author)
+			require.NoError(t, err)
+		}
+		err = s.db.View(func(tx Tx) error {
+			b, err := openBucketByPath(path, tx.Bucket(recordsBucketName))
+			require.NoError(t, err)
+			allChunks := b.Bucket([]byte("bundle")).Bucket(secretChunksBucketName)
+			assert.Nil(t, allChunks.Bucket(file.ID))
+			assert.Nil(t, allChunks.Bucket(versionKey(version)))
+			return nil
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("Abandoned Uploads", func(t *testing.T) {
+		require.NoError(t, s.StageChunks([]byte("dropped"), 0, [][]byte{[]byte("x")}))
+		require.NoError(t, s.DropUpload([]byte("dropped")))
+		require.NoError(t, s.DropUpload([]byte("dropped")))
+		_, err := s.SetFile([]string{"grace"}, "dropped", &models.FileManifest{ID: []byte("dropped"), Chunks: 1}, author)
+		assert.ErrorIs(t, err, ErrUploadNotFound)
+
+		require.NoError(t, s.StageChunks([]byte("stale"), 0, [][]byte{[]byte("x")}))
+		dropped, err := s.DropStaleUploads(time.Now().Add(-time.Hour))
+		require.NoError(t, err)
+		assert.Equal(t, 0, dropped)
+
+		dropped, err = s.DropStaleUploads(time.Now())
+		require.NoError(t, err)
+		assert.Equal(t, 1, dropped)
+		assert.ErrorIs(t, s.StageChunks([]byte("stale"), 1, [][]byte{[]byte("y")}), ErrUploadNotFound)
+	})
+
+	t.Run("Users", func(t *testing.T) {
+		require.NoError(t, s.Set(nil, "carol", []byte("hash"), userBucketName))
+
+		hash, err := s.Get(nil, "carol", userBucketName)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("hash"), hash)
+	})
+
+	// every write made above must keep the counters equal to the stored data
+	t.Run("Usage Counters", func(t *testing.T) {
+		stored := map[string]models.Usage{}
+		err := s.db.View(func(tx Tx) error {
+			top := tx.Bucket(recordsBucketName)
+			return top.ForEach(func(k, v []byte) error {
+				usage, err := bucketUsage(top.Bucket(k))
+				stored[string(k)] = usage
+				return err
+			})
+		})
+		require.NoError(t, err)
+		require.NotEmpty(t, stored)
+
+		for username, usage := range stored {
+			status, err := s.Usage(username)
+			require.NoError(t, err)
+			assert.Equal(t, usage, status.Usage, "namespace %s", username)
+		}
+	})
+}
@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"time"
+)
+
+// DeleteExpired removes records expired at the moment now
+// and returns the number of removed records.
+func (s *Storage) DeleteExpired(now time.Time) (int, error) {
+	var expired []recordRef
+
+	s.m.RLock()
+	err := s.db.View(func(tx Tx) error {
+		b := tx.Bucket(recordsBucketName)
+		if b == nil {
+			return ErrFailedToOpenTopBucket
+		}
+
+		return walkSecrets(b, nil, func(path []string, key string, secret Bucket) error {
+			header, err := readHeader(secret)
+			if err != nil {
+				return err
+			}
+			if header.expired(now) {
+				expired = append(expired, recordRef{path, key})
+			}
+			return nil
+		})
+	})
+	s.m.RUnlock()
+	if err != nil {
+		return 0, err
+	}
+
+	return s.deleteRecordsInBatches(expired, func(secret Bucket) (bool, error) {
+		header, err := readHeader(secret)
+		if err != nil {
+			return false, err
+		}
+		return header.expired(now), nil
+	})
+}
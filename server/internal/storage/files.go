@@ -0,0 +1,411 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/liriquew/secret_storage/server/internal/models"
+)
+
+// Versions stored as files keep their manifest in the version entry
+// and their chunks in a bucket of the version:
+//
+//	kv/<username>/<path...>/<key>/chunks/<file id>/<uint32 index>     - encrypted chunk
+//	kv/<username>/<path...>/<key>/chunks/<uint64 version>/<uint32 index> - chunks of a rolled back version
+//
+// Uploads are staged a batch of chunks at a time, every batch in its own
+// transaction, and the version is committed last by moving the staged
+// bucket under the secret:
+//
+//	meta/uploads/<file id>/<uint32 index> - encrypted chunk
+//	meta/uploads/<file id>/started        - unix nanoseconds of the start of the upload
+var (
+	secretChunksBucketName = []byte("chunks")
+	metaUploadsBucketName  = []byte("uploads")
+	uploadStartedKey       = []byte("started")
+)
+
+var (
+	ErrNotFile        = errors.New("secret is not a file")
+	ErrChunkNotFound  = errors.New("file chunk not found")
+	ErrUploadNotFound = errors.New("upload not found")
+)
+
+func chunkKey(index int) []byte {
+	k := make([]byte, 4)
+	binary.BigEndian.PutUint32(k, uint32(index))
+	return k
+}
+
+// StageChunks writes chunks of the upload id starting at the index first,
+// every call writes in a transaction of its own. The upload starts with
+// the chunk 0.
+func (s *Storage) StageChunks(id []byte, first int, chunks [][]byte) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.db.Update(func(tx Tx) error {
+		meta := tx.Bucket(metaBucketName)
+		if meta == nil {
+			return ErrFailedToOpenTopBucket
+		}
+		uploads, err := meta.CreateBucketIfNotExists(metaUploadsBucketName)
+		if err != nil {
+			return fmt.Errorf("error while creating uploads bucket: %w", err)
+		}
+
+		var staged Bucket
+		if first == 0 {
+			staged, err = uploads.CreateBucket(id)
+			if err != nil {
+				return fmt.Errorf("error while creating upload bucket: %w", err)
+			}
+			started := binary.BigEndian.AppendUint64(nil, uint64(time.Now().UnixNano()))
+			if err := staged.Put(uploadStartedKey, started); err != nil {
+				return err
+			}
+		} else if staged = uploads.Bucket(id); staged == nil {
+			return ErrUploadNotFound
+		}
+
+		for i, chunk := range chunks {
+			if err := staged.Put(chunkKey(first+i), chunk); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DropUpload removes the chunks staged by the upload id.
+func (s *Storage) DropUpload(id []byte) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.db.Update(func(tx Tx) error {
+		uploads := uploadsBucket(tx)
+		if uploads == nil || uploads.Bucket(id) == nil {
+			return nil
+		}
+		return uploads.DeleteBucket(id)
+	})
+}
+
+// DropStaleUploads removes uploads started before the moment before and never
+// committed, they are left by uploads interrupted by a restart of the node.
+// Returns the number of removed uploads.
+func (s *Storage) DropStaleUploads(before time.Time) (int, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	var dropped int
+	err := s.db.Update(func(tx Tx) error {
+		dropped = 0
+		uploads := uploadsBucket(tx)
+		if uploads == nil {
+			return nil
+		}
+
+		var stale [][]byte
+		err := uploads.ForEach(func(id, _ []byte) error {
+			started := uploads.Bucket(id).Get(uploadStartedKey)
+			if len(started) != 8 || time.Unix(0, int64(binary.BigEndian.Uint64(started))).Before(before) {
+				stale = append(stale, slices.Clone(id))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, id := range stale {
+			if err := uploads.DeleteBucket(id); err != nil {
+				return err
+			}
+		}
+		dropped = len(stale)
+		return nil
+	})
+	return dropped, err
+}
+
+func uploadsBucket(tx Tx) Bucket {
+	meta := tx.Bucket(metaBucketName)
+	if meta == nil {
+		return nil
+	}
+	return meta.Bucket(metaUploadsBucketName)
+}
+
+// SetFile commits the upload staged under the id of the file as a new version
+// of the record, all chunks of the file must be staged.
+func (s *Storage) SetFile(path []string, key string, file *models.FileManifest, opts models.WriteOptions) (int, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	var version int
+	var changes *changeLog
+	err := s.db.Update(func(tx Tx) error {
+		b := tx.Bucket(recordsBucketName)
+		if b == nil {
+			return ErrFailedToOpenTopBucket
+		}
+
+		uploads := uploadsBucket(tx)
+		if uploads == nil || uploads.Bucket(file.ID) == nil {
+			return ErrUploadNotFound
+		}
+
+		if err := s.names.register(tx, append(slices.Clip(path), key)...); err != nil {
+			return err
+		}
+
+		usage := s.newUsageTracker()
+		changes = s.newChangeLog()
+		refs := s.newNameRefs()
+
+		stored, storedKey := s.names.blindLocation(path, key)
+		var err error
+		version, err = setFile(b, stored, storedKey, file, uploads, opts, s.maxVersions, usage, changes, refs)
+		if err != nil {
+			return err
+		}
+		if err := usage.commit(tx); err != nil {
+			return err
+		}
+		if err := changes.resolve(tx, s.names); err != nil {
+			return err
+		}
+		return refs.commit(tx)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	s.publish(changes)
+	return version, nil
+}
+
+// stagedSize returns the size of the chunks of the staged upload, it fails
+// unless they are all staged.
+func stagedSize(staged Bucket, file *models.FileManifest) (int64, error) {
+	var size int64
+	var chunks int
+	err := staged.ForEach(func(k, v []byte) error {
+		if bytes.Equal(k, uploadStartedKey) {
+			return nil
+		}
+		size += int64(len(v))
+		chunks++
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if chunks != file.Chunks || (chunks > 0 && staged.Get(chunkKey(chunks-1)) == nil) {
+		return 0, fmt.Errorf("%w: %d of %d chunks are staged", ErrChunkNotFound, chunks, file.Chunks)
+	}
+	return size, nil
+}
+
+func setFile(b Bucket, path []string, key string, file *models.FileManifest, uploads Bucket, opts models.WriteOptions, maxVersions int, usage *usageTracker, changes *changeLog, refs *nameRefs) (int, error) {
+	size, err := stagedSize(uploads.Bucket(file.ID), file)
+	if err != nil {
+		return 0, err
+	}
+	if err := usage.checkSize(path, size); err != nil {
+		return 0, err
+	}
+
+	b, err = createBucketByPath(b, path, refs)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := checkCAS(b, key, opts.CAS); err != nil {
+		return 0, err
+	}
+
+	op, err := writeOp(b, key)
+	if err != nil {
+		return 0, err
+	}
+
+	before, err := entryUsage(b, []byte(key))
+	if err != nil {
+		return 0, err
+	}
+
+	refs.create(b, []byte(key))
+	secret, err := openSecret(b, key, true)
+	if err != nil {
+		return 0, err
+	}
+
+	version, err := putEntry(secret, &versionEntry{File: file, Chunks: file.ID}, opts, maxVersions)
+	if err != nil {
+		return 0, err
+	}
+
+	allChunks, err := secret.CreateBucketIfNotExists(secretChunksBucketName)
+	if err != nil {
+		return 0, fmt.Errorf("error while creating chunks bucket: %w", err)
+	}
+	// the staged bucket isn't changed by the transaction before the move
+	if err := uploads.MoveBucket(file.ID, allChunks); err != nil {
+		return 0, fmt.Errorf("error while committing upload: %w", err)
+	}
+	if err := allChunks.Bucket(file.ID).Delete(uploadStartedKey); err != nil {
+		return 0, err
+	}
+	changes.add(op, path, key, version)
+
+	after, err := secretUsage(secret)
+	if err != nil {
+		return 0, err
+	}
+	usage.track(path, before, after)
+
+	return version, nil
+}
+
+// putFileVersion writes chunks of a file as a new version of the secret
+// keeping them under the version key, chunks must be in the order of the content.
+func putFileVersion(secret Bucket, file *models.FileManifest, chunks [][]byte, opts models.WriteOptions, maxVersions int) (int, error) {
+	version, err := putEntry(secret, &versionEntry{File: file}, opts, maxVersions)
+	if err != nil {
+		return 0, err
+	}
+
+	allChunks, err := secret.CreateBucketIfNotExists(secretChunksBucketName)
+	if err != nil {
+		return 0, fmt.Errorf("error while creating chunks bucket: %w", err)
+	}
+	versionChunks, err := allChunks.CreateBucket(versionKey(version))
+	if err != nil {
+		return 0, fmt.Errorf("error while creating chunks bucket: %w", err)
+	}
+
+	for i, chunk := range chunks {
+		if err := versionChunks.Put(chunkKey(i), chunk); err != nil {
+			return 0, err
+		}
+	}
+
+	return version, nil
+}
+
+// fileChunks returns the bucket holding chunks of the version stored
+// under the version key, nil if there is none.
+func fileChunks(secret Bucket, version []byte, entry *versionEntry) Bucket {
+	allChunks := secret.Bucket(secretChunksBucketName)
+	if allChunks == nil {
+		return nil
+	}
+	if entry.Chunks != nil {
+		return allChunks.Bucket(entry.Chunks)
+	}
+	return allChunks.Bucket(version)
+}
+
+// readChunks copies all chunks of the version out of the transaction.
+func readChunks(secret Bucket, version int) ([][]byte, error) {
+	entry, err := readVersion(secret, version)
+	if err != nil {
+		return nil, err
+	}
+	versionChunks := fileChunks(secret, versionKey(version), entry)
+	if versionChunks == nil {
+		return nil, fmt.Errorf("%w: version - %d", ErrNotFile, version)
+	}
+
+	var chunks [][]byte
+	err = versionChunks.ForEach(func(k, v []byte) error {
+		chunks = append(chunks, append([]byte(nil), v...))
+		return nil
+	})
+	return chunks, err
+}
+
+// dropChunks removes chunks of the version if it is stored as a file,
+// raw is its version entry.
+func dropChunks(secret Bucket, version []byte, raw []byte) error {
+	entry := &versionEntry{}
+	if err := json.Unmarshal(raw, entry); err != nil {
+		return fmt.Errorf("error while decoding version: %w", err)
+	}
+
+	allChunks := secret.Bucket(secretChunksBucketName)
+	if entry.Chunks != nil {
+		version = entry.Chunks
+	}
+	if allChunks == nil || allChunks.Bucket(version) == nil {
+		return nil
+	}
+	return allChunks.DeleteBucket(version)
+}
+
+// GetFileChunk returns the encrypted chunk of the version of a file,
+// every chunk is read in its own transaction so a download doesn't block writers.
+func (s *Storage) GetFileChunk(path []string, key string, version int, index int) ([]byte, error) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	path, key = s.names.blindLocation(path, key)
+
+	var chunk []byte
+	err := s.db.View(func(tx Tx) error {
+		b := tx.Bucket(recordsBucketName)
+		if b == nil {
+			return ErrFailedToOpenTopBucket
+		}
+
+		b, err := openBucketByPath(path, b)
+		if err != nil {
+			return err
+		}
+
+		secret, err := openSecret(b, key, false)
+		if err != nil {
+			return err
+		}
+		if secret == nil {
+			return ErrNotFile
+		}
+
+		header, err := readHeader(secret)
+		if err != nil {
+			return err
+		}
+		if header.gone(time.Now()) {
+			return ErrRecordNotFound
+		}
+
+		entry, err := readVersion(secret, version)
+		if err != nil {
+			return err
+		}
+		versionChunks := fileChunks(secret, versionKey(version), entry)
+		if entry.File == nil || versionChunks == nil {
+			return fmt.Errorf("%w: version - %d", ErrNotFile, version)
+		}
+
+		value := versionChunks.Get(chunkKey(index))
+		if value == nil {
+			return fmt.Errorf("%w: version - %d, chunk - %d", ErrChunkNotFound, version, index)
+		}
+		chunk = append([]byte(nil), value...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return chunk, nil
+}
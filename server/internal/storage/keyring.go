@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/liriquew/secret_storage/server/internal/models"
+)
+
+// The data keys are kept in one value of the meta bucket sealed with the master key:
+//
+//	meta/keyring - wrapped keyring
+//
+// The storage never looks into it, the keyring is unwrapped by the encrypted storage.
+// The value is cached: ciphertexts are opened inside transactions, and a new key
+// met there is looked up without starting another one. In the HA cluster the cache
+// is refreshed by the raft log.
+var metaKeyringKey = []byte("keyring")
+
+func (s *Storage) cacheKeyring(wrapped []byte) {
+	wrapped = bytes.Clone(wrapped)
+	s.keyring.Store(&wrapped)
+}
+
+// Keyring returns the wrapped keyring, nil if the database has none yet.
+func (s *Storage) Keyring() []byte {
+	if wrapped := s.keyring.Load(); wrapped != nil {
+		return *wrapped
+	}
+	return nil
+}
+
+// SealedSample is a name or a value sealed before the keyring was created,
+// a key is checked by opening it before it becomes the first data key.
+type SealedSample struct {
+	// Blind is the index the sealed Name is bound to, set with blind names
+	Blind string
+	Name  []byte
+	// Value is the first stored value, set with plain names
+	Value *StoredValue
+}
+
+var errSampleFound = errors.New("sample found")
+
+// sealedSample returns a name or a value of the database sealed before the keyring,
+// nil if nothing is sealed. Emptied namespaces are pruned, so any bucket left holds secrets.
+func sealedSample(tx Tx, meta Bucket) (*SealedSample, error) {
+	if names := meta.Bucket(metaNamesBucketName); names != nil {
+		blind, sealed := names.Cursor().First()
+		if blind == nil {
+			return nil, nil
+		}
+		return &SealedSample{Blind: string(blind), Name: bytes.Clone(sealed)}, nil
+	}
+
+	records := tx.Bucket(recordsBucketName)
+	if records == nil {
+		return nil, nil
+	}
+
+	// names of a database without the name index are stored in plain
+	plain := func(stored []byte) (string, error) { return string(stored), nil }
+
+	var sample *SealedSample
+	err := walkValues(records, plain, func(v *StoredValue) error {
+		value := *v
+		value.Value, value.Chunk = bytes.Clone(v.Value), nil
+		sample = &SealedSample{Value: &value}
+		return errSampleFound
+	}, func(*models.VerifyIssue) {})
+	if err != nil && !errors.Is(err, errSampleFound) {
+		return nil, err
+	}
+	return sample, nil
+}
+
+// SealedSample returns a sample of data sealed before the keyring was created without
+// changing the database, nil if the database has the keyring or nothing is sealed.
+func (s *Storage) SealedSample() (*SealedSample, error) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	var sample *SealedSample
+	err := s.db.View(func(tx Tx) error {
+		meta := tx.Bucket(metaBucketName)
+		if meta == nil {
+			return ErrFailedToOpenTopBucket
+		}
+		if meta.Get(metaKeyringKey) != nil {
+			return nil
+		}
+
+		var err error
+		sample, err = sealedSample(tx, meta)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sample, nil
+}
+
+// UpdateKeyring replaces the wrapped keyring with the one returned by update
+// in one transaction, a nil result keeps the current keyring. Update gets
+// the current keyring, nil if there is none yet, and a sample of data sealed
+// before the keyring was created, nil if there is none.
+func (s *Storage) UpdateKeyring(update func(wrapped []byte, sample *SealedSample) ([]byte, error)) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	var wrapped, next []byte
+	err := s.db.Update(func(tx Tx) error {
+		meta := tx.Bucket(metaBucketName)
+		if meta == nil {
+			return ErrFailedToOpenTopBucket
+		}
+
+		wrapped = bytes.Clone(meta.Get(metaKeyringKey))
+
+		var sample *SealedSample
+		if wrapped == nil {
+			var err error
+			if sample, err = sealedSample(tx, meta); err != nil {
+				return err
+			}
+		}
+
+		var err error
+		next, err = update(wrapped, sample)
+		if err != nil || next == nil {
+			return err
+		}
+		return meta.Put(metaKeyringKey, next)
+	})
+	if err != nil {
+		return err
+	}
+
+	if next != nil {
+		s.cacheKeyring(next)
+	} else if wrapped != nil {
+		s.cacheKeyring(wrapped)
+	}
+	return nil
+}
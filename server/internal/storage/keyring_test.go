@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/liriquew/secret_storage/server/internal/lib/config"
+	"github.com/liriquew/secret_storage/server/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyring(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, cfg config.StorageConfig) {
+		s := newTestStorage(t, cfg)
+		assert.Nil(t, s.Keyring())
+
+		update := func(next []byte) (wrapped []byte, sample *SealedSample) {
+			err := s.UpdateKeyring(func(current []byte, sealed *SealedSample) ([]byte, error) {
+				wrapped, sample = current, sealed
+				return next, nil
+			})
+			require.NoError(t, err)
+			return wrapped, sample
+		}
+
+		t.Run("Sample", func(t *testing.T) {
+			_, sample := update(nil)
+			assert.Nil(t, sample)
+
+			_, err := s.SetRecord([]string{"alice"}, "key", []byte("value"), models.WriteOptions{})
+			require.NoError(t, err)
+			_, sample = update(nil)
+			require.NotNil(t, sample)
+			require.NotNil(t, sample.Value)
+			assert.Equal(t, []string{"alice", "key"}, sample.Value.Location)
+			assert.Equal(t, []byte("value"), sample.Value.Value)
+
+			require.NoError(t, s.BlindNames(testNameCodec{}))
+			_, sample = update(nil)
+			require.NotNil(t, sample)
+			assert.Nil(t, sample.Value)
+			name, err := testNameCodec{}.Open(sample.Blind, sample.Name)
+			require.NoError(t, err)
+			assert.Contains(t, []string{"alice", "key"}, name)
+			assert.Nil(t, s.Keyring())
+		})
+
+		t.Run("Create", func(t *testing.T) {
+			wrapped, _ := update([]byte("first"))
+			assert.Nil(t, wrapped)
+			assert.Equal(t, []byte("first"), s.Keyring())
+		})
+
+		t.Run("Update", func(t *testing.T) {
+			wrapped, sample := update(nil)
+			assert.Equal(t, []byte("first"), wrapped)
+			// data is sealed with the keyring once it exists
+			assert.Nil(t, sample)
+			assert.Equal(t, []byte("first"), s.Keyring())
+
+			update([]byte("second"))
+			assert.Equal(t, []byte("second"), s.Keyring())
+		})
+
+		t.Run("Failed Update", func(t *testing.T) {
+			errFailed := errors.New("failed")
+			err := s.UpdateKeyring(func([]byte, *SealedSample) ([]byte, error) { return nil, errFailed })
+			assert.ErrorIs(t, err, errFailed)
+			assert.Equal(t, []byte("second"), s.Keyring())
+		})
+
+		if cfg.Type == "memory" {
+			return
+		}
+		t.Run("Reopen", func(t *testing.T) {
+			require.NoError(t, s.Close())
+
+			reopened := newTestStorage(t, cfg)
+			assert.Equal(t, []byte("second"), reopened.Keyring())
+		})
+	})
+}
+
+func TestRaftKeyring(t *testing.T) {
+	nodes := newTestCluster(t, "memory", 3)
+	leader, _ := waitForLeader(t, nodes)
+
+	err := leader.UpdateKeyring(func([]byte, *SealedSample) ([]byte, error) { return []byte("wrapped"), nil })
+	require.NoError(t, err)
+
+	// followers learn the keyring from the log without reading their databases
+	for _, node := range nodes {
+		assert.Eventually(t, func() bool {
+			return string(node.Keyring()) == "wrapped"
+		}, electionTimeout, 50*time.Millisecond)
+	}
+}
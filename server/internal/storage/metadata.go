@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/liriquew/secret_storage/server/internal/models"
+)
+
+func (s *Storage) GetMetadata(path []string, key string) (*models.SecretMetadata, error) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	path, key = s.names.blindLocation(path, key)
+
+	var metadata *models.SecretMetadata
+	err := s.db.View(func(tx Tx) error {
+		b := tx.Bucket(recordsBucketName)
+		if b == nil {
+			return ErrFailedToOpenTopBucket
+		}
+
+		b, err := openBucketByPath(path, b)
+		if err != nil {
+			return err
+		}
+
+		secret, err := openSecret(b, key, false)
+		if err != nil {
+			return err
+		}
+		if secret == nil {
+			// legacy records have no metadata
+			metadata = &models.SecretMetadata{CurrentVersion: 1}
+			return nil
+		}
+
+		header, err := readHeader(secret)
+		if err != nil {
+			return err
+		}
+		if header.gone(time.Now()) {
+			return ErrRecordNotFound
+		}
+
+		metadata = header.metadata()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return metadata, nil
+}
+
+// SetLabels replaces labels of the record, the value and its versions stay untouched.
+func (s *Storage) SetLabels(path []string, key string, labels map[string]string) (*models.SecretMetadata, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	path, key = s.names.blindLocation(path, key)
+
+	var metadata *models.SecretMetadata
+	err := s.db.Update(func(tx Tx) error {
+		b := tx.Bucket(recordsBucketName)
+		if b == nil {
+			return ErrFailedToOpenTopBucket
+		}
+
+		b, err := openBucketByPath(path, b)
+		if err != nil {
+			return err
+		}
+
+		secret, err := openSecret(b, key, false)
+		if err != nil {
+			return err
+		}
+		if secret == nil {
+			if secret, err = openSecret(b, key, true); err != nil {
+				return err
+			}
+		}
+
+		header, err := readHeader(secret)
+		if err != nil {
+			return err
+		}
+		if header.gone(time.Now()) {
+			return ErrRecordNotFound
+		}
+
+		header.Labels = labels
+		if err := writeHeader(secret, header); err != nil {
+			return err
+		}
+
+		metadata = header.metadata()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return metadata, nil
+}
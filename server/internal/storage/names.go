@@ -0,0 +1,476 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// With blind names every user, bucket and secret name is stored as its blind
+// index and the real names are kept sealed in the meta bucket:
+//
+//	meta/names/<blind index> - sealed name
+//	meta/name_refs/<blind index> - number of entries stored under the index
+//
+// The names bucket marks the database as migrated to blind names, the refs bucket
+// marks blind indexes mixed with the parent location. A blind index depends on
+// the place of the name, so moved and copied entries are stored under new ones.
+var (
+	metaNamesBucketName    = []byte("names")
+	metaNameRefsBucketName = []byte("name_refs")
+)
+
+var (
+	ErrUnknownName    = errors.New("name isn't in the name index")
+	ErrNamesNotBlind  = errors.New("names are stored in plain")
+	ErrBlindNameClash = errors.New("blind index clashes with an existing name")
+)
+
+// NameCodec turns names into blind indexes and seals the real names.
+// Blind must be deterministic and must never return an empty string,
+// scope is the real location of the parent of the name.
+type NameCodec interface {
+	Blind(scope []string, name string) string
+	Seal(blind string, name string) ([]byte, error)
+	Open(blind string, sealed []byte) (string, error)
+}
+
+// nameIndex translates names given to the storage into stored ones and back,
+// a nil index keeps names as they are.
+type nameIndex struct {
+	codec NameCodec
+}
+
+func (n *nameIndex) blind(scope []string, name string) string {
+	if n == nil || name == "" {
+		// empty names stay empty to be rejected as they are
+		return name
+	}
+	return n.codec.Blind(scope, name)
+}
+
+func (n *nameIndex) blindPath(path []string) []string {
+	if n == nil {
+		return path
+	}
+
+	blinded := make([]string, len(path))
+	for i, pathPart := range path {
+		blinded[i] = n.blind(path[:i], pathPart)
+	}
+	return blinded
+}
+
+// blindLocation returns the stored path and the stored key of the record key at path.
+func (n *nameIndex) blindLocation(path []string, key string) ([]string, string) {
+	return n.blindPath(path), n.blind(path, key)
+}
+
+// register keeps the sealed names of the location to show them in listings,
+// names are registered in the transaction which stores them.
+func (n *nameIndex) register(tx Tx, location ...string) error {
+	if n == nil {
+		return nil
+	}
+
+	index, err := namesBucket(tx)
+	if err != nil {
+		return err
+	}
+
+	for i, name := range location {
+		if name == "" {
+			continue
+		}
+		if err := sealName(index, n.codec, n.codec.Blind(location[:i], name), name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sealName(index Bucket, codec NameCodec, blind string, name string) error {
+	if index.Get([]byte(blind)) != nil {
+		return nil
+	}
+
+	sealed, err := codec.Seal(blind, name)
+	if err != nil {
+		return err
+	}
+	return index.Put([]byte(blind), sealed)
+}
+
+// nameRefs counts entries stored and removed by a transaction under blind indexes,
+// a nil tracker counts nothing.
+type nameRefs struct {
+	delta map[string]int
+}
+
+func (s *Storage) newNameRefs() *nameRefs {
+	if s.names == nil {
+		return nil
+	}
+	return &nameRefs{delta: make(map[string]int)}
+}
+
+// create counts the entry key of b unless it's already stored,
+// it's called right before the entry is created.
+func (r *nameRefs) create(b Bucket, key []byte) {
+	if r == nil || b.Get(key) != nil || b.Bucket(key) != nil {
+		return
+	}
+	r.delta[string(key)]++
+}
+
+// drop uncounts a removed entry, stored is its stored name.
+func (r *nameRefs) drop(stored []byte) {
+	if r == nil {
+		return
+	}
+	r.delta[string(stored)]--
+}
+
+// remove uncounts the entry key of b together with everything inside a path bucket,
+// it's called right before the entry is removed.
+func (r *nameRefs) remove(b Bucket, key []byte) error {
+	if r == nil {
+		return nil
+	}
+
+	r.drop(key)
+	nested := b.Bucket(key)
+	if nested == nil || isSecretBucket(nested) {
+		return nil
+	}
+	return nested.ForEach(func(k, _ []byte) error {
+		return r.remove(nested, k)
+	})
+}
+
+// commit updates the counters of the transaction. Sealed names nothing is stored
+// under any more are removed, so names of removed users, buckets and secrets
+// can't be recovered from the index.
+func (r *nameRefs) commit(tx Tx) error {
+	if r == nil {
+		return nil
+	}
+
+	index, err := namesBucket(tx)
+	if err != nil {
+		return err
+	}
+	refs, err := nameRefsBucket(tx)
+	if err != nil {
+		return err
+	}
+
+	for blind, delta := range r.delta {
+		if delta == 0 {
+			continue
+		}
+
+		k := []byte(blind)
+		var count int64
+		if raw := refs.Get(k); len(raw) == 8 {
+			count = int64(binary.BigEndian.Uint64(raw))
+		}
+		count += int64(delta)
+
+		if count > 0 {
+			if err := refs.Put(k, binary.BigEndian.AppendUint64(nil, uint64(count))); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := refs.Delete(k); err != nil {
+			return err
+		}
+		if err := index.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// opener returns a function translating stored names of the transaction into real ones.
+func (n *nameIndex) opener(tx Tx) func(stored []byte) (string, error) {
+	if n == nil {
+		return func(stored []byte) (string, error) {
+			return string(stored), nil
+		}
+	}
+
+	index, err := namesBucket(tx)
+	return func(stored []byte) (string, error) {
+		if err != nil {
+			return "", err
+		}
+
+		sealed := index.Get(stored)
+		if sealed == nil {
+			return "", fmt.Errorf("%w: %s", ErrUnknownName, stored)
+		}
+		return n.codec.Open(string(stored), sealed)
+	}
+}
+
+func namesBucket(tx Tx) (Bucket, error) {
+	meta := tx.Bucket(metaBucketName)
+	if meta == nil {
+		return nil, ErrFailedToOpenTopBucket
+	}
+
+	index := meta.Bucket(metaNamesBucketName)
+	if index == nil {
+		return nil, ErrNamesNotBlind
+	}
+	return index, nil
+}
+
+func nameRefsBucket(tx Tx) (Bucket, error) {
+	meta := tx.Bucket(metaBucketName)
+	if meta == nil {
+		return nil, ErrFailedToOpenTopBucket
+	}
+
+	refs := meta.Bucket(metaNameRefsBucketName)
+	if refs == nil {
+		return nil, ErrNamesNotBlind
+	}
+	return refs, nil
+}
+
+// WalkSealedNames passes every sealed name of the index to visit,
+// a database with plain names has none.
+func (s *Storage) WalkSealedNames(visit func(blind string, sealed []byte) error) error {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	return s.db.View(func(tx Tx) error {
+		index, err := namesBucket(tx)
+		if errors.Is(err, ErrNamesNotBlind) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return index.ForEach(func(blind, sealed []byte) error {
+			return visit(string(blind), sealed)
+		})
+	})
+}
+
+// NamesBlinded reports whether the database stores blind names.
+func (s *Storage) NamesBlinded() (bool, error) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	blinded, _, err := s.namesFormat()
+	return blinded, err
+}
+
+// namesFormat reports whether the database stores blind names
+// and whether they are mixed with the parent location.
+func (s *Storage) namesFormat() (blinded bool, scoped bool, err error) {
+	err = s.db.View(func(tx Tx) error {
+		meta := tx.Bucket(metaBucketName)
+		if meta == nil {
+			return ErrFailedToOpenTopBucket
+		}
+
+		blinded = meta.Bucket(metaNamesBucketName) != nil
+		scoped = meta.Bucket(metaNameRefsBucketName) != nil
+		return nil
+	})
+	return blinded, scoped, err
+}
+
+// BlindNames switches the storage to blind names. A database with plain names
+// is migrated in one transaction: every name is replaced with its blind index.
+// A database blinded before indexes were mixed with the parent location
+// is migrated to them the same way.
+func (s *Storage) BlindNames(codec NameCodec) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	blinded, scoped, err := s.namesFormat()
+	if err != nil {
+		return err
+	}
+	switch {
+	case !blinded:
+		err = s.db.Update(func(tx Tx) error { return migrateToBlindNames(tx, codec) })
+	case !scoped:
+		err = s.db.Update(func(tx Tx) error { return migrateToScopedNames(tx, codec) })
+	}
+	if err != nil {
+		return err
+	}
+
+	s.names = &nameIndex{codec: codec}
+	s.quotas = s.quotas.blinded(func(username string) string {
+		return codec.Blind(nil, username)
+	})
+	return nil
+}
+
+func migrateToBlindNames(tx Tx, codec NameCodec) error {
+	meta := tx.Bucket(metaBucketName)
+	if meta == nil {
+		return ErrFailedToOpenTopBucket
+	}
+
+	index, err := meta.CreateBucket(metaNamesBucketName)
+	if err != nil {
+		return err
+	}
+
+	m := &nameMigration{
+		index: index,
+		codec: codec,
+		name: func(stored []byte) (string, error) {
+			return string(stored), nil
+		},
+	}
+	return m.run(tx)
+}
+
+// migrateToScopedNames replaces blind indexes which don't depend on the place
+// of the name, real names are opened with the sealed names of the old indexes.
+func migrateToScopedNames(tx Tx, codec NameCodec) error {
+	index, err := namesBucket(tx)
+	if err != nil {
+		return err
+	}
+
+	var old [][]byte
+	err = index.ForEach(func(k, _ []byte) error {
+		old = append(old, append([]byte(nil), k...))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	m := &nameMigration{
+		index: index,
+		codec: codec,
+		name: func(stored []byte) (string, error) {
+			sealed := index.Get(stored)
+			if sealed == nil {
+				return "", fmt.Errorf("%w: %s", ErrUnknownName, stored)
+			}
+			return codec.Open(string(stored), sealed)
+		},
+	}
+	if err := m.run(tx); err != nil {
+		return err
+	}
+
+	// old names are removed once both the records and the user buckets are renamed,
+	// names of users are stored in both of them
+	for _, k := range old {
+		if _, ok := m.refs.delta[string(k)]; ok {
+			continue
+		}
+		if err := index.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nameMigration renames every entry of the database to its blind index
+// and counts the entries stored under every index.
+type nameMigration struct {
+	index Bucket
+	refs  *nameRefs
+	codec NameCodec
+	// name returns the real name of a stored one
+	name func(stored []byte) (string, error)
+}
+
+func (m *nameMigration) run(tx Tx) error {
+	meta := tx.Bucket(metaBucketName)
+	if meta == nil {
+		return ErrFailedToOpenTopBucket
+	}
+	if _, err := meta.CreateBucket(metaNameRefsBucketName); err != nil {
+		return err
+	}
+
+	// names of users in the records and the user buckets are the same,
+	// so both of them are found in the index
+	m.refs = &nameRefs{delta: make(map[string]int)}
+	for _, bucketName := range [][]byte{recordsBucketName, userBucketName} {
+		if err := m.blindBucket(tx.Bucket(bucketName), nil); err != nil {
+			return err
+		}
+	}
+	if err := m.refs.commit(tx); err != nil {
+		return err
+	}
+
+	// counters are keyed by the names of users
+	if meta.Bucket(metaUsageBucketName) != nil {
+		if err := meta.DeleteBucket(metaUsageBucketName); err != nil {
+			return err
+		}
+	}
+	return recountUsage(tx)
+}
+
+// blindBucket renames entries of the path bucket b, scope is its real location.
+// Path buckets are renamed recursively and secrets are moved as they are.
+func (m *nameMigration) blindBucket(b Bucket, scope []string) error {
+	if b == nil {
+		return ErrFailedToOpenTopBucket
+	}
+
+	var keys [][]byte
+	err := b.ForEach(func(k, _ []byte) error {
+		keys = append(keys, append([]byte(nil), k...))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		name, err := m.name(k)
+		if err != nil {
+			return err
+		}
+
+		blind := m.codec.Blind(scope, name)
+		if err := sealName(m.index, m.codec, blind, name); err != nil {
+			return err
+		}
+		m.refs.delta[blind]++
+
+		renamed := blind != string(k)
+		if renamed && (b.Get([]byte(blind)) != nil || b.Bucket([]byte(blind)) != nil) {
+			return fmt.Errorf("%w: %s", ErrBlindNameClash, name)
+		}
+
+		value := b.Get(k)
+		if nested := b.Bucket(k); nested != nil && !isSecretBucket(nested) {
+			if err := m.blindBucket(nested, append(scope[:len(scope):len(scope)], name)); err != nil {
+				return err
+			}
+		}
+		if !renamed {
+			continue
+		}
+
+		if err := copyEntry(b, []byte(blind), b, k, value); err != nil {
+			return err
+		}
+		if err := deleteEntry(b, k); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,282 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/liriquew/secret_storage/server/internal/lib/config"
+	"github.com/liriquew/secret_storage/server/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testNameCodec hashes names with their scopes and "seals" them by reversing their bytes.
+type testNameCodec struct{}
+
+func (testNameCodec) Blind(scope []string, name string) string {
+	sum := sha256.Sum256([]byte(strings.Join(append(scope[:len(scope):len(scope)], name), "\x00")))
+	return "b" + hex.EncodeToString(sum[:8])
+}
+
+func (testNameCodec) Seal(blind string, name string) ([]byte, error) {
+	sealed := []byte(name)
+	for i, j := 0, len(sealed)-1; i < j; i, j = i+1, j-1 {
+		sealed[i], sealed[j] = sealed[j], sealed[i]
+	}
+	return append([]byte(blind+":"), sealed...), nil
+}
+
+func (c testNameCodec) Open(blind string, sealed []byte) (string, error) {
+	reversed, ok := bytes.CutPrefix(sealed, []byte(blind+":"))
+	if !ok {
+		return "", errors.New("name is sealed for another index")
+	}
+	name, _ := c.Seal("", string(reversed))
+	return string(name[1:]), nil
+}
+
+func TestBlindNames(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, cfg config.StorageConfig) {
+		cfg.UserQuotas = map[string]config.QuotaConfig{"alice": {MaxSecrets: 3}}
+		s := newTestStorage(t, cfg)
+
+		path := []string{"alice", "app", "prod"}
+		_, err := s.SetRecord(path, "password", []byte("qwerty"), models.WriteOptions{})
+		require.NoError(t, err)
+		_, err = s.SetRecord([]string{"alice", "app"}, "token", []byte("abc"), models.WriteOptions{})
+		require.NoError(t, err)
+		require.NoError(t, s.Set(nil, "alice", []byte("hash"), userBucketName))
+
+		blinded, err := s.NamesBlinded()
+		require.NoError(t, err)
+		require.False(t, blinded)
+
+		require.NoError(t, s.BlindNames(testNameCodec{}))
+		blinded, err = s.NamesBlinded()
+		require.NoError(t, err)
+		require.True(t, blinded)
+
+		indexed := func(location ...string) bool {
+			return nameIndexed(t, s, location[:len(location)-1], location[len(location)-1])
+		}
+
+		t.Run("Stored Names", func(t *testing.T) {
+			plain := map[string]bool{"alice": true, "app": true, "prod": true, "password": true, "token": true}
+
+			var walk func(b Bucket)
+			walk = func(b Bucket) {
+				b.ForEach(func(k, v []byte) error {
+					assert.False(t, plain[string(k)], "plain name %s is stored", k)
+					if nested := b.Bucket(k); v == nil && nested != nil && !isSecretBucket(nested) {
+						walk(nested)
+					}
+					return nil
+				})
+			}
+			require.NoError(t, s.db.View(func(tx Tx) error {
+				walk(tx.Bucket(recordsBucketName))
+				walk(tx.Bucket(userBucketName))
+				return nil
+			}))
+		})
+
+		t.Run("Read", func(t *testing.T) {
+			record, err := s.GetRecord(path, "password", 0)
+			require.NoError(t, err)
+			assert.Equal(t, "password", string(record.Key))
+			assert.Equal(t, "qwerty", string(record.Value))
+
+			hash, err := s.Get(nil, "alice", userBucketName)
+			require.NoError(t, err)
+			assert.Equal(t, "hash", string(hash))
+		})
+
+		t.Run("List", func(t *testing.T) {
+			info, err := s.ListRecords([]string{"alice", "app"}, models.PageOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, []string{"prod"}, info.Buckets)
+			require.Len(t, info.Records, 1)
+			assert.Equal(t, "token", string(info.Records[0].Key))
+
+			full, err := s.ListRecordsRecursively([]string{"alice"})
+			require.NoError(t, err)
+			require.Len(t, full.Buckets, 1)
+			assert.Equal(t, "app", full.Buckets[0].Name)
+			require.Len(t, full.Buckets[0].Buckets, 1)
+			assert.Equal(t, "password", string(full.Buckets[0].Buckets[0].Records[0].Key))
+		})
+
+		t.Run("Search", func(t *testing.T) {
+			matches, err := s.Search([]string{"alice"}, &models.SearchQuery{Pattern: "**/pass*"})
+			require.NoError(t, err)
+			require.Len(t, matches, 1)
+			assert.Equal(t, "app/prod/password", matches[0].Path)
+		})
+
+		t.Run("New Names", func(t *testing.T) {
+			_, err := s.SetRecord([]string{"alice", "app", "stage"}, "password", []byte("123"), models.WriteOptions{})
+			require.NoError(t, err)
+
+			info, err := s.ListRecords([]string{"alice", "app"}, models.PageOptions{})
+			require.NoError(t, err)
+			assert.ElementsMatch(t, []string{"prod", "stage"}, info.Buckets)
+		})
+
+		t.Run("Relocate", func(t *testing.T) {
+			_, err := s.Relocate(&models.Relocation{From: []string{"alice", "app", "prod"}, To: []string{"alice", "app", "stage"}}, nil)
+			require.ErrorIs(t, err, ErrRelocationConflict)
+			assert.Contains(t, err.Error(), "password")
+
+			_, err = s.Relocate(&models.Relocation{From: []string{"alice", "app"}, Key: "token", NewKey: "token", To: []string{"alice", "app", "stage"}, Move: true}, nil)
+			require.NoError(t, err)
+
+			record, err := s.GetRecord([]string{"alice", "app", "stage"}, "token", 0)
+			require.NoError(t, err)
+			assert.Equal(t, "abc", string(record.Value))
+			assert.True(t, indexed("alice", "app", "stage", "token"))
+			assert.False(t, indexed("alice", "app", "token"))
+		})
+
+		t.Run("Change Events", func(t *testing.T) {
+			var events []*models.ChangeEvent
+			s.OnChange(func(committed []*models.ChangeEvent) { events = append(events, committed...) })
+			defer s.OnChange(nil)
+
+			_, err := s.SoftDelete([]string{"alice", "app", "stage"}, "password")
+			require.NoError(t, err)
+
+			require.Len(t, events, 1)
+			assert.Equal(t, "alice", events[0].Username)
+			assert.Equal(t, "app/stage", events[0].Path)
+			assert.Equal(t, "password", events[0].Key)
+			require.NoError(t, s.Undelete([]string{"alice", "app", "stage"}, "password"))
+		})
+
+		t.Run("Delete Bucket Dry Run", func(t *testing.T) {
+			report, err := s.DeleteBucket([]string{"alice", "app", "stage"}, true, true)
+			require.NoError(t, err)
+			assert.ElementsMatch(t, []string{"app/stage/password", "app/stage/token"}, report.Secrets)
+			assert.Equal(t, []string{"app/stage"}, report.Buckets)
+		})
+
+		t.Run("Quota", func(t *testing.T) {
+			status, err := s.Usage("alice")
+			require.NoError(t, err)
+			assert.Equal(t, int64(3), status.Quota.MaxSecrets)
+			assert.Equal(t, int64(3), status.Usage.Secrets)
+
+			_, err = s.SetRecord(path, "another", []byte("v"), models.WriteOptions{})
+			assert.ErrorIs(t, err, ErrQuotaExceeded)
+		})
+
+		t.Run("Prune Names", func(t *testing.T) {
+			_, err := s.DeleteBucket([]string{"alice", "app", "stage"}, true, false)
+			require.NoError(t, err)
+			assert.False(t, indexed("alice", "app", "stage"))
+			assert.False(t, indexed("alice", "app", "stage", "token"))
+			assert.False(t, indexed("alice", "app", "stage", "password"))
+			assert.True(t, indexed("alice", "app", "prod", "password"))
+
+			_, err = s.Delete(path, "password", recordsBucketName)
+			require.NoError(t, err)
+			assert.False(t, indexed("alice", "app", "prod", "password"))
+			assert.False(t, indexed("alice", "app", "prod"))
+			assert.False(t, indexed("alice", "app"))
+			// the user is still stored in the user bucket
+			assert.True(t, indexed("alice"))
+			assert.Equal(t, 1, nameRefCount(t, s, nil, "alice"))
+		})
+	})
+}
+
+func TestScopedNamesMigration(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, cfg config.StorageConfig) {
+		s := newTestStorage(t, cfg)
+
+		for _, path := range [][]string{{"alice", "app"}, {"bob", "app"}} {
+			_, err := s.SetRecord(path, "password", []byte("qwerty"), models.WriteOptions{})
+			require.NoError(t, err)
+			require.NoError(t, s.Set(nil, path[0], []byte("hash"), userBucketName))
+		}
+
+		// indexes of the old format don't depend on the place of the name
+		require.NoError(t, s.BlindNames(unscopedNameCodec{}))
+		require.NoError(t, s.db.Update(func(tx Tx) error {
+			return tx.Bucket(metaBucketName).DeleteBucket(metaNameRefsBucketName)
+		}))
+		require.Equal(t, 4, indexSize(t, s))
+
+		require.NoError(t, s.BlindNames(testNameCodec{}))
+
+		for _, username := range []string{"alice", "bob"} {
+			record, err := s.GetRecord([]string{username, "app"}, "password", 0)
+			require.NoError(t, err)
+			assert.Equal(t, "qwerty", string(record.Value))
+
+			hash, err := s.Get(nil, username, userBucketName)
+			require.NoError(t, err)
+			assert.Equal(t, "hash", string(hash))
+
+			assert.True(t, nameIndexed(t, s, []string{username, "app"}, "password"))
+			assert.Equal(t, 1, nameRefCount(t, s, []string{username, "app"}, "password"))
+			assert.Equal(t, 2, nameRefCount(t, s, nil, username))
+		}
+		// every name is indexed in its place, old indexes are removed
+		assert.Equal(t, 6, indexSize(t, s))
+
+		_, err := s.Delete([]string{"alice", "app"}, "password", recordsBucketName)
+		require.NoError(t, err)
+		assert.False(t, nameIndexed(t, s, []string{"alice", "app"}, "password"))
+		assert.True(t, nameIndexed(t, s, []string{"bob", "app"}, "password"))
+		assert.Equal(t, 1, nameRefCount(t, s, nil, "alice"))
+	})
+}
+
+// unscopedNameCodec makes blind indexes the way they were made before they were mixed
+// with the parent location.
+type unscopedNameCodec struct {
+	testNameCodec
+}
+
+func (c unscopedNameCodec) Blind(_ []string, name string) string {
+	return c.testNameCodec.Blind(nil, name)
+}
+
+func nameIndexed(t *testing.T, s *Storage, scope []string, name string) bool {
+	var found bool
+	require.NoError(t, s.db.View(func(tx Tx) error {
+		index, err := namesBucket(tx)
+		found = err == nil && index.Get([]byte(testNameCodec{}.Blind(scope, name))) != nil
+		return err
+	}))
+	return found
+}
+
+func nameRefCount(t *testing.T, s *Storage, scope []string, name string) int {
+	var count int
+	require.NoError(t, s.db.View(func(tx Tx) error {
+		refs, err := nameRefsBucket(tx)
+		if err != nil {
+			return err
+		}
+		if raw := refs.Get([]byte(testNameCodec{}.Blind(scope, name))); raw != nil {
+			count = int(binary.BigEndian.Uint64(raw))
+		}
+		return nil
+	}))
+	return count
+}
+
+func indexSize(t *testing.T, s *Storage) int {
+	var size int
+	require.NoError(t, s.WalkSealedNames(func(string, []byte) error {
+		size++
+		return nil
+	}))
+	return size
+}
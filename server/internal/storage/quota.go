@@ -0,0 +1,353 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/liriquew/secret_storage/server/internal/lib/config"
+	"github.com/liriquew/secret_storage/server/internal/models"
+)
+
+// Usage counters of every user are kept in the meta bucket:
+//
+//	meta/usage/<username> - models.Usage (json)
+//
+// They are changed in the same transaction as the secrets they count.
+var metaUsageBucketName = []byte("usage")
+
+var (
+	ErrQuotaExceeded = errors.New("quota exceeded")
+	ErrValueTooLarge = errors.New("value is too large")
+)
+
+type quotas struct {
+	fallback models.Quota
+	users    map[string]models.Quota
+}
+
+func newQuotas(cfg config.StorageConfig) *quotas {
+	q := &quotas{
+		fallback: models.Quota(cfg.Quota),
+		users:    map[string]models.Quota{},
+	}
+
+	inherit := func(limit, fallback int64) int64 {
+		switch {
+		case limit < 0:
+			return 0
+		case limit == 0:
+			return fallback
+		}
+		return limit
+	}
+	for username, quota := range cfg.UserQuotas {
+		q.users[username] = models.Quota{
+			MaxSecrets:   inherit(quota.MaxSecrets, q.fallback.MaxSecrets),
+			MaxBytes:     inherit(quota.MaxBytes, q.fallback.MaxBytes),
+			MaxValueSize: inherit(quota.MaxValueSize, q.fallback.MaxValueSize),
+		}
+	}
+
+	return q
+}
+
+// blinded returns the quotas with names of users turned into their blind indexes.
+func (q *quotas) blinded(blind func(string) string) *quotas {
+	blinded := &quotas{
+		fallback: q.fallback,
+		users:    make(map[string]models.Quota, len(q.users)),
+	}
+	for username, quota := range q.users {
+		blinded.users[blind(username)] = quota
+	}
+	return blinded
+}
+
+func (q *quotas) of(username string) models.Quota {
+	if quota, ok := q.users[username]; ok {
+		return quota
+	}
+	return q.fallback
+}
+
+// usageTracker collects changes of usage made by a transaction,
+// a nil tracker tracks nothing.
+type usageTracker struct {
+	quotas *quotas
+	deltas map[string]models.Usage
+}
+
+func (s *Storage) newUsageTracker() *usageTracker {
+	return &usageTracker{
+		quotas: s.quotas,
+		deltas: map[string]models.Usage{},
+	}
+}
+
+// checkValue fails if the value is over the size limit of the namespace of path.
+func (u *usageTracker) checkValue(path []string, value []byte) error {
+	return u.checkSize(path, int64(len(value)))
+}
+
+// checkSize is checkValue for values of the given size, files are checked as a whole.
+func (u *usageTracker) checkSize(path []string, size int64) error {
+	if u == nil || len(path) == 0 {
+		return nil
+	}
+
+	limit := u.quotas.of(path[0]).MaxValueSize
+	if limit > 0 && size > limit {
+		return fmt.Errorf("%w: size - %d, limit - %d", ErrValueTooLarge, size, limit)
+	}
+	return nil
+}
+
+// track records the change of usage of an entry in the namespace of path.
+func (u *usageTracker) track(path []string, before, after models.Usage) {
+	if u == nil || len(path) == 0 {
+		return
+	}
+	u.deltas[path[0]] = u.deltas[path[0]].Add(after.Sub(before))
+}
+
+// commit applies the collected changes to the counters. Namespaces which usage
+// grows beyond their quota fail the transaction, shrinking is always allowed.
+func (u *usageTracker) commit(tx Tx) error {
+	if u == nil || len(u.deltas) == 0 {
+		return nil
+	}
+
+	meta := tx.Bucket(metaBucketName)
+	if meta == nil {
+		return ErrFailedToOpenTopBucket
+	}
+	counters, err := meta.CreateBucketIfNotExists(metaUsageBucketName)
+	if err != nil {
+		return err
+	}
+
+	for username, delta := range u.deltas {
+		if delta == (models.Usage{}) {
+			continue
+		}
+
+		usage, err := readUsage(counters, username)
+		if err != nil {
+			return err
+		}
+		usage = usage.Add(delta)
+
+		quota := u.quotas.of(username)
+		if delta.Secrets > 0 && quota.MaxSecrets > 0 && usage.Secrets > quota.MaxSecrets {
+			return fmt.Errorf("%w: secrets - %d, limit - %d", ErrQuotaExceeded, usage.Secrets, quota.MaxSecrets)
+		}
+		if delta.Bytes > 0 && quota.MaxBytes > 0 && usage.Bytes > quota.MaxBytes {
+			return fmt.Errorf("%w: bytes - %d, limit - %d", ErrQuotaExceeded, usage.Bytes, quota.MaxBytes)
+		}
+
+		if err := writeUsage(counters, username, usage); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func readUsage(counters Bucket, username string) (models.Usage, error) {
+	var usage models.Usage
+
+	raw := counters.Get([]byte(username))
+	if raw == nil {
+		return usage, nil
+	}
+	if err := json.Unmarshal(raw, &usage); err != nil {
+		return usage, fmt.Errorf("error while decoding usage: %w", err)
+	}
+	return usage, nil
+}
+
+func writeUsage(counters Bucket, username string, usage models.Usage) error {
+	if usage == (models.Usage{}) {
+		if counters.Get([]byte(username)) == nil {
+			return nil
+		}
+		return counters.Delete([]byte(username))
+	}
+
+	buf, err := json.Marshal(usage)
+	if err != nil {
+		return err
+	}
+	return counters.Put([]byte(username), buf)
+}
+
+// entryUsage measures the secret, the legacy value or the path bucket stored under key in b.
+func entryUsage(b Bucket, key []byte) (models.Usage, error) {
+	if value := b.Get(key); value != nil {
+		return models.Usage{Secrets: 1, Bytes: int64(len(value))}, nil
+	}
+
+	nested := b.Bucket(key)
+	if nested == nil {
+		return models.Usage{}, nil
+	}
+	if isSecretBucket(nested) {
+		return secretUsage(nested)
+	}
+
+	return bucketUsage(nested)
+}
+
+// secretUsage measures the secret with all of its versions, a deleted secret
+// takes nothing until it is restored.
+func secretUsage(secret Bucket) (models.Usage, error) {
+	header, err := readHeader(secret)
+	if err != nil {
+		return models.Usage{}, err
+	}
+	if header.DeletedAt != nil {
+		return models.Usage{}, nil
+	}
+
+	usage := models.Usage{Secrets: 1}
+
+	versions := secret.Bucket(secretVersionsBucketName)
+	if versions == nil {
+		return usage, nil
+	}
+
+	err = versions.ForEach(func(k, v []byte) error {
+		entry := &versionEntry{}
+		if err := json.Unmarshal(v, entry); err != nil {
+			return fmt.Errorf("error while decoding version: %w", err)
+		}
+		usage.Bytes += int64(len(entry.Value))
+		return nil
+	})
+	if err != nil {
+		return usage, err
+	}
+
+	allChunks := secret.Bucket(secretChunksBucketName)
+	if allChunks == nil {
+		return usage, nil
+	}
+	err = allChunks.ForEach(func(version, _ []byte) error {
+		return allChunks.Bucket(version).ForEach(func(_, chunk []byte) error {
+			usage.Bytes += int64(len(chunk))
+			return nil
+		})
+	})
+	return usage, err
+}
+
+// bucketUsage measures everything stored in the path bucket b.
+func bucketUsage(b Bucket) (models.Usage, error) {
+	var usage models.Usage
+
+	c := b.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		entry, err := entryUsage(b, k)
+		if err != nil {
+			return usage, err
+		}
+		usage = usage.Add(entry)
+	}
+
+	return usage, nil
+}
+
+// pathUsage measures the entry at path, or the path bucket itself if key is empty.
+// Missing entries take nothing.
+func pathUsage(top Bucket, path []string, key string) (models.Usage, error) {
+	b := top
+	for _, pathPart := range path {
+		b = b.Bucket([]byte(pathPart))
+		if b == nil || isSecretBucket(b) {
+			return models.Usage{}, nil
+		}
+	}
+
+	if key == "" {
+		return bucketUsage(b)
+	}
+	return entryUsage(b, []byte(key))
+}
+
+// recountUsage rebuilds counters of all users from the stored secrets,
+// it is done once for databases created before quotas.
+func recountUsage(tx Tx) error {
+	meta := tx.Bucket(metaBucketName)
+	if meta == nil || meta.Bucket(metaUsageBucketName) != nil {
+		return nil
+	}
+
+	counters, err := meta.CreateBucket(metaUsageBucketName)
+	if err != nil {
+		return err
+	}
+
+	top := tx.Bucket(recordsBucketName)
+	c := top.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if v != nil {
+			continue
+		}
+
+		usage, err := bucketUsage(top.Bucket(k))
+		if err != nil {
+			return err
+		}
+		if err := writeUsage(counters, string(k), usage); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// recountLiveUsage rebuilds counters which counted deleted secrets,
+// they take nothing since version 4.
+func recountLiveUsage(tx Tx) error {
+	meta := tx.Bucket(metaBucketName)
+	if meta == nil {
+		return nil
+	}
+	if meta.Bucket(metaUsageBucketName) != nil {
+		if err := meta.DeleteBucket(metaUsageBucketName); err != nil {
+			return err
+		}
+	}
+	return recountUsage(tx)
+}
+
+// Usage returns the usage of the user with the quota applied to them.
+func (s *Storage) Usage(username string) (*models.QuotaStatus, error) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	username = s.names.blind(nil, username)
+
+	status := &models.QuotaStatus{Quota: s.quotas.of(username)}
+	err := s.db.View(func(tx Tx) error {
+		meta := tx.Bucket(metaBucketName)
+		if meta == nil {
+			return ErrFailedToOpenTopBucket
+		}
+
+		counters := meta.Bucket(metaUsageBucketName)
+		if counters == nil {
+			return nil
+		}
+
+		var err error
+		status.Usage, err = readUsage(counters, username)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/liriquew/secret_storage/server/internal/lib/config"
+	"github.com/liriquew/secret_storage/server/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuota(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, cfg config.StorageConfig) {
+		cfg.Quota = config.QuotaConfig{MaxSecrets: 2, MaxBytes: 90, MaxValueSize: 40}
+		cfg.UserQuotas = map[string]config.QuotaConfig{
+			"bob": {MaxSecrets: 3, MaxValueSize: -1},
+		}
+		s := newTestStorage(t, cfg)
+
+		usage := func(username string) models.Usage {
+			status, err := s.Usage(username)
+			require.NoError(t, err)
+			return status.Usage
+		}
+
+		path := []string{"alice", "app"}
+		_, err := s.SetRecord(path, "a", make([]byte, 10), models.WriteOptions{})
+		require.NoError(t, err)
+		_, err = s.SetRecord(path, "b", make([]byte, 20), models.WriteOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, models.Usage{Secrets: 2, Bytes: 30}, usage("alice"))
+
+		t.Run("Secrets", func(t *testing.T) {
+			_, err := s.SetRecord(path, "c", make([]byte, 10), models.WriteOptions{})
+			assert.ErrorIs(t, err, ErrQuotaExceeded)
+
+			_, err = s.GetRecord(path, "c", 0)
+			assert.ErrorIs(t, err, ErrRecordNotFound)
+
+			// new versions of existing secrets are allowed
+			_, err = s.SetRecord(path, "a", make([]byte, 30), models.WriteOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, models.Usage{Secrets: 2, Bytes: 60}, usage("alice"))
+		})
+
+		t.Run("Bytes", func(t *testing.T) {
+			_, err := s.SetRecord(path, "b", make([]byte, 40), models.WriteOptions{})
+			assert.ErrorIs(t, err, ErrQuotaExceeded)
+			assert.Equal(t, models.Usage{Secrets: 2, Bytes: 60}, usage("alice"))
+		})
+
+		t.Run("Value Size", func(t *testing.T) {
+			_, err := s.SetRecord(path, "a", make([]byte, 41), models.WriteOptions{})
+			assert.ErrorIs(t, err, ErrValueTooLarge)
+
+			// bob has no value size limit
+			_, err = s.SetRecord([]string{"bob"}, "a", make([]byte, 41), models.WriteOptions{})
+			require.NoError(t, err)
+		})
+
+		t.Run("Delete Frees Space", func(t *testing.T) {
+			_, err := s.Delete(path, "a", recordsBucketName)
+			require.NoError(t, err)
+			assert.Equal(t, models.Usage{Secrets: 1, Bytes: 20}, usage("alice"))
+
+			_, err = s.SetRecord(path, "c", make([]byte, 10), models.WriteOptions{})
+			require.NoError(t, err)
+		})
+
+		t.Run("Batch", func(t *testing.T) {
+			_, err := s.Batch([]*models.BatchOperation{
+				{Op: models.BatchOpSet, Path: []string{"bob"}, Key: "b", Value: []byte("v")},
+				{Op: models.BatchOpSet, Path: []string{"bob"}, Key: "c", Value: []byte("v")},
+				{Op: models.BatchOpSet, Path: []string{"bob"}, Key: "d", Value: []byte("v")},
+			})
+			assert.ErrorIs(t, err, ErrQuotaExceeded)
+			assert.Equal(t, models.Usage{Secrets: 1, Bytes: 41}, usage("bob"))
+		})
+
+		t.Run("Relocate", func(t *testing.T) {
+			_, err := s.Relocate(&models.Relocation{From: path, To: []string{"alice", "copy"}}, nil)
+			assert.ErrorIs(t, err, ErrQuotaExceeded)
+
+			_, err = s.Relocate(&models.Relocation{From: path, To: []string{"alice", "moved"}, Move: true}, nil)
+			require.NoError(t, err)
+			assert.Equal(t, models.Usage{Secrets: 2, Bytes: 30}, usage("alice"))
+		})
+
+		t.Run("Soft Delete Frees Space", func(t *testing.T) {
+			moved := []string{"alice", "moved"}
+			_, err := s.SoftDelete(moved, "b")
+			require.NoError(t, err)
+			assert.Equal(t, models.Usage{Secrets: 1, Bytes: 10}, usage("alice"))
+
+			_, err = s.SetRecord(moved, "d", make([]byte, 5), models.WriteOptions{})
+			require.NoError(t, err)
+
+			// the restored secret doesn't fit any more
+			err = s.Undelete(moved, "b")
+			assert.ErrorIs(t, err, ErrQuotaExceeded)
+
+			_, err = s.Batch([]*models.BatchOperation{{Op: models.BatchOpDelete, Path: moved, Key: "d"}})
+			require.NoError(t, err)
+			require.NoError(t, s.Undelete(moved, "b"))
+			assert.Equal(t, models.Usage{Secrets: 2, Bytes: 30}, usage("alice"))
+		})
+
+		status, err := s.Usage("carol")
+		require.NoError(t, err)
+		assert.Equal(t, models.Usage{}, status.Usage)
+		assert.Equal(t, models.Quota{MaxSecrets: 2, MaxBytes: 90, MaxValueSize: 40}, status.Quota)
+
+		status, err = s.Usage("bob")
+		require.NoError(t, err)
+		assert.Equal(t, models.Quota{MaxSecrets: 3, MaxBytes: 90}, status.Quota)
+	})
+}
+
+func TestUsageRecount(t *testing.T) {
+	cfg := conformanceConfig(t, "bbolt")
+
+	s, err := New(cfg)
+	require.NoError(t, err)
+	_, err = s.SetRecord([]string{"alice", "a"}, "key", []byte("value"), models.WriteOptions{})
+	require.NoError(t, err)
+	require.NoError(t, s.Set([]string{"alice"}, "legacy", []byte("legacy"), recordsBucketName))
+	// deleted secrets aren't counted
+	_, err = s.SetRecord([]string{"alice", "a"}, "deleted", []byte("value"), models.WriteOptions{})
+	require.NoError(t, err)
+	_, err = s.SoftDelete([]string{"alice", "a"}, "deleted")
+	require.NoError(t, err)
+
+	// databases written before quotas have no counters and no schema version
+	err = s.db.Update(func(tx Tx) error {
+		if err := tx.Bucket(metaBucketName).Delete(metaSchemaVersionKey); err != nil {
+			return err
+		}
+		return tx.Bucket(metaBucketName).DeleteBucket(metaUsageBucketName)
+	})
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+
+	s = newTestStorage(t, cfg)
+	status, err := s.Usage("alice")
+	require.NoError(t, err)
+	assert.Equal(t, models.Usage{Secrets: 2, Bytes: 11}, status.Usage)
+}
@@ -0,0 +1,445 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/liriquew/secret_storage/server/internal/models"
+)
+
+var (
+	ErrRelocationConflict = errors.New("destination already exists")
+	ErrRelocationOverlap  = errors.New("source and destination overlap")
+)
+
+// Relocate copies a secret or a whole bucket subtree to another place in one
+// transaction. Values are copied as they are stored without decryption,
+// unless resealer is given to seal them for the new place.
+// Returns the number of relocated secrets.
+func (s *Storage) Relocate(r *models.Relocation, resealer Resealer) (int, error) {
+	if r.Key == "" && (isPathPrefix(r.From, r.To) || isPathPrefix(r.To, r.From)) {
+		return 0, ErrRelocationOverlap
+	}
+	if r.Key != "" && slices.Equal(r.From, r.To) && r.Key == r.NewKey {
+		return 0, ErrRelocationOverlap
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	plain := r
+	r = &models.Relocation{
+		From:      s.names.blindPath(r.From),
+		To:        s.names.blindPath(r.To),
+		Key:       s.names.blind(r.From, r.Key),
+		NewKey:    s.names.blind(r.To, r.NewKey),
+		Move:      r.Move,
+		Overwrite: r.Overwrite,
+	}
+
+	var relocated int
+	var changes *changeLog
+	err := s.db.Update(func(tx Tx) error {
+		top := tx.Bucket(recordsBucketName)
+		if top == nil {
+			return ErrFailedToOpenTopBucket
+		}
+
+		if err := s.names.register(tx, append(slices.Clip(plain.To), plain.NewKey)...); err != nil {
+			return err
+		}
+
+		fromBefore, err := pathUsage(top, r.From, r.Key)
+		if err != nil {
+			return err
+		}
+		toBefore, err := pathUsage(top, r.To, r.NewKey)
+		if err != nil {
+			return err
+		}
+
+		changes = s.newChangeLog()
+		rl := &relocator{
+			Relocation: r,
+			tx:         tx,
+			names:      s.names,
+			open:       s.names.opener(tx),
+			resealer:   resealer,
+			changes:    changes,
+			refs:       s.newNameRefs(),
+		}
+		if r.Key != "" {
+			relocated, err = rl.relocateSecret(top, plain)
+		} else {
+			relocated, err = rl.relocateBucket(top, plain)
+		}
+		if err != nil {
+			return err
+		}
+
+		fromAfter, err := pathUsage(top, r.From, r.Key)
+		if err != nil {
+			return err
+		}
+		toAfter, err := pathUsage(top, r.To, r.NewKey)
+		if err != nil {
+			return err
+		}
+
+		usage := s.newUsageTracker()
+		usage.track(r.From, fromBefore, fromAfter)
+		usage.track(r.To, toBefore, toAfter)
+		if err := usage.commit(tx); err != nil {
+			return err
+		}
+		if err := changes.resolve(tx, s.names); err != nil {
+			return err
+		}
+		return rl.refs.commit(tx)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	s.publish(changes)
+	return relocated, nil
+}
+
+func isPathPrefix(prefix, path []string) bool {
+	return len(prefix) <= len(path) && slices.Equal(prefix, path[:len(prefix)])
+}
+
+// relocator copies entries of a relocation with stored names, real names are
+// opened to reseal values, to report conflicts and to blind them for the destination.
+type relocator struct {
+	*models.Relocation
+	tx        Tx
+	names     *nameIndex
+	open      func([]byte) (string, error)
+	resealer  Resealer
+	changes   *changeLog
+	refs      *nameRefs
+	conflicts []string
+}
+
+// conflict records the destination location, it is reported relative to the namespace.
+func (rl *relocator) conflict(location []string) {
+	rl.conflicts = append(rl.conflicts, strings.Join(location[1:], "/"))
+}
+
+// relocateSecret relocates the secret rl.Key, plain is the relocation with real names.
+func (rl *relocator) relocateSecret(top Bucket, plain *models.Relocation) (int, error) {
+	src, err := openBucketByPath(rl.From, top)
+	if err != nil {
+		return 0, err
+	}
+
+	key := []byte(rl.Key)
+	value := src.Get(key)
+	if value == nil && !isSecretBucket(src.Bucket(key)) {
+		return 0, ErrRecordNotFound
+	}
+
+	dst, err := createBucketByPath(top, rl.To, rl.refs)
+	if err != nil {
+		return 0, err
+	}
+
+	to := append(slices.Clip(plain.To), plain.NewKey)
+	newKey := []byte(rl.NewKey)
+	var replaced int
+	if dst.Get(newKey) != nil || dst.Bucket(newKey) != nil {
+		if !rl.Overwrite {
+			rl.conflict(to)
+			return 0, fmt.Errorf("%w: %s", ErrRelocationConflict, strings.Join(rl.conflicts, ", "))
+		}
+		if replaced, err = rl.remove(dst, newKey, to); err != nil {
+			return 0, err
+		}
+	}
+
+	from := append(slices.Clip(plain.From), plain.Key)
+	if err := rl.copySecret(dst, newKey, src, key, value, from, to); err != nil {
+		return 0, err
+	}
+	if err := rl.copied(dst, newKey, to, replaced); err != nil {
+		return 0, err
+	}
+
+	if rl.Move {
+		// the usage is measured by Relocate as a whole
+		if _, err := deleteRecord(top, rl.From, rl.Key, nil, rl.changes, rl.refs); err != nil {
+			return 0, err
+		}
+	}
+
+	return 1, nil
+}
+
+// relocateBucket relocates the bucket rl.From, plain is the relocation with real names.
+func (rl *relocator) relocateBucket(top Bucket, plain *models.Relocation) (int, error) {
+	src, err := openBucketByPath(rl.From, top)
+	if err != nil {
+		return 0, err
+	}
+
+	dst, err := createBucketByPath(top, rl.To, rl.refs)
+	if err != nil {
+		return 0, err
+	}
+
+	relocated, err := rl.mergeBucket(dst, src, plain.From, plain.To)
+	if err != nil {
+		return 0, err
+	}
+	if len(rl.conflicts) > 0 {
+		return 0, fmt.Errorf("%w: %s", ErrRelocationConflict, strings.Join(rl.conflicts, ", "))
+	}
+
+	if rl.Move {
+		if err := rl.removedSecrets(src, plain.From); err != nil {
+			return 0, err
+		}
+		if _, err := deleteBucketByPath(top, rl.From, rl.refs); err != nil {
+			return 0, err
+		}
+	}
+
+	return relocated, nil
+}
+
+// mergeBucket copies entries of src into dst merging path buckets existing in both,
+// other existing entries are replaced with overwrite or reported as conflicts.
+// from and to are the real paths of src and dst.
+func (rl *relocator) mergeBucket(dst, src Bucket, from, to []string) (int, error) {
+	var copied int
+
+	c := src.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		name, err := rl.open(k)
+		if err != nil {
+			return 0, err
+		}
+		entryFrom := append(slices.Clip(from), name)
+		entryTo := append(slices.Clip(to), name)
+
+		// blind indexes depend on the parent, so the entry gets a new one at the destination
+		dk := []byte(rl.names.blind(to, name))
+		if err := rl.names.register(rl.tx, entryTo...); err != nil {
+			return 0, err
+		}
+
+		srcIsPath := v == nil && !isSecretBucket(src.Bucket(k))
+		dstBucket := dst.Bucket(dk)
+		dstExists := dstBucket != nil || dst.Get(dk) != nil
+
+		if srcIsPath && dstExists && (dstBucket == nil || isSecretBucket(dstBucket)) {
+			if !rl.Overwrite {
+				rl.conflict(entryTo)
+				continue
+			}
+			// the secret is replaced with the path bucket merged below
+			replaced, err := rl.remove(dst, dk, entryTo)
+			if err != nil {
+				return 0, err
+			}
+			if replaced > 0 {
+				rl.changes.addPlain(models.ChangeDelete, entryTo, replaced)
+			}
+		}
+
+		if srcIsPath {
+			rl.refs.create(dst, dk)
+			nested, err := dst.CreateBucketIfNotExists(dk)
+			if err != nil {
+				return 0, err
+			}
+
+			n, err := rl.mergeBucket(nested, src.Bucket(k), entryFrom, entryTo)
+			if err != nil {
+				return 0, err
+			}
+			copied += n
+			continue
+		}
+
+		var replaced int
+		if dstExists {
+			if !rl.Overwrite {
+				rl.conflict(entryTo)
+				continue
+			}
+			if replaced, err = rl.remove(dst, dk, entryTo); err != nil {
+				return 0, err
+			}
+		}
+
+		if err := rl.copySecret(dst, dk, src, k, v, entryFrom, entryTo); err != nil {
+			return 0, err
+		}
+		if err := rl.copied(dst, dk, entryTo, replaced); err != nil {
+			return 0, err
+		}
+		copied++
+	}
+
+	return copied, nil
+}
+
+// copySecret copies the secret or the legacy value stored under srcKey in src
+// to dstKey in dst, from and to are the real locations of both.
+func (rl *relocator) copySecret(dst Bucket, dstKey []byte, src Bucket, srcKey []byte, value []byte, from, to []string) error {
+	rl.refs.create(dst, dstKey)
+	if rl.resealer == nil {
+		return copyEntry(dst, dstKey, src, srcKey, value)
+	}
+
+	if value != nil {
+		value, err := rl.resealer.ResealValue(from, to, value)
+		if err != nil {
+			return err
+		}
+		return dst.Put(dstKey, value)
+	}
+
+	if err := copyEntry(dst, dstKey, src, srcKey, nil); err != nil {
+		return err
+	}
+	_, err := resealSecret(dst.Bucket(dstKey), rl.resealer, from, to)
+	return err
+}
+
+// remove deletes the entry of dst replaced by the relocation, location is its real location.
+// Returns the version of the removed live secret, secrets of a removed path bucket
+// are reported as deleted right away.
+func (rl *relocator) remove(dst Bucket, key []byte, location []string) (int, error) {
+	if err := rl.refs.remove(dst, key); err != nil {
+		return 0, err
+	}
+
+	if nested := dst.Bucket(key); nested != nil && !isSecretBucket(nested) {
+		if err := rl.removedSecrets(nested, location); err != nil {
+			return 0, err
+		}
+		return 0, deleteEntry(dst, key)
+	}
+
+	replaced, err := removedVersion(dst, string(key))
+	if err != nil {
+		return 0, err
+	}
+	return replaced, deleteEntry(dst, key)
+}
+
+// removedSecrets reports live secrets under the path bucket b as deleted,
+// location is the real location of b.
+func (rl *relocator) removedSecrets(b Bucket, location []string) error {
+	if rl.changes == nil {
+		return nil
+	}
+
+	return walkSecrets(b, nil, func(path []string, key string, secret Bucket) error {
+		version, err := secretRemovedVersion(secret)
+		if err != nil || version == 0 {
+			return err
+		}
+
+		secretLocation := slices.Clip(location)
+		for _, stored := range append(slices.Clip(path), key) {
+			name, err := rl.open([]byte(stored))
+			if err != nil {
+				return err
+			}
+			secretLocation = append(secretLocation, name)
+		}
+		rl.changes.addPlain(models.ChangeDelete, secretLocation, version)
+		return nil
+	})
+}
+
+// copied reports the secret copied to key of dst, replaced is the version
+// of the live secret it replaced.
+func (rl *relocator) copied(dst Bucket, key []byte, location []string, replaced int) error {
+	if rl.changes == nil {
+		return nil
+	}
+
+	current, err := liveVersion(dst, string(key))
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case current > 0 && replaced > 0:
+		rl.changes.addPlain(models.ChangeUpdate, location, current)
+	case current > 0:
+		rl.changes.addPlain(models.ChangeCreate, location, current)
+	case replaced > 0:
+		// a deleted secret took the place of a live one
+		rl.changes.addPlain(models.ChangeDelete, location, replaced)
+	}
+	return nil
+}
+
+// copyEntry copies the value or the nested bucket stored under srcKey in src to dstKey in dst.
+func copyEntry(dst Bucket, dstKey []byte, src Bucket, srcKey []byte, value []byte) error {
+	if value != nil {
+		return dst.Put(dstKey, value)
+	}
+
+	nested, err := dst.CreateBucket(dstKey)
+	if err != nil {
+		return err
+	}
+
+	from := src.Bucket(srcKey)
+	c := from.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if err := copyEntry(nested, k, from, k, v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func deleteEntry(b Bucket, key []byte) error {
+	if b.Bucket(key) != nil {
+		return b.DeleteBucket(key)
+	}
+	return b.Delete(key)
+}
+
+// deleteBucketByPath removes the path bucket with everything inside
+// and prunes parent buckets left empty. Returns the number of pruned parents.
+func deleteBucketByPath(top Bucket, path []string, refs *nameRefs) (int, error) {
+	parents := make([]Bucket, 0, len(path))
+	b := top
+	for _, pathPart := range path {
+		parents = append(parents, b)
+		b = b.Bucket([]byte(pathPart))
+		if b == nil || isSecretBucket(b) {
+			return 0, fmt.Errorf("%w: bucket name - %s", ErrBucketNotFound, pathPart)
+		}
+	}
+
+	pruned := 0
+	for i := len(path) - 1; i >= 0; i-- {
+		if err := refs.remove(parents[i], []byte(path[i])); err != nil {
+			return 0, err
+		}
+		if err := parents[i].DeleteBucket([]byte(path[i])); err != nil {
+			return 0, fmt.Errorf("error while deleting bucket: %w", err)
+		}
+		if i != len(path)-1 {
+			pruned++
+		}
+		if k, _ := parents[i].Cursor().First(); k != nil || i == 0 {
+			break
+		}
+	}
+
+	return pruned, nil
+}
@@ -1,20 +1,19 @@
 package storage
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"slices"
 	"strings"
 
 	"github.com/liriquew/secret_storage/server/internal/models"
-	"go.etcd.io/bbolt"
-	bolt "go.etcd.io/bbolt"
 )
 
 var (
 	recordsBucketName = []byte("kv")
 	userBucketName    = []byte("user")
 	metaBucketName    = []byte("meta")
-	metaTokenName     = []byte("token")
 )
 
 var (
@@ -25,31 +24,57 @@ var (
 	ErrIteratingBucket       = errors.New("error while iterating bucket")
 )
 
-func openBucketByPath(path []string, bucket *bolt.Bucket) (*bolt.Bucket, error) {
+func openBucketByPath(path []string, bucket Bucket) (Bucket, error) {
 	for _, pathPart := range path {
 		if pathPart == "" {
 			return nil, ErrEmptyPathPart
 		}
 		bucket = bucket.Bucket([]byte(pathPart))
-		if bucket == nil {
+		if bucket == nil || isSecretBucket(bucket) {
 			return nil, fmt.Errorf("%w: bucket name - %s", ErrBucketNotFound, pathPart)
 		}
 	}
 	return bucket, nil
 }
 
+// createBucketByPath opens the path bucket creating missing ones.
+func createBucketByPath(b Bucket, path []string, refs *nameRefs) (Bucket, error) {
+	var err error
+	for _, pathPart := range path {
+		if pathPart == "" {
+			return nil, ErrEmptyPathPart
+		}
+		refs.create(b, []byte(pathPart))
+		b, err = b.CreateBucketIfNotExists([]byte(pathPart))
+		if err != nil {
+			return nil, fmt.Errorf("%w: path - %s, err - %w", ErrIncorrectPath, strings.Join(path, "/"), err)
+		}
+		if isSecretBucket(b) {
+			return nil, fmt.Errorf("%w: %s is a secret", ErrIncorrectPath, pathPart)
+		}
+	}
+	return b, nil
+}
+
 func (s *Storage) Set(path []string, key string, value []byte, bucketName []byte) error {
 	s.m.Lock()
 	defer s.m.Unlock()
 
-	err := s.db.Update(func(tx *bolt.Tx) error {
+	err := s.db.Update(func(tx Tx) error {
 		b := tx.Bucket(bucketName)
 		if b == nil {
 			return ErrFailedToOpenTopBucket
 		}
 
+		if err := s.names.register(tx, append(slices.Clip(path), key)...); err != nil {
+			return err
+		}
+		path, key := s.names.blindLocation(path, key)
+		refs := s.newNameRefs()
+
 		var err error
 		for _, pathPart := range path {
+			refs.create(b, []byte(pathPart))
 			b, err = b.CreateBucketIfNotExists([]byte(pathPart))
 			if err != nil {
 				return fmt.Errorf("error while creating path buckets (pathPart - %s): %w", pathPart, err)
@@ -60,7 +85,11 @@ func (s *Storage) Set(path []string, key string, value []byte, bucketName []byte
 			return fmt.Errorf("%w: path - %s", ErrIncorrectPath, strings.Join(path, "/"))
 		}
 
-		return b.Put([]byte(key), value)
+		refs.create(b, []byte(key))
+		if err := b.Put([]byte(key), value); err != nil {
+			return err
+		}
+		return refs.commit(tx)
 	})
 
 	if err != nil {
@@ -73,8 +102,10 @@ func (s *Storage) Set(path []string, key string, value []byte, bucketName []byte
 func (s *Storage) Get(path []string, key string, bucketName []byte) ([]byte, error) {
 	s.m.RLock()
 	defer s.m.RUnlock()
+	path, key = s.names.blindLocation(path, key)
+
 	var value []byte
-	err := s.db.View(func(tx *bolt.Tx) error {
+	err := s.db.View(func(tx Tx) error {
 		b := tx.Bucket([]byte(bucketName))
 		if b == nil {
 			return ErrFailedToOpenTopBucket
@@ -95,72 +126,131 @@ func (s *Storage) Get(path []string, key string, bucketName []byte) ([]byte, err
 	return value, nil
 }
 
+// Delete removes the record with all of its versions and returns the number
+// of path buckets pruned because they were left empty.
 func (s *Storage) Delete(path []string, key string, bucketName []byte) (int, error) {
 	deletedBuckets := 0
 	s.m.Lock()
 	defer s.m.Unlock()
 
-	err := s.db.Update(func(tx *bolt.Tx) error {
+	path, key = s.names.blindLocation(path, key)
+
+	var changes *changeLog
+	err := s.db.Update(func(tx Tx) error {
 		topLevelBucket := tx.Bucket([]byte(bucketName))
 		if topLevelBucket == nil {
 			return ErrFailedToOpenTopBucket
 		}
 
-		var dfs func(*bbolt.Bucket, int) (bool, error)
-		dfs = func(b *bbolt.Bucket, pathIdx int) (bool, error) {
-			// pathIdx is next path part to open
-			if pathIdx == len(path) {
-				err := b.Delete([]byte(key))
-				if err != nil {
-					return false, fmt.Errorf("error while deleting key: %w", err)
-				}
-				someKey, _ := b.Cursor().First()
-				return someKey == nil, nil
-			}
+		var usage *usageTracker
+		changes = nil
+		if string(bucketName) == string(recordsBucketName) {
+			usage = s.newUsageTracker()
+			changes = s.newChangeLog()
+		}
+		refs := s.newNameRefs()
 
-			nextB := b.Bucket([]byte(path[pathIdx]))
-			if nextB == nil {
-				return false, fmt.Errorf("%w: bucket name - %s", ErrBucketNotFound, path[pathIdx])
-			}
+		var err error
+		deletedBuckets, err = deleteRecord(topLevelBucket, path, key, usage, changes, refs)
+		if err != nil {
+			return err
+		}
+		if err := usage.commit(tx); err != nil {
+			return err
+		}
+		if err := changes.resolve(tx, s.names); err != nil {
+			return err
+		}
+		return refs.commit(tx)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	s.publish(changes)
+	return deletedBuckets, nil
+}
+
+// deleteRecord removes key with all of its versions and prunes path buckets left empty,
+// returns the number of pruned buckets.
+func deleteRecord(topLevelBucket Bucket, path []string, key string, usage *usageTracker, changes *changeLog, refs *nameRefs) (int, error) {
+	pruned := 0
 
-			empty, err := dfs(nextB, pathIdx+1)
+	var dfs func(Bucket, int) (bool, error)
+	dfs = func(b Bucket, pathIdx int) (bool, error) {
+		// pathIdx is next path part to open
+		if pathIdx == len(path) {
+			before, err := entryUsage(b, []byte(key))
 			if err != nil {
 				return false, err
 			}
+			usage.track(path, before, models.Usage{})
 
-			if !empty {
-				return false, err
+			if changes != nil {
+				removed, err := removedVersion(b, key)
+				if err != nil {
+					return false, err
+				}
+				if removed > 0 {
+					changes.add(models.ChangeDelete, path, key, removed)
+				}
 			}
 
-			err = b.DeleteBucket([]byte(path[pathIdx]))
+			if isSecretBucket(b.Bucket([]byte(key))) {
+				err = b.DeleteBucket([]byte(key))
+			} else if b.Get([]byte(key)) != nil {
+				err = b.Delete([]byte(key))
+			} else {
+				return false, ErrRecordNotFound
+			}
+			refs.drop([]byte(key))
 			if err != nil {
-				return false, fmt.Errorf("error while deleting empty bucket: %w", err)
+				return false, fmt.Errorf("error while deleting key: %w", err)
 			}
-
 			someKey, _ := b.Cursor().First()
 			return someKey == nil, nil
 		}
 
-		if _, err := dfs(topLevelBucket, 0); err != nil {
-			return err
+		nextB := b.Bucket([]byte(path[pathIdx]))
+		if nextB == nil || isSecretBucket(nextB) {
+			return false, fmt.Errorf("%w: bucket name - %s", ErrBucketNotFound, path[pathIdx])
 		}
 
-		return nil
-	})
-	if err != nil {
-		return 0, err
+		empty, err := dfs(nextB, pathIdx+1)
+		if err != nil {
+			return false, err
+		}
+
+		if !empty {
+			return false, err
+		}
+
+		err = b.DeleteBucket([]byte(path[pathIdx]))
+		if err != nil {
+			return false, fmt.Errorf("error while deleting empty bucket: %w", err)
+		}
+		refs.drop([]byte(path[pathIdx]))
+		pruned++
+
+		someKey, _ := b.Cursor().First()
+		return someKey == nil, nil
 	}
 
-	return deletedBuckets, nil
+	_, err := dfs(topLevelBucket, 0)
+	return pruned, err
 }
 
-func (s *Storage) ListRecords(path []string) (*models.BucketInfo, error) {
+// ListRecords lists the bucket at path page by page, buckets and records
+// are counted together in the key order.
+func (s *Storage) ListRecords(path []string, page models.PageOptions) (*models.BucketInfo, error) {
 	var bucketInfo models.BucketInfo
 
 	s.m.RLock()
 	defer s.m.RUnlock()
 
-	err := s.db.View(func(tx *bolt.Tx) error {
+	path = s.names.blindPath(path)
+
+	err := s.db.View(func(tx Tx) error {
 		b := tx.Bucket(recordsBucketName)
 		if b == nil {
 			return ErrFailedToOpenTopBucket
@@ -170,14 +260,46 @@ func (s *Storage) ListRecords(path []string) (*models.BucketInfo, error) {
 		if err != nil {
 			return err
 		}
+		open := s.names.opener(tx)
 
 		c := b.Cursor()
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			if v == nil {
-				bucketInfo.Buckets = append(bucketInfo.Buckets, string(k))
+		k, v := c.First()
+		if page.After != nil {
+			k, v = c.Seek(page.After)
+			if bytes.Equal(k, page.After) {
+				k, v = c.Next()
+			}
+		}
+
+		var listed int
+		var lastKey []byte
+		for ; k != nil; k, v = c.Next() {
+			if page.Limit > 0 && listed == page.Limit {
+				bucketInfo.NextCursor = models.EncodeCursor(lastKey)
+				break
+			}
+
+			name, err := open(k)
+			if err != nil {
+				return err
+			}
+
+			if v == nil && !isSecretBucket(b.Bucket(k)) {
+				bucketInfo.Buckets = append(bucketInfo.Buckets, name)
 			} else {
-				bucketInfo.Records = append(bucketInfo.Records, &models.Record{Key: k, Value: v})
+				record, err := readRecord(b, string(k), 0)
+				if errors.Is(err, ErrRecordNotFound) {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				record.Key = []byte(name)
+				bucketInfo.Records = append(bucketInfo.Records, record)
 			}
+
+			listed++
+			lastKey = k
 		}
 
 		return err
@@ -194,8 +316,10 @@ func (s *Storage) ListRecordsRecursively(path []string) (*models.BucketFullInfo,
 	s.m.RLock()
 	defer s.m.RUnlock()
 
+	path = s.names.blindPath(path)
+
 	BucketInfo := &models.BucketFullInfo{}
-	err := s.db.View(func(tx *bolt.Tx) error {
+	err := s.db.View(func(tx Tx) error {
 		b := tx.Bucket(recordsBucketName)
 		if b == nil {
 			return ErrFailedToOpenTopBucket
@@ -205,10 +329,11 @@ func (s *Storage) ListRecordsRecursively(path []string) (*models.BucketFullInfo,
 		if err != nil {
 			return err
 		}
+		open := s.names.opener(tx)
 
 		// bfs
-		var iterateBucket func(*bbolt.Bucket) (*models.BucketFullInfo, error)
-		iterateBucket = func(b *bbolt.Bucket) (*models.BucketFullInfo, error) {
+		var iterateBucket func(Bucket) (*models.BucketFullInfo, error)
+		iterateBucket = func(b Bucket) (*models.BucketFullInfo, error) {
 			if b == nil {
 				return nil, nil
 			}
@@ -217,17 +342,30 @@ func (s *Storage) ListRecordsRecursively(path []string) (*models.BucketFullInfo,
 
 			c := b.Cursor()
 			for k, v := c.First(); k != nil; k, v = c.Next() {
-				if v == nil {
+				name, err := open(k)
+				if err != nil {
+					return nil, err
+				}
+
+				if v == nil && !isSecretBucket(b.Bucket(k)) {
 					subBucket := b.Bucket(k)
 					subBucketInfo, err := iterateBucket(subBucket)
 					if err != nil {
-						return nil, fmt.Errorf("%w: bucket name - %s, err - %w", ErrIteratingBucket, string(k), err)
+						return nil, fmt.Errorf("%w: bucket name - %s, err - %w", ErrIteratingBucket, name, err)
 					}
 
-					subBucketInfo.Name = string(k)
+					subBucketInfo.Name = name
 					bInfo.Buckets = append(bInfo.Buckets, subBucketInfo)
 				} else {
-					bInfo.Records = append(bInfo.Records, &models.Record{Key: k, Value: v})
+					record, err := readRecord(b, string(k), 0)
+					if errors.Is(err, ErrRecordNotFound) {
+						continue
+					}
+					if err != nil {
+						return nil, err
+					}
+					record.Key = []byte(name)
+					bInfo.Records = append(bInfo.Records, record)
 				}
 			}
 
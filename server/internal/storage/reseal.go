@@ -0,0 +1,240 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/liriquew/secret_storage/server/internal/models"
+)
+
+// Once a reseal pass finds no values sealed in an older format, the database is marked:
+//
+//	meta/resealed - any value
+//
+// and values of an older format found after that are not trusted. The mark is cached
+// like the keyring, values are opened inside transactions.
+var metaResealedKey = []byte("resealed")
+
+// LegacyResealed reports whether the database is marked as free of legacy values.
+func (s *Storage) LegacyResealed() bool {
+	return s.legacyResealed.Load()
+}
+
+// MarkLegacyResealed marks the database as free of legacy values.
+func (s *Storage) MarkLegacyResealed() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	err := s.db.Update(func(tx Tx) error {
+		meta := tx.Bucket(metaBucketName)
+		if meta == nil {
+			return ErrFailedToOpenTopBucket
+		}
+		return meta.Put(metaResealedKey, []byte{1})
+	})
+	if err != nil {
+		return err
+	}
+
+	s.legacyResealed.Store(true)
+	return nil
+}
+
+// Resealer seals stored values for their location, locations are real paths
+// ending with the key of the secret. Values are resealed when secrets are
+// relocated and by ResealRecords to upgrade values sealed by an older format.
+type Resealer interface {
+	// ResealValue returns the value sealed for the location to or nil
+	// if the value stored at from is good for it as it is.
+	ResealValue(from, to []string, value []byte) ([]byte, error)
+	// ResealFile is ResealValue for the manifest of a file, its chunks are never resealed.
+	ResealFile(from, to []string, file *models.FileManifest) (*models.FileManifest, error)
+}
+
+// resealSecret reseals every version of the secret, returns the number of changed versions.
+func resealSecret(secret Bucket, resealer Resealer, from, to []string) (int, error) {
+	versions := secret.Bucket(secretVersionsBucketName)
+	if versions == nil {
+		return 0, nil
+	}
+
+	var keys [][]byte
+	err := versions.ForEach(func(k, _ []byte) error {
+		keys = append(keys, slices.Clone(k))
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var resealed int
+	for _, k := range keys {
+		entry := &versionEntry{}
+		if err := json.Unmarshal(versions.Get(k), entry); err != nil {
+			return 0, fmt.Errorf("error while decoding version: %w", err)
+		}
+
+		if entry.File != nil {
+			file, err := resealer.ResealFile(from, to, entry.File)
+			if err != nil {
+				return 0, err
+			}
+			if file == nil {
+				continue
+			}
+			entry.File = file
+		} else {
+			value, err := resealer.ResealValue(from, to, entry.Value)
+			if err != nil {
+				return 0, err
+			}
+			if value == nil {
+				continue
+			}
+			entry.Value = value
+		}
+
+		buf, err := json.Marshal(entry)
+		if err != nil {
+			return 0, err
+		}
+		if err := versions.Put(k, buf); err != nil {
+			return 0, err
+		}
+		resealed++
+	}
+
+	return resealed, nil
+}
+
+// resealRef is a secret or a legacy value collected for resealing,
+// location is its real path ending with the key.
+type resealRef struct {
+	recordRef
+	location []string
+}
+
+// ResealRecords passes every stored value to resealer with its own location
+// as both from and to, values returned by it replace the stored ones.
+// Records are resealed in transactions of at most purgeBatchSize records,
+// returns the number of resealed values.
+func (s *Storage) ResealRecords(resealer Resealer) (int, error) {
+	var refs []resealRef
+
+	s.m.RLock()
+	err := s.db.View(func(tx Tx) error {
+		b := tx.Bucket(recordsBucketName)
+		if b == nil {
+			return ErrFailedToOpenTopBucket
+		}
+
+		open := s.names.opener(tx)
+
+		var collect func(b Bucket, path, location []string) error
+		collect = func(b Bucket, path, location []string) error {
+			c := b.Cursor()
+			for k, v := c.First(); k != nil; k, v = c.Next() {
+				name, err := open(k)
+				if err != nil {
+					return err
+				}
+
+				if v != nil || isSecretBucket(b.Bucket(k)) {
+					refs = append(refs, resealRef{
+						recordRef: recordRef{path, string(k)},
+						location:  append(slices.Clip(location), name),
+					})
+					continue
+				}
+
+				err = collect(b.Bucket(k), append(slices.Clip(path), string(k)), append(slices.Clip(location), name))
+				if err != nil {
+					return fmt.Errorf("%w: bucket name - %s, err - %w", ErrIteratingBucket, name, err)
+				}
+			}
+			return nil
+		}
+
+		return collect(b, nil, nil)
+	})
+	s.m.RUnlock()
+	if err != nil {
+		return 0, err
+	}
+
+	resealed := 0
+	for start := 0; start < len(refs); start += purgeBatchSize {
+		batch := refs[start:min(start+purgeBatchSize, len(refs))]
+
+		batchResealed := 0
+
+		s.m.Lock()
+		err := s.db.Update(func(tx Tx) error {
+			// resealed values may grow, the usage is tracked without limits
+			// to never fail the upgrade of a namespace over its quota
+			usage := &usageTracker{quotas: &quotas{}, deltas: map[string]models.Usage{}}
+
+			top := tx.Bucket(recordsBucketName)
+			if top == nil {
+				return ErrFailedToOpenTopBucket
+			}
+
+			for _, ref := range batch {
+				b, err := openBucketByPath(ref.path, top)
+				if err != nil {
+					// the record is gone since it was collected
+					continue
+				}
+
+				key := []byte(ref.key)
+				before, err := entryUsage(b, key)
+				if err != nil {
+					return err
+				}
+
+				n, err := resealEntry(b, key, resealer, ref.location)
+				if err != nil {
+					return err
+				}
+				if n == 0 {
+					continue
+				}
+
+				after, err := entryUsage(b, key)
+				if err != nil {
+					return err
+				}
+				usage.track(ref.path, before, after)
+				batchResealed += n
+			}
+
+			return usage.commit(tx)
+		})
+		s.m.Unlock()
+		if err != nil {
+			return resealed, err
+		}
+
+		resealed += batchResealed
+	}
+
+	return resealed, nil
+}
+
+// resealEntry reseals the secret or the legacy value stored under key in b in place.
+func resealEntry(b Bucket, key []byte, resealer Resealer, location []string) (int, error) {
+	if value := b.Get(key); value != nil {
+		value, err := resealer.ResealValue(location, location, value)
+		if err != nil || value == nil {
+			return 0, err
+		}
+		return 1, b.Put(key, value)
+	}
+
+	secret := b.Bucket(key)
+	if !isSecretBucket(secret) {
+		return 0, nil
+	}
+	return resealSecret(secret, resealer, location, location)
+}
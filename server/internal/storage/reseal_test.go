@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/liriquew/secret_storage/server/internal/lib/config"
+	"github.com/liriquew/secret_storage/server/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testResealer prefixes values with their location, values without
+// the prefix are legacy ones.
+type testResealer struct{}
+
+func (testResealer) reseal(from, to []string, value []byte) ([]byte, error) {
+	if rest, ok := bytes.CutPrefix(value, []byte("@")); ok {
+		location, payload, _ := bytes.Cut(rest, []byte("|"))
+		if string(location) != strings.Join(from, "/") {
+			return nil, fmt.Errorf("value is sealed for %s", location)
+		}
+		if strings.Join(from, "/") == strings.Join(to, "/") {
+			return nil, nil
+		}
+		value = payload
+	}
+	return append([]byte("@"+strings.Join(to, "/")+"|"), value...), nil
+}
+
+func (r testResealer) ResealValue(from, to []string, value []byte) ([]byte, error) {
+	return r.reseal(from, to, value)
+}
+
+func (r testResealer) ResealFile(from, to []string, file *models.FileManifest) (*models.FileManifest, error) {
+	digest, err := r.reseal(from, to, file.Digest)
+	if err != nil || digest == nil {
+		return nil, err
+	}
+	resealed := *file
+	resealed.Digest = digest
+	return &resealed, nil
+}
+
+func TestReseal(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, cfg config.StorageConfig) {
+		s := newTestStorage(t, cfg)
+
+		path := []string{"alice", "app"}
+		_, err := s.SetRecord(path, "a", []byte("one"), models.WriteOptions{})
+		require.NoError(t, err)
+		_, err = s.SetRecord(path, "a", []byte("two"), models.WriteOptions{})
+		require.NoError(t, err)
+		_, err = s.SetRecord(path, "b", []byte("@alice/app/b|bound"), models.WriteOptions{})
+		require.NoError(t, err)
+		require.NoError(t, s.Set(path, "legacy", []byte("plain"), recordsBucketName))
+		file := &models.FileManifest{ID: []byte("id"), Size: 1, ChunkSize: 1, Chunks: 1, Digest: []byte("digest")}
+		_, err = setTestFile(t, s, path, "file", file, [][]byte{[]byte("x")}, models.WriteOptions{})
+		require.NoError(t, err)
+
+		value := func(path []string, key string, version int) string {
+			record, err := s.GetRecord(path, key, version)
+			require.NoError(t, err)
+			if record.File != nil {
+				return string(record.File.Digest)
+			}
+			return string(record.Value)
+		}
+
+		t.Run("Records", func(t *testing.T) {
+			resealed, err := s.ResealRecords(testResealer{})
+			require.NoError(t, err)
+			assert.Equal(t, 4, resealed)
+
+			assert.Equal(t, "@alice/app/a|one", value(path, "a", 1))
+			assert.Equal(t, "@alice/app/a|two", value(path, "a", 2))
+			assert.Equal(t, "@alice/app/b|bound", value(path, "b", 0))
+			assert.Equal(t, "@alice/app/legacy|plain", value(path, "legacy", 0))
+			assert.Equal(t, "@alice/app/file|digest", value(path, "file", 0))
+
+			resealed, err = s.ResealRecords(testResealer{})
+			require.NoError(t, err)
+			assert.Equal(t, 0, resealed)
+		})
+
+		t.Run("Relocate", func(t *testing.T) {
+			_, err := s.Relocate(&models.Relocation{From: path, Key: "a", To: []string{"alice", "other"}, NewKey: "c"}, testResealer{})
+			require.NoError(t, err)
+			assert.Equal(t, "@alice/other/c|two", value([]string{"alice", "other"}, "c", 0))
+
+			_, err = s.Relocate(&models.Relocation{From: path, To: []string{"alice", "moved", "app"}, Move: true}, testResealer{})
+			require.NoError(t, err)
+			moved := []string{"alice", "moved", "app"}
+			assert.Equal(t, "@alice/moved/app/a|one", value(moved, "a", 1))
+			assert.Equal(t, "@alice/moved/app/legacy|plain", value(moved, "legacy", 0))
+			assert.Equal(t, "@alice/moved/app/file|digest", value(moved, "file", 0))
+		})
+	})
+}
+
+func TestLegacyResealedMark(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, cfg config.StorageConfig) {
+		s := newTestStorage(t, cfg)
+		assert.False(t, s.LegacyResealed())
+
+		require.NoError(t, s.MarkLegacyResealed())
+		assert.True(t, s.LegacyResealed())
+
+		if cfg.Type == "memory" {
+			return
+		}
+		require.NoError(t, s.Close())
+		assert.True(t, newTestStorage(t, cfg).LegacyResealed())
+	})
+}
+
+func TestRaftLegacyResealedMark(t *testing.T) {
+	nodes := newTestCluster(t, "memory", 3)
+	leader, _ := waitForLeader(t, nodes)
+
+	require.NoError(t, leader.MarkLegacyResealed())
+
+	// followers learn the mark from the log like the keyring
+	for _, node := range nodes {
+		assert.Eventually(t, node.LegacyResealed, electionTimeout, 50*time.Millisecond)
+	}
+}
@@ -0,0 +1,360 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/liriquew/secret_storage/server/internal/models"
+)
+
+// After a rotation of the data key every stored ciphertext is sealed again with the new key
+// in batches, the progress of the pass is written in the transaction of each batch:
+//
+//	meta/rewrap - JSON progress
+//
+// so the pass resumes from the last batch after a restart. Secrets are walked in the order
+// of their stored names and then sealed names are.
+var metaRewrapKey = []byte("rewrap")
+
+// Rewrapper seals stored ciphertexts again with the active data key, nil results
+// mean the ciphertext is sealed with it already. Values and manifests of files are
+// passed to the Resealer methods with the location of the secret as both from and to.
+type Rewrapper interface {
+	Resealer
+	RewrapChunk(file *models.FileManifest, index int, chunk []byte) ([]byte, error)
+	RewrapName(blind string, sealed []byte) ([]byte, error)
+}
+
+type rewrapProgress struct {
+	Term       int        `json:"term"`
+	Phase      string     `json:"phase"`
+	Secrets    int        `json:"secrets"`
+	Names      int        `json:"names"`
+	Rewrapped  int        `json:"rewrapped"`
+	StartedAt  time.Time  `json:"started_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	// Cursor is the stored location of the last walked secret or the last walked blind name
+	Cursor []string `json:"cursor,omitempty"`
+}
+
+func (p *rewrapProgress) status() *models.RewrapStatus {
+	return &models.RewrapStatus{
+		Term:       p.Term,
+		Phase:      p.Phase,
+		Secrets:    p.Secrets,
+		Names:      p.Names,
+		Rewrapped:  p.Rewrapped,
+		StartedAt:  p.StartedAt,
+		UpdatedAt:  p.UpdatedAt,
+		FinishedAt: p.FinishedAt,
+	}
+}
+
+func readRewrapProgress(meta Bucket) (*rewrapProgress, error) {
+	raw := meta.Get(metaRewrapKey)
+	if raw == nil {
+		return nil, nil
+	}
+
+	progress := &rewrapProgress{}
+	if err := json.Unmarshal(raw, progress); err != nil {
+		return nil, fmt.Errorf("error while decoding rewrap progress: %w", err)
+	}
+	return progress, nil
+}
+
+// RewrapStatus returns the progress of the last rewrap pass, nil if there was none.
+func (s *Storage) RewrapStatus() (*models.RewrapStatus, error) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	var status *models.RewrapStatus
+	err := s.db.View(func(tx Tx) error {
+		meta := tx.Bucket(metaBucketName)
+		if meta == nil {
+			return ErrFailedToOpenTopBucket
+		}
+
+		progress, err := readRewrapProgress(meta)
+		if progress != nil {
+			status = progress.status()
+		}
+		return err
+	})
+	return status, err
+}
+
+// Rewrap passes the next limit secrets or sealed names of the pass to term to rewrapper
+// in one transaction, returns the number of rewrapped ciphertexts. A pass to another
+// term is abandoned and the pass starts over, a finished pass isn't walked again.
+func (s *Storage) Rewrap(term int, limit int, rewrapper Rewrapper) (int, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	var rewrapped int
+	err := s.db.Update(func(tx Tx) error {
+		meta := tx.Bucket(metaBucketName)
+		if meta == nil {
+			return ErrFailedToOpenTopBucket
+		}
+
+		progress, err := readRewrapProgress(meta)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now().UTC()
+		if progress == nil || progress.Term != term {
+			progress = &rewrapProgress{Term: term, Phase: models.RewrapPhaseSecrets, StartedAt: now}
+		}
+		if progress.Phase == models.RewrapPhaseDone {
+			return nil
+		}
+		before := progress.Rewrapped
+
+		switch progress.Phase {
+		case models.RewrapPhaseSecrets:
+			err = s.rewrapSecrets(tx, progress, limit, rewrapper)
+		case models.RewrapPhaseNames:
+			err = s.rewrapNames(tx, progress, limit, rewrapper)
+		default:
+			err = fmt.Errorf("unknown rewrap phase %s", progress.Phase)
+		}
+		if err != nil {
+			return err
+		}
+
+		progress.UpdatedAt = now
+		if progress.Phase == models.RewrapPhaseDone {
+			progress.FinishedAt = &now
+		}
+
+		raw, err := json.Marshal(progress)
+		if err != nil {
+			return err
+		}
+		rewrapped = progress.Rewrapped - before
+		return meta.Put(metaRewrapKey, raw)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return rewrapped, nil
+}
+
+func (s *Storage) rewrapSecrets(tx Tx, progress *rewrapProgress, limit int, rewrapper Rewrapper) error {
+	top := tx.Bucket(recordsBucketName)
+	if top == nil {
+		return ErrFailedToOpenTopBucket
+	}
+
+	var refs []resealRef
+	_, err := walkSecretsAfter(top, nil, nil, progress.Cursor, s.names.opener(tx), func(ref resealRef) bool {
+		refs = append(refs, ref)
+		return len(refs) < limit
+	})
+	if err != nil {
+		return err
+	}
+
+	// rewrapped values may grow, the usage is tracked without limits
+	// like for resealed ones
+	usage := &usageTracker{quotas: &quotas{}, deltas: map[string]models.Usage{}}
+	for _, ref := range refs {
+		b, err := openBucketByPath(ref.path, top)
+		if err != nil {
+			return err
+		}
+
+		key := []byte(ref.key)
+		before, err := entryUsage(b, key)
+		if err != nil {
+			return err
+		}
+
+		n, err := rewrapEntry(b, key, rewrapper, ref.location)
+		if err != nil {
+			return fmt.Errorf("error while rewrapping %s: %w", strings.Join(ref.location, "/"), err)
+		}
+
+		after, err := entryUsage(b, key)
+		if err != nil {
+			return err
+		}
+		usage.track(ref.path, before, after)
+		progress.Rewrapped += n
+	}
+	progress.Secrets += len(refs)
+
+	if len(refs) == limit {
+		last := refs[len(refs)-1]
+		progress.Cursor = append(slices.Clone(last.path), last.key)
+	} else {
+		progress.Phase, progress.Cursor = models.RewrapPhaseNames, nil
+	}
+	return usage.commit(tx)
+}
+
+// walkSecretsAfter visits secrets and legacy values of b stored after the cursor location
+// in the order of stored names until visit returns false.
+func walkSecretsAfter(b Bucket, path, location, cursor []string, open func([]byte) (string, error), visit func(resealRef) bool) (bool, error) {
+	c := b.Cursor()
+	k, v := c.First()
+	if len(cursor) > 0 {
+		k, v = c.Seek([]byte(cursor[0]))
+	}
+
+	for ; k != nil; k, v = c.Next() {
+		// the cursor is followed only down its own branch
+		var rest []string
+		if len(cursor) > 0 && string(k) == cursor[0] {
+			rest = cursor[1:]
+		}
+
+		name, err := open(k)
+		if err != nil {
+			return false, err
+		}
+
+		if v != nil || isSecretBucket(b.Bucket(k)) {
+			if rest != nil {
+				// the secret under the cursor was walked by the last batch
+				continue
+			}
+			ref := resealRef{
+				recordRef: recordRef{path, string(k)},
+				location:  append(slices.Clip(location), name),
+			}
+			if !visit(ref) {
+				return false, nil
+			}
+			continue
+		}
+
+		more, err := walkSecretsAfter(b.Bucket(k), append(slices.Clip(path), string(k)), append(slices.Clip(location), name), rest, open, visit)
+		if err != nil || !more {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// rewrapEntry rewraps every ciphertext of the secret or the legacy value stored under key in b.
+func rewrapEntry(b Bucket, key []byte, rewrapper Rewrapper, location []string) (int, error) {
+	rewrapped, err := resealEntry(b, key, rewrapper, location)
+	if err != nil {
+		return 0, err
+	}
+
+	secret := b.Bucket(key)
+	if !isSecretBucket(secret) {
+		return rewrapped, nil
+	}
+	versions := secret.Bucket(secretVersionsBucketName)
+	if versions == nil || secret.Bucket(secretChunksBucketName) == nil {
+		return rewrapped, nil
+	}
+
+	type fileVersion struct {
+		key   []byte
+		entry *versionEntry
+	}
+	var files []fileVersion
+	err = versions.ForEach(func(k, v []byte) error {
+		entry := &versionEntry{}
+		if err := json.Unmarshal(v, entry); err != nil {
+			return fmt.Errorf("error while decoding version: %w", err)
+		}
+		if entry.File != nil {
+			files = append(files, fileVersion{slices.Clone(k), entry})
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, version := range files {
+		chunks := fileChunks(secret, version.key, version.entry)
+		if chunks == nil {
+			continue
+		}
+
+		for index := 0; ; index++ {
+			chunk := chunks.Get(chunkKey(index))
+			if chunk == nil {
+				break
+			}
+
+			chunk, err := rewrapper.RewrapChunk(version.entry.File, index, chunk)
+			if err != nil {
+				return 0, err
+			}
+			if chunk == nil {
+				continue
+			}
+			if err := chunks.Put(chunkKey(index), chunk); err != nil {
+				return 0, err
+			}
+			rewrapped++
+		}
+	}
+	return rewrapped, nil
+}
+
+func (s *Storage) rewrapNames(tx Tx, progress *rewrapProgress, limit int, rewrapper Rewrapper) error {
+	if s.names == nil {
+		progress.Phase = models.RewrapPhaseDone
+		return nil
+	}
+
+	index, err := namesBucket(tx)
+	if err != nil {
+		return err
+	}
+
+	type sealedName struct {
+		blind  []byte
+		sealed []byte
+	}
+	var names []sealedName
+
+	c := index.Cursor()
+	k, v := c.First()
+	if len(progress.Cursor) > 0 {
+		k, v = c.Seek([]byte(progress.Cursor[0]))
+		if string(k) == progress.Cursor[0] {
+			k, v = c.Next()
+		}
+	}
+	for ; k != nil && len(names) < limit; k, v = c.Next() {
+		names = append(names, sealedName{slices.Clone(k), slices.Clone(v)})
+	}
+
+	for _, name := range names {
+		sealed, err := rewrapper.RewrapName(string(name.blind), name.sealed)
+		if err != nil {
+			return fmt.Errorf("error while rewrapping name %s: %w", name.blind, err)
+		}
+		if sealed == nil {
+			continue
+		}
+		if err := index.Put(name.blind, sealed); err != nil {
+			return err
+		}
+		progress.Rewrapped++
+	}
+	progress.Names += len(names)
+
+	if len(names) == limit {
+		progress.Cursor = []string{string(names[len(names)-1].blind)}
+	} else {
+		progress.Phase, progress.Cursor = models.RewrapPhaseDone, nil
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/liriquew/secret_storage/server/internal/lib/config"
+	"github.com/liriquew/secret_storage/server/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testRewrapper rewraps ciphertexts prefixed with "old:" into "new:" ones
+// and passes sealed names as they are.
+type testRewrapper struct {
+	names int
+}
+
+func (testRewrapper) rewrap(ciphertext []byte) []byte {
+	if rest, ok := bytes.CutPrefix(ciphertext, []byte("old:")); ok {
+		return append([]byte("new:"), rest...)
+	}
+	return nil
+}
+
+func (r *testRewrapper) ResealValue(from, to []string, value []byte) ([]byte, error) {
+	if strings.Join(from, "/") != strings.Join(to, "/") {
+		return nil, fmt.Errorf("value is moved from %v to %v", from, to)
+	}
+	return r.rewrap(value), nil
+}
+
+func (r *testRewrapper) ResealFile(from, to []string, file *models.FileManifest) (*models.FileManifest, error) {
+	digest := r.rewrap(file.Digest)
+	if digest == nil {
+		return nil, nil
+	}
+	rewrapped := *file
+	rewrapped.Digest = digest
+	return &rewrapped, nil
+}
+
+func (r *testRewrapper) RewrapChunk(file *models.FileManifest, index int, chunk []byte) ([]byte, error) {
+	return r.rewrap(chunk), nil
+}
+
+func (r *testRewrapper) RewrapName(blind string, sealed []byte) ([]byte, error) {
+	r.names++
+	return sealed, nil
+}
+
+func TestRewrap(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, cfg config.StorageConfig) {
+		s := newTestStorage(t, cfg)
+		require.NoError(t, s.BlindNames(testNameCodec{}))
+
+		set := func(path []string, key string, value string) {
+			_, err := s.SetRecord(path, key, []byte(value), models.WriteOptions{})
+			require.NoError(t, err)
+		}
+		set([]string{"alice", "app"}, "a", "old:one")
+		set([]string{"alice", "app"}, "a", "old:two")
+		set([]string{"alice", "app", "db"}, "b", "new:fresh")
+		set([]string{"alice", "other"}, "c", "old:three")
+		set([]string{"bob"}, "d", "old:four")
+		file := &models.FileManifest{ID: []byte("id"), Size: 2, ChunkSize: 1, Chunks: 2, Digest: []byte("old:digest")}
+		_, err := setTestFile(t, s, []string{"bob"}, "file", file, [][]byte{[]byte("old:x"), []byte("old:y")}, models.WriteOptions{})
+		require.NoError(t, err)
+
+		value := func(path []string, key string, version int) string {
+			record, err := s.GetRecord(path, key, version)
+			require.NoError(t, err)
+			if record.File != nil {
+				return string(record.File.Digest)
+			}
+			return string(record.Value)
+		}
+
+		rewrapper := &testRewrapper{}
+		t.Run("Batches", func(t *testing.T) {
+			rewrapped, err := s.Rewrap(2, 2, rewrapper)
+			require.NoError(t, err)
+			// secrets are walked in the order of their blind indexes, a has 2 versions
+			assert.Contains(t, []int{2, 3}, rewrapped)
+
+			status, err := s.RewrapStatus()
+			require.NoError(t, err)
+			assert.Equal(t, 2, status.Term)
+			assert.Equal(t, models.RewrapPhaseSecrets, status.Phase)
+			assert.Equal(t, 2, status.Secrets)
+			assert.Equal(t, rewrapped, status.Rewrapped)
+
+			for range 10 {
+				_, err := s.Rewrap(2, 2, rewrapper)
+				require.NoError(t, err)
+			}
+
+			status, err = s.RewrapStatus()
+			require.NoError(t, err)
+			assert.Equal(t, models.RewrapPhaseDone, status.Phase)
+			assert.Equal(t, 5, status.Secrets)
+			// 2 versions of a, c, d, the digest and the chunks of the file
+			assert.Equal(t, 2+1+1+1+2+status.Names, status.Rewrapped)
+			assert.Equal(t, rewrapper.names, status.Names)
+			assert.NotNil(t, status.FinishedAt)
+
+			assert.Equal(t, "new:one", value([]string{"alice", "app"}, "a", 1))
+			assert.Equal(t, "new:two", value([]string{"alice", "app"}, "a", 2))
+			assert.Equal(t, "new:fresh", value([]string{"alice", "app", "db"}, "b", 0))
+			assert.Equal(t, "new:three", value([]string{"alice", "other"}, "c", 0))
+			assert.Equal(t, "new:four", value([]string{"bob"}, "d", 0))
+			assert.Equal(t, "new:digest", value([]string{"bob"}, "file", 0))
+			for index, want := range []string{"new:x", "new:y"} {
+				chunk, err := s.GetFileChunk([]string{"bob"}, "file", 1, index)
+				require.NoError(t, err)
+				assert.Equal(t, want, string(chunk))
+			}
+		})
+
+		t.Run("Finished Pass", func(t *testing.T) {
+			rewrapped, err := s.Rewrap(2, 2, rewrapper)
+			require.NoError(t, err)
+			assert.Equal(t, 0, rewrapped)
+		})
+
+		t.Run("Next Term", func(t *testing.T) {
+			set([]string{"alice", "app"}, "e", "old:five")
+
+			_, err := s.Rewrap(3, 100, rewrapper)
+			require.NoError(t, err)
+
+			status, err := s.RewrapStatus()
+			require.NoError(t, err)
+			assert.Equal(t, 3, status.Term)
+			assert.Equal(t, models.RewrapPhaseNames, status.Phase)
+			assert.Equal(t, 6, status.Secrets)
+			assert.Equal(t, 1, status.Rewrapped)
+			assert.Equal(t, "new:five", value([]string{"alice", "app"}, "e", 0))
+		})
+	})
+}
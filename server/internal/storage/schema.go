@@ -0,0 +1,215 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/liriquew/secret_storage/server/internal/lib/config"
+	"github.com/liriquew/secret_storage/server/internal/models"
+)
+
+// The version of the layout is kept in the meta bucket:
+//
+//	meta/schema_version - uint64
+//
+// Databases without it were created before the layout was versioned,
+// they are migrated from version 0.
+var metaSchemaVersionKey = []byte("schema_version")
+
+var (
+	ErrSchemaTooNew = errors.New("database schema is newer than supported")
+	// ErrSchemaOutdated is returned by OpenReadOnly, it never migrates the database
+	ErrSchemaOutdated = errors.New("schema needs migration")
+	errDryRun         = errors.New("dry run")
+)
+
+type migration struct {
+	models.Migration
+	migrate func(tx Tx) error
+}
+
+// migrations are ordered by version, a change of the layout is added
+// to the end of the list and must never change once released.
+var migrations = []migration{
+	{models.Migration{Version: 1, Description: "create top-level buckets"}, createTopBuckets},
+	{models.Migration{Version: 2, Description: "convert legacy plain values into versioned secrets"}, convertLegacyValues},
+	{models.Migration{Version: 3, Description: "count usage of users"}, recountUsage},
+	{models.Migration{Version: 4, Description: "stop counting deleted secrets in usage"}, recountLiveUsage},
+}
+
+// SchemaVersion is the layout version written by this server.
+func SchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// fileCopier is implemented by backends keeping the database in a file,
+// the copy is taken before migrations.
+type fileCopier interface {
+	CopyFile(path string) error
+}
+
+// Migrate brings the database described by the config to the current schema,
+// the database must not be opened. With dryRun the migrations are applied
+// and rolled back, so the report shows whether they would succeed.
+func Migrate(cfg config.StorageConfig, dryRun bool) (*models.MigrationReport, error) {
+	db, err := openBackend(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	return migrate(db, cfg, dryRun)
+}
+
+// migrate applies pending migrations in one transaction. A database
+// with data is copied next to its file before it is changed.
+func migrate(db Backend, cfg config.StorageConfig, dryRun bool) (*models.MigrationReport, error) {
+	report := &models.MigrationReport{
+		To:         SchemaVersion(),
+		Migrations: []*models.Migration{},
+		DryRun:     dryRun,
+	}
+
+	var fresh bool
+	err := db.View(func(tx Tx) error {
+		var err error
+		report.From, err = readSchemaVersion(tx)
+		fresh = tx.Bucket(recordsBucketName) == nil && tx.Bucket(userBucketName) == nil && tx.Bucket(metaBucketName) == nil
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if report.From > report.To {
+		return nil, fmt.Errorf("%w: database - %d, server - %d", ErrSchemaTooNew, report.From, report.To)
+	}
+	if report.From == report.To {
+		return report, nil
+	}
+
+	var pending []migration
+	for _, m := range migrations {
+		if m.Version > report.From {
+			pending = append(pending, m)
+			report.Migrations = append(report.Migrations, &m.Migration)
+		}
+	}
+
+	if copier, ok := db.(fileCopier); ok && !fresh && !dryRun {
+		report.Backup = fmt.Sprintf("%s.v%d.%s.bak", cfg.Path, report.From, time.Now().UTC().Format("20060102T150405Z"))
+		if err := copier.CopyFile(report.Backup); err != nil {
+			return nil, fmt.Errorf("error while copying database before migration: %w", err)
+		}
+	}
+
+	err = db.Update(func(tx Tx) error {
+		for _, m := range pending {
+			if err := m.migrate(tx); err != nil {
+				return fmt.Errorf("error while migrating to version %d: %w", m.Version, err)
+			}
+		}
+		if err := writeSchemaVersion(tx, report.To); err != nil {
+			return err
+		}
+
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err != nil && !(dryRun && errors.Is(err, errDryRun)) {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+func readSchemaVersion(tx Tx) (int, error) {
+	meta := tx.Bucket(metaBucketName)
+	if meta == nil {
+		return 0, nil
+	}
+
+	raw := meta.Get(metaSchemaVersionKey)
+	if raw == nil {
+		return 0, nil
+	}
+	if len(raw) != 8 {
+		return 0, fmt.Errorf("invalid schema version of %d bytes", len(raw))
+	}
+	return int(binary.BigEndian.Uint64(raw)), nil
+}
+
+func writeSchemaVersion(tx Tx, version int) error {
+	meta := tx.Bucket(metaBucketName)
+	if meta == nil {
+		return ErrFailedToOpenTopBucket
+	}
+	return meta.Put(metaSchemaVersionKey, binary.BigEndian.AppendUint64(nil, uint64(version)))
+}
+
+func createTopBuckets(tx Tx) error {
+	for _, name := range [][]byte{recordsBucketName, userBucketName, metaBucketName} {
+		if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// convertLegacyValues turns plain values written before versioning into secrets
+// with a single version, the same way a write to them does.
+func convertLegacyValues(tx Tx) error {
+	var convert func(b Bucket) error
+	convert = func(b Bucket) error {
+		var legacy []string
+		var nested [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			if v != nil {
+				legacy = append(legacy, string(k))
+			} else if !isSecretBucket(b.Bucket(k)) {
+				nested = append(nested, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, key := range legacy {
+			if _, err := openSecret(b, key, true); err != nil {
+				return err
+			}
+		}
+		for _, k := range nested {
+			if err := convert(b.Bucket(k)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	top := tx.Bucket(recordsBucketName)
+
+	// namespaces of users are collected first, they change while converted
+	var namespaces [][]byte
+	err := top.ForEach(func(k, v []byte) error {
+		if v == nil {
+			namespaces = append(namespaces, append([]byte(nil), k...))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, namespace := range namespaces {
+		if err := convert(top.Bucket(namespace)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
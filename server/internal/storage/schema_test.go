@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/liriquew/secret_storage/server/internal/lib/config"
+	"github.com/liriquew/secret_storage/server/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrate(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, cfg config.StorageConfig) {
+		if cfg.Type == "memory" {
+			t.Skip("memory backend doesn't keep the database between opens")
+		}
+
+		// a database written before the layout was versioned
+		db, err := openBackend(cfg)
+		require.NoError(t, err)
+		require.NoError(t, db.Update(func(tx Tx) error {
+			kv, err := tx.CreateBucketIfNotExists(recordsBucketName)
+			require.NoError(t, err)
+			alice, err := kv.CreateBucket([]byte("alice"))
+			require.NoError(t, err)
+			app, err := alice.CreateBucket([]byte("app"))
+			require.NoError(t, err)
+			return app.Put([]byte("token"), []byte("value"))
+		}))
+		require.NoError(t, db.Close())
+
+		t.Run("Dry Run", func(t *testing.T) {
+			report, err := Migrate(cfg, true)
+			require.NoError(t, err)
+			assert.Equal(t, 0, report.From)
+			assert.Equal(t, SchemaVersion(), report.To)
+			assert.Len(t, report.Migrations, SchemaVersion())
+			assert.Empty(t, report.Backup)
+
+			report, err = Migrate(cfg, true)
+			require.NoError(t, err)
+			assert.Equal(t, 0, report.From)
+		})
+
+		t.Run("Migrate", func(t *testing.T) {
+			report, err := Migrate(cfg, false)
+			require.NoError(t, err)
+			assert.Equal(t, 0, report.From)
+			require.NotEmpty(t, report.Backup)
+			_, err = os.Stat(report.Backup)
+			require.NoError(t, err)
+
+			s := newTestStorage(t, cfg)
+			assert.Empty(t, s.Migration().Migrations)
+
+			require.NoError(t, s.db.View(func(tx Tx) error {
+				app, err := openBucketByPath([]string{"alice", "app"}, tx.Bucket(recordsBucketName))
+				require.NoError(t, err)
+				assert.True(t, isSecretBucket(app.Bucket([]byte("token"))))
+				return nil
+			}))
+
+			record, err := s.GetRecord([]string{"alice", "app"}, "token", 1)
+			require.NoError(t, err)
+			assert.Equal(t, "value", string(record.Value))
+
+			status, err := s.Usage("alice")
+			require.NoError(t, err)
+			assert.Equal(t, models.Usage{Secrets: 1, Bytes: 5}, status.Usage)
+		})
+
+		t.Run("Newer Schema", func(t *testing.T) {
+			db, err := openBackend(cfg)
+			require.NoError(t, err)
+			require.NoError(t, db.Update(func(tx Tx) error {
+				return writeSchemaVersion(tx, SchemaVersion()+1)
+			}))
+			require.NoError(t, db.Close())
+
+			_, err = New(cfg)
+			assert.ErrorIs(t, err, ErrSchemaTooNew)
+		})
+	})
+}
+
+func TestMigrateFreshDatabase(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, cfg config.StorageConfig) {
+		s := newTestStorage(t, cfg)
+
+		report := s.Migration()
+		assert.Equal(t, 0, report.From)
+		assert.Equal(t, SchemaVersion(), report.To)
+		// there is nothing to lose in a new database
+		assert.Empty(t, report.Backup)
+	})
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, cfg config.StorageConfig) {
+		if cfg.Type == "memory" {
+			t.Skip("memory backend doesn't keep the database between opens")
+		}
+
+		db, err := openBackend(cfg)
+		require.NoError(t, err)
+		require.NoError(t, db.Update(func(tx Tx) error {
+			kv, err := tx.CreateBucketIfNotExists(recordsBucketName)
+			require.NoError(t, err)
+			alice, err := kv.CreateBucket([]byte("alice"))
+			require.NoError(t, err)
+			return alice.Put([]byte("token"), []byte("value"))
+		}))
+		require.NoError(t, db.Close())
+
+		checksum := func() [sha256.Size]byte {
+			raw, err := os.ReadFile(cfg.Path)
+			require.NoError(t, err)
+			return sha256.Sum256(raw)
+		}
+
+		t.Run("Outdated Schema", func(t *testing.T) {
+			before := checksum()
+			_, err := OpenReadOnly(cfg)
+			assert.ErrorIs(t, err, ErrSchemaOutdated)
+			assert.Equal(t, before, checksum())
+
+			backups, err := filepath.Glob(cfg.Path + ".*.bak")
+			require.NoError(t, err)
+			assert.Empty(t, backups)
+		})
+
+		t.Run("Read", func(t *testing.T) {
+			_, err := Migrate(cfg, false)
+			require.NoError(t, err)
+			before := checksum()
+
+			s, err := OpenReadOnly(cfg)
+			require.NoError(t, err)
+
+			record, err := s.GetRecord([]string{"alice"}, "token", 0)
+			require.NoError(t, err)
+			assert.Equal(t, "value", string(record.Value))
+
+			_, err = s.SetRecord([]string{"alice"}, "token", []byte("changed"), models.WriteOptions{})
+			assert.Error(t, err)
+
+			require.NoError(t, s.Close())
+			assert.Equal(t, before, checksum())
+		})
+	})
+}
@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/liriquew/secret_storage/server/internal/models"
+)
+
+var ErrBadSearchPattern = errors.New("bad search pattern")
+
+type searchMatcher struct {
+	pattern []string
+	prefix  string
+}
+
+func newSearchMatcher(query *models.SearchQuery) (*searchMatcher, error) {
+	m := &searchMatcher{prefix: strings.TrimPrefix(query.Prefix, "/")}
+	if query.Pattern == "" {
+		return m, nil
+	}
+
+	m.pattern = strings.Split(strings.Trim(query.Pattern, "/"), "/")
+	for _, part := range m.pattern {
+		if _, err := path.Match(part, ""); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrBadSearchPattern, query.Pattern)
+		}
+	}
+
+	return m, nil
+}
+
+func (m *searchMatcher) match(parts []string) bool {
+	if !strings.HasPrefix(strings.Join(parts, "/"), m.prefix) {
+		return false
+	}
+	return m.pattern == nil || matchGlob(m.pattern, parts)
+}
+
+// mayContain reports whether the bucket at parts can hold matching paths.
+func (m *searchMatcher) mayContain(parts []string) bool {
+	joined := strings.Join(parts, "/")
+	return strings.HasPrefix(joined, m.prefix) || strings.HasPrefix(m.prefix, joined+"/")
+}
+
+func matchGlob(pattern, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(parts); i++ {
+				if matchGlob(pattern[1:], parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(parts) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], parts[0]); !ok {
+			return false
+		}
+		pattern, parts = pattern[1:], parts[1:]
+	}
+
+	return len(parts) == 0
+}
+
+// Search walks the buckets under path and returns buckets and live secrets
+// matching the query, values are returned only if the query asks for them.
+func (s *Storage) Search(path []string, query *models.SearchQuery) ([]*models.SearchMatch, error) {
+	matcher, err := newSearchMatcher(query)
+	if err != nil {
+		return nil, err
+	}
+
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	var matches []*models.SearchMatch
+	err = s.db.View(func(tx Tx) error {
+		b := tx.Bucket(recordsBucketName)
+		if b == nil {
+			return ErrFailedToOpenTopBucket
+		}
+
+		b, err := openBucketByPath(s.names.blindPath(path), b)
+		if err != nil {
+			return err
+		}
+		open := s.names.opener(tx)
+
+		var walk func(b Bucket, parts []string) error
+		walk = func(b Bucket, parts []string) error {
+			c := b.Cursor()
+			for k, v := c.First(); k != nil; k, v = c.Next() {
+				realName, err := open(k)
+				if err != nil {
+					return err
+				}
+				name := append(parts[:len(parts):len(parts)], realName)
+
+				if v == nil && !isSecretBucket(b.Bucket(k)) {
+					if !matcher.mayContain(name) {
+						continue
+					}
+					if matcher.match(name) {
+						matches = append(matches, &models.SearchMatch{Path: strings.Join(name, "/"), Bucket: true})
+					}
+					if err := walk(b.Bucket(k), name); err != nil {
+						return err
+					}
+					continue
+				}
+
+				if !matcher.match(name) {
+					continue
+				}
+
+				record, err := readRecord(b, string(k), 0)
+				if errors.Is(err, ErrRecordNotFound) {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+
+				match := &models.SearchMatch{Path: strings.Join(name, "/")}
+				if query.WithValues {
+					record.Key = []byte(realName)
+					match.Record = record
+				}
+				matches = append(matches, match)
+			}
+			return nil
+		}
+
+		return walk(b, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if s.names != nil {
+		// blind indexes are walked in no particular order, matches are
+		// sorted the way plain names are walked
+		slices.SortFunc(matches, func(a, b *models.SearchMatch) int {
+			return slices.Compare(strings.Split(a.Path, "/"), strings.Split(b.Path, "/"))
+		})
+	}
+
+	return matches, nil
+}
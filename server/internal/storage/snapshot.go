@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/liriquew/secret_storage/server/internal/lib/config"
+)
+
+var (
+	ErrSnapshotUnsupported = errors.New("storage backend doesn't support snapshots")
+	ErrInvalidSnapshot     = errors.New("invalid snapshot")
+)
+
+// Snapshotter is implemented by backends able to dump a consistent copy of the whole database.
+type Snapshotter interface {
+	// Snapshot calls fn with a read-only view of the database,
+	// every WriteTo of it writes out the same bytes.
+	Snapshot(fn func(io.WriterTo) error) error
+}
+
+// snapshotRestorers replace the database described by the config with a snapshot,
+// the database must not be opened.
+var snapshotRestorers = map[string]func(cfg config.StorageConfig, r io.Reader) error{
+	"bbolt": restoreBoltSnapshot,
+}
+
+// Snapshot doesn't block writers, the snapshot is taken from a read transaction.
+// In HA mode the local copy of the replicated database is dumped.
+func (s *Storage) Snapshot(fn func(io.WriterTo) error) error {
+	db := s.db
+	if replicated, ok := db.(*raftBackend); ok {
+		db = replicated.local
+	}
+
+	snapshotter, ok := db.(Snapshotter)
+	if !ok {
+		return ErrSnapshotUnsupported
+	}
+
+	return snapshotter.Snapshot(fn)
+}
+
+// Restore validates the snapshot read from r and swaps it in place of the database.
+func Restore(cfg config.StorageConfig, r io.Reader) error {
+	if cfg.HA.Enabled {
+		// the restored node would diverge from the rest of the cluster
+		return fmt.Errorf("%w: HA is enabled", ErrSnapshotUnsupported)
+	}
+
+	backendType := cfg.Type
+	if backendType == "" {
+		backendType = DefaultBackend
+	}
+
+	restore, ok := snapshotRestorers[backendType]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrSnapshotUnsupported, backendType)
+	}
+
+	return restore(cfg, r)
+}
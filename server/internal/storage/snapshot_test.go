@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/liriquew/secret_storage/server/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	source := newTestStorage(t, conformanceConfig(t, "bbolt"))
+	_, err := source.SetRecord([]string{"alice", "a"}, "key", []byte("value"), models.WriteOptions{})
+	require.NoError(t, err)
+
+	var snapshot, again bytes.Buffer
+	err = source.Snapshot(func(tx io.WriterTo) error {
+		if _, err := tx.WriteTo(&snapshot); err != nil {
+			return err
+		}
+		_, err := tx.WriteTo(&again)
+		return err
+	})
+	require.NoError(t, err)
+	assert.Equal(t, snapshot.Bytes(), again.Bytes())
+
+	cfg := conformanceConfig(t, "bbolt")
+	require.NoError(t, os.WriteFile(cfg.Path, []byte("old database"), 0600))
+
+	t.Run("Invalid Snapshot", func(t *testing.T) {
+		err := Restore(cfg, bytes.NewReader([]byte("garbage")))
+		assert.ErrorIs(t, err, ErrInvalidSnapshot)
+
+		// the database is left intact
+		old, err := os.ReadFile(cfg.Path)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("old database"), old)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		require.NoError(t, Restore(cfg, bytes.NewReader(snapshot.Bytes())))
+
+		target := newTestStorage(t, cfg)
+		record, err := target.GetRecord([]string{"alice", "a"}, "key", 0)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("value"), record.Value)
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		memory := newTestStorage(t, conformanceConfig(t, "memory"))
+		err := memory.Snapshot(func(io.WriterTo) error { return nil })
+		assert.ErrorIs(t, err, ErrSnapshotUnsupported)
+
+		err = Restore(conformanceConfig(t, "memory"), bytes.NewReader(snapshot.Bytes()))
+		assert.ErrorIs(t, err, ErrSnapshotUnsupported)
+	})
+}
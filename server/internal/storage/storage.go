@@ -1,40 +1,141 @@
 package storage
 
 import (
+	"bytes"
+	"fmt"
 	"sync"
-	"time"
+	"sync/atomic"
 
 	"github.com/liriquew/secret_storage/server/internal/lib/config"
-	bolt "go.etcd.io/bbolt"
+	"github.com/liriquew/secret_storage/server/internal/models"
 )
 
 type Storage struct {
-	db *bolt.DB
+	db Backend
 	m  sync.RWMutex
+
+	maxVersions int
+	quotas      *quotas
+	// names is set if names are stored as blind indexes
+	names *nameIndex
+	// migration is the report of migrations applied on open
+	migration *models.MigrationReport
+	// onChange is called with changes of secrets committed by writes
+	onChange func([]*models.ChangeEvent)
+	// keyring caches the wrapped keyring, it's read without transactions
+	keyring atomic.Pointer[[]byte]
+	// legacyResealed caches the mark of a database without legacy values
+	legacyResealed atomic.Bool
 }
 
 func New(cfg config.StorageConfig) (*Storage, error) {
-	db, err := bolt.Open(cfg.Path, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	db, err := openBackend(cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	err = db.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists(recordsBucketName)
-		if err == nil {
-			_, err = tx.CreateBucketIfNotExists(userBucketName)
-		}
-		if err == nil {
-			_, err = tx.CreateBucketIfNotExists(metaBucketName)
+	migration, err := migrate(db, cfg, false)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	s := &Storage{
+		db:          db,
+		m:           sync.RWMutex{},
+		maxVersions: cfg.MaxVersions,
+		quotas:      newQuotas(cfg),
+		migration:   migration,
+	}
+	if err := s.loadMeta(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if cfg.HA.Enabled {
+		// every node migrates its local database,
+		// only writes made after this point are replicated
+		replicated, err := newRaftBackend(db, cfg.HA, s.cacheMeta)
+		if err != nil {
+			db.Close()
+			return nil, err
 		}
+		s.db = replicated
+	}
+
+	return s, nil
+}
+
+// OpenReadOnly opens the database described by the config without changing it,
+// it's used by offline checks. The database isn't migrated, so it must be of the
+// current schema, and the node never joins the HA cluster. Writes fail.
+func OpenReadOnly(cfg config.StorageConfig) (*Storage, error) {
+	cfg.ReadOnly = true
+	db, err := openBackend(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var version int
+	err = db.View(func(tx Tx) error {
+		version, err = readSchemaVersion(tx)
 		return err
 	})
-
+	if err == nil && version > SchemaVersion() {
+		err = fmt.Errorf("%w: database - %d, server - %d", ErrSchemaTooNew, version, SchemaVersion())
+	}
+	if err == nil && version < SchemaVersion() {
+		err = fmt.Errorf("%w: database - %d, server - %d", ErrSchemaOutdated, version, SchemaVersion())
+	}
 	if err != nil {
+		db.Close()
 		return nil, err
 	}
 
-	return &Storage{db, sync.RWMutex{}}, nil
+	s := &Storage{
+		db:          db,
+		m:           sync.RWMutex{},
+		maxVersions: cfg.MaxVersions,
+		quotas:      newQuotas(cfg),
+		migration:   &models.MigrationReport{From: version, To: version, Migrations: []*models.Migration{}},
+	}
+	if err := s.loadMeta(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// loadMeta caches the meta values read without transactions.
+func (s *Storage) loadMeta() error {
+	return s.db.View(func(tx Tx) error {
+		meta := tx.Bucket(metaBucketName)
+		if meta == nil {
+			return ErrFailedToOpenTopBucket
+		}
+
+		for _, key := range [][]byte{metaKeyringKey, metaResealedKey} {
+			if value := meta.Get(key); value != nil {
+				s.cacheMeta(key, value)
+			}
+		}
+		return nil
+	})
+}
+
+// cacheMeta updates the cache of the meta value of key, other values aren't cached.
+func (s *Storage) cacheMeta(key, value []byte) {
+	switch {
+	case bytes.Equal(key, metaKeyringKey):
+		s.cacheKeyring(value)
+	case bytes.Equal(key, metaResealedKey):
+		s.legacyResealed.Store(true)
+	}
+}
+
+// Migration reports the migrations applied when the storage was opened.
+func (s *Storage) Migration() *models.MigrationReport {
+	return s.migration
 }
 
 func (s *Storage) Close() error {
@@ -42,3 +143,12 @@ func (s *Storage) Close() error {
 	defer s.m.Unlock()
 	return s.db.Close()
 }
+
+// HAStatus reports the state of the raft cluster, if HA is disabled
+// the node is always the leader.
+func (s *Storage) HAStatus() *models.HAStatus {
+	if replicated, ok := s.db.(*raftBackend); ok {
+		return replicated.Status()
+	}
+	return &models.HAStatus{Enabled: false}
+}
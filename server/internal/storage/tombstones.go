@@ -0,0 +1,283 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/liriquew/secret_storage/server/internal/models"
+)
+
+// purgeBatchSize bounds the number of records removed in one transaction,
+// so a large purge doesn't block writers for long.
+const purgeBatchSize = 100
+
+type recordRef struct {
+	path []string
+	key  string
+}
+
+// walkSecrets calls fn for every secret bucket under b, path is the path of b.
+// Legacy plain values are skipped.
+func walkSecrets(b Bucket, path []string, fn func(path []string, key string, secret Bucket) error) error {
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if v != nil {
+			continue
+		}
+
+		sub := b.Bucket(k)
+		if isSecretBucket(sub) {
+			if err := fn(path, string(k), sub); err != nil {
+				return err
+			}
+			continue
+		}
+
+		subPath := append(append(make([]string, 0, len(path)+1), path...), string(k))
+		if err := walkSecrets(sub, subPath, fn); err != nil {
+			return fmt.Errorf("%w: bucket name - %s, err - %w", ErrIteratingBucket, string(k), err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Storage) SoftDelete(path []string, key string) (time.Time, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	path, key = s.names.blindLocation(path, key)
+
+	deletedAt := time.Now().UTC()
+	var changes *changeLog
+	err := s.db.Update(func(tx Tx) error {
+		b := tx.Bucket(recordsBucketName)
+		if b == nil {
+			return ErrFailedToOpenTopBucket
+		}
+
+		usage := s.newUsageTracker()
+		changes = s.newChangeLog()
+		if err := softDeleteRecord(b, path, key, deletedAt, usage, changes); err != nil {
+			return err
+		}
+		if err := usage.commit(tx); err != nil {
+			return err
+		}
+		return changes.resolve(tx, s.names)
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	s.publish(changes)
+	return deletedAt, nil
+}
+
+// softDeleteRecord marks the record as deleted at the moment deletedAt,
+// the record stops counting against the quota.
+func softDeleteRecord(b Bucket, path []string, key string, deletedAt time.Time, usage *usageTracker, changes *changeLog) error {
+	pathBucket, err := openBucketByPath(path, b)
+	if err != nil {
+		return err
+	}
+
+	before, err := entryUsage(pathBucket, []byte(key))
+	if err != nil {
+		return err
+	}
+
+	secret, err := openSecret(pathBucket, key, false)
+	if err != nil {
+		return err
+	}
+	if secret == nil {
+		// legacy plain value has no header to keep the tombstone in
+		if secret, err = openSecret(pathBucket, key, true); err != nil {
+			return err
+		}
+	}
+
+	header, err := readHeader(secret)
+	if err != nil {
+		return err
+	}
+	if header.gone(deletedAt) {
+		return ErrRecordNotFound
+	}
+
+	header.DeletedAt = &deletedAt
+	usage.track(path, before, models.Usage{})
+	changes.add(models.ChangeDelete, path, key, header.CurrentVersion)
+	return writeHeader(secret, header)
+}
+
+func (s *Storage) Undelete(path []string, key string) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	path, key = s.names.blindLocation(path, key)
+
+	var changes *changeLog
+	err := s.db.Update(func(tx Tx) error {
+		b := tx.Bucket(recordsBucketName)
+		if b == nil {
+			return ErrFailedToOpenTopBucket
+		}
+
+		b, err := openBucketByPath(path, b)
+		if err != nil {
+			return err
+		}
+
+		secret, err := openSecret(b, key, false)
+		if err != nil {
+			return err
+		}
+		if secret == nil {
+			// legacy records can't be deleted softly
+			return ErrRecordNotFound
+		}
+
+		header, err := readHeader(secret)
+		if err != nil {
+			return err
+		}
+		if header.DeletedAt == nil {
+			return ErrRecordNotFound
+		}
+
+		header.DeletedAt = nil
+		if err := writeHeader(secret, header); err != nil {
+			return err
+		}
+
+		// the restored secret counts against the quota again
+		after, err := secretUsage(secret)
+		if err != nil {
+			return err
+		}
+		usage := s.newUsageTracker()
+		usage.track(path, models.Usage{}, after)
+		if err := usage.commit(tx); err != nil {
+			return err
+		}
+
+		// the restored secret appears again for watchers
+		changes = s.newChangeLog()
+		changes.add(models.ChangeCreate, path, key, header.CurrentVersion)
+		return changes.resolve(tx, s.names)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.publish(changes)
+	return nil
+}
+
+// PurgeDeleted removes records deleted before the given moment
+// and returns the number of removed records.
+func (s *Storage) PurgeDeleted(before time.Time) (int, error) {
+	var expired []recordRef
+
+	s.m.RLock()
+	err := s.db.View(func(tx Tx) error {
+		b := tx.Bucket(recordsBucketName)
+		if b == nil {
+			return ErrFailedToOpenTopBucket
+		}
+
+		return walkSecrets(b, nil, func(path []string, key string, secret Bucket) error {
+			header, err := readHeader(secret)
+			if err != nil {
+				return err
+			}
+			if header.DeletedAt != nil && header.DeletedAt.Before(before) {
+				expired = append(expired, recordRef{path, key})
+			}
+			return nil
+		})
+	})
+	s.m.RUnlock()
+	if err != nil {
+		return 0, err
+	}
+
+	return s.deleteRecordsInBatches(expired, func(secret Bucket) (bool, error) {
+		header, err := readHeader(secret)
+		if err != nil {
+			return false, err
+		}
+		return header.DeletedAt != nil && header.DeletedAt.Before(before), nil
+	})
+}
+
+// deleteRecordsInBatches removes refs in transactions of at most purgeBatchSize records.
+// Every record is checked again with stillMatches, because it could be changed
+// since it was collected.
+func (s *Storage) deleteRecordsInBatches(refs []recordRef, stillMatches func(Bucket) (bool, error)) (int, error) {
+	deleted := 0
+	for start := 0; start < len(refs); start += purgeBatchSize {
+		batch := refs[start:min(start+purgeBatchSize, len(refs))]
+
+		batchDeleted := 0
+		var changes *changeLog
+
+		s.m.Lock()
+		err := s.db.Update(func(tx Tx) error {
+			usage := s.newUsageTracker()
+			changes = s.newChangeLog()
+			refs := s.newNameRefs()
+
+			top := tx.Bucket(recordsBucketName)
+			if top == nil {
+				return ErrFailedToOpenTopBucket
+			}
+
+			for _, ref := range batch {
+				b, err := openBucketByPath(ref.path, top)
+				if err != nil {
+					continue
+				}
+
+				secret := b.Bucket([]byte(ref.key))
+				if !isSecretBucket(secret) {
+					continue
+				}
+
+				matches, err := stillMatches(secret)
+				if err != nil {
+					return err
+				}
+				if !matches {
+					continue
+				}
+
+				if _, err := deleteRecord(top, ref.path, ref.key, usage, changes, refs); err != nil {
+					return err
+				}
+				batchDeleted++
+			}
+
+			if err := usage.commit(tx); err != nil {
+				return err
+			}
+			if err := changes.resolve(tx, s.names); err != nil {
+				return err
+			}
+			return refs.commit(tx)
+		})
+		if err == nil {
+			s.publish(changes)
+		}
+		s.m.Unlock()
+		if err != nil {
+			return deleted, err
+		}
+
+		deleted += batchDeleted
+	}
+
+	return deleted, nil
+}
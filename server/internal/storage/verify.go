@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/liriquew/secret_storage/server/internal/models"
+)
+
+// StoredValue is a version of a secret passed by WalkValues.
+type StoredValue struct {
+	// Location is the real path of the secret ending with its key
+	Location []string
+	Version  int
+	// Current is set for the current version of the secret
+	Current bool
+	Value   []byte
+	File    *models.FileManifest
+	// Chunk returns the chunk of the file or nil if it is missing,
+	// it is valid only until the visit returns
+	Chunk func(index int) []byte
+}
+
+// WalkValues calls visit for every version of every secret in one read transaction.
+// Entries which can't be read are passed to report and skipped, so a damaged
+// database is walked to the end.
+func (s *Storage) WalkValues(visit func(*StoredValue) error, report func(*models.VerifyIssue)) error {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	return s.db.View(func(tx Tx) error {
+		b := tx.Bucket(recordsBucketName)
+		if b == nil {
+			return ErrFailedToOpenTopBucket
+		}
+		return walkValues(b, s.names.opener(tx), visit, report)
+	})
+}
+
+// walkValues is WalkValues for the records bucket b, open translates stored names.
+func walkValues(b Bucket, open func(stored []byte) (string, error), visit func(*StoredValue) error, report func(*models.VerifyIssue)) error {
+	var walk func(b Bucket, location []string) error
+	walk = func(b Bucket, location []string) error {
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			name, err := open(k)
+			if err != nil {
+				report(&models.VerifyIssue{
+					Path:    strings.Join(append(slices.Clip(location), string(k)), "/"),
+					Problem: models.ProblemUnknownName,
+					Error:   err.Error(),
+				})
+				continue
+			}
+			entryLocation := append(slices.Clip(location), name)
+
+			if v != nil {
+				// legacy plain value
+				err := visit(&StoredValue{Location: entryLocation, Version: 1, Current: true, Value: v})
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			nested := b.Bucket(k)
+			if !isSecretBucket(nested) {
+				if err := walk(nested, entryLocation); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if err := walkSecretValues(nested, entryLocation, visit, report); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return walk(b, nil)
+}
+
+func walkSecretValues(secret Bucket, location []string, visit func(*StoredValue) error, report func(*models.VerifyIssue)) error {
+	path := strings.Join(location, "/")
+
+	header, err := readHeader(secret)
+	if err != nil {
+		report(&models.VerifyIssue{Path: path, Problem: models.ProblemBadHeader, Error: err.Error()})
+		return nil
+	}
+
+	versions := secret.Bucket(secretVersionsBucketName)
+	if versions == nil || versions.Get(versionKey(header.CurrentVersion)) == nil {
+		report(&models.VerifyIssue{
+			Path:    path,
+			Version: header.CurrentVersion,
+			Problem: models.ProblemMissingVersion,
+		})
+	}
+	if versions == nil {
+		return nil
+	}
+
+	return versions.ForEach(func(k, raw []byte) error {
+		version := int(binary.BigEndian.Uint64(k))
+
+		entry := &versionEntry{}
+		if err := json.Unmarshal(raw, entry); err != nil {
+			report(&models.VerifyIssue{
+				Path:    path,
+				Version: version,
+				Problem: models.ProblemBadVersion,
+				Error:   fmt.Sprintf("error while decoding version: %s", err),
+			})
+			return nil
+		}
+
+		value := &StoredValue{
+			Location: location,
+			Version:  version,
+			Current:  version == header.CurrentVersion,
+			Value:    entry.Value,
+			File:     entry.File,
+		}
+		if entry.File != nil {
+			versionChunks := fileChunks(secret, k, entry)
+			value.Chunk = func(index int) []byte {
+				if versionChunks == nil {
+					return nil
+				}
+				return versionChunks.Get(chunkKey(index))
+			}
+		}
+
+		return visit(value)
+	})
+}
@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/liriquew/secret_storage/server/internal/lib/config"
+	"github.com/liriquew/secret_storage/server/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWalkValues(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, cfg config.StorageConfig) {
+		s := newTestStorage(t, cfg)
+
+		path := []string{"alice", "app"}
+		for _, key := range []string{"a", "broken", "missing"} {
+			_, err := s.SetRecord(path, key, []byte("value"), models.WriteOptions{})
+			require.NoError(t, err)
+		}
+		_, err := s.SetRecord(path, "a", []byte("new value"), models.WriteOptions{})
+		require.NoError(t, err)
+		require.NoError(t, s.Set(path, "legacy", []byte("plain"), recordsBucketName))
+		file := &models.FileManifest{ID: []byte("id"), Size: 2, ChunkSize: 1, Chunks: 2}
+		_, err = setTestFile(t, s, path, "file", file, [][]byte{[]byte("x"), []byte("y")}, models.WriteOptions{})
+		require.NoError(t, err)
+
+		require.NoError(t, s.db.Update(func(tx Tx) error {
+			app, err := openBucketByPath(path, tx.Bucket(recordsBucketName))
+			require.NoError(t, err)
+
+			require.NoError(t, app.Bucket([]byte("broken")).Put(secretMetaKey, []byte("{")))
+			require.NoError(t, app.Bucket([]byte("missing")).Bucket(secretVersionsBucketName).Delete(versionKey(1)))
+			return app.Bucket([]byte("file")).Bucket(secretChunksBucketName).Bucket(file.ID).Delete(chunkKey(1))
+		}))
+
+		var values []*StoredValue
+		var chunks [][]byte
+		var issues []*models.VerifyIssue
+		err = s.WalkValues(func(v *StoredValue) error {
+			values = append(values, v)
+			if v.File != nil {
+				chunks = append(chunks, v.Chunk(0), v.Chunk(1))
+			}
+			return nil
+		}, func(issue *models.VerifyIssue) {
+			issues = append(issues, issue)
+		})
+		require.NoError(t, err)
+
+		require.Len(t, values, 4)
+		assert.Equal(t, []string{"alice", "app", "a"}, values[0].Location)
+		assert.False(t, values[0].Current)
+		assert.Equal(t, "value", string(values[0].Value))
+		assert.True(t, values[1].Current)
+		assert.Equal(t, []string{"alice", "app", "file"}, values[2].Location)
+		assert.Equal(t, [][]byte{[]byte("x"), nil}, chunks)
+		assert.Equal(t, "plain", string(values[3].Value))
+
+		require.Len(t, issues, 2)
+		assert.Equal(t, "alice/app/broken", issues[0].Path)
+		assert.Equal(t, models.ProblemBadHeader, issues[0].Problem)
+		assert.Equal(t, "alice/app/missing", issues[1].Path)
+		assert.Equal(t, models.ProblemMissingVersion, issues[1].Problem)
+	})
+}
@@ -0,0 +1,551 @@
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/liriquew/secret_storage/server/internal/models"
+)
+
+// Every secret is stored as a nested bucket named after its key:
+//
+//	kv/<username>/<path...>/<key>/meta              - secretHeader (json)
+//	kv/<username>/<path...>/<key>/versions/<uint64> - versionEntry (json)
+//
+// Path buckets never hold plain values, so a bucket is a secret iff it has
+// a plain "meta" value. Plain values found in path buckets are records written
+// before versioning was introduced, they are read as version 1.
+var (
+	secretMetaKey            = []byte("meta")
+	secretVersionsBucketName = []byte("versions")
+)
+
+var (
+	ErrRecordNotFound  = errors.New("record not found")
+	ErrVersionNotFound = errors.New("version not found")
+	ErrCASMismatch     = errors.New("check-and-set version mismatch")
+)
+
+type secretHeader struct {
+	CurrentVersion int               `json:"current_version"`
+	DeletedAt      *time.Time        `json:"deleted_at,omitempty"`
+	CreatedAt      time.Time         `json:"created_at"`
+	CreatedBy      string            `json:"created_by"`
+	UpdatedAt      time.Time         `json:"updated_at"`
+	UpdatedBy      string            `json:"updated_by"`
+	Labels         map[string]string `json:"labels,omitempty"`
+	ExpiresAt      *time.Time        `json:"expires_at,omitempty"`
+}
+
+// gone reports whether the record is deleted or expired at the moment now.
+func (h *secretHeader) gone(now time.Time) bool {
+	return h.DeletedAt != nil || h.expired(now)
+}
+
+func (h *secretHeader) expired(now time.Time) bool {
+	return h.ExpiresAt != nil && !now.Before(*h.ExpiresAt)
+}
+
+func (h *secretHeader) metadata() *models.SecretMetadata {
+	return &models.SecretMetadata{
+		CreatedAt:      h.CreatedAt,
+		CreatedBy:      h.CreatedBy,
+		UpdatedAt:      h.UpdatedAt,
+		UpdatedBy:      h.UpdatedBy,
+		CurrentVersion: h.CurrentVersion,
+		Labels:         h.Labels,
+		ExpiresAt:      h.ExpiresAt,
+	}
+}
+
+type versionEntry struct {
+	CreatedAt time.Time `json:"created_at"`
+	Value     []byte    `json:"value"`
+	// File is set instead of Value for versions stored as files
+	File *models.FileManifest `json:"file,omitempty"`
+	// Chunks names the bucket of the chunks of a committed upload,
+	// other file versions keep them under their version key
+	Chunks []byte `json:"chunks,omitempty"`
+}
+
+func versionKey(version int) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, uint64(version))
+	return k
+}
+
+func isSecretBucket(b Bucket) bool {
+	return b != nil && b.Get(secretMetaKey) != nil
+}
+
+func readHeader(secret Bucket) (*secretHeader, error) {
+	header := &secretHeader{}
+	if err := json.Unmarshal(secret.Get(secretMetaKey), header); err != nil {
+		return nil, fmt.Errorf("error while decoding secret header: %w", err)
+	}
+	return header, nil
+}
+
+func writeHeader(secret Bucket, header *secretHeader) error {
+	buf, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	return secret.Put(secretMetaKey, buf)
+}
+
+func readVersion(secret Bucket, version int) (*versionEntry, error) {
+	versions := secret.Bucket(secretVersionsBucketName)
+	if versions == nil {
+		return nil, fmt.Errorf("%w: version - %d", ErrVersionNotFound, version)
+	}
+
+	raw := versions.Get(versionKey(version))
+	if raw == nil {
+		return nil, fmt.Errorf("%w: version - %d", ErrVersionNotFound, version)
+	}
+
+	entry := &versionEntry{}
+	if err := json.Unmarshal(raw, entry); err != nil {
+		return nil, fmt.Errorf("error while decoding version %d: %w", version, err)
+	}
+	return entry, nil
+}
+
+// openSecret returns the secret bucket for key. A nil bucket without an error
+// means that key holds a legacy plain value. With create set a missing secret
+// is created and a legacy value is converted into its first version.
+func openSecret(b Bucket, key string, create bool) (Bucket, error) {
+	if secret := b.Bucket([]byte(key)); secret != nil {
+		if !isSecretBucket(secret) {
+			return nil, fmt.Errorf("%w: key %s is a bucket", ErrIncorrectPath, key)
+		}
+		return secret, nil
+	}
+
+	legacyValue := b.Get([]byte(key))
+	if !create {
+		if legacyValue == nil {
+			return nil, ErrRecordNotFound
+		}
+		return nil, nil
+	}
+
+	if legacyValue != nil {
+		legacyValue = append([]byte(nil), legacyValue...)
+		if err := b.Delete([]byte(key)); err != nil {
+			return nil, fmt.Errorf("error while deleting legacy record: %w", err)
+		}
+	}
+
+	secret, err := b.CreateBucket([]byte(key))
+	if err != nil {
+		return nil, fmt.Errorf("error while creating secret bucket: %w", err)
+	}
+	if _, err := secret.CreateBucket(secretVersionsBucketName); err != nil {
+		return nil, fmt.Errorf("error while creating versions bucket: %w", err)
+	}
+	if err := writeHeader(secret, &secretHeader{}); err != nil {
+		return nil, err
+	}
+
+	if legacyValue != nil {
+		if _, err := putVersion(secret, legacyValue, models.WriteOptions{}, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	return secret, nil
+}
+
+// putVersion appends a new version to secret and drops the oldest versions
+// beyond maxVersions (0 keeps all of them).
+func putVersion(secret Bucket, value []byte, opts models.WriteOptions, maxVersions int) (int, error) {
+	return putEntry(secret, &versionEntry{Value: value}, opts, maxVersions)
+}
+
+func putEntry(secret Bucket, entry *versionEntry, opts models.WriteOptions, maxVersions int) (int, error) {
+	header, err := readHeader(secret)
+	if err != nil {
+		return 0, err
+	}
+
+	versions := secret.Bucket(secretVersionsBucketName)
+	if versions == nil {
+		return 0, ErrFailedToOpenTopBucket
+	}
+
+	now := time.Now().UTC()
+	if header.CurrentVersion == 0 {
+		header.CreatedAt = now
+		header.CreatedBy = opts.Author
+	}
+	header.UpdatedAt = now
+	header.UpdatedBy = opts.Author
+
+	// a write keeps the expiration unless it sets or clears one, a deleted or
+	// expired secret is written anew
+	if opts.ExpiresAt != nil || opts.ClearExpiration || header.gone(now) {
+		header.ExpiresAt = opts.ExpiresAt
+	}
+	header.CurrentVersion++
+	header.DeletedAt = nil
+
+	entry.CreatedAt = now
+	buf, err := json.Marshal(entry)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := versions.Put(versionKey(header.CurrentVersion), buf); err != nil {
+		return 0, err
+	}
+
+	if maxVersions > 0 && header.CurrentVersion > maxVersions {
+		oldest := versionKey(header.CurrentVersion - maxVersions + 1)
+		c := versions.Cursor()
+		for k, v := c.First(); k != nil && string(k) < string(oldest); k, v = c.First() {
+			if err := dropChunks(secret, k, v); err != nil {
+				return 0, fmt.Errorf("error while pruning versions: %w", err)
+			}
+			if err := c.Delete(); err != nil {
+				return 0, fmt.Errorf("error while pruning versions: %w", err)
+			}
+		}
+	}
+
+	return header.CurrentVersion, writeHeader(secret, header)
+}
+
+// readRecord reads version of the record stored under key in b,
+// version 0 stands for the current one.
+func readRecord(b Bucket, key string, version int) (*models.Record, error) {
+	secret, err := openSecret(b, key, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if secret == nil {
+		if version > 1 {
+			return nil, fmt.Errorf("%w: version - %d", ErrVersionNotFound, version)
+		}
+		return &models.Record{Key: []byte(key), Value: b.Get([]byte(key)), Version: 1}, nil
+	}
+
+	header, err := readHeader(secret)
+	if err != nil {
+		return nil, err
+	}
+	if header.gone(time.Now()) {
+		return nil, ErrRecordNotFound
+	}
+	if version == 0 {
+		version = header.CurrentVersion
+	}
+
+	entry, err := readVersion(secret, version)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.Record{
+		Key:      []byte(key),
+		Value:    entry.Value,
+		Version:  version,
+		Metadata: header.metadata(),
+		File:     entry.File,
+	}, nil
+}
+
+// liveVersion returns the current version of the record stored under key in b,
+// 0 means that there is no such record or it is deleted.
+func liveVersion(b Bucket, key string) (int, error) {
+	secret, err := openSecret(b, key, false)
+	if errors.Is(err, ErrRecordNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if secret == nil {
+		return 1, nil
+	}
+
+	header, err := readHeader(secret)
+	if err != nil {
+		return 0, err
+	}
+	if header.gone(time.Now()) {
+		return 0, nil
+	}
+
+	return header.CurrentVersion, nil
+}
+
+func checkCAS(b Bucket, key string, cas *int) error {
+	if cas == nil {
+		return nil
+	}
+
+	current, err := liveVersion(b, key)
+	if err != nil {
+		return err
+	}
+	if current != *cas {
+		return fmt.Errorf("%w: expected version - %d, current version - %d", ErrCASMismatch, *cas, current)
+	}
+
+	return nil
+}
+
+func (s *Storage) SetRecord(path []string, key string, value []byte, opts models.WriteOptions) (int, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	var version int
+	var changes *changeLog
+	err := s.db.Update(func(tx Tx) error {
+		b := tx.Bucket(recordsBucketName)
+		if b == nil {
+			return ErrFailedToOpenTopBucket
+		}
+
+		if err := s.names.register(tx, append(slices.Clip(path), key)...); err != nil {
+			return err
+		}
+
+		usage := s.newUsageTracker()
+		changes = s.newChangeLog()
+		refs := s.newNameRefs()
+
+		stored, storedKey := s.names.blindLocation(path, key)
+		var err error
+		version, err = setRecord(b, stored, storedKey, value, opts, s.maxVersions, usage, changes, refs)
+		if err != nil {
+			return err
+		}
+		if err := usage.commit(tx); err != nil {
+			return err
+		}
+		if err := changes.resolve(tx, s.names); err != nil {
+			return err
+		}
+		return refs.commit(tx)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	s.publish(changes)
+	return version, nil
+}
+
+// setRecord writes a new version of the record creating missing path buckets.
+func setRecord(b Bucket, path []string, key string, value []byte, opts models.WriteOptions, maxVersions int, usage *usageTracker, changes *changeLog, refs *nameRefs) (int, error) {
+	if err := usage.checkValue(path, value); err != nil {
+		return 0, err
+	}
+
+	b, err := createBucketByPath(b, path, refs)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := checkCAS(b, key, opts.CAS); err != nil {
+		return 0, err
+	}
+
+	op, err := writeOp(b, key)
+	if err != nil {
+		return 0, err
+	}
+
+	before, err := entryUsage(b, []byte(key))
+	if err != nil {
+		return 0, err
+	}
+
+	refs.create(b, []byte(key))
+	secret, err := openSecret(b, key, true)
+	if err != nil {
+		return 0, err
+	}
+
+	version, err := putVersion(secret, value, opts, maxVersions)
+	if err != nil {
+		return 0, err
+	}
+	changes.add(op, path, key, version)
+
+	after, err := secretUsage(secret)
+	if err != nil {
+		return 0, err
+	}
+	usage.track(path, before, after)
+
+	return version, nil
+}
+
+func (s *Storage) GetRecord(path []string, key string, version int) (*models.Record, error) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	var record *models.Record
+	err := s.db.View(func(tx Tx) error {
+		b := tx.Bucket(recordsBucketName)
+		if b == nil {
+			return ErrFailedToOpenTopBucket
+		}
+
+		stored, storedKey := s.names.blindLocation(path, key)
+		b, err := openBucketByPath(stored, b)
+		if err != nil {
+			return err
+		}
+
+		record, err = readRecord(b, storedKey, version)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	record.Key = []byte(key)
+	return record, nil
+}
+
+func (s *Storage) ListVersions(path []string, key string) ([]*models.SecretVersion, error) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	path, key = s.names.blindLocation(path, key)
+
+	var versions []*models.SecretVersion
+	err := s.db.View(func(tx Tx) error {
+		b := tx.Bucket(recordsBucketName)
+		if b == nil {
+			return ErrFailedToOpenTopBucket
+		}
+
+		b, err := openBucketByPath(path, b)
+		if err != nil {
+			return err
+		}
+
+		secret, err := openSecret(b, key, false)
+		if err != nil {
+			return err
+		}
+		if secret == nil {
+			versions = append(versions, &models.SecretVersion{Version: 1})
+			return nil
+		}
+
+		return secret.Bucket(secretVersionsBucketName).ForEach(func(k, v []byte) error {
+			entry := &versionEntry{}
+			if err := json.Unmarshal(v, entry); err != nil {
+				return fmt.Errorf("error while decoding version: %w", err)
+			}
+
+			versions = append(versions, &models.SecretVersion{
+				Version:   int(binary.BigEndian.Uint64(k)),
+				CreatedAt: entry.CreatedAt,
+			})
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return versions, nil
+}
+
+// Rollback writes the value of the given version as a new current version.
+func (s *Storage) Rollback(path []string, key string, version int, opts models.WriteOptions) (int, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	path, key = s.names.blindLocation(path, key)
+
+	var newVersion int
+	var changes *changeLog
+	err := s.db.Update(func(tx Tx) error {
+		b := tx.Bucket(recordsBucketName)
+		if b == nil {
+			return ErrFailedToOpenTopBucket
+		}
+
+		b, err := openBucketByPath(path, b)
+		if err != nil {
+			return err
+		}
+
+		record, err := readRecord(b, key, version)
+		if err != nil {
+			return err
+		}
+		value := append([]byte(nil), record.Value...)
+
+		var chunks [][]byte
+		if record.File != nil {
+			// chunks are copied before the old version may be pruned
+			chunks, err = readChunks(b.Bucket([]byte(key)), record.Version)
+			if err != nil {
+				return err
+			}
+		}
+
+		secret, err := openSecret(b, key, true)
+		if err != nil {
+			return err
+		}
+
+		header, err := readHeader(secret)
+		if err != nil {
+			return err
+		}
+		// rollback changes the value only, the record keeps its expiration
+		opts.ExpiresAt = header.ExpiresAt
+
+		before, err := secretUsage(secret)
+		if err != nil {
+			return err
+		}
+
+		if record.File != nil {
+			newVersion, err = putFileVersion(secret, record.File, chunks, opts, s.maxVersions)
+		} else {
+			newVersion, err = putVersion(secret, value, opts, s.maxVersions)
+		}
+		if err != nil {
+			return err
+		}
+
+		after, err := secretUsage(secret)
+		if err != nil {
+			return err
+		}
+
+		usage := s.newUsageTracker()
+		usage.track(path, before, after)
+		if err := usage.commit(tx); err != nil {
+			return err
+		}
+
+		// only live versions are rolled back to
+		changes = s.newChangeLog()
+		changes.add(models.ChangeUpdate, path, key, newVersion)
+		return changes.resolve(tx, s.names)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	s.publish(changes)
+	return newVersion, nil
+}
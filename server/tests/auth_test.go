@@ -12,6 +12,7 @@ import (
 	"github.com/liriquew/secret_storage/server/internal/models"
 	"github.com/liriquew/secret_storage/server/tests/suite"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 const (
@@ -146,3 +147,36 @@ func CreateUser(t *testing.T, ts *suite.Suite) *UserWithToken {
 		Token: token.Token,
 	}
 }
+
+// AdminUser signs in the admin of the tested server named by the test config,
+// the admin is signed up on the first run. The test is skipped without an admin.
+func AdminUser(t *testing.T, ts *suite.Suite) *UserWithToken {
+	user := models.User{
+		Username: ts.TestConfig.Service.AdminUsername,
+		Password: ts.TestConfig.Service.AdminPassword,
+	}
+	if user.Username == "" {
+		t.Skip("admin of the tested server isn't configured")
+	}
+
+	buf, _ := json.Marshal(user)
+	for _, endpoint := range []string{"signin", "signup"} {
+		req, _ := http.NewRequest("POST", fmt.Sprintf("%s/%s", ts.GetURL(), endpoint), bytes.NewBuffer(buf))
+		req.Header.Set(contentType, applicationJSON)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		if resp.Status != StatusOK {
+			continue
+		}
+
+		var token JWT
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&token))
+		return &UserWithToken{User: user, Token: token.Token}
+	}
+
+	t.Fatalf("admin %s can't sign in", user.Username)
+	return nil
+}
@@ -0,0 +1,138 @@
+package tests
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/liriquew/secret_storage/server/internal/app/api"
+	"github.com/liriquew/secret_storage/server/internal/lib/config"
+	"github.com/liriquew/secret_storage/server/internal/models"
+	"github.com/liriquew/secret_storage/server/internal/service"
+	"github.com/liriquew/secret_storage/server/tests/suite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackupRequiresAdmin(t *testing.T) {
+	ts := suite.New(t)
+	userCreds := CreateUser(t, ts)
+
+	do := func(method, path string) *http.Response {
+		req, _ := http.NewRequest(method, fmt.Sprintf("%s/sys/%s", ts.GetURL(), path), bytes.NewBufferString("backup"))
+		req.Header.Set("Authorization", "Bearer "+userCreds.Token)
+		req.Header.Set("X-Backup-Passphrase", "passphrase")
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	assert.Equal(t, http.StatusForbidden, do("GET", "backup").StatusCode)
+	assert.Equal(t, http.StatusForbidden, do("POST", "restore").StatusCode)
+}
+
+// SealedNode starts a node with an empty storage in the test process, the tested
+// server can't be sealed to restore a backup into it. The admin of ts is its admin.
+func SealedNode(t *testing.T, ts *suite.Suite) *suite.Suite {
+	dir := t.TempDir()
+	cfgPath := filepath.Join(dir, "config.yaml")
+	// the port is required by the config, the node is served by httptest
+	cfgYAML := fmt.Sprintf("service_config:\n  port: 1\n  admins: [%q]\nstorage_config:\n  path: %q\n",
+		ts.TestConfig.Service.AdminUsername, filepath.Join(dir, "data.db"))
+	require.NoError(t, os.WriteFile(cfgPath, []byte(cfgYAML), 0o600))
+
+	gin.SetMode(gin.TestMode)
+	gin.DefaultWriter = io.Discard
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	srv := httptest.NewServer(api.New(service.New(log, config.MustLoadPath(cfgPath))).Handler())
+	t.Cleanup(srv.Close)
+
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	return &suite.Suite{
+		TestConfig: &config.AppTestConfig{
+			Service: config.ServiceTestConfig{Host: u.Hostname(), Port: u.Port()},
+		},
+	}
+}
+
+func TestBackupRestore(t *testing.T) {
+	if masterParts == nil {
+		t.Skip("storage was unsealed before the run, its shares are unknown")
+	}
+
+	ts := suite.New(t)
+	adminCreds := AdminUser(t, ts)
+	userCreds := CreateUser(t, ts)
+
+	CreateRecord(t, ts, userCreds, "backup/app", &models.RecordDTO{Key: "token", Value: "value"})
+	content := GetRandBytes(300 * 1024)
+	resp := UploadFile(t, ts, userCreds, "keystore", "backup", bytes.NewReader(content), "application/octet-stream")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// the backup keeps the keyring sealed with the current master key
+	parts := slices.Clone(masterParts)
+
+	req, _ := http.NewRequest("GET", fmt.Sprintf("%s/sys/backup", ts.GetURL()), nil)
+	req.Header.Set("Authorization", "Bearer "+adminCreds.Token)
+	req.Header.Set("X-Backup-Passphrase", "passphrase")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	backup, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	node := SealedNode(t, ts)
+	restore := func(passphrase string) *http.Response {
+		req, _ := http.NewRequest("POST", fmt.Sprintf("%s/sys/restore", node.GetURL()), bytes.NewReader(backup))
+		req.Header.Set("Authorization", "Bearer "+adminCreds.Token)
+		req.Header.Set("X-Backup-Passphrase", passphrase)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	t.Run("Wrong Passphrase", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, restore("wrong passphrase").StatusCode)
+	})
+
+	t.Run("Sealed Restore", func(t *testing.T) {
+		require.Equal(t, http.StatusOK, restore("passphrase").StatusCode)
+
+		for _, part := range parts[:3] {
+			resp, err := http.Post(fmt.Sprintf("%s/unseal?part=%s", node.GetURL(), url.QueryEscape(part)), "", nil)
+			require.NoError(t, err)
+			resp.Body.Close()
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+		}
+		resp, err := http.Post(fmt.Sprintf("%s/unseal/complete", node.GetURL()), "", nil)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		record := GetRecord(t, node, userCreds, "token", "backup/app")
+		assert.Equal(t, "value", record.Value)
+
+		resp, body := DownloadFile(t, node, userCreds, "keystore", "backup", "")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.True(t, bytes.Equal(content, body))
+
+		report := VerifyStorage(t, node, adminCreds)
+		assert.True(t, report.OK)
+		assert.Empty(t, report.Issues)
+	})
+}
@@ -0,0 +1,86 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/liriquew/secret_storage/server/internal/models"
+	"github.com/liriquew/secret_storage/server/tests/suite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatch(t *testing.T) {
+	ts := suite.New(t)
+	userCreds := CreateUser(t, ts)
+
+	oldRecord := CreateRecord(t, ts, userCreds, "db/old", nil)
+
+	doBatch := func(ops []models.BatchOperationDTO) *http.Response {
+		buf, _ := json.Marshal(ops)
+
+		req, _ := http.NewRequest("POST", fmt.Sprintf("%s/batch", ts.GetURL()), bytes.NewBuffer(buf))
+		req.Header.Set("Authorization", "Bearer "+userCreds.Token)
+		req.Header.Set(contentType, applicationJSON)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		resp := doBatch([]models.BatchOperationDTO{
+			{Op: models.BatchOpSet, Path: "db/main", RecordDTO: models.RecordDTO{Key: "username", Value: "admin"}},
+			{Op: models.BatchOpSet, Path: "db/main", RecordDTO: models.RecordDTO{Key: "password", Value: "qwerty"}},
+			{Op: models.BatchOpSet, Path: "app", RecordDTO: models.RecordDTO{Key: "dsn", Value: "admin:qwerty@db"}},
+			{Op: models.BatchOpDelete, Path: "db/old", RecordDTO: models.RecordDTO{Key: oldRecord.Key}},
+		})
+		defer resp.Body.Close()
+
+		assert.Equal(t, StatusOK, resp.Status)
+
+		var results []models.BatchResult
+		err := json.NewDecoder(resp.Body).Decode(&results)
+		require.NoError(t, err)
+
+		require.Len(t, results, 4)
+		assert.Equal(t, 1, results[0].Version)
+		assert.Equal(t, "db/main", results[1].Path)
+		assert.NotNil(t, results[3].DeletedAt)
+
+		assert.Equal(t, "qwerty", GetRecord(t, ts, userCreds, "password", "db/main").Value)
+		assert.Equal(t, "admin:qwerty@db", GetRecord(t, ts, userCreds, "dsn", "app").Value)
+	})
+
+	t.Run("Fails As A Whole", func(t *testing.T) {
+		staleVersion := 0
+		resp := doBatch([]models.BatchOperationDTO{
+			{Op: models.BatchOpSet, Path: "db/main", RecordDTO: models.RecordDTO{Key: "username", Value: "root"}},
+			{Op: models.BatchOpSet, Path: "db/main", RecordDTO: models.RecordDTO{Key: "password", Value: "123"}, CAS: &staleVersion},
+		})
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+		var failure struct {
+			Operation int `json:"operation"`
+		}
+		err := json.NewDecoder(resp.Body).Decode(&failure)
+		require.NoError(t, err)
+		assert.Equal(t, 1, failure.Operation)
+
+		assert.Equal(t, "admin", GetRecord(t, ts, userCreds, "username", "db/main").Value)
+	})
+
+	t.Run("Unknown Operation", func(t *testing.T) {
+		resp := doBatch([]models.BatchOperationDTO{
+			{Op: "rename", Path: "db/main", RecordDTO: models.RecordDTO{Key: "username"}},
+		})
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
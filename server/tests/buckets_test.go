@@ -0,0 +1,73 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/liriquew/secret_storage/server/internal/models"
+	"github.com/liriquew/secret_storage/server/tests/suite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeleteBucket(t *testing.T) {
+	ts := suite.New(t)
+	userCreds := CreateUser(t, ts)
+
+	CreateRecord(t, ts, userCreds, "svc/app", &models.RecordDTO{Key: "token", Value: "value"})
+	CreateRecord(t, ts, userCreds, "svc/app/db", &models.RecordDTO{Key: "password", Value: "value"})
+
+	deleteBucket := func(query string) (*http.Response, *models.BucketDeletion) {
+		req, _ := http.NewRequest("DELETE", fmt.Sprintf("%s/buckets?%s", ts.GetURL(), query), nil)
+		req.Header.Set("Authorization", "Bearer "+userCreds.Token)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return resp, nil
+		}
+		report := &models.BucketDeletion{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(report))
+		return resp, report
+	}
+
+	t.Run("Bad Requests", func(t *testing.T) {
+		resp, _ := deleteBucket("path=&recursive=true")
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		resp, _ = deleteBucket("path=svc/app")
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+		resp, _ = deleteBucket("path=missing&recursive=true")
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("Dry Run", func(t *testing.T) {
+		resp, report := deleteBucket("path=svc/app&recursive=true&dry_run=true")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.True(t, report.DryRun)
+		assert.ElementsMatch(t, []string{"svc/app/token", "svc/app/db/password"}, report.Secrets)
+
+		record := GetRecord(t, ts, userCreds, "password", "svc/app/db")
+		assert.Equal(t, "value", record.Value)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		resp, report := deleteBucket("path=svc/app&recursive=true")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, 2, report.DeletedSecrets)
+		// app and db with svc and the namespace left empty
+		assert.Equal(t, 4, report.DeletedBuckets)
+
+		req, _ := http.NewRequest("GET", fmt.Sprintf("%s/secrets/password?path=svc/app/db", ts.GetURL()), nil)
+		req.Header.Set("Authorization", "Bearer "+userCreds.Token)
+		getResp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		getResp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, getResp.StatusCode)
+	})
+}
@@ -0,0 +1,101 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/liriquew/secret_storage/server/internal/models"
+	"github.com/liriquew/secret_storage/server/tests/suite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpiration(t *testing.T) {
+	ts := suite.New(t)
+	userCreds := CreateUser(t, ts)
+
+	path := "short/lived"
+
+	t.Run("Expires After TTL", func(t *testing.T) {
+		record := GetRandRecord()
+		record.TTL = "1s"
+		CreateRecord(t, ts, userCreds, path, record)
+
+		got := GetRecord(t, ts, userCreds, record.Key, path)
+		assert.Equal(t, record.Value, got.Value)
+		require.NotNil(t, got.Metadata)
+		assert.NotNil(t, got.Metadata.ExpiresAt)
+
+		time.Sleep(1100 * time.Millisecond)
+
+		req, _ := http.NewRequest("GET", fmt.Sprintf("%s/secrets/%s?path=%s", ts.GetURL(), record.Key, path), nil)
+		req.Header.Set("Authorization", "Bearer "+userCreds.Token)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, StatusNotFound, resp.Status)
+	})
+
+	t.Run("Kept On Update", func(t *testing.T) {
+		record := GetRandRecord()
+		record.TTL = "1h"
+		CreateRecord(t, ts, userCreds, path, record)
+		expiresAt := GetRecord(t, ts, userCreds, record.Key, path).Metadata.ExpiresAt
+		require.NotNil(t, expiresAt)
+
+		UpdateRecord(t, ts, userCreds, record.Key, path, "plain update")
+		got := GetRecord(t, ts, userCreds, record.Key, path)
+		require.NotNil(t, got.Metadata.ExpiresAt)
+		assert.True(t, expiresAt.Equal(*got.Metadata.ExpiresAt))
+
+		buf, _ := json.Marshal(models.RecordDTO{Value: "persistent", ClearExpiration: true})
+		req, _ := http.NewRequest("PATCH", fmt.Sprintf("%s/secrets/%s?path=%s", ts.GetURL(), record.Key, path), bytes.NewBuffer(buf))
+		req.Header.Set(contentType, applicationJSON)
+		req.Header.Set("Authorization", "Bearer "+userCreds.Token)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		got = GetRecord(t, ts, userCreds, record.Key, path)
+		assert.Nil(t, got.Metadata.ExpiresAt)
+	})
+
+	t.Run("Bad Expiration", func(t *testing.T) {
+		past := time.Now().Add(-time.Hour)
+		inPast := GetRandRecord()
+		inPast.ExpiresAt = &past
+
+		withBoth := GetRandRecord()
+		withBoth.TTL = "1h"
+		withBoth.ExpiresAt = &past
+
+		badTTL := GetRandRecord()
+		badTTL.TTL = "tomorrow"
+
+		setAndCleared := GetRandRecord()
+		setAndCleared.TTL = "1h"
+		setAndCleared.ClearExpiration = true
+
+		for _, record := range [...]any{inPast, withBoth, badTTL, setAndCleared} {
+			buf, _ := json.Marshal(record)
+
+			req, _ := http.NewRequest("POST", fmt.Sprintf("%s/secrets?path=%s", ts.GetURL(), path), bytes.NewBuffer(buf))
+			req.Header.Set(contentType, applicationJSON)
+			req.Header.Set("Authorization", "Bearer "+userCreds.Token)
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		}
+	})
+}
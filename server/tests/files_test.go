@@ -0,0 +1,133 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"testing"
+
+	"github.com/liriquew/secret_storage/server/internal/models"
+	"github.com/liriquew/secret_storage/server/tests/suite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func UploadFile(t *testing.T, ts *suite.Suite, userCreds *UserWithToken, key, path string, body io.Reader, mediaType string) *http.Response {
+	req, _ := http.NewRequest("PUT", fmt.Sprintf("%s/files/%s?path=%s", ts.GetURL(), key, path), body)
+	req.Header.Set(contentType, mediaType)
+	req.Header.Set("Authorization", "Bearer "+userCreds.Token)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	return resp
+}
+
+func DownloadFile(t *testing.T, ts *suite.Suite, userCreds *UserWithToken, key, path, rangeHeader string) (*http.Response, []byte) {
+	req, _ := http.NewRequest("GET", fmt.Sprintf("%s/files/%s?path=%s", ts.GetURL(), key, path), nil)
+	req.Header.Set("Authorization", "Bearer "+userCreds.Token)
+	if rangeHeader != "" {
+		req.Header.Set("Range", rangeHeader)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, body
+}
+
+func TestFiles(t *testing.T) {
+	ts := suite.New(t)
+	userCreds := CreateUser(t, ts)
+
+	// a few chunks with a short last one
+	content := make([]byte, 600*1024)
+	rand.Read(content)
+
+	resp := UploadFile(t, ts, userCreds, "keystore", "files", bytes.NewReader(content), "application/x-pkcs12")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	t.Run("Download", func(t *testing.T) {
+		resp, body := DownloadFile(t, ts, userCreds, "keystore", "files", "")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "application/x-pkcs12", resp.Header.Get("Content-Type"))
+		assert.NotEmpty(t, resp.Header.Get("Repr-Digest"))
+		assert.True(t, bytes.Equal(content, body))
+	})
+
+	t.Run("Range", func(t *testing.T) {
+		// the range crosses the border of the first two chunks
+		resp, body := DownloadFile(t, ts, userCreds, "keystore", "files", "bytes=262000-263000")
+		require.Equal(t, http.StatusPartialContent, resp.StatusCode)
+		assert.True(t, bytes.Equal(content[262000:263001], body))
+
+		resp, body = DownloadFile(t, ts, userCreds, "keystore", "files", "bytes=-100")
+		require.Equal(t, http.StatusPartialContent, resp.StatusCode)
+		assert.True(t, bytes.Equal(content[len(content)-100:], body))
+
+		resp, _ = DownloadFile(t, ts, userCreds, "keystore", "files", fmt.Sprintf("bytes=%d-", len(content)))
+		assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, resp.StatusCode)
+	})
+
+	t.Run("Several Batches", func(t *testing.T) {
+		// chunks are written 16 at a time
+		large := make([]byte, 5*1024*1024+1)
+		rand.Read(large)
+
+		resp := UploadFile(t, ts, userCreds, "archive", "files", bytes.NewReader(large), "application/zip")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		resp, body := DownloadFile(t, ts, userCreds, "archive", "files", "")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.True(t, bytes.Equal(large, body))
+	})
+
+	t.Run("Multipart", func(t *testing.T) {
+		var form bytes.Buffer
+		writer := multipart.NewWriter(&form)
+		part, err := writer.CreateFormFile("file", "kubeconfig.yaml")
+		require.NoError(t, err)
+		part.Write([]byte("apiVersion: v1"))
+		require.NoError(t, writer.Close())
+
+		resp := UploadFile(t, ts, userCreds, "kubeconfig", "files", &form, writer.FormDataContentType())
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		resp, body := DownloadFile(t, ts, userCreds, "kubeconfig", "files", "")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "apiVersion: v1", string(body))
+		assert.Contains(t, resp.Header.Get("Content-Disposition"), "kubeconfig.yaml")
+	})
+
+	t.Run("Metadata", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", fmt.Sprintf("%s/secrets/keystore?path=files", ts.GetURL()), nil)
+		req.Header.Set("Authorization", "Bearer "+userCreds.Token)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var record models.RecordDTO
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&record))
+		require.NotNil(t, record.File)
+		assert.Equal(t, int64(len(content)), record.File.Size)
+	})
+
+	t.Run("Not A File", func(t *testing.T) {
+		CreateRecord(t, ts, userCreds, "files", &models.RecordDTO{Key: "plain", Value: "value"})
+
+		resp, _ := DownloadFile(t, ts, userCreds, "plain", "files", "")
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+		resp, _ = DownloadFile(t, ts, userCreds, "missing", "files", "")
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
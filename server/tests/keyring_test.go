@@ -0,0 +1,31 @@
+package tests
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/liriquew/secret_storage/server/tests/suite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyringRequiresAdmin(t *testing.T) {
+	ts := suite.New(t)
+	userCreds := CreateUser(t, ts)
+
+	for _, endpoint := range []struct{ method, path string }{
+		{"POST", "sys/rotate"},
+		{"GET", "sys/rewrap"},
+		{"POST", "sys/rewrap/purge"},
+	} {
+		req, _ := http.NewRequest(endpoint.method, fmt.Sprintf("%s/%s", ts.GetURL(), endpoint.path), nil)
+		req.Header.Set("Authorization", "Bearer "+userCreds.Token)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode, endpoint.path)
+	}
+}
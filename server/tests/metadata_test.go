@@ -0,0 +1,87 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/liriquew/secret_storage/server/internal/models"
+	"github.com/liriquew/secret_storage/server/tests/suite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetadata(t *testing.T) {
+	ts := suite.New(t)
+	userCreds := CreateUser(t, ts)
+
+	path := "with/metadata"
+	record := CreateRecord(t, ts, userCreds, path, nil)
+
+	t.Run("Returned By Get", func(t *testing.T) {
+		got := GetRecord(t, ts, userCreds, record.Key, path)
+
+		require.NotNil(t, got.Metadata)
+		assert.Equal(t, userCreds.User.Username, got.Metadata.CreatedBy)
+		assert.Equal(t, userCreds.User.Username, got.Metadata.UpdatedBy)
+		assert.False(t, got.Metadata.CreatedAt.IsZero())
+		assert.Equal(t, 1, got.Metadata.CurrentVersion)
+	})
+
+	t.Run("Update Labels", func(t *testing.T) {
+		labels := map[string]string{"team": "backend", "env": "prod"}
+		buf, _ := json.Marshal(map[string]any{"labels": labels})
+
+		req, _ := http.NewRequest("PUT",
+			fmt.Sprintf("%s/secrets/%s/metadata?path=%s", ts.GetURL(), record.Key, path),
+			bytes.NewBuffer(buf),
+		)
+		req.Header.Set("Authorization", "Bearer "+userCreds.Token)
+		req.Header.Set(contentType, applicationJSON)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, StatusOK, resp.Status)
+
+		req, _ = http.NewRequest("GET",
+			fmt.Sprintf("%s/secrets/%s/metadata?path=%s", ts.GetURL(), record.Key, path),
+			nil,
+		)
+		req.Header.Set("Authorization", "Bearer "+userCreds.Token)
+
+		resp, err = http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, StatusOK, resp.Status)
+
+		var metadata models.SecretMetadata
+		err = json.NewDecoder(resp.Body).Decode(&metadata)
+		assert.NoError(t, err)
+
+		assert.Equal(t, labels, metadata.Labels)
+		assert.Equal(t, 1, metadata.CurrentVersion)
+
+		got := GetRecord(t, ts, userCreds, record.Key, path)
+		assert.Equal(t, record.Value, got.Value)
+		assert.Equal(t, 1, got.Version)
+	})
+
+	t.Run("Not Found", func(t *testing.T) {
+		req, _ := http.NewRequest("GET",
+			fmt.Sprintf("%s/secrets/%s/metadata?path=%s", ts.GetURL(), "abra_cadabra", path),
+			nil,
+		)
+		req.Header.Set("Authorization", "Bearer "+userCreds.Token)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, StatusNotFound, resp.Status)
+	})
+}
@@ -0,0 +1,59 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/liriquew/secret_storage/server/internal/models"
+	"github.com/liriquew/secret_storage/server/tests/suite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuotaUsage(t *testing.T) {
+	ts := suite.New(t)
+	userCreds := CreateUser(t, ts)
+
+	getUsage := func() models.Usage {
+		req, _ := http.NewRequest("GET", fmt.Sprintf("%s/quota", ts.GetURL()), nil)
+		req.Header.Set("Authorization", "Bearer "+userCreds.Token)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var status models.QuotaStatus
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+		return status.Usage
+	}
+
+	assert.Equal(t, models.Usage{}, getUsage())
+
+	CreateRecord(t, ts, userCreds, "quota/a", &models.RecordDTO{Key: "key0", Value: "value"})
+	CreateRecord(t, ts, userCreds, "quota/b", &models.RecordDTO{Key: "key1", Value: "value"})
+
+	usage := getUsage()
+	assert.Equal(t, int64(2), usage.Secrets)
+	// stored values are ciphertexts, so they are longer than the plaintexts
+	assert.Greater(t, usage.Bytes, int64(2*len("value")))
+
+	// deleted secrets stop counting until they are restored
+	req, _ := http.NewRequest("DELETE", fmt.Sprintf("%s/secrets/key0?path=quota/a", ts.GetURL()), nil)
+	req.Header.Set("Authorization", "Bearer "+userCreds.Token)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int64(1), getUsage().Secrets)
+
+	req, _ = http.NewRequest("POST", fmt.Sprintf("%s/secrets/key0/undelete?path=quota/a", ts.GetURL()), nil)
+	req.Header.Set("Authorization", "Bearer "+userCreds.Token)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, usage, getUsage())
+}
@@ -0,0 +1,232 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/liriquew/secret_storage/server/internal/models"
+	"github.com/liriquew/secret_storage/server/tests/suite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRekeyRequiresAdmin(t *testing.T) {
+	ts := suite.New(t)
+	userCreds := CreateUser(t, ts)
+
+	for _, endpoint := range []struct{ method, path string }{
+		{"GET", "rekey"},
+		{"POST", "rekey/init?parts=3&threshold=2&current_threshold=3"},
+		{"POST", "rekey/update?nonce=nonce&part=part"},
+		{"GET", "rekey/shares?nonce=nonce"},
+		{"DELETE", "rekey"},
+	} {
+		for _, token := range []string{"", userCreds.Token} {
+			req, _ := http.NewRequest(endpoint.method, fmt.Sprintf("%s/%s", ts.GetURL(), endpoint.path), nil)
+			if token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+
+			assert.Equal(t, http.StatusForbidden, resp.StatusCode, endpoint.path)
+		}
+	}
+}
+
+func TestRekey(t *testing.T) {
+	if masterParts == nil {
+		t.Skip("storage was unsealed before the run, its shares are unknown")
+	}
+
+	ts := suite.New(t)
+	adminCreds := AdminUser(t, ts)
+	userCreds := CreateUser(t, ts)
+	CreateRecord(t, ts, userCreds, "rekey", &models.RecordDTO{Key: "token", Value: "value"})
+
+	rekey := func(method, path string) (*http.Response, *models.RekeyStatus) {
+		req, _ := http.NewRequest(method, fmt.Sprintf("%s/rekey%s", ts.GetURL(), path), nil)
+		req.Header.Set("Authorization", "Bearer "+adminCreds.Token)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+			return resp, nil
+		}
+		status := &models.RekeyStatus{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(status))
+		return resp, status
+	}
+	update := func(nonce, part string) (*http.Response, *models.RekeyStatus) {
+		return rekey("POST", fmt.Sprintf("/update?nonce=%s&part=%s", nonce, url.QueryEscape(part)))
+	}
+	receive := func(nonce string) (*websocket.Conn, *http.Response) {
+		wsURL := strings.Replace(fmt.Sprintf("%s/rekey/shares?nonce=%s", ts.GetURL(), nonce), "http://", "ws://", 1)
+		headers := http.Header{}
+		headers.Set("Authorization", "Bearer "+adminCreds.Token)
+
+		conn, resp, _ := websocket.DefaultDialer.Dial(wsURL, headers)
+		if conn != nil {
+			t.Cleanup(func() { conn.Close() })
+		}
+		return conn, resp
+	}
+
+	t.Run("Bad Requests", func(t *testing.T) {
+		resp, _ := rekey("POST", "/init?parts=3&threshold=4")
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		resp, _ = rekey("POST", "/init?parts=3&threshold=1&current_threshold=3")
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		resp, _ = rekey("POST", "/init?parts=3&threshold=2")
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		resp, _ = update("nonce", masterParts[0])
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	resp, status := rekey("POST", "/init?parts=3&threshold=2&current_threshold=3")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.True(t, status.Started)
+	nonce := status.Nonce
+
+	t.Run("Conflicts", func(t *testing.T) {
+		resp, _ := rekey("POST", "/init?parts=3&threshold=2&current_threshold=3")
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+		resp, _ = update("other", masterParts[0])
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+		// shares are accepted once every new share has its receiver
+		resp, _ = update(nonce, masterParts[0])
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+		_, resp = receive("other")
+		require.NotNil(t, resp)
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	})
+
+	t.Run("Cancel", func(t *testing.T) {
+		var receivers []*websocket.Conn
+		for range 3 {
+			conn, _ := receive(nonce)
+			require.NotNil(t, conn)
+			receivers = append(receivers, conn)
+		}
+
+		resp, status := update(nonce, masterParts[0])
+		require.Equal(t, http.StatusAccepted, resp.StatusCode)
+		assert.Equal(t, 1, status.Progress)
+		assert.Equal(t, 3, status.Receivers)
+
+		resp, _ = rekey("DELETE", "")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		_, _, err := receivers[0].ReadMessage()
+		assert.Error(t, err)
+
+		_, status = rekey("GET", "")
+		assert.False(t, status.Started)
+	})
+
+	t.Run("Complete", func(t *testing.T) {
+		resp, status := rekey("POST", "/init?parts=3&threshold=2&current_threshold=3")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		nonce := status.Nonce
+
+		var receivers []*websocket.Conn
+		for range 3 {
+			conn, _ := receive(nonce)
+			require.NotNil(t, conn)
+			receivers = append(receivers, conn)
+		}
+		_, resp = receive(nonce)
+		require.NotNil(t, resp)
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+		t.Run("Bad Parts", func(t *testing.T) {
+			resp, _ := update(nonce, base64.StdEncoding.EncodeToString(make([]byte, 10)))
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+			// x-coordinate is the last byte of a part
+			resp, _ = update(nonce, base64.StdEncoding.EncodeToString(make([]byte, 33)))
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		})
+
+		t.Run("Foreign Parts", func(t *testing.T) {
+			first, err := base64.RawStdEncoding.DecodeString(masterParts[0])
+			require.NoError(t, err)
+			second, err := base64.RawStdEncoding.DecodeString(masterParts[1])
+			require.NoError(t, err)
+
+			resp, _ := update(nonce, masterParts[0])
+			require.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+			sameX := bytes.Clone(first)
+			sameX[0]++
+			resp, _ = update(nonce, base64.StdEncoding.EncodeToString(sameX))
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+			resp, _ = update(nonce, masterParts[1])
+			require.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+			foreign := make([]byte, 33)
+			rand.Read(foreign[:32])
+			for foreign[32] = 1; foreign[32] == first[32] || foreign[32] == second[32]; foreign[32]++ {
+			}
+			resp, _ = update(nonce, base64.StdEncoding.EncodeToString(foreign))
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+			// the spoiled set is dropped
+			_, status := rekey("GET", "")
+			assert.True(t, status.Started)
+			assert.Equal(t, 0, status.Progress)
+		})
+
+		// the storage was unsealed with 3 of 5 shares
+		resp, _ = update(nonce, masterParts[0])
+		require.Equal(t, http.StatusAccepted, resp.StatusCode)
+		resp, _ = update(nonce, masterParts[0])
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+		resp, status = update(nonce, masterParts[1])
+		require.Equal(t, http.StatusAccepted, resp.StatusCode)
+		assert.Equal(t, 2, status.Progress)
+
+		resp, status = update(nonce, masterParts[2])
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.True(t, status.Complete)
+
+		// every receiver gets only its own share, then the outcome
+		var parts []string
+		for _, conn := range receivers {
+			var part, outcome models.RekeyShareMessage
+			require.NoError(t, conn.ReadJSON(&part))
+			require.NotEmpty(t, part.Part)
+			assert.NotContains(t, parts, part.Part)
+			parts = append(parts, part.Part)
+
+			require.NoError(t, conn.ReadJSON(&outcome))
+			assert.True(t, outcome.Complete)
+			assert.Empty(t, outcome.Error)
+		}
+		masterParts = parts
+
+		_, status = rekey("GET", "")
+		assert.False(t, status.Started)
+
+		record := GetRecord(t, ts, userCreds, "token", "rekey")
+		assert.Equal(t, "value", record.Value)
+	})
+}
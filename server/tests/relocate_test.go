@@ -0,0 +1,99 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/liriquew/secret_storage/server/internal/models"
+	"github.com/liriquew/secret_storage/server/tests/suite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRelocate(t *testing.T) {
+	ts := suite.New(t)
+	userCreds := CreateUser(t, ts)
+
+	relocate := func(action string, dto models.RelocateDTO) (*http.Response, int) {
+		buf, _ := json.Marshal(dto)
+
+		req, _ := http.NewRequest("POST", fmt.Sprintf("%s/%s", ts.GetURL(), action), bytes.NewBuffer(buf))
+		req.Header.Set("Authorization", "Bearer "+userCreds.Token)
+		req.Header.Set(contentType, applicationJSON)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		var result struct {
+			Relocated int `json:"relocated"`
+		}
+		json.NewDecoder(resp.Body).Decode(&result)
+
+		return resp, result.Relocated
+	}
+
+	getStatus := func(key, path string) int {
+		req, _ := http.NewRequest("GET", fmt.Sprintf("%s/secrets/%s?path=%s", ts.GetURL(), key, path), nil)
+		req.Header.Set("Authorization", "Bearer "+userCreds.Token)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		return resp.StatusCode
+	}
+
+	CreateRecord(t, ts, userCreds, "db/prod", &models.RecordDTO{Key: "password", Value: "qwerty"})
+	CreateRecord(t, ts, userCreds, "db/prod", &models.RecordDTO{Key: "username", Value: "admin"})
+
+	t.Run("Rename Secret", func(t *testing.T) {
+		resp, relocated := relocate("move", models.RelocateDTO{From: "db/prod", To: "db/prod", Key: "password", NewKey: "pass"})
+		assert.Equal(t, StatusOK, resp.Status)
+		assert.Equal(t, 1, relocated)
+
+		assert.Equal(t, "qwerty", GetRecord(t, ts, userCreds, "pass", "db/prod").Value)
+		assert.Equal(t, http.StatusNotFound, getStatus("password", "db/prod"))
+	})
+
+	t.Run("Copy Bucket", func(t *testing.T) {
+		resp, relocated := relocate("copy", models.RelocateDTO{From: "db/prod", To: "db/stage"})
+		assert.Equal(t, StatusOK, resp.Status)
+		assert.Equal(t, 2, relocated)
+
+		assert.Equal(t, "admin", GetRecord(t, ts, userCreds, "username", "db/stage").Value)
+		assert.Equal(t, "admin", GetRecord(t, ts, userCreds, "username", "db/prod").Value)
+	})
+
+	t.Run("Conflict", func(t *testing.T) {
+		UpdateRecord(t, ts, userCreds, "username", "db/stage", "root")
+
+		resp, _ := relocate("copy", models.RelocateDTO{From: "db/prod", To: "db/stage"})
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+		// nothing is copied on conflict
+		assert.Equal(t, "root", GetRecord(t, ts, userCreds, "username", "db/stage").Value)
+	})
+
+	t.Run("Move Bucket With Overwrite", func(t *testing.T) {
+		resp, relocated := relocate("move", models.RelocateDTO{From: "db/prod", To: "db/stage", Overwrite: true})
+		assert.Equal(t, StatusOK, resp.Status)
+		assert.Equal(t, 2, relocated)
+
+		assert.Equal(t, "admin", GetRecord(t, ts, userCreds, "username", "db/stage").Value)
+		assert.Equal(t, http.StatusNotFound, getStatus("username", "db/prod"))
+	})
+
+	t.Run("Overlap", func(t *testing.T) {
+		resp, _ := relocate("move", models.RelocateDTO{From: "db", To: "db/stage/nested"})
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("Not Found", func(t *testing.T) {
+		resp, _ := relocate("move", models.RelocateDTO{From: "missing", To: "other"})
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
@@ -18,6 +18,10 @@ const StatusOK = "200 OK"
 
 var isUnsealed bool
 
+// masterParts are the shares the storage was unsealed with by this run,
+// nil if it was unsealed before
+var masterParts []string
+
 func TestMain(m *testing.M) {
 	t := &testing.T{}
 
@@ -85,6 +89,7 @@ func UnsealWithMaster(t *testing.T) {
 
 		parts = append(parts, part.Part)
 	}
+	masterParts = parts
 
 	for i := range 3 {
 		req, _ := http.NewRequest("POST", fmt.Sprintf("%s/unseal?part=%s", ts.GetURL(), parts[i]), nil)
@@ -0,0 +1,93 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/liriquew/secret_storage/server/internal/models"
+	"github.com/liriquew/secret_storage/server/tests/suite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type searchMatch struct {
+	Path   string            `json:"path"`
+	Bucket bool              `json:"bucket"`
+	Record *models.RecordDTO `json:"record"`
+}
+
+func TestSearch(t *testing.T) {
+	ts := suite.New(t)
+	userCreds := CreateUser(t, ts)
+
+	for _, path := range []string{"db/prod", "db/dev", "web"} {
+		CreateRecord(t, ts, userCreds, path, &models.RecordDTO{Key: "password", Value: "qwerty"})
+	}
+
+	search := func(query url.Values) (*http.Response, []searchMatch) {
+		req, _ := http.NewRequest("GET", fmt.Sprintf("%s/search?%s", ts.GetURL(), query.Encode()), nil)
+		req.Header.Set("Authorization", "Bearer "+userCreds.Token)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		var result struct {
+			Matches []searchMatch `json:"matches"`
+		}
+		json.NewDecoder(resp.Body).Decode(&result)
+
+		return resp, result.Matches
+	}
+
+	t.Run("Glob", func(t *testing.T) {
+		resp, matches := search(url.Values{"q": {"db/*/password"}})
+		assert.Equal(t, StatusOK, resp.Status)
+
+		require.Len(t, matches, 2)
+		assert.Equal(t, "db/dev/password", matches[0].Path)
+		assert.Equal(t, "db/prod/password", matches[1].Path)
+		assert.Nil(t, matches[0].Record)
+	})
+
+	t.Run("Prefix With Values", func(t *testing.T) {
+		resp, matches := search(url.Values{"prefix": {"db/pr"}, "values": {"true"}})
+		assert.Equal(t, StatusOK, resp.Status)
+
+		require.Len(t, matches, 2)
+		assert.True(t, matches[0].Bucket)
+		assert.Equal(t, "db/prod/password", matches[1].Path)
+		require.NotNil(t, matches[1].Record)
+		assert.Equal(t, "qwerty", matches[1].Record.Value)
+	})
+
+	t.Run("Other Namespaces Are Not Searched", func(t *testing.T) {
+		otherCreds := CreateUser(t, ts)
+
+		req, _ := http.NewRequest("GET", fmt.Sprintf("%s/search?q=**/password", ts.GetURL()), nil)
+		req.Header.Set("Authorization", "Bearer "+otherCreds.Token)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, StatusOK, resp.Status)
+
+		var result struct {
+			Matches []searchMatch `json:"matches"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+		assert.Empty(t, result.Matches)
+	})
+
+	t.Run("Bad Request", func(t *testing.T) {
+		resp, _ := search(url.Values{})
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		resp, _ = search(url.Values{"q": {"db/["}})
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
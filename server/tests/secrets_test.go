@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"math/rand"
 	"net/http"
+	"net/url"
+	"strconv"
 	"sync"
 	"testing"
 
@@ -85,6 +87,38 @@ func GetRecord(t *testing.T, ts *suite.Suite, userCreds *UserWithToken, key, pat
 	return record
 }
 
+// ListAll lists the bucket page by page following next_cursor, it returns
+// all entries of the bucket and the number of requested pages.
+func ListAll(t *testing.T, ts *suite.Suite, userCreds *UserWithToken, path string, limit int) (*models.BucketInfo, int) {
+	all := &models.BucketInfo{}
+
+	var pages int
+	query := url.Values{"path": {path}, "limit": {strconv.Itoa(limit)}}
+	for {
+		req, _ := http.NewRequest("GET", fmt.Sprintf("%s/list?%s", ts.GetURL(), query.Encode()), nil)
+		req.Header.Set("Authorization", "Bearer "+userCreds.Token)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		page := &models.BucketInfo{}
+		err = json.NewDecoder(resp.Body).Decode(page)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.NoError(t, err)
+
+		pages++
+		assert.LessOrEqual(t, len(page.Buckets)+len(page.Records), limit)
+		all.Buckets = append(all.Buckets, page.Buckets...)
+		all.Records = append(all.Records, page.Records...)
+
+		if page.NextCursor == "" {
+			return all, pages
+		}
+		query.Set("after", page.NextCursor)
+	}
+}
+
 func TestCreate(t *testing.T) {
 	ts := suite.New(t)
 
@@ -396,3 +430,105 @@ func TestListSecretsRecursively(t *testing.T) {
 	assert.Len(t, info.Buckets, 1)
 	assert.Len(t, info.Records, 0)
 }
+
+func TestListSecretsPagination(t *testing.T) {
+	ts := suite.New(t)
+
+	userCreds := CreateUser(t, ts)
+
+	// random keys may repeat, so the keys are fixed
+	for i := range 5 {
+		CreateRecord(t, ts, userCreds, "paged", &models.RecordDTO{Key: fmt.Sprintf("key%d", i), Value: "value"})
+	}
+	CreateRecord(t, ts, userCreds, "paged/sub", nil)
+
+	listPage := func(query string) (*http.Response, *models.BucketInfo) {
+		req, _ := http.NewRequest("GET", fmt.Sprintf("%s/list?path=paged&%s", ts.GetURL(), query), nil)
+		req.Header.Set("Authorization", "Bearer "+userCreds.Token)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		var info models.BucketInfo
+		json.NewDecoder(resp.Body).Decode(&info)
+
+		return resp, &info
+	}
+
+	t.Run("Follow Cursors", func(t *testing.T) {
+		info, pages := ListAll(t, ts, userCreds, "paged", 2)
+
+		assert.Equal(t, 3, pages)
+		assert.Len(t, info.Buckets, 1)
+		assert.Len(t, info.Records, 5)
+	})
+
+	t.Run("Bad Params", func(t *testing.T) {
+		resp, _ := listPage("limit=0")
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		resp, _ = listPage("after=%2B%2B")
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("Missing Path", func(t *testing.T) {
+		for _, endpoint := range []string{"list", "reclist"} {
+			req, _ := http.NewRequest("GET", fmt.Sprintf("%s/%s?path=paged/missing", ts.GetURL(), endpoint), nil)
+			req.Header.Set("Authorization", "Bearer "+userCreds.Token)
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+
+			assert.Equal(t, http.StatusNotFound, resp.StatusCode, endpoint)
+		}
+	})
+}
+
+func TestUndelete(t *testing.T) {
+	ts := suite.New(t)
+
+	userCreds := CreateUser(t, ts)
+
+	path := "soft/delete"
+	record := CreateRecord(t, ts, userCreds, path, nil)
+
+	doRequest := func(method, url string) *http.Response {
+		req, _ := http.NewRequest(method, url, nil)
+		req.Header.Set("Authorization", "Bearer "+userCreds.Token)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	secretURL := fmt.Sprintf("%s/secrets/%s", ts.GetURL(), record.Key)
+
+	resp := doRequest("DELETE", fmt.Sprintf("%s?path=%s", secretURL, path))
+	assert.Equal(t, StatusOK, resp.Status)
+
+	resp = doRequest("GET", fmt.Sprintf("%s?path=%s", secretURL, path))
+	assert.Equal(t, StatusNotFound, resp.Status)
+
+	t.Run("Success Undelete", func(t *testing.T) {
+		resp := doRequest("POST", fmt.Sprintf("%s/undelete?path=%s", secretURL, path))
+		assert.Equal(t, StatusOK, resp.Status)
+
+		restored := GetRecord(t, ts, userCreds, record.Key, path)
+		assert.Equal(t, record.Value, restored.Value)
+	})
+
+	t.Run("Undelete Not Deleted", func(t *testing.T) {
+		resp := doRequest("POST", fmt.Sprintf("%s/undelete?path=%s", secretURL, path))
+		assert.Equal(t, StatusNotFound, resp.Status)
+	})
+
+	t.Run("Destroy", func(t *testing.T) {
+		resp := doRequest("DELETE", fmt.Sprintf("%s/destroy?path=%s", secretURL, path))
+		assert.Equal(t, StatusOK, resp.Status)
+
+		resp = doRequest("POST", fmt.Sprintf("%s/undelete?path=%s", secretURL, path))
+		assert.Equal(t, StatusNotFound, resp.Status)
+	})
+}
@@ -0,0 +1,144 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/liriquew/secret_storage/server/internal/models"
+	"github.com/liriquew/secret_storage/server/tests/suite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportImport(t *testing.T) {
+	ts := suite.New(t)
+	userCreds := CreateUser(t, ts)
+
+	CreateRecord(t, ts, userCreds, "app/db", &models.RecordDTO{Key: "password", Value: "qwe\"rty\n"})
+	CreateRecord(t, ts, userCreds, "app", &models.RecordDTO{Key: "port", Value: "5432"})
+	CreateRecord(t, ts, userCreds, "app", &models.RecordDTO{Key: "cert", Value: "AAEC", Base64: true})
+
+	export := func(path, format string) (*http.Response, string) {
+		query := url.Values{"path": {path}, "format": {format}}
+		req, _ := http.NewRequest("GET", fmt.Sprintf("%s/export?%s", ts.GetURL(), query.Encode()), nil)
+		req.Header.Set("Authorization", "Bearer "+userCreds.Token)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	doImport := func(query url.Values, document string) (*http.Response, *models.ImportReport) {
+		req, _ := http.NewRequest("POST", fmt.Sprintf("%s/import?%s", ts.GetURL(), query.Encode()), bytes.NewBufferString(document))
+		req.Header.Set("Authorization", "Bearer "+userCreds.Token)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		report := &models.ImportReport{}
+		json.NewDecoder(resp.Body).Decode(report)
+		return resp, report
+	}
+
+	t.Run("Export", func(t *testing.T) {
+		resp, body := export("app", "json")
+		assert.Equal(t, StatusOK, resp.Status)
+		assert.JSONEq(t, `{"cert": "base64:AAEC", "db": {"password": "qwe\"rty\n"}, "port": "5432"}`, body)
+
+		resp, body = export("app", "yaml")
+		assert.Equal(t, StatusOK, resp.Status)
+		assert.Equal(t, "cert: base64:AAEC\ndb:\n  password: |\n    qwe\"rty\nport: \"5432\"\n", body)
+
+		resp, body = export("app", "env")
+		assert.Equal(t, StatusOK, resp.Status)
+		assert.Equal(t, "cert=\"base64:AAEC\"\ndb__password=\"qwe\\\"rty\\n\"\nport=\"5432\"\n", body)
+
+		resp, _ = export("app", "xml")
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		resp, _ = export("missing", "json")
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("Files", func(t *testing.T) {
+		resp := UploadFile(t, ts, userCreds, "keystore", "files/certs", bytes.NewBufferString("binary"), "application/octet-stream")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		CreateRecord(t, ts, userCreds, "files", &models.RecordDTO{Key: "port", Value: "5432"})
+
+		resp, body := export("files", "json")
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+		assert.Contains(t, body, "certs/keystore")
+
+		query := url.Values{"path": {"files"}, "skip_files": {"true"}}
+		req, _ := http.NewRequest("GET", fmt.Sprintf("%s/export?%s", ts.GetURL(), query.Encode()), nil)
+		req.Header.Set("Authorization", "Bearer "+userCreds.Token)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		require.Equal(t, StatusOK, resp.Status)
+		assert.Equal(t, []string{"certs%2Fkeystore"}, resp.Header.Values("X-Skipped-File"))
+		document, _ := io.ReadAll(resp.Body)
+		assert.JSONEq(t, `{"port": "5432"}`, string(document))
+	})
+
+	t.Run("Round Trip", func(t *testing.T) {
+		for _, format := range []string{"json", "yaml", "env"} {
+			_, document := export("app", format)
+
+			resp, report := doImport(url.Values{"path": {"copy/" + format}, "format": {format}}, document)
+			require.Equal(t, StatusOK, resp.Status)
+			assert.Equal(t, []string{"cert", "db/password", "port"}, report.Created)
+
+			assert.Equal(t, "qwe\"rty\n", GetRecord(t, ts, userCreds, "password", "copy/"+format+"/db").Value)
+			assert.Equal(t, "5432", GetRecord(t, ts, userCreds, "port", "copy/"+format).Value)
+		}
+	})
+
+	t.Run("Dry Run", func(t *testing.T) {
+		document := "# comment\nport=5433\ndb__password=\"qwe\\\"rty\\n\"\nuser='admin'\nport__number=1\n"
+
+		resp, report := doImport(url.Values{"path": {"app"}, "format": {"env"}, "dry_run": {"true"}}, document)
+		require.Equal(t, StatusOK, resp.Status)
+		assert.True(t, report.DryRun)
+		assert.Equal(t, []string{"user"}, report.Created)
+		assert.Equal(t, []string{"db/password"}, report.Unchanged)
+		assert.Equal(t, []string{"port", "port/number"}, report.Conflicts)
+
+		// nothing is written
+		assert.Equal(t, "5432", GetRecord(t, ts, userCreds, "port", "app").Value)
+	})
+
+	t.Run("Conflict", func(t *testing.T) {
+		resp, report := doImport(url.Values{"path": {"app"}}, `{"port": 5433, "user": "admin"}`)
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+		assert.Equal(t, []string{"port"}, report.Conflicts)
+
+		// the import is aborted as a whole
+		resp, _ = doImport(url.Values{"path": {"app"}, "dry_run": {"true"}}, `{"user": "admin"}`)
+		assert.Equal(t, StatusOK, resp.Status)
+	})
+
+	t.Run("Overwrite", func(t *testing.T) {
+		resp, report := doImport(url.Values{"path": {"app"}, "format": {"yaml"}, "overwrite": {"true"}}, "port: 5433\nuser: admin\n")
+		require.Equal(t, StatusOK, resp.Status)
+		assert.Equal(t, []string{"user"}, report.Created)
+		assert.Equal(t, []string{"port"}, report.Updated)
+
+		assert.Equal(t, "5433", GetRecord(t, ts, userCreds, "port", "app").Value)
+	})
+
+	t.Run("Bad Document", func(t *testing.T) {
+		resp, _ := doImport(url.Values{"path": {"app"}}, `{"list": [1, 2]}`)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
@@ -0,0 +1,63 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/liriquew/secret_storage/server/internal/models"
+	"github.com/liriquew/secret_storage/server/tests/suite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// VerifyStorage runs the verification of the storage of ts as the admin.
+func VerifyStorage(t *testing.T, ts *suite.Suite, adminCreds *UserWithToken) *models.VerifyReport {
+	req, _ := http.NewRequest("GET", fmt.Sprintf("%s/sys/verify", ts.GetURL()), nil)
+	req.Header.Set("Authorization", "Bearer "+adminCreds.Token)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	report := &models.VerifyReport{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(report))
+	return report
+}
+
+func TestVerifyRequiresAdmin(t *testing.T) {
+	ts := suite.New(t)
+	userCreds := CreateUser(t, ts)
+
+	req, _ := http.NewRequest("GET", fmt.Sprintf("%s/sys/verify", ts.GetURL()), nil)
+	req.Header.Set("Authorization", "Bearer "+userCreds.Token)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestVerify(t *testing.T) {
+	ts := suite.New(t)
+	adminCreds := AdminUser(t, ts)
+	userCreds := CreateUser(t, ts)
+
+	CreateRecord(t, ts, userCreds, "verify", &models.RecordDTO{Key: "token", Value: "value"})
+	UpdateRecord(t, ts, userCreds, "token", "verify", "new value")
+	resp := UploadFile(t, ts, userCreds, "keystore", "verify", bytes.NewReader(GetRandBytes(300*1024)), "application/octet-stream")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	report := VerifyStorage(t, ts, adminCreds)
+	assert.True(t, report.OK)
+	assert.Empty(t, report.Issues)
+	// the storage is shared with other tests, so only entries of this one are known
+	assert.GreaterOrEqual(t, report.Secrets, 2)
+	assert.GreaterOrEqual(t, report.Values, 3)
+	assert.GreaterOrEqual(t, report.Chunks, 1)
+	assert.False(t, report.FinishedAt.Before(report.StartedAt))
+}
@@ -0,0 +1,178 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/liriquew/secret_storage/server/internal/models"
+	"github.com/liriquew/secret_storage/server/tests/suite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func UpdateRecord(t *testing.T, ts *suite.Suite, userCreds *UserWithToken, key, path, value string) int {
+	buf, _ := json.Marshal(models.RecordDTO{Value: value})
+
+	req, _ := http.NewRequest("PATCH", fmt.Sprintf("%s/secrets/%s?path=%s", ts.GetURL(), key, path), bytes.NewBuffer(buf))
+	req.Header.Set("Authorization", "Bearer "+userCreds.Token)
+	req.Header.Set(contentType, applicationJSON)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, StatusOK, resp.Status)
+
+	var version struct {
+		Version int `json:"version"`
+	}
+	json.NewDecoder(resp.Body).Decode(&version)
+
+	return version.Version
+}
+
+func TestVersions(t *testing.T) {
+	ts := suite.New(t)
+	userCreds := CreateUser(t, ts)
+
+	path := "versioned/path"
+	record := CreateRecord(t, ts, userCreds, path, nil)
+
+	assert.Equal(t, 2, UpdateRecord(t, ts, userCreds, record.Key, path, "second"))
+	assert.Equal(t, 3, UpdateRecord(t, ts, userCreds, record.Key, path, "third"))
+
+	t.Run("Current Version", func(t *testing.T) {
+		current := GetRecord(t, ts, userCreds, record.Key, path)
+
+		assert.Equal(t, "third", current.Value)
+		assert.Equal(t, 3, current.Version)
+	})
+
+	t.Run("Read By Version", func(t *testing.T) {
+		first := GetRecord(t, ts, userCreds, record.Key, path+"&version=1")
+
+		assert.Equal(t, record.Value, first.Value)
+		assert.Equal(t, 1, first.Version)
+	})
+
+	t.Run("Version Not Found", func(t *testing.T) {
+		req, _ := http.NewRequest("GET",
+			fmt.Sprintf("%s/secrets/%s?path=%s&version=%d", ts.GetURL(), record.Key, path, 100),
+			nil,
+		)
+		req.Header.Set("Authorization", "Bearer "+userCreds.Token)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, StatusNotFound, resp.Status)
+	})
+
+	t.Run("List Versions", func(t *testing.T) {
+		req, _ := http.NewRequest("GET",
+			fmt.Sprintf("%s/secrets/%s/versions?path=%s", ts.GetURL(), record.Key, path),
+			nil,
+		)
+		req.Header.Set("Authorization", "Bearer "+userCreds.Token)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, StatusOK, resp.Status)
+
+		var versions []models.SecretVersion
+		err = json.NewDecoder(resp.Body).Decode(&versions)
+		assert.NoError(t, err)
+
+		require.Len(t, versions, 3)
+		for i, version := range versions {
+			assert.Equal(t, i+1, version.Version)
+			assert.False(t, version.CreatedAt.IsZero())
+		}
+	})
+
+	t.Run("Rollback", func(t *testing.T) {
+		req, _ := http.NewRequest("POST",
+			fmt.Sprintf("%s/secrets/%s/rollback?path=%s&version=%d", ts.GetURL(), record.Key, path, 1),
+			nil,
+		)
+		req.Header.Set("Authorization", "Bearer "+userCreds.Token)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, StatusOK, resp.Status)
+
+		current := GetRecord(t, ts, userCreds, record.Key, path)
+
+		assert.Equal(t, record.Value, current.Value)
+		assert.Equal(t, 4, current.Version)
+	})
+}
+
+func TestCheckAndSet(t *testing.T) {
+	ts := suite.New(t)
+	userCreds := CreateUser(t, ts)
+
+	path := "cas/path"
+	record := CreateRecord(t, ts, userCreds, path, nil)
+
+	doWrite := func(method, query string, record *models.RecordDTO) *http.Response {
+		buf, _ := json.Marshal(record)
+
+		url := fmt.Sprintf("%s/secrets?path=%s%s", ts.GetURL(), path, query)
+		if method == "PATCH" {
+			url = fmt.Sprintf("%s/secrets/%s?path=%s%s", ts.GetURL(), record.Key, path, query)
+		}
+
+		req, _ := http.NewRequest(method, url, bytes.NewBuffer(buf))
+		req.Header.Set("Authorization", "Bearer "+userCreds.Token)
+		req.Header.Set(contentType, applicationJSON)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	t.Run("Create Existing", func(t *testing.T) {
+		resp := doWrite("POST", "", record)
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	})
+
+	t.Run("Create Existing With Overwrite", func(t *testing.T) {
+		resp := doWrite("POST", "&overwrite=true", record)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("Update With Stale Version", func(t *testing.T) {
+		resp := doWrite("PATCH", "&cas=1", &models.RecordDTO{Key: record.Key, Value: "stale"})
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+		current := GetRecord(t, ts, userCreds, record.Key, path)
+		assert.Equal(t, record.Value, current.Value)
+	})
+
+	t.Run("Update With Current Version", func(t *testing.T) {
+		resp := doWrite("PATCH", "&cas=2", &models.RecordDTO{Key: record.Key, Value: "fresh"})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		current := GetRecord(t, ts, userCreds, record.Key, path)
+		assert.Equal(t, "fresh", current.Value)
+		assert.Equal(t, 3, current.Version)
+	})
+
+	t.Run("Must Not Exist", func(t *testing.T) {
+		resp := doWrite("PATCH", "&cas=0", &models.RecordDTO{Key: record.Key, Value: "other"})
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+		resp = doWrite("PATCH", "&cas=0", &models.RecordDTO{Key: record.Key + "_new", Value: "other"})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+}
@@ -0,0 +1,81 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/liriquew/secret_storage/server/internal/models"
+	"github.com/liriquew/secret_storage/server/tests/suite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatch(t *testing.T) {
+	ts := suite.New(t)
+	userCreds := CreateUser(t, ts)
+	otherCreds := CreateUser(t, ts)
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+userCreds.Token)
+
+	t.Run("WebSocket", func(t *testing.T) {
+		url := strings.Replace(ts.GetURL(), "http", "ws", 1) + "/watch?path=watched"
+		conn, resp, err := websocket.DefaultDialer.Dial(url, header)
+		require.NoError(t, err)
+		defer conn.Close()
+		require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+
+		// changes outside of the subtree or of other users aren't sent
+		CreateRecord(t, ts, userCreds, "other", &models.RecordDTO{Key: "skipped", Value: "value"})
+		CreateRecord(t, ts, otherCreds, "watched", &models.RecordDTO{Key: "skipped", Value: "value"})
+		CreateRecord(t, ts, userCreds, "watched/app", &models.RecordDTO{Key: "token", Value: "value"})
+
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var event map[string]any
+		require.NoError(t, conn.ReadJSON(&event))
+
+		assert.Equal(t, models.ChangeCreate, event["op"])
+		assert.Equal(t, "watched/app", event["path"])
+		assert.Equal(t, "token", event["key"])
+		assert.Equal(t, float64(1), event["version"])
+		assert.NotContains(t, event, "value")
+	})
+
+	t.Run("Server-Sent Events", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		req, _ := http.NewRequestWithContext(ctx, "GET", ts.GetURL()+"/watch?path=watched", nil)
+		req.Header = header.Clone()
+		req.Header.Set("Accept", "text/event-stream")
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		CreateRecord(t, ts, userCreds, "watched", &models.RecordDTO{Key: "token", Value: "value"})
+
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data:")
+			if !ok {
+				continue
+			}
+
+			var event models.ChangeEvent
+			require.NoError(t, json.Unmarshal([]byte(data), &event))
+			assert.Equal(t, models.ChangeCreate, event.Op)
+			assert.Equal(t, "watched", event.Path)
+			assert.Equal(t, "token", event.Key)
+			return
+		}
+		t.Fatal("stream ended without events", scanner.Err())
+	})
+}